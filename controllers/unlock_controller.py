@@ -1059,7 +1059,8 @@ class UnlockController(QObject):
                 fail_count = 0
                 failed_ids = []  # [(app_id, error_msg), ...]
                 
-                if process.returncode == 0 and last_json_line:
+                # 全部失败时下载器以退出码 1 结束，但仍会输出结果 JSON
+                if last_json_line:
                     try:
                         result_json = json.loads(last_json_line)
                         for r in result_json.get("results", []):
@@ -913,7 +913,8 @@ class UnlockModel:
                     
                     process.wait()
                     
-                    if process.returncode == 0 and last_json_line:
+                    # 全部失败时下载器以退出码 1 结束，但仍会输出结果 JSON
+                    if last_json_line:
                         result_json = json.loads(last_json_line)
                         for r in result_json.get("results", []):
                             aid = r.get("app_id", "")
@@ -142,7 +142,7 @@ def build_go_downloader():
     print(f"正在编译 {go_source_file}...")
     try:
         result = subprocess.run(
-            ["go", "build", "-ldflags", "-s -w", "-o", "../../downloader.exe", "main.go"],
+            ["go", "build", "-ldflags", "-s -w", "-o", "../../downloader.exe", "."],
             cwd=str(go_source_dir),
             capture_output=True,
             text=True
@@ -5,272 +5,147 @@ Steam Unlocker - 高并发下载器 (Go 版) v17
 2. 增加下载重试机制 (3次随机退避)，大幅提高 GitHub 网络波动的容错率。
 3. 优化进度统计，确保在二级并行下结果依然准确。
 4. 延续 v16 的“原名保存”逻辑。
+
+下载核心位于 pkg/downloader，此处只负责解析命令行、读取配置并输出结果。
 */
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"strings"
-	"sync"
-	"sync/atomic"
-	"time"
-)
-
-type Config struct {
-	Token        string              `json:"token"`
-	Repo         string              `json:"repo"`
-	AppIDs       []string            `json:"app_ids"`
-	AppData      map[string][]string `json:"app_data"`
-	LuaDir       string              `json:"lua_dir"`
-	ManifestDir  string              `json:"manifest_dir"`
-	DirectMode   bool                `json:"direct_mode"`
-	ManifestOnly bool                `json:"manifest_only"`
-}
-
-type AppResult struct {
-	AppID    string `json:"app_id"`
-	Lua      int    `json:"lua"`
-	Manifest int    `json:"manifest"`
-	Error    string `json:"error,omitempty"`
-}
-
-type Result struct {
-	Success   bool        `json:"success"`
-	Results   []AppResult `json:"results"`
-	TotalTime float64     `json:"total_time_seconds"`
-}
+	"syscall"
 
-const (
-	DOWNLOAD_CONCURRENCY = 100 // 主线程池：处理不同游戏的并发
-	MAX_RETRIES          = 3   // 下载重试次数
-)
-
-var httpClient = &http.Client{
-	Timeout: 60 * time.Second, // 略微增加超时
-}
-
-var (
-	downloadedCount int64 = 0
-	totalTaskCount  int64 = 0
-	logMu           sync.Mutex
+	"github.com/steamunlocker/downloader/pkg/downloader"
 )
 
 func main() {
-	startTime := time.Now()
+	os.Exit(run())
+}
 
-	configPath := flag.String("config", "", "JSON config file path")
+// run 执行一次完整的下载并返回进程退出码：参数错误或所有 App 都没拿到文件时返回 1
+func run() int {
+	configPath := flag.String("config", "", "JSON config file path or http(s) URL")
+	debugFlag := flag.Bool("debug", false, "print debug logs (source selection reasons) to stderr")
+	progressFlag := flag.String("progress", "", "progress output format: text (default) or json (NDJSON events on stderr)")
+	explainApp := flag.String("explain", "", "dry-run source selection for one item: -explain <appid> <item>")
+	logLevel := flag.String("log-level", "", "console log level: debug, info (default), warn, error, or quiet (only the final JSON)")
+	verboseFlag := flag.Bool("verbose", false, "log every download attempt and its outcome to stderr")
+	freshFlag := flag.Bool("fresh", false, "ignore and overwrite the state_file from a previous run")
+	appIDsFile := flag.String("appids-file", "", "read additional app IDs (one per line, # comments, ranges like 220-240) from a file, or - for stdin")
+	onlyFlag := flag.String("only", "", "comma-separated glob patterns (e.g. 570,730 or 2280*): process only matching app IDs from the config")
+	skipFlag := flag.String("skip", "", "comma-separated glob patterns: leave matching app IDs out of this run; skip wins over -only when both match")
+	dryRunFlag := flag.Bool("dry-run", false, "check which Lua scripts and manifests exist (HEAD requests) without writing any files")
+	doctorFlag := flag.Bool("doctor", false, "measure network/disk and print a suggested config as JSON")
+	stdinTimeout := flag.Duration("stdin-timeout", downloader.DEFAULT_STDIN_TIMEOUT, "without -config: give up if no complete JSON config arrives on stdin within this time (0 = wait forever)")
+	stdinLimit := flag.Int64("stdin-max-bytes", downloader.DEFAULT_STDIN_MAX_BYTES, "without -config: maximum size of the JSON config read from stdin (0 = unlimited)")
+	strictFlag := flag.Bool("strict", false, "reject config files (and stdin configs) that contain unknown fields instead of silently ignoring them")
+	outputPath := flag.String("output", "", "write the result JSON atomically to this file instead of stdout, or - to keep stdout pure JSON (logs go to stderr)")
+	compressOutput := flag.Bool("compress-output", false, "gzip the -output file and name it .json.gz (results on stdout stay uncompressed)")
 	flag.Parse()
 
-	var config Config
-	if *configPath != "" {
-		data, err := os.ReadFile(*configPath)
-		if err != nil {
-			outputError("无法读取配置文件: " + err.Error())
-			return
-		}
-		if err := json.Unmarshal(data, &config); err != nil {
-			outputError("配置文件 JSON 解析失败: " + err.Error())
-			return
-		}
-	} else {
-		decoder := json.NewDecoder(os.Stdin)
-		if err := decoder.Decode(&config); err != nil {
-			outputError("Stdin JSON 解析失败: " + err.Error())
-			return
-		}
+	if *compressOutput {
+		// 读取配置之前的错误也写到压缩后的文件名
+		*outputPath = downloader.CompressedOutputPath(*outputPath)
 	}
-
-	if config.Repo == "" || len(config.AppIDs) == 0 {
-		outputError("参数不足 (repo 或 app_ids 缺失)")
-		return
+	if *appIDsFile == "-" && *configPath == "" {
+		outputError(*outputPath, &downloader.ConfigError{
+			Code:  downloader.CODE_INVALID_VALUE,
+			Field: "appids_file",
+			Msg:   "-appids-file - 需要同时指定 -config (stdin 只能提供一种输入)",
+		})
+		return 1
 	}
-
-	if config.LuaDir != "" && !config.ManifestOnly {
-		os.MkdirAll(config.LuaDir, 0755)
+	config, err := downloader.ReadConfigWith(*configPath, downloader.StdinOptions{MaxBytes: *stdinLimit, Timeout: *stdinTimeout, Strict: *strictFlag})
+	if err != nil {
+		outputError(*outputPath, err)
+		return 1
 	}
-	if config.ManifestDir != "" {
-		os.MkdirAll(config.ManifestDir, 0755)
+	if *outputPath != "" {
+		config.OutputPath = *outputPath
 	}
-
-	fmt.Printf("[INFO] downloader.exe version: 2026-01-06-v17 (Internal Parallel & Retry)\n")
-	os.Stdout.Sync()
-
-	results := processAllApps(config)
-
-	output := Result{
-		Success:   true,
-		Results:   results,
-		TotalTime: time.Since(startTime).Seconds(),
+	if *compressOutput {
+		config.CompressOutput = true
 	}
-	jsonOutput, _ := json.Marshal(output)
-	fmt.Println(string(jsonOutput))
-}
-
-func downloadFileWithRetry(url, destPath, token string) error {
-	var lastErr error
-	for i := 0; i < MAX_RETRIES; i++ {
-		err := downloadFile(url, destPath, token)
-		if err == nil {
-			return nil
-		}
-		lastErr = err
-		// 如果是 404，不重试，直接换路径
-		if strings.Contains(err.Error(), "Status 404") {
-			return err
-		}
-		// 否则等待一小会重试
-		time.Sleep(time.Duration(200*(i+1)) * time.Millisecond)
+	if config.CompressOutput {
+		// 配置文件中的 compress_output 同样作用于 Explain/Doctor 的错误输出；
+		// CompressedOutputPath 幂等，已由 -compress-output 转换过的路径不变，prepare 也不会再改
+		config.OutputPath = downloader.CompressedOutputPath(config.OutputPath)
 	}
-	return lastErr
-}
-
-func downloadFile(url, destPath, token string) error {
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return err
+	if *progressFlag != "" {
+		config.ProgressFormat = *progressFlag
 	}
-	if token != "" {
-		req.Header.Set("Authorization", "token "+token)
+	if *dryRunFlag {
+		config.DryRun = true
 	}
-
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return err
+	if *onlyFlag != "" {
+		config.Only = strings.Split(*onlyFlag, ",")
+	}
+	if *skipFlag != "" {
+		config.Skip = strings.Split(*skipFlag, ",")
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("Status %d", resp.StatusCode)
+	client := &downloader.Client{
+		Output:     os.Stdout,
+		Debug:      *debugFlag,
+		Verbose:    *verboseFlag,
+		LogLevel:   *logLevel,
+		Fresh:      *freshFlag,
+		AppIDsFile: *appIDsFile,
+	}
+	if *explainApp != "" {
+		if err := client.Explain(config, *explainApp, flag.Arg(0)); err != nil {
+			outputError(config.OutputPath, err)
+			return 1
+		}
+		return 0
+	}
+	if *doctorFlag {
+		code, err := client.Doctor(config)
+		if err != nil {
+			outputError(config.OutputPath, err)
+		}
+		return code
 	}
 
-	os.MkdirAll(filepath.Dir(destPath), 0755)
-	out, err := os.Create(destPath)
+	// Ctrl-C / SIGTERM 会取消 ctx，工作协程尽快退出并输出部分结果
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	result, err := client.Run(ctx, config)
 	if err != nil {
-		return err
+		outputError(config.OutputPath, err)
+		return 1
 	}
-	defer out.Close()
-
-	_, err = io.Copy(out, resp.Body)
-	return err
+	if !result.Success {
+		return 1
+	}
+	return 0
 }
 
-func processAllApps(config Config) []AppResult {
-	var results []AppResult
-	taskChan := make(chan string, len(config.AppIDs))
-	downloadResults := make(map[string]*AppResult)
-	var downloadMu sync.Mutex
-	var wg sync.WaitGroup
-
-	atomic.StoreInt64(&totalTaskCount, int64(len(config.AppIDs)))
-
-	for i := 0; i < DOWNLOAD_CONCURRENCY; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for appID := range taskChan {
-				res := &AppResult{AppID: appID}
-
-				// 1. 下载 Lua
-				if !config.ManifestOnly && config.LuaDir != "" && config.DirectMode {
-					for _, v := range []string{appID + ".lua", "depots.lua", "config.lua"} {
-						url := fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/%s", config.Repo, appID, v)
-						if err := downloadFileWithRetry(url, filepath.Join(config.LuaDir, appID+".lua"), config.Token); err == nil {
-							res.Lua = 1
-							break
-						}
-					}
-				}
-
-				// 2. 下载清单 (二级并行)
-				if mList, ok := config.AppData[appID]; ok && config.ManifestDir != "" && len(mList) > 0 {
-					var mwg sync.WaitGroup
-					var mCount int64 = 0
-
-					for _, item := range mList {
-						mwg.Add(1)
-						go func(manifestItem string) {
-							defer mwg.Done()
-							parts := strings.Split(manifestItem, "_")
-							var depotID, manifestID string
-							if len(parts) == 2 {
-								depotID, manifestID = parts[0], parts[1]
-							} else {
-								manifestID = manifestItem
-							}
-
-							var onlineNames []string
-							if depotID != "" {
-								onlineNames = append(onlineNames, fmt.Sprintf("%s_%s.manifest", depotID, manifestID), fmt.Sprintf("%s_%s", depotID, manifestID))
-							}
-							if appID != depotID {
-								onlineNames = append(onlineNames, fmt.Sprintf("%s_%s.manifest", appID, manifestID), fmt.Sprintf("%s_%s", appID, manifestID))
-							}
-							onlineNames = append(onlineNames, manifestID+".manifest", manifestID)
-
-							success := false
-							for _, branch := range []string{appID, "main", "master"} {
-								for _, oname := range onlineNames {
-									url := fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/%s", config.Repo, branch, oname)
-
-									localName := oname
-									if !strings.HasSuffix(localName, ".manifest") && !strings.Contains(localName, ".manifest") {
-										localName += ".manifest"
-									}
-									destPath := filepath.Join(config.ManifestDir, localName)
-
-									if err := downloadFileWithRetry(url, destPath, config.Token); err == nil {
-										success = true
-										atomic.AddInt64(&mCount, 1)
-										logMu.Lock()
-										// 内部日志减少刷屏，如需全量可开启
-										// fmt.Printf("[DOWNLOAD_SUCCESS] %s -> %s\n", appID, localName)
-										logMu.Unlock()
-										break
-									}
-								}
-								if success {
-									break
-								}
-							}
-						}(item)
-					}
-					mwg.Wait()
-					res.Manifest = int(mCount)
-				}
-
-				downloadMu.Lock()
-				downloadResults[appID] = res
-				downloadMu.Unlock()
+// errorOutput 是无法开始运行时输出的单行 JSON，与正常结果写到同一个去向 (stdout 或 -output 文件)
+type errorOutput struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error"`
+	Code    string `json:"code,omitempty"`  // 配置错误代码，见 downloader.CODE_*
+	Field   string `json:"field,omitempty"` // 出错的配置字段
+}
 
-				count := atomic.AddInt64(&downloadedCount, 1)
-				if count%100 == 0 || count == totalTaskCount {
-					fmt.Printf("[PROGRESS] %d/%d\n", count, totalTaskCount)
-					os.Stdout.Sync()
-				}
-			}
-		}()
+// outputError 输出错误结果，配置错误额外带上 code 与 field。
+// 通过 json.Marshal 生成，消息中含引号、反斜杠 (Windows 路径) 或换行时仍是有效 JSON；
+// 写入 -output 文件失败时退回 stdout
+func outputError(path string, err error) {
+	out := errorOutput{Error: err.Error()}
+	var ce *downloader.ConfigError
+	if errors.As(err, &ce) {
+		out.Code, out.Field = ce.Code, ce.Field
 	}
-
-	for _, id := range config.AppIDs {
-		taskChan <- id
+	data, _ := json.Marshal(out)
+	if werr := downloader.WriteOutput(path, data); werr != nil {
+		fmt.Println(string(data))
 	}
-	close(taskChan)
-	wg.Wait()
-
-	for _, id := range config.AppIDs {
-		if r, ok := downloadResults[id]; ok {
-			results = append(results, *r)
-		}
-	}
-	return results
-}
-
-func outputError(msg string) {
-	fmt.Printf("{\"success\":false,\"error\":\"%s\"}\n", msg)
 }
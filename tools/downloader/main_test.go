@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/steamunlocker/downloader/pkg/downloader"
+)
+
+func TestOutputError(t *testing.T) {
+	dir := t.TempDir()
+	strictPath := filepath.Join(dir, "strict.json")
+	if err := os.WriteFile(strictPath, []byte(`{"repo":"a/b","app_ids":["10"],"lua_dir":"l","manifest_dir":"m","bogus":1}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, strictErr := downloader.ReadConfigWith(strictPath, downloader.StdinOptions{Strict: true})
+	if strictErr == nil {
+		t.Fatal("strict config with an unknown field was accepted")
+	}
+
+	tests := []struct {
+		name  string
+		err   error
+		want  errorOutput
+		field bool // 输出中应出现 code/field 键
+	}{
+		{
+			name: "quotes backslashes newlines",
+			err:  errors.New(`open "C:\Steam\config.json": 拒绝访问` + "\n第二行"),
+			want: errorOutput{Error: `open "C:\Steam\config.json": 拒绝访问` + "\n第二行"},
+		},
+		{
+			name:  "config error",
+			err:   &downloader.ConfigError{Code: downloader.CODE_MISSING_FIELD, Field: "repo", Msg: `缺少 "repo"`},
+			want:  errorOutput{Error: `缺少 "repo"`, Code: downloader.CODE_MISSING_FIELD, Field: "repo"},
+			field: true,
+		},
+		{
+			name:  "wrapped config error",
+			err:   fmt.Errorf("加载失败: %w", &downloader.ConfigError{Code: downloader.CODE_INVALID_VALUE, Field: "app_ids", Msg: "app_ids 为空"}),
+			want:  errorOutput{Error: "加载失败: app_ids 为空", Code: downloader.CODE_INVALID_VALUE, Field: "app_ids"},
+			field: true,
+		},
+		{
+			name:  "strict unknown field",
+			err:   strictErr,
+			want:  errorOutput{Error: strictErr.Error(), Code: downloader.CODE_UNKNOWN_FIELD, Field: "bogus"},
+			field: true,
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, fmt.Sprintf("out%d.json", i))
+			outputError(path, tt.err)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got errorOutput
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("output is not valid JSON: %v\n%s", err, data)
+			}
+			if got != tt.want {
+				t.Errorf("output = %+v, want %+v", got, tt.want)
+			}
+			var keys map[string]json.RawMessage
+			json.Unmarshal(data, &keys)
+			if _, ok := keys["success"]; !ok {
+				t.Errorf("success missing from %s", data)
+			}
+			_, hasCode := keys["code"]
+			_, hasField := keys["field"]
+			if hasCode != tt.field || hasField != tt.field {
+				t.Errorf("code/field present = %v/%v, want %v: %s", hasCode, hasField, tt.field, data)
+			}
+		})
+	}
+}
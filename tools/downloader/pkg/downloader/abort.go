@@ -0,0 +1,31 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+)
+
+// abortError 表示运行因全局性故障 (网络被过滤、磁盘已满等) 被提前终止，剩余任务不再尝试
+type abortError struct {
+	kind string // KIND_*
+	msg  string
+}
+
+func (e *abortError) Error() string { return e.msg }
+
+// abortIfDiskFull 在写入因磁盘已满失败时终止运行：剩余任务同样会失败，继续下去只会重复同一错误
+func (rn *run) abortIfDiskFull(err error) {
+	var de *diskError
+	if errors.As(err, &de) && isDiskFull(de.err) {
+		rn.abortRun(&abortError{kind: KIND_DISK_FULL, msg: "磁盘空间不足: " + de.err.Error()})
+	}
+}
+
+// abortCause 返回运行被提前终止的原因，不是因 abortRun 取消时返回 nil
+func abortCause(ctx context.Context) *abortError {
+	var ae *abortError
+	if errors.As(context.Cause(ctx), &ae) {
+		return ae
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package downloader
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// appFilter 是 only / skip (-only / -skip) 的 glob 模式 (path.Match 语义，例如 "2280*"、"57?")。
+// 同时匹配两者时 skip 优先：AppID 须匹配 only 中的某个模式 (only 为空时视为全部匹配)，且不匹配 skip 中的任何模式
+type appFilter struct {
+	only, skip []string
+}
+
+// newAppFilter 去掉空白与空模式并校验语法，两者都为空时返回 nil
+func newAppFilter(only, skip []string) (*appFilter, string, error) {
+	f := &appFilter{}
+	for _, list := range []struct {
+		field string
+		in    []string
+		out   *[]string
+	}{{"only", only, &f.only}, {"skip", skip, &f.skip}} {
+		for _, p := range list.in {
+			if p = strings.TrimSpace(p); p == "" {
+				continue
+			}
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, list.field, fmt.Errorf("模式 %q 无效", p)
+			}
+			*list.out = append(*list.out, p)
+		}
+	}
+	if len(f.only) == 0 && len(f.skip) == 0 {
+		return nil, "", nil
+	}
+	return f, "", nil
+}
+
+func matchAny(patterns []string, id string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, id); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// selected 判断 AppID 是否保留在本次运行中
+func (f *appFilter) selected(id string) bool {
+	if f == nil {
+		return true
+	}
+	if matchAny(f.skip, id) {
+		return false
+	}
+	return len(f.only) == 0 || matchAny(f.only, id)
+}
+
+// apply 从 config.AppIDs 中去掉未选中的 App，返回被过滤掉的 AppID (按原顺序)
+func (f *appFilter) apply(config *Config) []string {
+	if f == nil {
+		return nil
+	}
+	var kept, skipped []string
+	for _, id := range config.AppIDs {
+		if f.selected(id) {
+			kept = append(kept, id)
+		} else {
+			skipped = append(skipped, id)
+		}
+	}
+	config.AppIDs = kept
+	return skipped
+}
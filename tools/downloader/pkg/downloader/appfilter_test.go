@@ -0,0 +1,63 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestAppFilter(t *testing.T) {
+	ids := []string{"570", "730", "2280", "22800", "22801", "440"}
+	tests := []struct {
+		name       string
+		only, skip []string
+		kept       []string
+		skipped    []string
+	}{
+		{"none", nil, nil, ids, nil},
+		{"blank patterns", []string{" ", ""}, []string{""}, ids, nil},
+		{"only exact", []string{"570", "730"}, nil, []string{"570", "730"}, []string{"2280", "22800", "22801", "440"}},
+		{"skip glob", nil, []string{"2280*"}, []string{"570", "730", "440"}, []string{"2280", "22800", "22801"}},
+		{"single char", []string{"2280?"}, nil, []string{"22800", "22801"}, []string{"570", "730", "2280", "440"}},
+		// 同时匹配 only 与 skip 时 skip 优先
+		{"skip wins", []string{"2280*"}, []string{"22801"}, []string{"2280", "22800"}, []string{"570", "730", "22801", "440"}},
+	}
+	for _, tt := range tests {
+		f, _, err := newAppFilter(tt.only, tt.skip)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		config := Config{AppIDs: append([]string(nil), ids...)}
+		skipped := f.apply(&config)
+		if !slices.Equal(config.AppIDs, tt.kept) || !slices.Equal(skipped, tt.skipped) {
+			t.Errorf("%s: kept %v skipped %v; want %v and %v", tt.name, config.AppIDs, skipped, tt.kept, tt.skipped)
+		}
+	}
+
+	if _, field, err := newAppFilter(nil, []string{"22[80"}); err == nil || field != "skip" {
+		t.Errorf("malformed skip pattern: field %q, err %v", field, err)
+	}
+}
+
+func TestRunSkippedByFilter(t *testing.T) {
+	r := newTestRepo(t, map[string]string{"a/b/570/570.lua": "-- 570", "a/b/730/730.lua": "-- 730"})
+	cfg := testConfig(t, map[string][]string{"570": nil, "730": nil, "2280": nil})
+	cfg.AppIDs = []string{"570", "730", "2280"}
+	cfg.Skip = []string{"2280*", "730"}
+	res := r.download(t, cfg)
+	if !slices.Equal(res.SkippedByFilter, []string{"730", "2280"}) {
+		t.Errorf("skipped_by_filter = %v, want [730 2280]", res.SkippedByFilter)
+	}
+	if len(res.Results) != 1 || res.Results[0].AppID != "570" || r.count("a/b/730/730.lua") != 0 {
+		t.Errorf("results = %+v, want only 570 processed", res.Results)
+	}
+
+	// 全部被过滤时报错，而不是空跑
+	cfg.Only = []string{"9*"}
+	_, err := r.client().Run(context.Background(), cfg)
+	var ce *ConfigError
+	if !errors.As(err, &ce) || ce.Field != "only" {
+		t.Errorf("Run() = %v, want an only error", err)
+	}
+}
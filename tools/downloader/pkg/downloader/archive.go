@@ -0,0 +1,308 @@
+//go:build !slim
+
+package downloader
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// archiveURL 返回 GitHub 打包整个分支的 tarball 地址
+func (rn *run) archiveURL(repo, branch string) string {
+	return fmt.Sprintf("%s/repos/%s/tarball/%s", rn.apiBase, repo, branch)
+}
+
+// downloadBranchArchive 是 branch_archive 模式：每个 App 只下载一次 appID 分支的 tarball，
+// 按常规命名规则解压 Lua、清单与 .vdf/.st，不再逐个探测候选文件。
+// 仓库按优先级依次尝试，某个仓库没有该分支 (404) 时换下一个。
+func (rn *run) downloadBranchArchive(ctx context.Context, config Config, appID string, items []string, res *AppResult) error {
+	var lastErr error
+	for _, repo := range config.Repos {
+		x := &archiveExtractor{rn: rn, config: config, appID: appID, res: res, luaParts: make(map[int]string), got: make(map[string]bool)}
+		err := x.fetch(ctx, repo)
+		if err == nil {
+			res.SourceRepo = repo
+			x.markMissing(items)
+			return nil
+		}
+		if ctx.Err() != nil || len(x.got) > 0 || res.Lua > 0 || res.St > 0 {
+			// 已解压出部分文件时不再换仓库，避免同名文件重复计数
+			x.markMissing(items)
+			return err
+		}
+		if lastErr == nil || statusCode(err) != 404 {
+			lastErr = err
+		}
+		rn.debugf("%s 仓库 %s 的分支包不可用 (%v)", appID, repo, err)
+	}
+	return lastErr
+}
+
+// fetch 请求并解压单个仓库的分支包；读取中途出错时已解压的文件保留，计数以 res 为准
+func (x *archiveExtractor) fetch(ctx context.Context, repo string) error {
+	config, appID := x.config, x.appID
+	url := x.rn.archiveURL(repo, appID)
+	if err := x.rn.waitLimiter(ctx, url); err != nil {
+		return err
+	}
+	// 整个分支包比单个文件大得多，超时按整体运行的 ctx 控制
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	x.rn.setRequestHeaders(req)
+	tok := x.rn.authToken(config.Token)
+	if tok != "" {
+		req.Header.Set("Authorization", "token "+tok)
+	}
+	resp, err := x.rn.dl.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		se := &statusError{code: resp.StatusCode}
+		se.rateLimited = resp.StatusCode == 403 && resp.Header.Get("X-RateLimit-Remaining") == "0"
+		if (resp.StatusCode == 429 || se.rateLimited) && x.rn.tokens != nil && tok != "" {
+			x.rn.tokens.coolDown(tok, resp.Header.Get("X-RateLimit-Reset"))
+		}
+		return x.rn.filters.observe(url, resp, se)
+	}
+
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	defer x.finishLua()
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := x.extract(hdr.Name, tr); err != nil {
+			var de *diskError
+			if errors.As(err, &de) {
+				x.rn.abortIfDiskFull(err)
+				return err
+			}
+			// 单个条目无效 (路径穿越、校验失败) 只跳过该条目
+			x.rn.warnf("%s 分支包条目 %s 已跳过: %v", appID, hdr.Name, err)
+		}
+	}
+}
+
+// archiveExtractor 把分支包中的条目写到 LuaDir/ManifestDir 并更新 res
+type archiveExtractor struct {
+	rn       *run
+	config   Config
+	appID    string
+	res      *AppResult
+	luaParts map[int]string  // Lua 候选序号 -> 临时文件，解压完成后取优先级最高的一个
+	got      map[string]bool // 已解压或跳过的清单本地文件名
+}
+
+func (x *archiveExtractor) extract(entry string, r io.Reader) error {
+	name, err := archiveEntryName(entry)
+	if err != nil {
+		return err
+	}
+	ext := strings.ToLower(path.Ext(name))
+	wantLua := !x.config.ManifestOnly && x.config.LuaDir != "" && x.config.DirectMode
+
+	if wantLua && (ext == ".lua" || ext == ".st") {
+		matched := false
+		for i, c := range x.rn.luaCandidates(x.appID) {
+			// 归档条目已扁平化为文件名，lua/{appid}.lua 这类模板按文件名匹配
+			if name != path.Base(c) {
+				continue
+			}
+			tmp := fmt.Sprintf("%s.part%d", filepath.Join(x.config.LuaDir, scriptName(x.appID, x.rn.luaTemplates[i])), i)
+			if _, err := x.rn.writeArchiveFile(tmp, r); err != nil {
+				return err
+			}
+			x.luaParts[i] = tmp
+			matched = true
+		}
+		if matched || ext == ".lua" {
+			return nil
+		}
+	}
+
+	switch ext {
+	case ".vdf", ".st":
+		if ext == ".vdf" && wantKeys(x.config) && strings.EqualFold(name, "key.vdf") {
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			if keys, err := parseDepotKeys(data); err == nil {
+				x.res.Keys = keys
+				x.rn.recordKeys(keys)
+			}
+			r = bytes.NewReader(data)
+		}
+		if !wantLua {
+			return nil
+		}
+		destPath, err := safeJoin(x.config.LuaDir, name)
+		if err != nil {
+			return err
+		}
+		if prev, ok := x.rn.claimDestination(x.config.LuaDir, name); !ok {
+			x.res.Collisions = append(x.res.Collisions, x.rn.collisionNote(x.appID, name, prev))
+			return nil
+		}
+		if _, err = x.rn.writeArchiveFile(destPath, r); err != nil {
+			x.rn.releaseDestination(x.config.LuaDir, name)
+		}
+		return err
+	case ".manifest":
+		if x.config.ManifestDir == "" {
+			return nil
+		}
+		dir := appManifestDir(x.config, x.appID)
+		localName := manifestLocalName(name)
+		if x.got[localName] {
+			// 扁平化后与其它子目录中的文件同名，保留先出现的一个
+			return nil
+		}
+		destPath, err := safeJoin(dir, localName)
+		if err != nil {
+			return err
+		}
+		if prev, ok := x.rn.claimDestination(dir, localName); !ok {
+			x.res.Collisions = append(x.res.Collisions, x.rn.collisionNote(x.appID, localName, prev))
+			return nil
+		}
+		if x.config.SkipExisting && x.rn.fileIsUsable(destPath) {
+			x.got[localName] = true
+			x.res.Skipped++
+			x.res.existing = append(x.res.existing, manifestFileName(x.config, x.appID, localName))
+			return nil
+		}
+		d, err := x.rn.writeArchiveFile(destPath, r)
+		if err != nil {
+			x.rn.releaseDestination(dir, localName)
+			var ce *corruptError
+			if errors.As(err, &ce) {
+				x.res.InvalidFiles = append(x.res.InvalidFiles, localName)
+			}
+			return err
+		}
+		x.got[localName] = true
+		x.res.Manifest++
+		x.res.Files = append(x.res.Files, FileInfo{Name: manifestFileName(x.config, x.appID, localName), Size: d.Size, SHA256: d.SHA256})
+		if len(x.config.ManifestDirs) > 0 {
+			x.res.TargetErrors = append(x.res.TargetErrors, x.rn.fanOutFile(dir, localName, appManifestTargets(x.config, x.appID))...)
+		}
+		x.rn.emitEvent("file_done", map[string]interface{}{"app_id": x.appID, "file": localName, "bytes": d.Size})
+	}
+	return nil
+}
+
+// finishLua 把优先级最高的 Lua 候选重命名为 appID.lua (.st 模板为 appID.st)，删除其余临时文件
+func (x *archiveExtractor) finishLua() {
+	if len(x.luaParts) == 0 {
+		return
+	}
+	won := false
+	for i := range x.rn.luaCandidates(x.appID) {
+		tmp, ok := x.luaParts[i]
+		if !ok {
+			continue
+		}
+		if won {
+			x.rn.fsys.Remove(tmp)
+			continue
+		}
+		name := scriptName(x.appID, x.rn.luaTemplates[i])
+		if err := x.rn.fsys.Rename(tmp, filepath.Join(x.config.LuaDir, name)); err != nil {
+			x.rn.fsys.Remove(tmp)
+			continue
+		}
+		won = true
+		recordScript(x.res, x.rn.luaTemplates[i])
+		x.res.LuaBranch, x.res.LuaTemplate = x.appID, x.rn.luaTemplates[i]
+		x.rn.emitEvent("file_done", map[string]interface{}{"app_id": x.appID, "file": name})
+	}
+}
+
+// writeArchiveFile 把一个 tar 条目经同目录的临时文件写入 destPath，校验失败时保留原有文件；清单文件同样经过 steam-safe 校验
+func (rn *run) writeArchiveFile(destPath string, r io.Reader) (download, error) {
+	if err := rn.fsys.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return download{}, &diskError{err}
+	}
+	safe := rn.useSteamSafe(destPath)
+	writePath := tempPath(destPath)
+	out, err := rn.fsys.Create(writePath)
+	if err != nil {
+		return download{}, &diskError{err}
+	}
+	hasher := sha256.New()
+	head := &headWriter{}
+	dw := &diskWriter{w: out}
+	n, err := io.Copy(io.MultiWriter(dw, hasher, head), r)
+	if safe && err == nil {
+		if serr := out.Sync(); serr != nil {
+			err = &diskError{serr}
+		}
+	}
+	if cerr := out.Close(); cerr != nil && err == nil {
+		err = &diskError{cerr}
+	}
+	if dw.err != nil {
+		err = &diskError{dw.err}
+	}
+	if err == nil {
+		err = rn.checkVanished(writePath)
+	}
+	if err == nil && isManifestPath(destPath) {
+		err = rn.validateManifest(n, 0, head.head, safe)
+	}
+	if err == nil {
+		err = rn.renameTemp(writePath, destPath)
+	}
+	if err != nil {
+		rn.fsys.Remove(writePath)
+		return download{}, err
+	}
+	atomic.AddInt64(&rn.totalBytes, n)
+	return download{Size: n, SHA256: hex.EncodeToString(hasher.Sum(nil))}, nil
+}
+
+// markMissing 把 app_data 中请求了但分支包里没有的条目记入 FailedManifests
+func (x *archiveExtractor) markMissing(items []string) {
+	for _, item := range items {
+		found := false
+		for _, oname := range x.rn.manifestCandidates(x.appID, item) {
+			if x.got[manifestLocalName(oname)] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			x.res.FailedManifests = append(x.res.FailedManifests, item)
+		}
+	}
+}
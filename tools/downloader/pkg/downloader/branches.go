@@ -0,0 +1,126 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BRANCH_DETECT_TIMEOUT 是查询仓库默认分支的总时限 (秒)，API 不可达时不拖慢整个运行
+const BRANCH_DETECT_TIMEOUT = 10
+
+// manifestBranches 返回清单在 repo 中的分支探测顺序：配置了 branches 时按原样使用
+// (use_app_branch 为 true 时在前面加上 appID 分支)，否则为 appID、仓库默认分支、main、master
+func (rn *run) manifestBranches(repo, appID string) []string {
+	if len(rn.branches) == 0 {
+		out := []string{appID}
+		for _, b := range []string{rn.defaultBranches[repo], "main", "master"} {
+			if b != "" && !containsString(out, b) {
+				out = append(out, b)
+			}
+		}
+		return out
+	}
+	if !rn.useAppBranch {
+		return rn.branches
+	}
+	out := []string{appID}
+	for _, b := range rn.branches {
+		if b != appID {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// detectDefaultBranches 通过 API 并发查询每个仓库的默认分支并加入探测顺序。
+// 配置了 branches 或 disable_branch_detect 时不查询；查询失败只记录调试日志，按原顺序探测。
+func (rn *run) detectDefaultBranches(ctx context.Context, config Config) {
+	if len(rn.branches) > 0 || config.DisableBranchDetect {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, BRANCH_DETECT_TIMEOUT*time.Second)
+	defer cancel()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, repo := range config.Repos {
+		wg.Add(1)
+		go func(repo string) {
+			defer wg.Done()
+			b, err := rn.fetchDefaultBranch(ctx, config.Token, repo)
+			if err != nil {
+				rn.debugf("仓库 %s 默认分支查询失败: %v", repo, err)
+				return
+			}
+			rn.debugf("仓库 %s 默认分支: %s", repo, b)
+			mu.Lock()
+			rn.defaultBranches[repo] = b
+			mu.Unlock()
+		}(repo)
+	}
+	wg.Wait()
+}
+
+// fetchDefaultBranch 请求仓库元数据并返回 default_branch
+func (rn *run) fetchDefaultBranch(ctx context.Context, token, repo string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", rn.apiBase+"/repos/"+repo, nil)
+	if err != nil {
+		return "", err
+	}
+	rn.setRequestHeaders(req)
+	if token != "" {
+		req.Header.Set("Authorization", "token "+rn.authToken(token))
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := rn.dl.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", &statusError{code: resp.StatusCode}
+	}
+	var info struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return "", err
+	}
+	if info.DefaultBranch == "" {
+		return "", errors.New("响应中没有 default_branch")
+	}
+	return info.DefaultBranch, nil
+}
+
+func deadBranchKey(repo, branch, appID string) string {
+	return repo + "\x00" + branch + "\x00" + appID
+}
+
+func (rn *run) branchDead(repo, branch, appID string) bool {
+	rn.deadBranches.Lock()
+	defer rn.deadBranches.Unlock()
+	return rn.deadBranches.m[deadBranchKey(repo, branch, appID)]
+}
+
+func (rn *run) markBranchesDead(repo, appID string, list []string) {
+	if len(list) == 0 {
+		return
+	}
+	rn.deadBranches.Lock()
+	defer rn.deadBranches.Unlock()
+	for _, b := range list {
+		rn.deadBranches.m[deadBranchKey(repo, b, appID)] = true
+	}
+}
@@ -0,0 +1,108 @@
+package downloader
+
+import (
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestManifestBranches(t *testing.T) {
+	tests := []struct {
+		name         string
+		branches     []string
+		useAppBranch bool
+		detected     string // 查询到的默认分支
+		want         []string
+	}{
+		{"default", nil, false, "", []string{"10", "main", "master"}},
+		{"default with detected branch", nil, false, "dev", []string{"10", "dev", "main", "master"}},
+		{"detected branch already listed", nil, false, "master", []string{"10", "master", "main"}},
+		{"custom verbatim", []string{"release", "2024-01"}, false, "", []string{"release", "2024-01"}},
+		{"custom ignores detection", []string{"release"}, false, "dev", []string{"release"}},
+		{"custom with app branch", []string{"release", "main"}, true, "", []string{"10", "release", "main"}},
+		{"app branch not repeated", []string{"main", "10"}, true, "", []string{"10", "main"}},
+	}
+	for _, tt := range tests {
+		rn := newRun()
+		rn.branches, rn.useAppBranch = tt.branches, tt.useAppBranch
+		if tt.detected != "" {
+			rn.defaultBranches["a/b"] = tt.detected
+		}
+		if got := rn.manifestBranches("a/b", "10"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: branches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRunBranches(t *testing.T) {
+	tests := []struct {
+		name         string
+		branch       string // 清单所在分支
+		branches     []string
+		useAppBranch bool
+		detect       bool
+		want         []string // 探测清单的分支 (按出现的请求)
+	}{
+		{"default", "master", nil, false, false, []string{"10", "main", "master"}},
+		{"custom", "release", []string{"release"}, false, false, []string{"release"}},
+		{"custom with app branch", "release", []string{"release"}, true, false, []string{"10", "release"}},
+		{"detected default branch", "dev", nil, false, true, []string{"10", "dev"}},
+		// 配置了 branches 时不查询默认分支
+		{"custom skips detection", "release", []string{" release ", ""}, false, true, []string{"release"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepo(t, map[string]string{
+				"a/b/10/10.lua":                        "-- 10",
+				"a/b/" + tt.branch + "/11_22.manifest": testManifest,
+			})
+			r.handle("repos/a/b", func(w http.ResponseWriter, _ *http.Request) {
+				io.WriteString(w, `{"default_branch":"dev"}`)
+			})
+			cfg := testConfig(t, map[string][]string{"10": {"11_22"}})
+			cfg.Branches, cfg.UseAppBranch = tt.branches, tt.useAppBranch
+			cfg.DisableBranchDetect = !tt.detect
+			res := r.download(t, cfg)
+			if res.Summary.Manifest != 1 {
+				t.Fatalf("summary = %+v", res.Summary)
+			}
+			if got := r.count("repos/a/b"); (got > 0) != (tt.detect && len(tt.branches) == 0) {
+				t.Errorf("default branch queried %d times", got)
+			}
+			// 只探测期望的分支，且在找到清单后停止
+			var probed []string
+			for _, b := range []string{"10", "main", "master", "dev", "release"} {
+				r.mu.Lock()
+				for p := range r.hits {
+					if strings.HasPrefix(p, "a/b/"+b+"/") && strings.Contains(p, "22") {
+						probed = append(probed, b)
+						break
+					}
+				}
+				r.mu.Unlock()
+			}
+			if !sameSet(probed, tt.want) {
+				t.Errorf("probed branches %v, want %v", probed, tt.want)
+			}
+		})
+	}
+}
+
+// sameSet 判断两个字符串列表包含的元素是否相同 (不计顺序)
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := map[string]bool{}
+	for _, s := range a {
+		seen[s] = true
+	}
+	for _, s := range b {
+		if !seen[s] {
+			return false
+		}
+	}
+	return true
+}
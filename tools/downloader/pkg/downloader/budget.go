@@ -0,0 +1,152 @@
+package downloader
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// SourceBudget 是一个下载源在本次运行中可用的请求数与流量 (source_budgets)，<= 0 的字段不限制
+type SourceBudget struct {
+	MaxRequests int64 `json:"max_requests"`
+	MaxBytesMB  int64 `json:"max_bytes_mb"`
+}
+
+// BudgetUsage 是一个下载源相对预算的实际用量，写入结果的 summary.source_budgets
+type BudgetUsage struct {
+	Requests    int64   `json:"requests"`
+	MaxRequests int64   `json:"max_requests,omitempty"`
+	BytesMB     float64 `json:"bytes_mb"`
+	MaxBytesMB  int64   `json:"max_bytes_mb,omitempty"`
+	Exhausted   bool    `json:"exhausted,omitempty"` // 运行中用完预算，之后不再使用该源
+}
+
+// sourceBudget 是一个源的预算与计数，所有字段以原子操作更新
+type sourceBudget struct {
+	rn        *run
+	host      string
+	limit     SourceBudget
+	requests  int64
+	bytes     int64
+	exhausted int32
+}
+
+// budgetError 表示请求因源的预算用完而没有发出。它按源失败处理 (换下一个源)，不重试；
+// host 为空表示全部源都已用完
+type budgetError struct {
+	host string
+}
+
+func (e *budgetError) Error() string {
+	if e.host == "" {
+		return "全部下载源的 source_budgets 已用完"
+	}
+	return "下载源 " + e.host + " 的 source_budgets 已用完"
+}
+
+// newBudgets 校验 source_budgets 并建立预算表；键可以是主机名或完整地址
+func (rn *run) newBudgets(m map[string]SourceBudget) (map[string]*sourceBudget, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]*sourceBudget, len(m))
+	for k, b := range m {
+		host := urlHost(k)
+		if host == "" {
+			return nil, fmt.Errorf("%q 不是有效的主机名", k)
+		}
+		if b.MaxRequests <= 0 && b.MaxBytesMB <= 0 {
+			return nil, fmt.Errorf("%s 没有设置 max_requests 或 max_bytes_mb", k)
+		}
+		out[host] = &sourceBudget{rn: rn, host: host, limit: b}
+	}
+	return out, nil
+}
+
+// urlHost 返回地址 (或模板、裸主机名) 中的主机部分，小写并保留端口
+func urlHost(raw string) string {
+	s := strings.TrimSpace(raw)
+	if i := strings.Index(s, "://"); i >= 0 {
+		s = s[i+3:]
+	}
+	if i := strings.IndexAny(s, "/?#"); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		s = s[i+1:]
+	}
+	return strings.ToLower(s)
+}
+
+// budgetFor 返回地址所属源的预算，没有配置时返回 nil；带端口的地址也匹配只写了主机名的键
+func (rn *run) budgetFor(rawURL string) *sourceBudget {
+	if rn.budgets == nil {
+		return nil
+	}
+	host := urlHost(rawURL)
+	if b, ok := rn.budgets[host]; ok {
+		return b
+	}
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.Contains(host[i:], "]") {
+		return rn.budgets[host[:i]]
+	}
+	return nil
+}
+
+// spent 判断预算是否已用完
+func (b *sourceBudget) spent() bool {
+	return b != nil && atomic.LoadInt32(&b.exhausted) == 1
+}
+
+// acquire 为一次请求占用预算：已用完或本次请求会超出 max_requests 时返回 budgetError
+func (b *sourceBudget) acquire() error {
+	if b == nil {
+		return nil
+	}
+	if b.spent() {
+		return &budgetError{b.host}
+	}
+	if n := atomic.AddInt64(&b.requests, 1); b.limit.MaxRequests > 0 && n > b.limit.MaxRequests {
+		atomic.AddInt64(&b.requests, -1)
+		b.exhaust(fmt.Sprintf("已达到 max_requests %d", b.limit.MaxRequests))
+		return &budgetError{b.host}
+	}
+	return nil
+}
+
+// consume 记录成功下载的字节数，达到 max_bytes_mb 后停用该源 (正在进行的下载照常完成)
+func (b *sourceBudget) consume(n int64) {
+	if b == nil {
+		return
+	}
+	total := atomic.AddInt64(&b.bytes, n)
+	if b.limit.MaxBytesMB > 0 && total >= b.limit.MaxBytesMB<<20 {
+		b.exhaust(fmt.Sprintf("已达到 max_bytes_mb %d", b.limit.MaxBytesMB))
+	}
+}
+
+// exhaust 停用该源，只警告一次
+func (b *sourceBudget) exhaust(reason string) {
+	if atomic.CompareAndSwapInt32(&b.exhausted, 0, 1) {
+		b.rn.warnf("下载源 %s 的 source_budgets %s，本次运行剩余部分不再使用该源", b.host, reason)
+		b.rn.emitEvent("budget_exhausted", map[string]interface{}{"source": b.host, "reason": reason})
+	}
+}
+
+// budgetUsage 返回每个预算的用量，没有配置 source_budgets 时返回 nil
+func (rn *run) budgetUsage() map[string]BudgetUsage {
+	if len(rn.budgets) == 0 {
+		return nil
+	}
+	out := make(map[string]BudgetUsage, len(rn.budgets))
+	for h, b := range rn.budgets {
+		out[h] = BudgetUsage{
+			Requests:    atomic.LoadInt64(&b.requests),
+			MaxRequests: b.limit.MaxRequests,
+			BytesMB:     float64(atomic.LoadInt64(&b.bytes)) / (1 << 20),
+			MaxBytesMB:  b.limit.MaxBytesMB,
+			Exhausted:   b.spent(),
+		}
+	}
+	return out
+}
@@ -0,0 +1,54 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// 本文件与 e2e_test.go 都不带构建标签，go test -tags slim 时同样运行：
+// 精简版必须以 unsupported_in_this_build 拒绝被裁掉的选项，完整版必须接受它们
+func TestBuildVariantOptions(t *testing.T) {
+	tests := []struct {
+		field string
+		set   func(cfg *Config)
+	}{
+		{"branch_archive", func(cfg *Config) { cfg.BranchArchive = true }},
+		{"bundle_dir", func(cfg *Config) { cfg.BundleDir = filepath.Join(cfg.LuaDir, "..", "bundles") }},
+		// 精简版不做任何 Steam appinfo 查询
+		{"resolve_depots", func(cfg *Config) { cfg.ResolveDepots = true }},
+		{"include_dlc", func(cfg *Config) { cfg.IncludeDLC = true }},
+		{"steam_info_url", func(cfg *Config) { cfg.SteamInfoURL = "http://steam.test/{appid}" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			dl := newMemDownloader(map[string]string{"a/b/10/10.lua": "-- lua"})
+			cfg := testConfig(t, map[string][]string{"10": nil})
+			tt.set(&cfg)
+			_, err := memClient(dl, newMemFS()).Run(context.Background(), cfg)
+			var ce *ConfigError
+			unsupported := errors.As(err, &ce) && ce.Code == CODE_UNSUPPORTED
+			if unsupported != SLIM_BUILD {
+				t.Fatalf("Run() = %v, want unsupported_in_this_build only in slim build (slim %v)", err, SLIM_BUILD)
+			}
+			if unsupported && ce.Field != tt.field {
+				t.Errorf("Field = %q, want %q", ce.Field, tt.field)
+			}
+			if unsupported && dl.requests() != 0 {
+				t.Errorf("rejected config still sent %d requests", dl.requests())
+			}
+		})
+	}
+}
+
+func TestSlimRejectsDoctor(t *testing.T) {
+	if !SLIM_BUILD {
+		t.Skip("完整版的 doctor 需要真实网络")
+	}
+	_, err := (&Client{Downloader: newMemDownloader(nil), FileSystem: newMemFS()}).Doctor(Config{})
+	var ce *ConfigError
+	if !errors.As(err, &ce) || ce.Code != CODE_UNSUPPORTED || ce.Field != "doctor" {
+		t.Errorf("Doctor() = %v, want unsupported_in_this_build for doctor", err)
+	}
+}
@@ -0,0 +1,216 @@
+//go:build !slim
+
+package downloader
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BUNDLE_QUEUE_SIZE 是等待打包的 App 数上限，队列满时 worker 才会等待打包完成
+const BUNDLE_QUEUE_SIZE = 64
+
+// bundleManifest 是打包中 manifest.json 的内容，描述包内每个文件
+type bundleManifest struct {
+	AppID   string     `json:"app_id"`
+	Name    string     `json:"name,omitempty"`
+	Created string     `json:"created"`
+	Files   []FileInfo `json:"files"`
+}
+
+// bundleFile 是要写入打包的一个文件：来自磁盘 (path) 或内存 (data)
+type bundleFile struct {
+	name string
+	path string
+	data []byte
+}
+
+func (f bundleFile) copyTo(fsys FileSystem, w io.Writer) (int64, error) {
+	if f.path == "" {
+		n, err := w.Write(f.data)
+		return int64(n), err
+	}
+	in, err := fsys.Open(f.path)
+	if err != nil {
+		return 0, &diskError{err}
+	}
+	defer in.Close()
+	return io.Copy(w, in)
+}
+
+// bundler 是打包写入器：成功的 App 交给单独的协程打成 <appid>_<name>.zip，不阻塞下载。
+// 打包失败只记为警告。
+type bundler struct {
+	rn       *run
+	config   Config
+	jobs     chan AppResult
+	done     chan struct{}
+	mu       sync.Mutex
+	warnings []string
+}
+
+func (rn *run) newBundler(config Config) *bundler {
+	b := &bundler{rn: rn, config: config, jobs: make(chan AppResult, BUNDLE_QUEUE_SIZE), done: make(chan struct{})}
+	go func() {
+		defer close(b.done)
+		for res := range b.jobs {
+			if err := b.write(res); err != nil {
+				rn.warnf("%s 打包失败: %v", res.AppID, err)
+				b.mu.Lock()
+				b.warnings = append(b.warnings, fmt.Sprintf("bundle_dir: %s: %v", res.AppID, err))
+				b.mu.Unlock()
+			}
+		}
+	}()
+	return b
+}
+
+// add 提交一个处理完成的 App，没有拿到任何文件的 App 不打包
+func (b *bundler) add(res AppResult) {
+	if appFailed(res) {
+		return
+	}
+	b.jobs <- res
+}
+
+// wait 等待所有打包完成并返回期间的警告
+func (b *bundler) wait() []string {
+	close(b.jobs)
+	<-b.done
+	return b.warnings
+}
+
+// bundleName 返回打包文件名，app_names 中有名称时附加在 AppID 后 (去掉文件名中的非法字符)
+func bundleName(appID, name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, " .")
+	if name == "" {
+		return appID + ".zip"
+	}
+	return appID + "_" + name + ".zip"
+}
+
+// files 列出 App 的 Lua (或 .st)、清单 (包括本次跳过的已有文件) 以及由 Keys 生成的 key.vdf
+func (b *bundler) files(res AppResult) []bundleFile {
+	config := b.config
+	var out []bundleFile
+	if config.LuaDir != "" {
+		names := b.rn.scriptNames(res.AppID)
+		if name := savedScript(res); name != "" {
+			names = []string{name}
+		}
+		for _, name := range names {
+			if p := filepath.Join(config.LuaDir, name); b.rn.fileIsUsable(p) {
+				out = append(out, bundleFile{name: name, path: p})
+				break
+			}
+		}
+	}
+	if config.ManifestDir != "" {
+		seen := make(map[string]bool)
+		add := func(name string) {
+			p := filepath.Join(appManifestDir(config, res.AppID), name)
+			if !seen[name] && b.rn.fileIsUsable(p) {
+				seen[name] = true
+				out = append(out, bundleFile{name: name, path: p})
+			}
+		}
+		for _, f := range res.Files {
+			add(path.Base(f.Name))
+		}
+		for _, item := range config.AppData[res.AppID] {
+			for _, c := range b.rn.manifestCandidates(res.AppID, item) {
+				add(manifestLocalName(c))
+			}
+		}
+	}
+	if len(res.Keys) > 0 {
+		var sb strings.Builder
+		sb.WriteString("\"depots\"\n{\n")
+		for _, id := range sortedKeys(res.Keys) {
+			sb.WriteString(depotBlock(id, res.Keys[id], "\t", "\n"))
+		}
+		sb.WriteString("}\n")
+		out = append(out, bundleFile{name: "key.vdf", data: []byte(sb.String())})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].name < out[j].name })
+	return out
+}
+
+// write 先写入临时文件，完整写完后再替换已有的打包，失败时保留旧文件
+func (b *bundler) write(res AppResult) error {
+	dir := b.config.BundleDir
+	if err := b.rn.fsys.MkdirAll(dir, 0755); err != nil {
+		return &diskError{err}
+	}
+	dest := filepath.Join(dir, bundleName(res.AppID, b.config.AppNames[res.AppID]))
+	tmp := dest + ".tmp"
+	out, err := b.rn.fsys.Create(tmp)
+	if err != nil {
+		return &diskError{err}
+	}
+	err = writeBundle(b.rn.fsys, out, res.AppID, b.config.AppNames[res.AppID], b.files(res))
+	if cerr := out.Close(); cerr != nil && err == nil {
+		err = &diskError{cerr}
+	}
+	if err == nil {
+		if rerr := b.rn.renameWithRetry(tmp, dest); rerr != nil {
+			err = &diskError{rerr}
+		}
+	}
+	if err != nil {
+		b.rn.fsys.Remove(tmp)
+		return err
+	}
+	b.rn.debugf("%s 已打包到 %s", res.AppID, dest)
+	return nil
+}
+
+// writeBundle 把 files 与描述它们的 manifest.json 写成 zip
+func writeBundle(fsys FileSystem, w io.Writer, appID, name string, files []bundleFile) error {
+	zw := zip.NewWriter(w)
+	now := time.Now()
+	create := func(name string) (io.Writer, error) {
+		return zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+	}
+	desc := bundleManifest{AppID: appID, Name: name, Created: now.UTC().Format(time.RFC3339), Files: []FileInfo{}}
+	for _, f := range files {
+		fw, err := create(f.name)
+		if err != nil {
+			return err
+		}
+		hasher := sha256.New()
+		n, err := f.copyTo(fsys, io.MultiWriter(fw, hasher))
+		if err != nil {
+			return err
+		}
+		desc.Files = append(desc.Files, FileInfo{Name: f.name, Size: n, SHA256: hex.EncodeToString(hasher.Sum(nil))})
+	}
+	data, err := json.MarshalIndent(desc, "", "  ")
+	if err != nil {
+		return err
+	}
+	fw, err := create("manifest.json")
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
@@ -0,0 +1,59 @@
+package downloader
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBundleDir(t *testing.T) {
+	if SLIM_BUILD {
+		t.Skip("bundle_dir 不在精简版中")
+	}
+	r := newTestRepo(t, map[string]string{"a/b/10/10.lua": "-- 10", "a/b/10/11_22.manifest": testManifest})
+	cfg := testConfig(t, map[string][]string{"10": {"11_22"}})
+	cfg.BundleDir = filepath.Join(t.TempDir(), "bundles")
+	cfg.AppNames = map[string]string{"10": "Half: Life"}
+	res := r.download(t, cfg)
+	if len(res.Warnings) != 0 {
+		t.Errorf("warnings = %v", res.Warnings)
+	}
+	zr, err := zip.OpenReader(filepath.Join(cfg.BundleDir, "10_Half_ Life.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, ",") != "10.lua,11_22.manifest,manifest.json" {
+		t.Errorf("bundle entries = %v", names)
+	}
+}
+
+func TestBundleWarningsWithLeakCheck(t *testing.T) {
+	if SLIM_BUILD {
+		t.Skip("bundle_dir 不在精简版中")
+	}
+	r := newTestRepo(t, map[string]string{"a/b/10/10.lua": "-- 10"})
+	for _, leakCheck := range []bool{false, true} {
+		cfg := testConfig(t, map[string][]string{"10": nil})
+		// bundle_dir 是一个普通文件，打包必然失败
+		cfg.BundleDir = filepath.Join(t.TempDir(), "bundles")
+		if err := os.WriteFile(cfg.BundleDir, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		cfg.LeakCheck = leakCheck
+		res := r.download(t, cfg)
+		found := false
+		for _, w := range res.Warnings {
+			found = found || strings.HasPrefix(w, "bundle_dir: 10:")
+		}
+		if !found {
+			t.Errorf("leak_check %v: warnings = %v, want bundle_dir warning", leakCheck, res.Warnings)
+		}
+	}
+}
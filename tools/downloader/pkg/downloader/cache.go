@@ -0,0 +1,138 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sync"
+)
+
+// CACHE_FILE_NAME 是保存在 ManifestDir 中的 ETag 索引文件名
+const CACHE_FILE_NAME = ".downloader_cache.json"
+
+// cacheEntry 记录某个本地文件最后一次下载时的来源 URL 与 ETag；
+// Lua 还记录来源仓库、分支与模板，304 时原样写入结果
+type cacheEntry struct {
+	URL  string `json:"url"`
+	ETag string `json:"etag"`
+
+	Repo     string `json:"repo,omitempty"`
+	Branch   string `json:"branch,omitempty"`
+	Template string `json:"template,omitempty"`
+}
+
+// etagCache 是本地文件名 -> cacheEntry 的索引，供 verify_existing / conditional_sync 做条件请求
+type etagCache struct {
+	rn      *run
+	mu      sync.Mutex
+	path    string
+	entries map[string]cacheEntry
+	dirty   bool
+}
+
+// loadETagCache 读取 dir 下的索引文件；文件不存在或损坏时返回空索引
+func (rn *run) loadETagCache(dir string) *etagCache {
+	c := &etagCache{
+		rn:      rn,
+		path:    filepath.Join(dir, CACHE_FILE_NAME),
+		entries: make(map[string]cacheEntry),
+	}
+	data, err := rn.fsys.ReadFile(c.path)
+	if err != nil {
+		return c
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		c.entries = make(map[string]cacheEntry)
+	}
+	return c
+}
+
+func (c *etagCache) get(name string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[name]
+	return e, ok
+}
+
+func (c *etagCache) set(name, url, etag string) {
+	c.setEntry(name, cacheEntry{URL: url, ETag: etag})
+}
+
+// setEntry 记录 name 的完整条目，没有 ETag 时不记录
+func (c *etagCache) setEntry(name string, e cacheEntry) {
+	if e.ETag == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[name] = e
+	c.dirty = true
+}
+
+// revalidateLua 是 conditional_sync 对已有 Lua 的条件请求：向上次下载的地址发送 If-None-Match，
+// 304 时沿用本地文件，200 时替换并记录新 ETag。没有记录、本地文件不可用或请求失败时返回 false，按正常流程查找。
+// appID.lua 与 appID.st 都有记录时按 prefer 的顺序取第一个
+func (rn *run) revalidateLua(ctx context.Context, config Config, appID string) (luaHit, bool) {
+	var name, dest string
+	var entry cacheEntry
+	found := false
+	for _, n := range rn.scriptNames(appID) {
+		if e, ok := rn.luaETags.get(n); ok && rn.fileIsUsable(filepath.Join(config.LuaDir, n)) {
+			name, dest, entry, found = n, filepath.Join(config.LuaDir, n), e, true
+			break
+		}
+	}
+	if !found {
+		return luaHit{}, false
+	}
+	hit := luaHit{download: download{Repo: entry.Repo, URL: entry.URL, ETag: entry.ETag}, branch: entry.Branch, template: entry.Template}
+	tmp := rn.stagingPath(dest, ".part")
+	d, err := rn.downloadFileWithRetry(ctx, entry.URL, tmp, config.Token, entry.ETag)
+	if errors.Is(err, errNotModified) {
+		rn.debugf("%s Lua 未变更 (304)，沿用本地文件", appID)
+		hit.unchanged = true
+		return hit, true
+	}
+	if err == nil {
+		err = rn.commitFile(ctx, tmp, dest)
+	}
+	if err != nil {
+		rn.fsys.Remove(tmp)
+		rn.debugf("%s Lua 条件请求失败，重新查找: %v", appID, err)
+		return luaHit{}, false
+	}
+	d.Repo = entry.Repo
+	hit.download = d
+	rn.luaETags.setEntry(name, cacheEntry{URL: d.URL, ETag: d.ETag, Repo: entry.Repo, Branch: entry.Branch, Template: entry.Template})
+	rn.verbosef("%s Lua 已更新 (%s)", appID, d.URL)
+	return hit, true
+}
+
+// save 仅在有变更时写回索引 (先写临时文件再重命名，避免中途崩溃损坏索引)
+func (c *etagCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := c.rn.fsys.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := c.rn.fsys.Rename(tmp, c.path); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// fileIsUsable 判断目标文件存在且非空 (远程目标查询其存储)；0 字节文件视为上次崩溃的残留
+func (rn *run) fileIsUsable(path string) bool {
+	info, err := rn.statStored(path)
+	return err == nil && info.Size > 0
+}
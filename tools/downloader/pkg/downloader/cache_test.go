@@ -0,0 +1,82 @@
+package downloader
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// etagServer 让 path 按 ETag 响应：If-None-Match 与当前 ETag 相同时返回 304
+type etagServer struct {
+	mu          sync.Mutex
+	etag        string
+	body        string
+	conditional int // 收到的条件请求数
+}
+
+func (s *etagServer) set(etag, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.etag, s.body = etag, body
+}
+
+func (s *etagServer) serve(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	etag, body := s.etag, s.body
+	if req.Header.Get("If-None-Match") != "" {
+		s.conditional++
+	}
+	s.mu.Unlock()
+	w.Header().Set("ETag", etag)
+	if req.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	io.WriteString(w, body)
+}
+
+func TestConditionalSync(t *testing.T) {
+	r := newTestRepo(t, nil)
+	manifest := &etagServer{etag: `"m1"`, body: testManifest}
+	lua := &etagServer{etag: `"l1"`, body: "-- v1"}
+	r.handle("a/b/10/11_22.manifest", manifest.serve)
+	r.handle("a/b/10/10.lua", lua.serve)
+	cfg := testConfig(t, map[string][]string{"10": {"11_22"}})
+	cfg.ConditionalSync = true
+	luaPath := filepath.Join(cfg.LuaDir, "10.lua")
+
+	res := r.download(t, cfg)
+	if res.Summary.Lua != 1 || res.Summary.Manifest != 1 || res.Summary.NotModified != 0 {
+		t.Fatalf("first run summary = %+v", res.Summary)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.ManifestDir, CACHE_FILE_NAME)); err != nil {
+		t.Errorf("manifest ETag index not written: %v", err)
+	}
+
+	// 未变更：两个文件都发送 If-None-Match 并收到 304，本地文件保持不变
+	res = r.download(t, cfg)
+	if manifest.conditional != 1 || lua.conditional != 1 {
+		t.Errorf("conditional requests: manifest %d, lua %d; want 1 each", manifest.conditional, lua.conditional)
+	}
+	if res.Summary.NotModified == 0 {
+		t.Errorf("second run summary = %+v, want not_modified", res.Summary)
+	}
+	if data, _ := os.ReadFile(luaPath); string(data) != "-- v1" {
+		t.Errorf("10.lua = %q after 304", data)
+	}
+
+	// 上游更新：200 替换本地文件并记录新 ETag，之后再次命中 304
+	lua.set(`"l2"`, "-- v2")
+	r.download(t, cfg)
+	if data, _ := os.ReadFile(luaPath); string(data) != "-- v2" {
+		t.Errorf("10.lua = %q, want updated content", data)
+	}
+	before := lua.conditional
+	r.download(t, cfg)
+	if data, _ := os.ReadFile(luaPath); string(data) != "-- v2" || lua.conditional != before+1 {
+		t.Errorf("10.lua = %q after revalidating the new ETag", data)
+	}
+}
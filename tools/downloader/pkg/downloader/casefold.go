@@ -0,0 +1,94 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// destClaim 是一个目标文件的占用者：首个写入的文件名与仍在使用它的写入数
+type destClaim struct {
+	name string
+	n    int
+}
+
+// probeCaseInsensitive 在 dir 中创建小写名的探测文件，再用大写名查找；
+// 无法写入探测文件或 dry_run 时按操作系统的默认行为判断
+func (rn *run) probeCaseInsensitive(dir string) bool {
+	name := filepath.Join(dir, fmt.Sprintf(".casecheck-%d", os.Getpid()))
+	if !rn.dryRun && rn.fsys.MkdirAll(dir, 0755) == nil {
+		if f, err := rn.fsys.Create(name); err == nil {
+			f.Close()
+			defer rn.fsys.Remove(name)
+			_, err := rn.fsys.Stat(filepath.Join(dir, strings.ToUpper(filepath.Base(name))))
+			return err == nil
+		}
+	}
+	return runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+}
+
+// dirCaseInsensitive 返回 dir 是否不区分大小写 (带缓存)
+func (rn *run) dirCaseInsensitive(dir string) bool {
+	dir = filepath.Clean(dir)
+	rn.caseDirs.Lock()
+	defer rn.caseDirs.Unlock()
+	v, ok := rn.caseDirs.m[dir]
+	if !ok && rn.remoteStorage(dir) != nil {
+		// 对象存储与 WebDAV 的路径按原样区分大小写，也不在本地写探测文件
+		ok = true
+		rn.caseDirs.m[dir] = false
+	}
+	if !ok {
+		v = rn.caseProbe(dir)
+		rn.caseDirs.m[dir] = v
+		if v {
+			rn.debugf("%s 不区分大小写，按大小写无关的文件名检测冲突", dir)
+		}
+	}
+	return v
+}
+
+// destKey 返回 dir 下 name 对应的目标文件键；目录不区分大小写时文件名统一转为小写
+func (rn *run) destKey(dir, name string) string {
+	if rn.dirCaseInsensitive(dir) {
+		name = strings.ToLower(name)
+	}
+	return filepath.Clean(dir) + string(filepath.Separator) + name
+}
+
+// claimDestination 在写入 dir/name 前占用目标文件。目标已被只有大小写不同的文件名占用时
+// 返回占用者的文件名与 false，调用方不应写入；同名的重复占用视为同一文件，照常返回 true
+func (rn *run) claimDestination(dir, name string) (string, bool) {
+	key := rn.destKey(dir, name)
+	rn.destinations.Lock()
+	defer rn.destinations.Unlock()
+	if c, ok := rn.destinations.m[key]; ok {
+		if c.name != name {
+			return c.name, false
+		}
+		c.n++
+		return "", true
+	}
+	rn.destinations.m[key] = &destClaim{name: name, n: 1}
+	return "", true
+}
+
+// releaseDestination 在写入失败后释放 claimDestination 的占用
+func (rn *run) releaseDestination(dir, name string) {
+	key := rn.destKey(dir, name)
+	rn.destinations.Lock()
+	defer rn.destinations.Unlock()
+	if c, ok := rn.destinations.m[key]; ok && c.name == name {
+		if c.n--; c.n <= 0 {
+			delete(rn.destinations.m, key)
+		}
+	}
+}
+
+// collisionNote 返回 AppResult.Collisions 中的一条记录并输出警告
+func (rn *run) collisionNote(appID, name, existing string) string {
+	rn.warnf("%s 的 %s 与已写入的 %s 只有大小写不同，在不区分大小写的文件系统上是同一个文件，已保留先写入的文件", appID, name, existing)
+	return name + " -> " + existing
+}
@@ -0,0 +1,137 @@
+package downloader
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// foldFS 模拟不区分大小写的文件系统 (Windows、macOS 默认)：全部路径按小写存取
+type foldFS struct{ *memFS }
+
+func fold(name string) string { return strings.ToLower(name) }
+
+func (f foldFS) MkdirAll(path string, perm fs.FileMode) error {
+	return f.memFS.MkdirAll(fold(path), perm)
+}
+func (f foldFS) Create(name string) (File, error) { return f.memFS.Create(fold(name)) }
+func (f foldFS) CreateTemp(dir, pattern string) (File, error) {
+	return f.memFS.CreateTemp(fold(dir), fold(pattern))
+}
+func (f foldFS) Open(name string) (File, error) { return f.memFS.Open(fold(name)) }
+func (f foldFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	return f.memFS.OpenFile(fold(name), flag, perm)
+}
+func (f foldFS) ReadFile(name string) ([]byte, error) { return f.memFS.ReadFile(fold(name)) }
+func (f foldFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return f.memFS.WriteFile(fold(name), data, perm)
+}
+func (f foldFS) ReadDir(name string) ([]fs.DirEntry, error) { return f.memFS.ReadDir(fold(name)) }
+func (f foldFS) Link(oldname, newname string) error {
+	return f.memFS.Link(fold(oldname), fold(newname))
+}
+func (f foldFS) Rename(oldpath, newpath string) error {
+	return f.memFS.Rename(fold(oldpath), fold(newpath))
+}
+func (f foldFS) Remove(name string) error              { return f.memFS.Remove(fold(name)) }
+func (f foldFS) Stat(name string) (fs.FileInfo, error) { return f.memFS.Stat(fold(name)) }
+
+func TestProbeCaseInsensitive(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		fsys FileSystem
+		want bool
+	}{
+		{"case sensitive", newMemFS(), false},
+		{"case insensitive", foldFS{newMemFS()}, true},
+	} {
+		rn := newRun()
+		rn.fsys = tt.fsys
+		if got := rn.probeCaseInsensitive("/data/depotcache"); got != tt.want {
+			t.Errorf("%s: probeCaseInsensitive = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestClaimDestination(t *testing.T) {
+	for _, insensitive := range []bool{false, true} {
+		rn := newRun()
+		rn.caseProbe = func(string) bool { return insensitive }
+		if _, ok := rn.claimDestination("/m", "11_ABC.manifest"); !ok {
+			t.Fatal("first claim refused")
+		}
+		// 同名的重复占用视为同一文件
+		if _, ok := rn.claimDestination("/m", "11_ABC.manifest"); !ok {
+			t.Errorf("insensitive=%v: same name refused", insensitive)
+		}
+		prev, ok := rn.claimDestination("/m", "11_abc.manifest")
+		if ok == insensitive || (insensitive && prev != "11_ABC.manifest") {
+			t.Errorf("insensitive=%v: case-only claim = %q, %v", insensitive, prev, ok)
+		}
+		// 全部占用释放后其它写法可以写入
+		rn.releaseDestination("/m", "11_ABC.manifest")
+		rn.releaseDestination("/m", "11_ABC.manifest")
+		if _, ok := rn.claimDestination("/m", "11_abc.manifest"); !ok {
+			t.Errorf("insensitive=%v: claim after release refused", insensitive)
+		}
+	}
+}
+
+func TestRunCaseCollisions(t *testing.T) {
+	upper, lower := testManifest+"U", testManifest+"l"
+	files := map[string]string{"a/b/10/11_ABC.manifest": upper, "a/b/10/11_abc.manifest": lower, "a/b/20/11_abc.manifest": lower}
+	tests := []struct {
+		name        string
+		insensitive bool
+		appData     map[string][]string
+		manifests   int
+		collisions  int
+	}{
+		// Linux：两个文件各自写入
+		{"same app, case sensitive", false, map[string][]string{"10": {"11_ABC", "11_abc"}}, 2, 0},
+		{"same app, case insensitive", true, map[string][]string{"10": {"11_ABC", "11_abc"}}, 1, 1},
+		{"across apps, case sensitive", false, map[string][]string{"10": {"11_ABC"}, "20": {"11_abc"}}, 2, 0},
+		{"across apps, case insensitive", true, map[string][]string{"10": {"11_ABC"}, "20": {"11_abc"}}, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := newMemFS()
+			c := memClient(newMemDownloader(files), mem)
+			var fsys FileSystem = mem
+			if tt.insensitive {
+				fsys = foldFS{mem}
+				c.FileSystem = fsys
+			}
+			cfg := testConfig(t, tt.appData)
+			cfg.ManifestOnly = true
+			res, err := c.Run(context.Background(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Summary.Manifest != tt.manifests || res.Summary.Collisions != tt.collisions {
+				t.Errorf("summary manifest %d, collisions %d; want %d, %d", res.Summary.Manifest, res.Summary.Collisions, tt.manifests, tt.collisions)
+			}
+			// 留下的文件是先写入者的完整内容，没有被另一个文件覆盖
+			var written []string
+			for _, app := range res.Results {
+				for _, f := range app.Files {
+					data, err := fsys.ReadFile(filepath.Join(cfg.ManifestDir, f.Name))
+					if err != nil || (string(data) != upper && string(data) != lower) || f.SHA256 != sha256Hex(string(data)) {
+						t.Errorf("%s: %d bytes, %v", f.Name, len(data), err)
+					}
+					written = append(written, f.Name)
+				}
+				for _, note := range app.Collisions {
+					if !strings.Contains(note, " -> ") {
+						t.Errorf("collision note = %q", note)
+					}
+				}
+			}
+			if len(written) != tt.manifests {
+				t.Errorf("files = %v", written)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RunChanges 是本次运行相对 state_file 中上一次记录的变化；没有上一次记录时不输出。
+// 只包含本次处理过的 App：未设置 changelog_path 或 force 时，上次已完成而跳过的 App 不会出现在这里
+type RunChanges struct {
+	NewApps         []string    `json:"new_apps,omitempty"`         // 第一次拿到文件的 App
+	UpdatedApps     []AppChange `json:"updated_apps,omitempty"`     // 有新文件或文件内容变化的 App
+	RemovedUpstream []string    `json:"removed_upstream,omitempty"` // 上次拿到、本次在仓库中已找不到的文件 ("appid/文件名")
+}
+
+// AppChange 是单个 App 的文件变化
+type AppChange struct {
+	AppID   string   `json:"app_id"`
+	Added   []string `json:"added,omitempty"`   // 上次没有的文件
+	Changed []string `json:"changed,omitempty"` // SHA-256 与上次不同的文件
+}
+
+// appFiles 返回 App 本次拿到的文件 (不含 nest_by_app 子目录的文件名 -> SHA-256)；Lua 的 SHA-256 从已保存的文件读取
+func (rn *run) appFiles(res AppResult, luaDir string) map[string]string {
+	files := make(map[string]string)
+	for _, f := range res.Files {
+		files[path.Base(f.Name)] = f.SHA256
+	}
+	if name := savedScript(res); name != "" && luaDir != "" {
+		if sha := rn.storedSHA256(filepath.Join(luaDir, name)); sha != "" {
+			files[name] = sha
+		}
+	}
+	return files
+}
+
+// diffApp 比较 App 上一次记录的文件与本次结果，返回合并后的文件记录。
+// 跳过 (已存在) 的文件沿用上次的记录；本次全部候选 404 的清单从记录中移除并计为 removed_upstream
+func (st *runState) diffApp(res AppResult, prev map[string]string) map[string]string {
+	cur := make(map[string]string, len(prev))
+	for name, sha := range prev {
+		cur[name] = sha
+	}
+	var change AppChange
+	for name, sha := range st.rn.appFiles(res, st.luaDir) {
+		old, ok := prev[name]
+		switch {
+		case !ok:
+			change.Added = append(change.Added, name)
+		case old != sha:
+			change.Changed = append(change.Changed, name)
+		}
+		cur[name] = sha
+	}
+	var removed []string
+	for _, item := range res.notFound {
+		name := manifestLocalName(strings.TrimSpace(item))
+		if _, ok := prev[name]; ok {
+			removed = append(removed, res.AppID+"/"+name)
+			delete(cur, name)
+		}
+	}
+
+	if st.prior {
+		switch {
+		case len(prev) == 0 && len(change.Added) > 0:
+			st.changes.NewApps = append(st.changes.NewApps, res.AppID)
+		case len(change.Added) > 0 || len(change.Changed) > 0:
+			sort.Strings(change.Added)
+			sort.Strings(change.Changed)
+			change.AppID = res.AppID
+			st.changes.UpdatedApps = append(st.changes.UpdatedApps, change)
+		}
+		st.changes.RemovedUpstream = append(st.changes.RemovedUpstream, removed...)
+	}
+	return cur
+}
+
+// runChanges 返回排序后的变化；没有上一次记录时返回 nil
+func (st *runState) runChanges() *RunChanges {
+	if st == nil || !st.prior {
+		return nil
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	c := st.changes
+	sort.Strings(c.NewApps)
+	sort.Slice(c.UpdatedApps, func(i, j int) bool { return c.UpdatedApps[i].AppID < c.UpdatedApps[j].AppID })
+	sort.Strings(c.RemovedUpstream)
+	return &c
+}
+
+// writeChangelog 把变化写成 Markdown (changelog_path)，先写临时文件再重命名
+func (rn *run) writeChangelog(path string, c *RunChanges, since string) error {
+	return rn.writeOutputFile(path, func(w io.Writer) error {
+		fmt.Fprintf(w, "# 下载变化 %s\n\n", time.Now().Format("2006-01-02 15:04"))
+		if since != "" {
+			fmt.Fprintf(w, "对比上一次运行: %s\n\n", since)
+		}
+		if len(c.NewApps) == 0 && len(c.UpdatedApps) == 0 && len(c.RemovedUpstream) == 0 {
+			_, err := fmt.Fprintln(w, "没有变化。")
+			return err
+		}
+		if len(c.NewApps) > 0 {
+			fmt.Fprintf(w, "## 新增 App (%d)\n\n", len(c.NewApps))
+			for _, id := range c.NewApps {
+				fmt.Fprintf(w, "- %s\n", id)
+			}
+			fmt.Fprintln(w)
+		}
+		if len(c.UpdatedApps) > 0 {
+			fmt.Fprintf(w, "## 更新的 App (%d)\n\n", len(c.UpdatedApps))
+			for _, a := range c.UpdatedApps {
+				fmt.Fprintf(w, "- %s\n", a.AppID)
+				for _, name := range a.Added {
+					fmt.Fprintf(w, "  - 新增 %s\n", name)
+				}
+				for _, name := range a.Changed {
+					fmt.Fprintf(w, "  - 内容变化 %s\n", name)
+				}
+			}
+			fmt.Fprintln(w)
+		}
+		if len(c.RemovedUpstream) > 0 {
+			fmt.Fprintf(w, "## 仓库中已移除 (%d)\n\n", len(c.RemovedUpstream))
+			for _, name := range c.RemovedUpstream {
+				fmt.Fprintf(w, "- %s\n", name)
+			}
+		}
+		return nil
+	})
+}
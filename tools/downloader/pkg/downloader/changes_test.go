@@ -0,0 +1,85 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// setFiles 修改 r 中的文件：值为空串时删除该路径
+func (r *testRepo) setFiles(files map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for p, body := range files {
+		if body == "" {
+			delete(r.files, p)
+		} else {
+			r.files[p] = body
+		}
+	}
+}
+
+func TestRunChangesAcrossRuns(t *testing.T) {
+	r := newTestRepo(t, map[string]string{
+		"a/b/10/10.lua":         "-- 10 v1",
+		"a/b/10/11_22.manifest": testManifest,
+		"a/b/10/12_33.manifest": testManifest + "12",
+		"a/b/20/20.lua":         "-- 20",
+		// 20/21_44 与 App 30 第二次运行前才出现
+	})
+	cfg := testConfig(t, map[string][]string{"10": {"11_22", "12_33"}, "20": {"21_44"}, "30": {"31_55"}})
+	dir := t.TempDir()
+	cfg.StateFile = filepath.Join(dir, "state.json")
+	cfg.ChangelogPath = filepath.Join(dir, "changelog.md")
+
+	// 没有上一次记录：不输出 changes，也不写 changelog
+	if res := r.download(t, cfg); res.Changes != nil {
+		t.Errorf("first run changes = %+v, want nil", res.Changes)
+	}
+	if _, err := os.Stat(cfg.ChangelogPath); err == nil {
+		t.Error("changelog written without prior state")
+	}
+
+	r.setFiles(map[string]string{
+		"a/b/10/10.lua":         "-- 10 v2",
+		"a/b/10/12_33.manifest": "",
+		"a/b/20/21_44.manifest": testManifest + "21",
+		"a/b/30/30.lua":         "-- 30",
+		"a/b/30/31_55.manifest": testManifest + "31",
+	})
+	res := r.download(t, cfg)
+	want := &RunChanges{
+		NewApps: []string{"30"},
+		UpdatedApps: []AppChange{
+			{AppID: "10", Changed: []string{"10.lua"}},
+			{AppID: "20", Added: []string{"21_44.manifest"}},
+		},
+		RemovedUpstream: []string{"10/12_33.manifest"},
+	}
+	if !reflect.DeepEqual(res.Changes, want) {
+		t.Errorf("changes = %+v, want %+v", res.Changes, want)
+	}
+	data, err := os.ReadFile(cfg.ChangelogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"## 新增 App (1)", "- 30", "## 更新的 App (2)", "  - 内容变化 10.lua", "  - 新增 21_44.manifest", "## 仓库中已移除 (1)", "- 10/12_33.manifest"} {
+		if !strings.Contains(string(data), line+"\n") {
+			t.Errorf("changelog missing %q:\n%s", line, data)
+		}
+	}
+
+	// 上游没有变化：已完成的 App 仍被重新检查，报告为空
+	res = r.download(t, cfg)
+	if res.Changes == nil || len(res.Changes.NewApps)+len(res.Changes.UpdatedApps)+len(res.Changes.RemovedUpstream) != 0 {
+		t.Errorf("unchanged run changes = %+v, want empty", res.Changes)
+	}
+	if len(res.ResumedDone) != 0 {
+		t.Errorf("resumed_done = %v, want done apps re-checked for changelog", res.ResumedDone)
+	}
+	if data, _ := os.ReadFile(cfg.ChangelogPath); !strings.Contains(string(data), "没有变化。") {
+		t.Errorf("changelog = %s", data)
+	}
+}
@@ -0,0 +1,122 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// checksumError 表示下载内容与 checksums、plan 或 Git blob SHA 不符。
+// 它同时是一种 corruptError (计入 invalid_files、可重试)，另外计入 AppResult.ChecksumFailed
+type checksumError struct {
+	reason string
+}
+
+func (e *checksumError) Error() string { return "checksum: " + e.reason }
+func (e *checksumError) Unwrap() error { return &corruptError{reason: e.reason} }
+
+// expectKey 是 context 中 withExpected 的键
+type expectKey struct{}
+
+// expected 是下载内容提交前要满足的大小与校验和，为空的字段不检查。
+// source 说明期望值的来源 (plan、checksums)，用于错误信息
+type expected struct {
+	rn     *run
+	size   int64
+	sha256 string
+	gitSHA string // Git blob SHA-1，仅在没有 SHA-256 时校验
+	source string
+}
+
+// withExpected 让 ctx 中的下载在写入目标前校验大小与校验和，不符时按损坏处理 (触发重试，最终不写入目标)
+func withExpected(ctx context.Context, exp expected) context.Context {
+	exp.sha256, exp.gitSHA = strings.ToLower(exp.sha256), strings.ToLower(exp.gitSHA)
+	if exp.sha256 != "" {
+		exp.gitSHA = ""
+	}
+	return context.WithValue(ctx, expectKey{}, exp)
+}
+
+// expectedFrom 返回 ctx 中的期望值
+func expectedFrom(ctx context.Context) expected {
+	exp, _ := ctx.Value(expectKey{}).(expected)
+	return exp
+}
+
+// blobBuffer 返回计算 Git blob SHA-1 所需的内容缓冲区 (blob 头包含长度，只能在读完后计算)，不需要时返回 nil
+func (e expected) blobBuffer() *bytes.Buffer {
+	if e.gitSHA == "" {
+		return nil
+	}
+	return &bytes.Buffer{}
+}
+
+// verify 校验下载内容的大小、SHA-256 与 Git blob SHA-1，并在 verbose 模式下输出每个文件的校验结果
+func (e expected) verify(url, destPath string, n int64, sha string, blob *bytes.Buffer) error {
+	if e.size > 0 && n != e.size {
+		return &corruptError{reason: fmt.Sprintf("大小 %d 与 %s 中的 %d 不符", n, e.source, e.size)}
+	}
+	algo, want, got := "sha256", e.sha256, sha
+	if want == "" && e.gitSHA != "" && blob != nil {
+		algo, want, got = "git_sha1", e.gitSHA, gitBlobSHA1(blob.Bytes())
+	}
+	if want == "" {
+		return nil
+	}
+	ok := want == got
+	if e.rn.verboseEnabled {
+		e.rn.emitEvent("checksum", map[string]interface{}{"file": filepath.Base(destPath), "url": url, "algo": algo, "ok": ok})
+	}
+	if !ok {
+		e.rn.verbosef("%s %s 校验失败: 期望 %s，实际 %s (%s)", filepath.Base(destPath), algo, want, got, url)
+		label := "SHA-256"
+		if algo == "git_sha1" {
+			label = "Git blob SHA-1"
+		}
+		return &checksumError{fmt.Sprintf("%s 与 %s 不符", label, e.source)}
+	}
+	e.rn.verbosef("%s %s 校验通过 (%s)", filepath.Base(destPath), algo, e.source)
+	return nil
+}
+
+// gitBlobSHA1 返回内容的 Git blob 对象 ID：sha1("blob <长度>\0" + 内容)
+func gitBlobSHA1(content []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(content))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// normalizeChecksums 校验 checksums 配置并规范化键 (去掉开头的 /) 与值 (小写)
+func normalizeChecksums(m map[string]string) (map[string]string, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if _, err := hex.DecodeString(v); err != nil || len(v) != 64 {
+			return nil, fmt.Errorf("%s 的 sha256 无效", k)
+		}
+		out[path.Clean(strings.TrimLeft(strings.TrimSpace(k), "/"))] = v
+	}
+	return out, nil
+}
+
+// checksumFor 返回 names (仓库内路径或本地文件名) 中首个在 checksums 里的 SHA-256
+func (rn *run) checksumFor(names ...string) string {
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if v, ok := rn.checksums[path.Clean(strings.TrimLeft(name, "/"))]; ok {
+			return v
+		}
+	}
+	return ""
+}
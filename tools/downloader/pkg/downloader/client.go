@@ -0,0 +1,621 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Client 是下载器的可嵌入入口，命令行程序只是它的一层包装。
+// 每次调用使用独立的运行状态 (计数、去重表、源统计等)，同一进程内的多个 Client 可以并发运行。
+type Client struct {
+	// HTTPClient: 发起全部下载请求的客户端；为 nil 时按 cfg.Transport 创建
+	HTTPClient *http.Client
+	// RawBase: 直连源的基础地址，默认 RAW_BASE；测试时可指向 httptest 服务器
+	RawBase string
+	// APIBase: GitHub API 的基础地址，默认 API_BASE (私有仓库回退、默认分支查询等使用)
+	APIBase string
+	// Output: 非 nil 时把最终结果以单行 JSON 写入 (与命令行输出相同，low_memory 时直接从临时文件回放)
+	Output io.Writer
+	// OnEvent: 进度事件回调 (不论 progress_format 如何都会调用)，会被多个协程并发调用
+	OnEvent func(Event)
+	// Debug / Verbose: 与配置中的 debug / verbose 取或
+	Debug   bool
+	Verbose bool
+	// LogLevel: 非空时覆盖配置中的 log_level (-log-level)
+	LogLevel string
+	// Fresh: 忽略并覆盖 state_file 中上次运行的进度
+	Fresh bool
+	// AppIDsFile: 额外读取的 AppID 列表文件 (每行一个，支持 # 注释与 220-240 区间)，"-" 表示 stdin
+	AppIDsFile string
+	// Downloader: 非 nil 时代替 HTTPClient 发出全部请求 (下载源、GitHub API、预检)，测试时可换成内存实现
+	Downloader Downloader
+	// FileSystem: 非 nil 时代替本地磁盘完成全部文件读写 (输出目录、缓存、状态文件、日志与报告)
+	FileSystem FileSystem
+
+	mu   sync.Mutex
+	last *run // 进行中或最近一次 Run 的运行状态，供 EventsSince 读取
+}
+
+// prepared 是 prepare 规范化配置后得到的、需要写入结果的信息
+type prepared struct {
+	appIDMap    map[string][]string
+	rejectedIDs []string
+	filteredIDs []string
+}
+
+// start 为一次 Run 新建运行状态，并记为 EventsSince 读取的当前运行
+func (c *Client) start() *run {
+	rn := newRun()
+	c.mu.Lock()
+	c.last = rn
+	c.mu.Unlock()
+	return rn
+}
+
+// prepare 校验并规范化配置，把本次运行使用的全部参数设置到 rn。needApps 为 false 时 (doctor) 不要求 repo/app_ids。
+func (c *Client) prepare(rn *run, config *Config, needApps bool) (prepared, error) {
+	var p prepared
+	rn.eventHook = c.OnEvent
+	rn.resetEvents(config.EventBufferSize)
+	rn.rawBase = RAW_BASE
+	if c.RawBase != "" {
+		rn.rawBase = strings.TrimRight(c.RawBase, "/")
+	}
+	rn.apiBase = API_BASE
+	if c.APIBase != "" {
+		rn.apiBase = strings.TrimRight(c.APIBase, "/")
+	}
+
+	switch config.ProgressFormat {
+	case "", PROGRESS_TEXT:
+	case PROGRESS_JSON:
+		rn.progressJSON = true
+	default:
+		return p, &ConfigError{Code: CODE_INVALID_VALUE, Field: "progress_format", Msg: "progress_format 无效: " + config.ProgressFormat}
+	}
+	rn.structuredOutput = config.StructuredOutput
+	if config.OutputPath == OUTPUT_STDOUT || rn.progressJSON {
+		rn.logOut = os.Stderr
+	}
+	if c.LogLevel != "" {
+		config.LogLevel = c.LogLevel
+	}
+	level, ok := parseLogLevel(config.LogLevel)
+	if !ok {
+		return p, &ConfigError{Code: CODE_INVALID_VALUE, Field: "log_level", Msg: "log_level 无效: " + config.LogLevel + " (可选 debug、info、warn、error、quiet)"}
+	}
+	rn.consoleLevel = level
+	rn.logFileEnabled = config.LogFile != ""
+
+	rn.dryRun = config.DryRun
+	if rn.dryRun {
+		if off := dryRunOverrides(config); len(off) > 0 {
+			rn.infof("dry_run: 以下选项不会生效: %s", strings.Join(off, ", "))
+		}
+	}
+	if config.ConditionalSync {
+		// 清单的条件请求即 verify_existing，Lua 由 luaETags 处理
+		config.VerifyExisting = true
+	}
+	if config.CompressOutput {
+		// 直接使用库时在这里转换；命令行已转换过的路径保持不变
+		config.OutputPath = CompressedOutputPath(config.OutputPath)
+	}
+	switch strings.ToLower(strings.TrimSpace(config.Layout)) {
+	case "", LAYOUT_FLAT:
+	case LAYOUT_PER_APP:
+		config.NestByApp = true
+	default:
+		return p, &ConfigError{Code: CODE_INVALID_VALUE, Field: "layout", Msg: "layout 无效: " + config.Layout + " (可选 flat、per_app)"}
+	}
+	if config.FlattenSymlinks && !config.NestByApp {
+		return p, &ConfigError{Code: CODE_INVALID_VALUE, Field: "flatten_symlinks", Msg: "flatten_symlinks 需要 layout: per_app (或 nest_by_app)"}
+	}
+
+	if c.AppIDsFile != "" {
+		ids, bad, err := readAppIDList(c.AppIDsFile)
+		if err != nil {
+			return p, &ConfigError{Code: CODE_CONFIG_UNREADABLE, Field: "appids_file", Msg: "无法读取 appids-file: " + err.Error()}
+		}
+		if len(bad) > 0 {
+			rn.warnf("appids-file 中 %d 个条目不是有效的 AppID 或区间，已跳过: %s", len(bad), strings.Join(bad, ", "))
+		}
+		config.AppIDs = append(config.AppIDs, ids...)
+	}
+
+	for _, w := range normalizeConfig(config) {
+		rn.warnf("%s", w)
+	}
+	if err := validateMirrors(config.Mirrors); err != nil {
+		return p, &ConfigError{Code: CODE_INVALID_VALUE, Field: "mirrors", Msg: "mirrors 模板无效: " + err.Error()}
+	}
+	normalizeRepos(config)
+	normalizeTokens(config)
+	p.appIDMap, p.rejectedIDs = canonicalizeAppIDs(config)
+	if len(p.appIDMap) > 0 {
+		rn.warnf("%d 个 app_id 写法不规范 (前导零、空白等)，已按规范形式请求，对照见结果中的 app_id_map", len(p.appIDMap))
+	}
+	if len(p.rejectedIDs) > 0 {
+		rn.warnf("忽略 %d 个无效 app_id (非数字或 0): %s", len(p.rejectedIDs), strings.Join(p.rejectedIDs, ", "))
+	}
+	if len(config.Tokens) > 1 {
+		rn.tokens = rn.newTokenPool(config.Tokens)
+	}
+
+	if err := rn.normalizePlan(config); err != nil {
+		return p, &ConfigError{Code: CODE_INVALID_VALUE, Field: "plan", Msg: "plan 无效: " + err.Error()}
+	}
+	filter, field, err := newAppFilter(config.Only, config.Skip)
+	if err != nil {
+		return p, &ConfigError{Code: CODE_INVALID_VALUE, Field: field, Msg: field + " 无效: " + err.Error()}
+	}
+	if p.filteredIDs = filter.apply(config); len(p.filteredIDs) > 0 {
+		rn.infof("only/skip 过滤掉 %d 个 App，剩余 %d 个 (见结果中的 skipped_by_filter)", len(p.filteredIDs), len(config.AppIDs))
+		if needApps && len(config.AppIDs) == 0 {
+			field = "skip"
+			if len(filter.only) > 0 {
+				field = "only"
+			}
+			return p, &ConfigError{Code: CODE_INVALID_VALUE, Field: field, Msg: fmt.Sprintf("only/skip 过滤掉了全部 %d 个 App", len(p.filteredIDs))}
+		}
+	}
+	if needApps && ((config.Repo == "" && len(config.Plan) == 0) || len(config.AppIDs) == 0) {
+		field := "app_ids"
+		if config.Repo == "" && len(config.Plan) == 0 {
+			field = "repo"
+		}
+		return p, &ConfigError{Code: CODE_MISSING_FIELD, Field: field, Msg: "参数不足 (repo/repos 或 app_ids 缺失)"}
+	}
+	if opts := unsupportedOptions(*config); len(opts) > 0 {
+		return p, &ConfigError{Code: CODE_UNSUPPORTED, Field: opts[0], Msg: "精简版不支持以下选项: " + strings.Join(opts, ", ")}
+	}
+	if err := rn.setupStorage(config); err != nil {
+		return p, err
+	}
+	switch config.ResultDetail {
+	case "":
+		config.ResultDetail = DETAIL_FULL
+	case DETAIL_FULL, DETAIL_SUMMARY, DETAIL_FAILURES_ONLY:
+	default:
+		return p, &ConfigError{Code: CODE_INVALID_VALUE, Field: "result_detail", Msg: "result_detail 无效: " + config.ResultDetail}
+	}
+
+	rn.debugEnabled = config.Debug || c.Debug || rn.consoleLevel == levelDebug
+	rn.verboseEnabled = config.Verbose || c.Verbose || rn.consoleLevel == levelDebug
+	rn.detailedStats = config.DetailedStats
+	rn.extendedCandidates = config.ExtendedCandidates
+	rn.probeWithHead = config.ProbeWithHead
+	if ua := strings.TrimSpace(config.UserAgent); ua != "" {
+		rn.userAgent = ua
+	}
+	for k, v := range config.Headers {
+		if !validHeaderName(k) || strings.ContainsAny(v, "\r\n") {
+			return p, &ConfigError{Code: CODE_INVALID_VALUE, Field: "headers", Msg: "headers 无效: " + strconv.Quote(k)}
+		}
+		if strings.EqualFold(k, "Host") {
+			return p, &ConfigError{Code: CODE_INVALID_VALUE, Field: "headers", Msg: "headers 不能设置 Host"}
+		}
+	}
+	rn.extraHeaders = config.Headers
+	b, err := rn.newBudgets(config.SourceBudgets)
+	if err != nil {
+		return p, &ConfigError{Code: CODE_INVALID_VALUE, Field: "source_budgets", Msg: "source_budgets 无效: " + err.Error()}
+	}
+	rn.budgets = b
+	rn.steamInfoURL = DEFAULT_STEAM_INFO_URL
+	if config.SteamInfoURL != "" {
+		if !strings.Contains(config.SteamInfoURL, "{appid}") {
+			return p, &ConfigError{Code: CODE_INVALID_VALUE, Field: "steam_info_url", Msg: "steam_info_url 必须包含 {appid}"}
+		}
+		rn.steamInfoURL = config.SteamInfoURL
+	}
+	rn.proxyURL = nil
+	if config.Proxy != "" {
+		u, err := parseProxy(strings.TrimSpace(config.Proxy))
+		if err != nil {
+			return p, &ConfigError{Code: CODE_INVALID_VALUE, Field: "proxy", Msg: "proxy 无效: " + err.Error()}
+		}
+		rn.proxyURL = u
+	}
+	sums, err := normalizeChecksums(config.Checksums)
+	if err != nil {
+		return p, &ConfigError{Code: CODE_INVALID_VALUE, Field: "checksums", Msg: "checksums 无效: " + err.Error()}
+	}
+	rn.checksums = sums
+	if err := validateProfiles(*config); err != nil {
+		return p, err
+	}
+	if config.ChangelogPath != "" && config.StateFile == "" && config.StatePath == "" {
+		return p, &ConfigError{Code: CODE_MISSING_FIELD, Field: "state_file", Msg: "changelog_path 需要 state_file 记录上一次运行"}
+	}
+	templates, templateField := config.LuaPathTemplates, "lua_path_templates"
+	if len(templates) == 0 {
+		templates, templateField = config.LuaNamePatterns, "lua_name_patterns"
+	}
+	if len(templates) > 0 {
+		rn.luaTemplates = nil
+		for _, t := range templates {
+			t = strings.TrimSpace(t)
+			if t == "" || strings.HasPrefix(t, "/") || strings.Contains(t, "\\") || containsString(strings.Split(t, "/"), "..") {
+				return p, &ConfigError{Code: CODE_INVALID_VALUE, Field: templateField, Msg: templateField + " 无效: " + strconv.Quote(t) + " 必须是仓库内的相对路径"}
+			}
+			rn.luaTemplates = append(rn.luaTemplates, t)
+		}
+	}
+	switch strings.ToLower(strings.TrimSpace(config.Prefer)) {
+	case "", PREFER_LUA:
+		rn.luaTemplates = withSTTemplate(rn.luaTemplates, false)
+	case PREFER_ST:
+		rn.luaTemplates = withSTTemplate(rn.luaTemplates, true)
+	default:
+		return p, &ConfigError{Code: CODE_INVALID_VALUE, Field: "prefer", Msg: "prefer 只能是 lua 或 st: " + strconv.Quote(config.Prefer)}
+	}
+	rn.branches, rn.useAppBranch = nil, config.UseAppBranch
+	for _, b := range config.Branches {
+		if b = strings.TrimSpace(b); b != "" {
+			rn.branches = append(rn.branches, b)
+		}
+	}
+	rn.filters = rn.newFilterDetector(config.IgnoreNetworkFilter)
+	rn.retry = newRetryPolicy(config.MaxRetries, config.RetryBaseMs, config.RetryMaxMs)
+	rn.limiter = newHostLimiter(config.RequestsPerSecond)
+	if config.RequestTimeoutSeconds > 0 {
+		rn.requestTimeout = time.Duration(config.RequestTimeoutSeconds) * time.Second
+	}
+	rn.sources = rn.newSourceSet(config.Mirrors)
+	if config.ProbeDelayMs > 0 {
+		rn.probeDelay = time.Duration(config.ProbeDelayMs) * time.Millisecond
+	}
+	rn.contentCheck = !config.DisableContentCheck
+	if config.MinManifestSize > 0 {
+		rn.minManifestSize = config.MinManifestSize
+	}
+	if config.MaxFileBytes > 0 {
+		rn.maxFileBytes = config.MaxFileBytes
+	}
+	if config.MaxConnsPerHost > 0 {
+		config.Transport.MaxConnsPerHost = config.MaxConnsPerHost
+	}
+	rn.httpClient = c.HTTPClient
+	if rn.httpClient == nil {
+		rn.httpClient = &http.Client{Transport: rn.newTransport(config.Transport, rn.proxyURL)}
+	}
+	rn.dl = rn.httpClient
+	if c.Downloader != nil {
+		rn.dl = c.Downloader
+	}
+	if c.FileSystem != nil {
+		rn.fsys = c.FileSystem
+	}
+	return p, nil
+}
+
+// EventsSince 返回当前 (或最近一次) 运行中 seq 大于 since 的缓冲事件，供重启后的调用方补齐错过的事件
+// (只有 JSON 进度、structured_output 或设置了 OnEvent 时才会产生事件)，
+// 之后以 seq 去重继续接收 OnEvent 或 NDJSON 输出。可在 Run 进行中从其它协程调用。
+// 第二个返回值为 false 表示部分事件已被挤出缓冲区 (event_buffer_size)，调用方应以最终结果为准。
+func (c *Client) EventsSince(since int64) ([]Event, bool) {
+	c.mu.Lock()
+	rn := c.last
+	c.mu.Unlock()
+	if rn == nil {
+		return nil, true
+	}
+	return rn.eventsSince(since)
+}
+
+// Explain 模拟单个条目的下载源选择过程并把决策树打印到 stdout，不发起网络请求
+func (c *Client) Explain(cfg Config, appID, item string) error {
+	rn := newRun()
+	if _, err := c.prepare(rn, &cfg, true); err != nil {
+		return err
+	}
+	rn.runExplain(cfg, appID, item)
+	return nil
+}
+
+// Doctor 测量网络与磁盘环境并把建议配置以 JSON 打印到 stdout，返回命令行退出码
+func (c *Client) Doctor(cfg Config) (int, error) {
+	if SLIM_BUILD {
+		return 1, &ConfigError{Code: CODE_UNSUPPORTED, Field: "doctor", Msg: "精简版不支持 -doctor"}
+	}
+	rn := newRun()
+	if _, err := c.prepare(rn, &cfg, false); err != nil {
+		return 1, err
+	}
+	return rn.runDoctor(cfg), nil
+}
+
+// Run 以默认设置 (直连 GitHub、不输出结果 JSON、没有事件回调) 执行一次下载，等同于 (&Client{}).Run(ctx, cfg)。
+// 需要替换基础地址、HTTP 客户端或接收进度事件时使用 Client
+func Run(ctx context.Context, cfg Config) (Result, error) {
+	return (&Client{}).Run(ctx, cfg)
+}
+
+// Run 执行一次完整的下载。配置无效或无法开始时返回 error；否则返回结果
+// (Success 为 false 表示全部失败或运行被中止，与命令行退出码 1 对应)。
+// low_memory 模式下若设置了 Output，返回的 Result.Results 为空，完整结果只写入 Output。
+func (c *Client) Run(ctx context.Context, cfg Config) (Result, error) {
+	rn := c.start()
+	startTime := time.Now()
+	config := cfg
+	p, err := c.prepare(rn, &config, true)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var startupWarnings []string
+	if config.LogDir != "" {
+		keep := config.LogKeep
+		if keep <= 0 {
+			keep = DEFAULT_LOG_KEEP
+		}
+		if path, err := rn.openRunLog(config.LogDir, keep); err != nil {
+			rn.warnf("无法创建运行日志: %v", err)
+			startupWarnings = append(startupWarnings, "log_dir: "+err.Error())
+		} else {
+			defer rn.closeRunLog()
+			rn.debugf("运行日志写入 %s", path)
+		}
+	}
+	if config.LogFile != "" {
+		if err := rn.openDebugLog(config.LogFile); err != nil {
+			rn.warnf("无法创建 log_file: %v", err)
+			startupWarnings = append(startupWarnings, "log_file: "+err.Error())
+		} else {
+			defer rn.debugLog.close()
+		}
+	}
+
+	// 先于创建任何目录检查路径，相对路径一旦落错目录就很难察觉
+	for _, w := range checkStartupPaths() {
+		rn.warnf("%s", w)
+		startupWarnings = append(startupWarnings, w)
+	}
+
+	if config.LuaDir != "" && !config.ManifestOnly && rn.remoteStorage(config.LuaDir) == nil && !rn.dryRun {
+		rn.fsys.MkdirAll(config.LuaDir, 0755)
+	}
+	normalizeManifestDirs(&config)
+	for _, dir := range append([]string{config.ManifestDir}, config.ManifestDirs...) {
+		if dir != "" && rn.remoteStorage(dir) == nil && !rn.dryRun {
+			rn.fsys.MkdirAll(dir, 0755)
+		}
+	}
+
+	var resumedDone []string
+	if config.StateFile == "" {
+		config.StateFile = config.StatePath
+	}
+	if config.StateFile != "" {
+		rn.resumeState = rn.openRunState(config.StateFile, config, c.Fresh)
+		// changelog_path 要报告已完成 App 的变化，这些 App 同样重新检查 (配合 conditional_sync 时未变化的文件只收到 304)
+		if !config.Force && config.ChangelogPath == "" {
+			resumedDone = rn.resumeState.apply(&config)
+		}
+		if len(resumedDone) > 0 {
+			rn.infof("state_file: 跳过 %d 个上次已完成的 App", len(resumedDone))
+		}
+		defer rn.resumeState.autosave()()
+	}
+
+	rn.steamSafe = config.SteamSafeWrites
+	for _, dir := range append([]string{config.ManifestDir}, config.ManifestDirs...) {
+		rn.steamSafe = rn.steamSafe || (isDepotcacheDir(dir) && rn.remoteStorage(dir) == nil)
+	}
+	if rn.steamSafe {
+		rn.infof("清单使用 steam-safe 写入 (校验文件头、fsync、重命名重试)")
+	}
+
+	rn.infof("downloader.exe version: %s (Internal Parallel & Retry)", VERSION)
+
+	var spool *resultSpool
+	if config.LowMemory {
+		s, err := rn.newResultSpool(config.ResultDetail)
+		if err != nil {
+			return Result{}, errors.New("无法创建结果临时文件: " + err.Error())
+		}
+		defer s.close()
+		spool = s
+	}
+
+	// 调用方取消 ctx 或整体超时后，工作协程尽快退出并返回部分结果
+	if config.TimeoutSeconds <= 0 {
+		config.TimeoutSeconds = config.TotalTimeoutSeconds
+	}
+	if config.TimeoutSeconds > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(config.TimeoutSeconds)*time.Second)
+		defer cancel()
+	}
+	ctx, abort := context.WithCancelCause(ctx)
+	defer abort(nil)
+	rn.abortRun = func(err *abortError) { abort(err) }
+
+	// 先确认有路由可用：完全不通时在这里终止，而不是让每个文件各自超时
+	var routes []RouteStatus
+	if c.HTTPClient == nil && c.Downloader == nil {
+		var routeWarnings []string
+		routes, routeWarnings = rn.checkRoute(ctx, config)
+		startupWarnings = append(startupWarnings, routeWarnings...)
+	}
+
+	var unavailable []RepoStatus
+	if config.RepoCheck && ctx.Err() == nil {
+		unavailable = rn.checkRepos(ctx, &config)
+	}
+	if len(config.Repos) == 0 && len(unavailable) > 0 {
+		// 全部仓库都已下架：只输出仓库级记录，不再为每个 App 产生 404。
+		// 只有 plan 而没有配置仓库时照常执行 plan 条目
+		output := Result{
+			Success:         false,
+			Results:         []AppResult{},
+			Summary:         ResultSummary{Apps: len(config.AppIDs), Failed: len(config.AppIDs)},
+			Failed:          config.AppIDs,
+			RepoUnavailable: unavailable,
+			AppIDMap:        p.appIDMap,
+			RejectedAppIDs:  p.rejectedIDs,
+			SkippedByFilter: p.filteredIDs,
+			TotalTime:       time.Since(startTime).Seconds(),
+		}
+		return output, c.deliver(rn, &output, nil, config)
+	}
+
+	warnings := startupWarnings
+	if ctx.Err() == nil {
+		warnings = append(warnings, rn.checkTokenAccess(ctx, config)...)
+		rn.detectDefaultBranches(ctx, config)
+	}
+	results, appIDs, runWarnings := rn.processAllApps(ctx, config, spool)
+	warnings = append(warnings, runWarnings...)
+	rn.verbosef("连接: 新建 %d，复用 %d，TLS 握手 %d", atomic.LoadInt64(&rn.connsOpened), atomic.LoadInt64(&rn.connsReused), atomic.LoadInt64(&rn.tlsHandshakes))
+
+	var appListCreated, appListPresent int
+	if config.GreenLumaDir != "" {
+		var err error
+		appListCreated, appListPresent, err = rn.writeAppList(config.GreenLumaDir, appIDs)
+		if err != nil {
+			rn.warnf("写入 GreenLuma AppList 失败: %v", err)
+			warnings = append(warnings, "greenluma_dir: "+err.Error())
+		} else {
+			rn.infof("GreenLuma AppList: 新增 %d 个条目，%d 个已存在", appListCreated, appListPresent)
+		}
+	}
+
+	if config.SummaryPath != "" {
+		if n, err := rn.writeSummaryFile(config.SummaryPath, appIDs); err != nil {
+			rn.warnf("写入 summary_path 失败: %v", err)
+			warnings = append(warnings, "summary_path: "+err.Error())
+		} else {
+			rn.infof("已写入 %d 个成功 App 的汇总到 %s", n, config.SummaryPath)
+		}
+	}
+
+	var profiles map[string]int
+	if needProfiles(config) {
+		var profileWarnings []string
+		profiles, profileWarnings = rn.writeProfiles(config, appIDs)
+		warnings = append(warnings, profileWarnings...)
+	}
+
+	if config.DedupReport != "" {
+		if r, err := rn.writeDedupReport(config.DedupReport, appIDs); err != nil {
+			rn.warnf("写入 dedup_report 失败: %v", err)
+			warnings = append(warnings, "dedup_report: "+err.Error())
+		} else {
+			rn.infof("dedup_report: %d 个清单共 %d 字节，去重后 %d 字节 (%.2f%% 重复)", r.Manifests, r.LogicalBytes, r.UniqueBytes, r.SharedPercent)
+		}
+	}
+
+	changes := rn.resumeState.runChanges()
+	if changes != nil && config.ChangelogPath != "" {
+		if err := rn.writeChangelog(config.ChangelogPath, changes, rn.resumeState.since); err != nil {
+			rn.warnf("写入 changelog_path 失败: %v", err)
+			warnings = append(warnings, "changelog_path: "+err.Error())
+		}
+	}
+
+	keysMerged := 0
+	if config.SteamConfigVDF != "" && len(rn.collectedKeys) > 0 {
+		n, err := rn.mergeKeysIntoConfig(config.SteamConfigVDF, rn.collectedKeys)
+		if err != nil {
+			rn.warnf("合并密钥到 %s 失败: %v", config.SteamConfigVDF, err)
+			warnings = append(warnings, "steam_config_vdf: "+err.Error())
+		} else {
+			rn.infof("已合并 %d 个 depot 密钥到 %s (原文件备份为 .bak)", n, config.SteamConfigVDF)
+			keysMerged = n
+		}
+	}
+
+	output := Result{
+		Success:         true,
+		Results:         results,
+		Mirror:          rn.sources.busiest(),
+		Cancelled:       ctx.Err() != nil,
+		DryRun:          rn.dryRun,
+		Warnings:        warnings,
+		RepoUnavailable: unavailable,
+		Routes:          routes,
+		KeysMerged:      keysMerged,
+		AppIDMap:        p.appIDMap,
+		RejectedAppIDs:  p.rejectedIDs,
+		SkippedByFilter: p.filteredIDs,
+		AppListCreated:  appListCreated,
+		AppListPresent:  appListPresent,
+		Profiles:        profiles,
+		ResumedDone:     resumedDone,
+		Changes:         changes,
+		TotalTime:       time.Since(startTime).Seconds(),
+	}
+	output.TotalBytes = atomic.LoadInt64(&rn.totalBytes)
+	output.ProbeDelaySeconds = time.Duration(atomic.LoadInt64(&rn.probeDelayTotal)).Seconds()
+	output.Stats = rn.retryStats.snapshot()
+	if output.TotalTime > 0 {
+		output.BytesPerSecond = float64(output.TotalBytes) / output.TotalTime
+	}
+	if spool != nil {
+		output.Summary, output.Failed = spool.summary, spool.failed
+		if len(spool.lost) > 0 {
+			// 结果不完整，不能报告成功
+			output.Success = false
+			output.Warnings = append(output.Warnings, fmt.Sprintf("low_memory: %d 个 App 的结果写入临时文件失败而丢失: %s", len(spool.lost), strings.Join(spool.lost, ", ")))
+		}
+	} else {
+		output.Summary, output.Failed = summarize(results)
+	}
+	output.Summary.finish()
+	output.Summary.FileVanished = atomic.LoadInt64(&rn.fileVanishedCount)
+	output.Summary.DedupHits = atomic.LoadInt64(&rn.dedupHits)
+	output.Summary.SourceBudgets = rn.budgetUsage()
+	if ae := abortCause(ctx); ae != nil {
+		rn.errorf("运行已中止: %s", ae.msg)
+		output.Success = false
+		output.Error, output.ErrorKind = ae.msg, ae.kind
+	}
+	// 所有请求的 App 都没有拿到任何文件时视为整体失败，便于 shell 调用方检测
+	if output.Summary.Apps > 0 && output.Summary.Failed == output.Summary.Apps {
+		output.Success = false
+	}
+	return output, c.deliver(rn, &output, spool, config)
+}
+
+// deliver 把结果写入 output_path 指定的文件 (写入失败返回错误) 或 Output (与命令行相同，写入失败不视为运行错误)；
+// 未设置 Output 且结果在 spool 中时，读回到 output.Results
+func (c *Client) deliver(rn *run, output *Result, spool *resultSpool, config Config) error {
+	if config.OutputPath != "" && config.OutputPath != OUTPUT_STDOUT {
+		err := rn.writeResultFile(config.OutputPath, func(w io.Writer) error {
+			return rn.writeResult(w, *output, spool, config.ResultDetail)
+		})
+		if err != nil {
+			return fmt.Errorf("无法写入 output_path %s: %w", config.OutputPath, err)
+		}
+		if c.Output != nil {
+			return nil
+		}
+	} else if c.Output != nil {
+		rn.writeResult(c.Output, *output, spool, config.ResultDetail)
+		return nil
+	}
+	if spool == nil {
+		return nil
+	}
+	output.Results = []AppResult{}
+	return spool.each(func(raw []byte) error {
+		var r AppResult
+		if err := json.Unmarshal(raw, &r); err != nil {
+			return err
+		}
+		output.Results = append(output.Results, r)
+		return nil
+	})
+}
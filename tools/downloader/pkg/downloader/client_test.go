@@ -0,0 +1,179 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentClients(t *testing.T) {
+	// 两个 Client 同时运行，各自的源、配置与计数互不影响
+	type job struct {
+		repo *testRepo
+		cfg  Config
+		want string // 写入的 Lua 内容
+		res  Result
+	}
+	var jobs []*job
+	for _, body := range []string{"-- first", "-- second"} {
+		r := newTestRepo(t, map[string]string{
+			"a/b/10/10.lua":         body,
+			"a/b/10/11_22.manifest": testManifest,
+		})
+		jobs = append(jobs, &job{repo: r, cfg: testConfig(t, map[string][]string{"10": {"11_22"}}), want: body})
+	}
+	// 第二个运行只做 dry_run：若 dry_run 泄漏到第一个运行，后者不会写任何文件
+	jobs[1].cfg.DryRun = true
+
+	var wg sync.WaitGroup
+	for _, j := range jobs {
+		wg.Add(1)
+		go func(j *job) {
+			defer wg.Done()
+			res, err := j.repo.client().Run(context.Background(), j.cfg)
+			if err != nil {
+				t.Errorf("Run: %v", err)
+			}
+			j.res = res
+		}(j)
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(filepath.Join(jobs[0].cfg.LuaDir, "10.lua"))
+	if err != nil || string(data) != jobs[0].want {
+		t.Errorf("first run lua = %q, %v; want %q", data, err, jobs[0].want)
+	}
+	if jobs[0].res.DryRun || !jobs[1].res.DryRun {
+		t.Errorf("DryRun = %v, %v; want false, true", jobs[0].res.DryRun, jobs[1].res.DryRun)
+	}
+	if _, err := os.Stat(filepath.Join(jobs[1].cfg.LuaDir, "10.lua")); err == nil {
+		t.Error("dry_run client wrote 10.lua")
+	}
+	for i, j := range jobs {
+		if j.res.Summary.Lua != 1 || j.res.Summary.Manifest != 1 {
+			t.Errorf("run %d summary = %+v, want 1 lua and 1 manifest", i, j.res.Summary)
+		}
+		if n := j.repo.count("a/b/10/10.lua"); n == 0 {
+			t.Errorf("run %d never reached its own server", i)
+		}
+	}
+	if jobs[1].res.TotalBytes != 0 || jobs[0].res.TotalBytes == 0 {
+		t.Errorf("TotalBytes = %d, %d; want >0, 0", jobs[0].res.TotalBytes, jobs[1].res.TotalBytes)
+	}
+}
+
+func TestEventsSincePerClient(t *testing.T) {
+	r := newTestRepo(t, map[string]string{"a/b/10/10.lua": "-- lua"})
+	var mu sync.Mutex
+	var seen []Event
+	withEvents := r.client()
+	withEvents.OnEvent = func(e Event) {
+		mu.Lock()
+		seen = append(seen, e)
+		mu.Unlock()
+	}
+	quiet := r.client()
+	for _, c := range []*Client{withEvents, quiet} {
+		if _, err := c.Run(context.Background(), testConfig(t, map[string][]string{"10": nil})); err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+	}
+
+	got, complete := withEvents.EventsSince(0)
+	if !complete || len(got) == 0 || len(got) != len(seen) {
+		t.Fatalf("EventsSince(0) = %d events (complete %v), OnEvent saw %d", len(got), complete, len(seen))
+	}
+	for i, e := range got {
+		if e.Seq != int64(i+1) {
+			t.Fatalf("event %d has seq %d", i, e.Seq)
+		}
+	}
+	if later, _ := withEvents.EventsSince(got[len(got)-1].Seq); len(later) != 0 {
+		t.Errorf("EventsSince(last) = %d events, want 0", len(later))
+	}
+	// 另一个 Client 的运行没有开启事件，也看不到前者的事件
+	if other, _ := quiet.EventsSince(0); len(other) != 0 {
+		t.Errorf("quiet client EventsSince(0) = %d events, want 0", len(other))
+	}
+	if none, complete := (&Client{}).EventsSince(0); len(none) != 0 || !complete {
+		t.Errorf("unused client EventsSince(0) = %d events (complete %v)", len(none), complete)
+	}
+}
+
+func TestRunOutputMatchesResult(t *testing.T) {
+	// Output 收到的单行 JSON 与命令行输出相同，且与返回的 Result 一致
+	r := newTestRepo(t, map[string]string{
+		"a/b/10/10.lua":         "-- lua",
+		"a/b/10/11_22.manifest": testManifest,
+	})
+	var out bytes.Buffer
+	c := r.client()
+	c.HTTPClient = r.srv.Client()
+	c.Output = &out
+	cfg := testConfig(t, map[string][]string{"10": {"11_22"}})
+	cfg.OutputPath = "" // 设置了 output_path 时结果写入文件，Output 不再收到
+	res, err := c.Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !bytes.HasSuffix(out.Bytes(), []byte("\n")) || bytes.Count(out.Bytes(), []byte("\n")) != 1 {
+		t.Fatalf("Output = %q, want one JSON line", out.String())
+	}
+	var got Result
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("Output is not JSON: %v", err)
+	}
+	if got.Success != res.Success || got.Summary.Lua != res.Summary.Lua || got.Summary.Manifest != res.Summary.Manifest ||
+		got.TotalBytes != res.TotalBytes || len(got.Results) != len(res.Results) {
+		t.Errorf("Output result = %+v, returned %+v", got.Summary, res.Summary)
+	}
+	if !res.Success || res.Summary.Lua != 1 || res.Summary.Manifest != 1 {
+		t.Errorf("summary = %+v, want 1 lua and 1 manifest", res.Summary)
+	}
+}
+
+func TestTotalBytes(t *testing.T) {
+	// 多个 App、每个 App 多个清单并发下载，TotalBytes 等于实际写入的字节总和
+	files := map[string]string{}
+	appData := map[string][]string{}
+	var want, luaBytes int64
+	for app := 10; app < 14; app++ {
+		id := strconv.Itoa(app)
+		lua := "-- " + strings.Repeat(id, app)
+		files["a/b/"+id+"/"+id+".lua"] = lua
+		want += int64(len(lua))
+		luaBytes += int64(len(lua))
+		for depot := 0; depot < 5; depot++ {
+			item := fmt.Sprintf("%d%d_%d", app, depot, depot+1)
+			body := testManifest + strings.Repeat("x", app*100+depot)
+			files["a/b/"+id+"/"+item+".manifest"] = body
+			appData[id] = append(appData[id], item)
+			want += int64(len(body))
+		}
+	}
+	r := newTestRepo(t, files)
+	cfg := testConfig(t, appData)
+	res := r.download(t, cfg)
+	if res.Summary.Lua != 4 || res.Summary.Manifest != 20 {
+		t.Fatalf("summary = %+v", res.Summary)
+	}
+	if res.TotalBytes != want {
+		t.Errorf("TotalBytes = %d, want %d", res.TotalBytes, want)
+	}
+	if res.TotalTime <= 0 || res.BytesPerSecond != float64(res.TotalBytes)/res.TotalTime {
+		t.Errorf("BytesPerSecond = %v, TotalTime = %v", res.BytesPerSecond, res.TotalTime)
+	}
+
+	// 第二次运行跳过已有清单，只有重新获取的 Lua 计入字节
+	cfg.SkipExisting = true
+	if res := r.download(t, cfg); res.TotalBytes != luaBytes {
+		t.Errorf("skip run: TotalBytes = %d, want %d", res.TotalBytes, luaBytes)
+	}
+}
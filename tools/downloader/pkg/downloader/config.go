@@ -0,0 +1,460 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CONFIG_TOKEN_ENVS: 远程配置位于 GitHub 时依次读取的 Token 环境变量 (此时配置中的 token 尚不可用)
+var CONFIG_TOKEN_ENVS = []string{"DOWNLOADER_TOKEN", "GITHUB_TOKEN"}
+
+// readConfigSource 读取 -config 指定的配置：http:// 或 https:// 开头时通过网络获取，否则读本地文件
+func (rn *run) readConfigSource(src string) ([]byte, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return os.ReadFile(src)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), rn.requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", src, nil)
+	if err != nil {
+		return nil, err
+	}
+	// 配置尚未读取，只能使用默认 User-Agent
+	req.Header.Set("User-Agent", DEFAULT_USER_AGENT)
+	if rn.isGitHubURL(src) {
+		for _, env := range CONFIG_TOKEN_ENVS {
+			if token := strings.TrimSpace(os.Getenv(env)); token != "" {
+				req.Header.Set("Authorization", "token "+token)
+				break
+			}
+		}
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := rn.dl.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("获取远程配置失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("获取远程配置失败: %v", &statusError{code: resp.StatusCode})
+	}
+	// raw.githubusercontent.com 返回 text/plain，因此不强制 application/json，只拒绝明显的网页
+	if ct := resp.Header.Get("Content-Type"); strings.HasPrefix(ct, "text/html") {
+		return nil, fmt.Errorf("远程配置不是 JSON (Content-Type: %s)", ct)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// stdin 配置的默认大小上限与读取时限
+const (
+	DEFAULT_STDIN_MAX_BYTES = 64 << 20
+	DEFAULT_STDIN_TIMEOUT   = 30 * time.Second
+)
+
+// StdinOptions 限制从 stdin 读取配置：调用方崩溃而没有写入 stdin 时不会永远阻塞，
+// 也不会读入无限大的输入。为 0 的字段不限制
+type StdinOptions struct {
+	MaxBytes int64
+	Timeout  time.Duration
+	// Strict 为 true 时 (-strict) 配置中出现未知字段即报错，而不是静默忽略；对文件、URL 与 stdin 都生效
+	Strict bool
+}
+
+// DefaultStdinOptions 是 ReadConfig 使用的限制 (64 MB、30 秒)
+var DefaultStdinOptions = StdinOptions{MaxBytes: DEFAULT_STDIN_MAX_BYTES, Timeout: DEFAULT_STDIN_TIMEOUT}
+
+// ReadConfig 读取并解析配置：src 为空时从 stdin 解码 (限制见 DefaultStdinOptions)，否则按 -config 的规则读取文件或 URL。
+// 返回的错误信息与命令行输出的 error 字段一致。
+func ReadConfig(src string) (Config, error) {
+	return ReadConfigWith(src, DefaultStdinOptions)
+}
+
+// ReadConfigWith 与 ReadConfig 相同，但使用 opts 限制 stdin 的读取并选择是否拒绝未知字段
+func ReadConfigWith(src string, opts StdinOptions) (Config, error) {
+	var config Config
+	if src == "" {
+		return readStdinConfig(os.Stdin, opts)
+	}
+	// 配置读取早于任何运行，远程配置使用默认设置的运行状态 (超时、HTTP 客户端)
+	data, err := newRun().readConfigSource(src)
+	if err == nil && bytes.HasPrefix(data, gzipMagic) {
+		data, err = gunzip(data)
+	}
+	if err != nil {
+		return config, &ConfigError{Code: CODE_CONFIG_UNREADABLE, Msg: "无法读取配置文件: " + err.Error()}
+	}
+	if !opts.Strict {
+		if err := json.Unmarshal(stripBOM(data), &config); err != nil {
+			return config, &ConfigError{Code: CODE_BAD_JSON, Msg: "配置文件 JSON 解析失败: " + err.Error()}
+		}
+		return config, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(stripBOM(data)))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&config)
+	if err == nil {
+		// 与 json.Unmarshal 一样拒绝 JSON 之后的多余内容
+		if _, terr := dec.Token(); terr != io.EOF {
+			err = errors.New("JSON 之后还有多余内容")
+		}
+	}
+	if err != nil {
+		if ce := unknownFieldError(err, "配置文件"); ce != nil {
+			return config, ce
+		}
+		return config, &ConfigError{Code: CODE_BAD_JSON, Msg: "配置文件 JSON 解析失败: " + err.Error()}
+	}
+	return config, nil
+}
+
+// unknownFieldError 把 DisallowUnknownFields 的错误转换为指明字段的 ConfigError，其它错误返回 nil
+func unknownFieldError(err error, source string) *ConfigError {
+	field, ok := strings.CutPrefix(err.Error(), "json: unknown field ")
+	if !ok {
+		return nil
+	}
+	field = strings.Trim(field, `"`)
+	return &ConfigError{Code: CODE_UNKNOWN_FIELD, Field: field, Msg: fmt.Sprintf("%s中有未知字段 %q (-strict 模式不忽略未知字段，请检查拼写)", source, field)}
+}
+
+// gzipMagic 是 gzip 数据开头的两个字节：以此开头的配置文件、远程配置与 stdin 先透明解压再解析
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// gunzip 解压整个 gzip 数据
+func gunzip(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("gzip 解压失败: %v", err)
+	}
+	out, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("gzip 解压失败: %v", err)
+	}
+	return out, nil
+}
+
+// gzipError 表示 stdin 以 gzip 魔数开头但不是有效的 gzip 数据
+type gzipError struct{ err error }
+
+func (e *gzipError) Error() string { return "gzip 解压失败: " + e.err.Error() }
+
+// gzipBody 把解压过程中的错误 (截断、校验和不符) 包装为 gzipError，避免被当作 JSON 解析错误
+type gzipBody struct{ zr *gzip.Reader }
+
+func (g gzipBody) Read(p []byte) (int, error) {
+	n, err := g.zr.Read(p)
+	if err != nil && err != io.EOF {
+		err = &gzipError{err}
+	}
+	return n, err
+}
+
+// errStdinTooLarge 表示 stdin 的内容超过 StdinOptions.MaxBytes
+var errStdinTooLarge = errors.New("stdin too large")
+
+// readStdinConfig 从 r 解码一个 JSON 配置，gzip 压缩的输入先解压 (MaxBytes 按解压后的大小计算)。
+// 只需读到 JSON 结束，调用方不必关闭 stdin；超时后读取协程仍阻塞在 r 上，随进程退出
+func readStdinConfig(r io.Reader, opts StdinOptions) (Config, error) {
+	type decoded struct {
+		config Config
+		err    error
+	}
+	done := make(chan decoded, 1)
+	go func() {
+		var d decoded
+		br := bufio.NewReader(r)
+		var src io.Reader = br
+		if head, err := br.Peek(len(gzipMagic)); err == nil && bytes.Equal(head, gzipMagic) {
+			zr, err := gzip.NewReader(br)
+			if err != nil {
+				done <- decoded{err: &gzipError{err}}
+				return
+			}
+			src = gzipBody{zr}
+		}
+		if opts.MaxBytes > 0 {
+			src = &capReader{r: src, n: opts.MaxBytes}
+		}
+		dec := json.NewDecoder(skipBOM(src))
+		if opts.Strict {
+			dec.DisallowUnknownFields()
+		}
+		d.err = dec.Decode(&d.config)
+		done <- d
+	}()
+	var timeout <-chan time.Time
+	if opts.Timeout > 0 {
+		timer := time.NewTimer(opts.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case d := <-done:
+		switch {
+		case errors.Is(d.err, errStdinTooLarge):
+			return Config{}, &ConfigError{Code: CODE_STDIN_TOO_LARGE, Msg: fmt.Sprintf("Stdin 配置超过 %d 字节上限", opts.MaxBytes)}
+		case errors.As(d.err, new(*gzipError)):
+			return Config{}, &ConfigError{Code: CODE_CONFIG_UNREADABLE, Msg: "Stdin 配置 " + d.err.Error()}
+		case d.err != nil:
+			if ce := unknownFieldError(d.err, "Stdin 配置"); ce != nil {
+				return Config{}, ce
+			}
+			return Config{}, &ConfigError{Code: CODE_BAD_JSON, Msg: "Stdin JSON 解析失败: " + d.err.Error()}
+		}
+		return d.config, nil
+	case <-timeout:
+		return Config{}, &ConfigError{Code: CODE_STDIN_TIMEOUT, Msg: fmt.Sprintf("%s 内没有从 stdin 读到完整的 JSON 配置 (未指定 -config 时配置须通过 stdin 传入)", opts.Timeout)}
+	}
+}
+
+// capReader 最多读取 n 字节；恰好读满后若还有数据则返回 errStdinTooLarge，否则照常返回 EOF
+type capReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *capReader) Read(p []byte) (int, error) {
+	if c.n <= 0 {
+		var one [1]byte
+		n, err := c.r.Read(one[:])
+		if n > 0 {
+			return 0, errStdinTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > c.n {
+		p = p[:c.n]
+	}
+	n, err := c.r.Read(p)
+	c.n -= int64(n)
+	return n, err
+}
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// stripBOM 去掉 Excel/记事本导出文件开头的 UTF-8 BOM
+func stripBOM(data []byte) []byte {
+	return bytes.TrimPrefix(data, utf8BOM)
+}
+
+// skipBOM 包装 reader，若开头是 UTF-8 BOM 则跳过
+func skipBOM(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	if head, err := br.Peek(len(utf8BOM)); err == nil && bytes.Equal(head, utf8BOM) {
+		br.Discard(len(utf8BOM))
+	}
+	return br
+}
+
+// normalizeConfig 去掉 token、repo、app_ids 与 app_data 中多余的首尾空白，
+// 返回被修正字段的警告信息 (复制粘贴的 Token 带空格会导致每个请求都鉴权失败)
+func normalizeConfig(config *Config) []string {
+	var warnings []string
+	trim := func(field string, v *string) {
+		t := strings.TrimSpace(strings.TrimPrefix(*v, "\ufeff"))
+		if t != *v {
+			warnings = append(warnings, field+" 含有首尾空白，已自动去除")
+			*v = t
+		}
+	}
+
+	trim("token", &config.Token)
+	trim("repo", &config.Repo)
+
+	ids := config.AppIDs[:0]
+	changed := false
+	for _, id := range config.AppIDs {
+		t := strings.TrimSpace(id)
+		if t != id {
+			changed = true
+		}
+		if t != "" {
+			ids = append(ids, t)
+		}
+	}
+	config.AppIDs = ids
+	if changed {
+		warnings = append(warnings, "app_ids 中部分条目含有首尾空白，已自动去除")
+	}
+
+	if len(config.AppData) > 0 {
+		changed = false
+		data := make(map[string][]string, len(config.AppData))
+		for key, items := range config.AppData {
+			k := strings.TrimSpace(key)
+			if k != key {
+				changed = true
+			}
+			for _, item := range items {
+				t := strings.TrimSpace(item)
+				if t != item {
+					changed = true
+				}
+				if t != "" {
+					data[k] = append(data[k], t)
+				}
+			}
+		}
+		config.AppData = data
+		if changed {
+			warnings = append(warnings, "app_data 中部分条目含有首尾空白，已自动去除")
+		}
+	}
+	return warnings
+}
+
+// canonicalAppID 把表格导出常见的 "0570"、"570.0" 等写法规范为 "570"，非数字或 0 返回 ok=false
+func canonicalAppID(id string) (string, bool) {
+	id = strings.TrimSpace(strings.TrimPrefix(id, "\ufeff"))
+	if i := strings.IndexByte(id, '.'); i > 0 && strings.Trim(id[i+1:], "0") == "" {
+		id = id[:i]
+	}
+	if !isDigits(id) {
+		return "", false
+	}
+	id = strings.TrimLeft(id, "0")
+	if id == "" {
+		return "", false
+	}
+	return id, true
+}
+
+// canonicalizeAppIDs 规范 app_ids 与 app_data 的键并去重，请求始终使用规范形式。
+// 返回 规范 ID -> 原始写法 (仅包含被改写的条目) 以及被拒绝的非数字条目。
+func canonicalizeAppIDs(config *Config) (map[string][]string, []string) {
+	mapping := make(map[string][]string)
+	var rejected []string
+	seen := make(map[string]bool, len(config.AppIDs))
+	ids := make([]string, 0, len(config.AppIDs))
+	for _, id := range config.AppIDs {
+		c, ok := canonicalAppID(id)
+		if !ok {
+			rejected = append(rejected, id)
+			continue
+		}
+		if c != id {
+			mapping[c] = append(mapping[c], id)
+		}
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+		ids = append(ids, c)
+	}
+	config.AppIDs = ids
+
+	if len(config.AppData) > 0 {
+		data := make(map[string][]string, len(config.AppData))
+		for key, items := range config.AppData {
+			c, ok := canonicalAppID(key)
+			if !ok {
+				c = key
+			}
+			data[c] = mergeManifestItems(data[c], items)
+		}
+		config.AppData = data
+	}
+	if len(mapping) == 0 {
+		mapping = nil
+	}
+	return mapping, rejected
+}
+
+// MAX_APPID_RANGE 是 -appids-file 中单个区间 (如 220-240) 允许展开的最大 ID 数，防止笔误生成海量任务
+const MAX_APPID_RANGE = 100000
+
+// readAppIDList 读取 -appids-file 指定的 ID 列表 ("-" 表示 stdin)：每行一个 ID 或区间 "220-240"，
+// 忽略空行与 # 之后的注释。返回展开后的 ID (未去重) 与无法识别的条目。
+func readAppIDList(src string) ([]string, []string, error) {
+	var r io.Reader
+	if src == "-" {
+		r = os.Stdin
+	} else {
+		f, err := os.Open(src)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var ids, rejected []string
+	scanner := bufio.NewScanner(skipBOM(r))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(line, "-")
+		if !isRange {
+			if _, ok := canonicalAppID(line); !ok {
+				rejected = append(rejected, line)
+				continue
+			}
+			ids = append(ids, line)
+			continue
+		}
+		from, err1 := strconv.Atoi(strings.TrimSpace(lo))
+		to, err2 := strconv.Atoi(strings.TrimSpace(hi))
+		if err1 != nil || err2 != nil || from <= 0 || to < from || to-from >= MAX_APPID_RANGE {
+			rejected = append(rejected, line)
+			continue
+		}
+		for id := from; id <= to; id++ {
+			ids = append(ids, strconv.Itoa(id))
+		}
+	}
+	return ids, rejected, scanner.Err()
+}
+
+// normalizeTokens 合并 token 与 tokens 为去重后的列表：Token 为首个，Tokens 为完整列表
+func normalizeTokens(config *Config) {
+	seen := make(map[string]bool)
+	var all []string
+	for _, t := range append([]string{config.Token}, config.Tokens...) {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		all = append(all, t)
+	}
+	config.Tokens = all
+	if len(all) > 0 {
+		config.Token = all[0]
+	}
+}
+
+// normalizeRepos 合并 repo 与 repos 为去重后的优先级列表：Repo 为首个仓库，Repos 为完整列表
+func normalizeRepos(config *Config) {
+	seen := make(map[string]bool)
+	var all []string
+	for _, r := range append([]string{config.Repo}, config.Repos...) {
+		r = strings.Trim(strings.TrimSpace(r), "/")
+		if r == "" || seen[strings.ToLower(r)] {
+			continue
+		}
+		seen[strings.ToLower(r)] = true
+		all = append(all, r)
+	}
+	config.Repos = all
+	if len(all) > 0 {
+		config.Repo = all[0]
+	}
+}
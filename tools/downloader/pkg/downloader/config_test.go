@@ -0,0 +1,484 @@
+package downloader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadConfigGzip(t *testing.T) {
+	plain := []byte(`{"repos":["a/b"],"app_ids":["10"]}`)
+	big := []byte(`{"repos":["a/b"],"app_ids":["10"],"lua_dir":"` + string(bytes.Repeat([]byte("x"), 200)) + `"}`)
+	tests := []struct {
+		name     string
+		data     []byte
+		maxBytes int64
+		code     string // 期望的 ConfigError.Code，空表示成功
+	}{
+		{"plain", plain, 0, ""},
+		{"gzip", gzipBytes(t, plain), 0, ""},
+		{"truncated gzip", gzipBytes(t, plain)[:12], 0, CODE_CONFIG_UNREADABLE},
+		{"bad gzip header", append(append([]byte{}, gzipMagic...), "not gzip"...), 0, CODE_CONFIG_UNREADABLE},
+		// 上限按解压后的大小计算：压缩后很小的内容仍会超限
+		{"gzip over limit", gzipBytes(t, big), 100, CODE_STDIN_TOO_LARGE},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := func(src string, config Config, err error) {
+				t.Helper()
+				if tt.code == "" {
+					if err != nil {
+						t.Fatalf("%s: %v", src, err)
+					}
+					if len(config.AppIDs) != 1 || config.AppIDs[0] != "10" {
+						t.Errorf("%s: AppIDs = %v", src, config.AppIDs)
+					}
+					return
+				}
+				var ce *ConfigError
+				if !errors.As(err, &ce) || ce.Code != tt.code {
+					t.Errorf("%s: err = %v, want code %s", src, err, tt.code)
+				}
+			}
+			config, err := readStdinConfig(bytes.NewReader(tt.data), StdinOptions{MaxBytes: tt.maxBytes})
+			check("stdin", config, err)
+			if tt.maxBytes > 0 {
+				return // 文件来源没有大小上限
+			}
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, tt.data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			config, err = ReadConfigWith(path, StdinOptions{})
+			check("file", config, err)
+		})
+	}
+}
+
+func TestReadStdinLimits(t *testing.T) {
+	plain := `{"repos":["a/b"],"app_ids":["10"]}`
+	tests := []struct {
+		name     string
+		data     string
+		maxBytes int64
+		code     string // 期望的 ConfigError.Code，空表示成功
+	}{
+		{"exact limit", plain, int64(len(plain)), ""},
+		{"one byte over", plain, int64(len(plain)) - 1, CODE_STDIN_TOO_LARGE},
+		{"oversized", `{"lua_dir":"` + strings.Repeat("x", 1<<20) + `"}`, 1 << 10, CODE_STDIN_TOO_LARGE},
+		// 调用方写完 JSON 却不关闭 stdin 时照常返回
+		{"complete json, open pipe", plain, 0, ""},
+		// 调用方只写了一半就挂起：在时限内返回超时而不是永远阻塞
+		{"partial json, open pipe", plain[:10], 0, CODE_STDIN_TIMEOUT},
+		{"nothing written", "", 0, CODE_STDIN_TIMEOUT},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr, pw := io.Pipe()
+			t.Cleanup(func() { pw.Close() })
+			go pw.Write([]byte(tt.data)) // 写完后不关闭
+			start := time.Now()
+			config, err := readStdinConfig(pr, StdinOptions{MaxBytes: tt.maxBytes, Timeout: 200 * time.Millisecond})
+			if d := time.Since(start); d > 2*time.Second {
+				t.Errorf("readStdinConfig took %s", d)
+			}
+			if tt.code == "" {
+				if err != nil || len(config.AppIDs) != 1 {
+					t.Errorf("config = %+v, err = %v", config, err)
+				}
+				return
+			}
+			var ce *ConfigError
+			if !errors.As(err, &ce) || ce.Code != tt.code {
+				t.Errorf("err = %v, want code %s", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestReadConfigGzipLargeRoundTrip(t *testing.T) {
+	// 大批量配置压缩与不压缩读取的结果必须完全相同
+	cfg := Config{Repos: []string{"a/b", "c/d"}, AppData: map[string][]string{}}
+	for i := 0; i < 10000; i++ {
+		id := strconv.Itoa(100000 + i)
+		cfg.AppIDs = append(cfg.AppIDs, id)
+		cfg.AppData[id] = []string{id + "_" + strconv.Itoa(i*7919)}
+	}
+	plain, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	var configs []Config
+	for name, data := range map[string][]byte{"config.json": plain, "config.json.gz": gzipBytes(t, plain)} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		fromFile, err := ReadConfigWith(path, StdinOptions{})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		fromStdin, err := readStdinConfig(bytes.NewReader(data), StdinOptions{})
+		if err != nil {
+			t.Fatalf("%s via stdin: %v", name, err)
+		}
+		configs = append(configs, fromFile, fromStdin)
+	}
+	for i, c := range configs {
+		if !reflect.DeepEqual(c, configs[0]) {
+			t.Fatalf("config %d differs from the uncompressed file", i)
+		}
+	}
+	if len(configs[0].AppIDs) != 10000 || len(configs[0].AppData) != 10000 {
+		t.Errorf("AppIDs = %d, AppData = %d, want 10000", len(configs[0].AppIDs), len(configs[0].AppData))
+	}
+}
+
+func TestReadConfigStrict(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		field string // strict 时期望报错的字段，空表示两种模式都接受
+	}{
+		{"known fields", `{"repos":["a/b"],"app_ids":["10"],"manifest_dir":"m"}`, ""},
+		{"typo", `{"repos":["a/b"],"app_ids":["10"],"manifests_dir":"m"}`, "manifests_dir"},
+		{"unknown bool", `{"repos":["a/b"],"app_ids":["10"],"dryrun":true}`, "dryrun"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tt.data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			for _, strict := range []bool{false, true} {
+				opts := StdinOptions{Strict: strict}
+				for src, read := range map[string]func() (Config, error){
+					"file":  func() (Config, error) { return ReadConfigWith(path, opts) },
+					"stdin": func() (Config, error) { return readStdinConfig(strings.NewReader(tt.data), opts) },
+				} {
+					config, err := read()
+					if !strict || tt.field == "" {
+						// 宽松模式 (默认) 忽略未知字段
+						if err != nil || len(config.AppIDs) != 1 {
+							t.Errorf("%s strict=%v: %v (app_ids %v)", src, strict, err, config.AppIDs)
+						}
+						continue
+					}
+					var ce *ConfigError
+					if !errors.As(err, &ce) || ce.Code != CODE_UNKNOWN_FIELD || ce.Field != tt.field {
+						t.Errorf("%s strict: err = %v, want unknown_field %q", src, err, tt.field)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCanonicalAppID(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string // 空表示拒绝
+	}{
+		{"570", "570"},
+		{"0570", "570"},
+		{"570 ", "570"},
+		{"\t 570\r", "570"},
+		{"\ufeff570", "570"},
+		{"570.0", "570"}, // 表格把数字导出为浮点
+		{"000730", "730"},
+		{"0", ""},
+		{"000", ""},
+		{"", ""},
+		{"570.5", ""},
+		{"-570", ""},
+		{"+570", ""},
+		{"5 70", ""},
+		{"57O", ""},
+		{"Dota 2", ""},
+		{"０５７０", ""}, // 全角数字
+	}
+	for _, tt := range tests {
+		got, ok := canonicalAppID(tt.in)
+		if ok != (tt.want != "") || got != tt.want {
+			t.Errorf("canonicalAppID(%q) = %q, %v; want %q", tt.in, got, ok, tt.want)
+		}
+	}
+}
+
+func TestCanonicalizeAppIDs(t *testing.T) {
+	cfg := Config{
+		AppIDs:  []string{"0570", "570", " 730", "abc", "0730 ", "440"},
+		AppData: map[string][]string{"0570": {"571_1"}, "570": {"572_2"}, "abc": {"1_1"}},
+	}
+	mapping, rejected := canonicalizeAppIDs(&cfg)
+	if want := []string{"570", "730", "440"}; !reflect.DeepEqual(cfg.AppIDs, want) {
+		t.Errorf("app_ids = %v, want %v", cfg.AppIDs, want)
+	}
+	if want := map[string][]string{"570": {"0570"}, "730": {" 730", "0730 "}}; !reflect.DeepEqual(mapping, want) {
+		t.Errorf("mapping = %v, want %v", mapping, want)
+	}
+	if want := []string{"abc"}; !reflect.DeepEqual(rejected, want) {
+		t.Errorf("rejected = %v, want %v", rejected, want)
+	}
+	// 写法不同的同一 App 的清单合并到规范键下
+	if got := cfg.AppData["570"]; len(got) != 2 || cfg.AppData["0570"] != nil {
+		t.Errorf("app_data = %v", cfg.AppData)
+	}
+
+	// 全部规范时不生成对照表
+	cfg = Config{AppIDs: []string{"10", "20"}}
+	if mapping, rejected := canonicalizeAppIDs(&cfg); mapping != nil || rejected != nil {
+		t.Errorf("mapping = %v, rejected = %v, want nil", mapping, rejected)
+	}
+}
+
+func TestRunCanonicalAppIDs(t *testing.T) {
+	r := newTestRepo(t, map[string]string{"a/b/10/10.lua": "-- 10", "a/b/10/11_22.manifest": testManifest})
+	cfg := testConfig(t, nil)
+	cfg.AppIDs = []string{"0010", "x10"}
+	cfg.AppData = map[string][]string{"010": {"11_22"}}
+	res := r.download(t, cfg)
+	// 请求使用规范形式，不会去探测不存在的 0010 分支
+	if r.count("a/b/10/10.lua") == 0 || r.count("a/b/0010/0010.lua") != 0 || r.count("a/b/10/11_22.manifest") != 1 {
+		t.Errorf("requests: 10.lua %d, 11_22 %d", r.count("a/b/10/10.lua"), r.count("a/b/10/11_22.manifest"))
+	}
+	if len(res.Results) != 1 || res.Results[0].AppID != "10" || res.Summary.Manifest != 1 {
+		t.Errorf("results = %+v", res.Results)
+	}
+	if want := map[string][]string{"10": {"0010"}}; !reflect.DeepEqual(res.AppIDMap, want) {
+		t.Errorf("app_id_map = %v, want %v", res.AppIDMap, want)
+	}
+	if want := []string{"x10"}; !reflect.DeepEqual(res.RejectedAppIDs, want) {
+		t.Errorf("rejected_app_ids = %v, want %v", res.RejectedAppIDs, want)
+	}
+}
+
+func TestReadConfigURL(t *testing.T) {
+	plain := `{"repos":["a/b"],"app_ids":["10"]}`
+	tests := []struct {
+		name        string
+		contentType string
+		status      int
+		body        []byte
+		code        string // 期望的 ConfigError.Code，空表示成功
+	}{
+		{"json", "application/json", 200, []byte(plain), ""},
+		// raw.githubusercontent.com 以 text/plain 返回
+		{"text plain", "text/plain; charset=utf-8", 200, []byte(plain), ""},
+		{"gzip", "application/octet-stream", 200, gzipBytes(t, []byte(plain)), ""},
+		{"not found", "text/plain", 404, []byte("404: Not Found"), CODE_CONFIG_UNREADABLE},
+		{"html page", "text/html", 200, []byte("<html>login</html>"), CODE_CONFIG_UNREADABLE},
+		{"bad json", "application/json", 200, []byte(`{"repos":`), CODE_BAD_JSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DOWNLOADER_TOKEN", "ghp_env")
+			r := newTestRepo(t, nil)
+			var auth, accept string
+			r.handle("job/config.json", func(w http.ResponseWriter, req *http.Request) {
+				auth, accept = req.Header.Get("Authorization"), req.Header.Get("Accept")
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				w.Write(tt.body)
+			})
+			config, err := ReadConfigWith(r.srv.URL+"/job/config.json", StdinOptions{})
+			if tt.code == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(config.Repos, []string{"a/b"}) || !reflect.DeepEqual(config.AppIDs, []string{"10"}) {
+					t.Errorf("config = %+v", config)
+				}
+			} else {
+				var ce *ConfigError
+				if !errors.As(err, &ce) || ce.Code != tt.code {
+					t.Errorf("err = %v, want code %s", err, tt.code)
+				}
+			}
+			// 非 GitHub 地址不能带上环境变量中的 Token
+			if auth != "" || accept != "application/json" {
+				t.Errorf("Authorization %q, Accept %q", auth, accept)
+			}
+		})
+	}
+}
+
+func TestReadConfigURLGitHubToken(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"downloader token", map[string]string{"DOWNLOADER_TOKEN": "ghp_a", "GITHUB_TOKEN": "ghp_b"}, "token ghp_a"},
+		{"github token", map[string]string{"DOWNLOADER_TOKEN": " ", "GITHUB_TOKEN": "ghp_b"}, "token ghp_b"},
+		{"none", map[string]string{"DOWNLOADER_TOKEN": "", "GITHUB_TOKEN": ""}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			r := newTestRepo(t, nil)
+			var auth string
+			r.handle("a/b/main/config.json", func(w http.ResponseWriter, req *http.Request) {
+				auth = req.Header.Get("Authorization")
+				io.WriteString(w, `{"repos":["a/b"]}`)
+			})
+			rn := newRun()
+			rn.rawBase = r.srv.URL
+			data, err := rn.readConfigSource(r.srv.URL + "/a/b/main/config.json")
+			if err != nil || string(data) != `{"repos":["a/b"]}` {
+				t.Fatalf("readConfigSource = %q, %v", data, err)
+			}
+			if auth != tt.want {
+				t.Errorf("Authorization = %q, want %q", auth, tt.want)
+			}
+		})
+	}
+}
+
+// dirtyConfig 模拟 Excel 导出与复制粘贴产生的配置：开头带 BOM，Token 与各条目带首尾空白
+const dirtyConfig = "\xef\xbb\xbf{\r\n" +
+	`"repo": " a/b\t", "token": "abc \t", "app_ids": [" 10 ", "\t20", "  "],` + "\r\n" +
+	`"app_data": {" 10": [" 11_22 ", "", "12_33\t"], "20": []}` + "\r\n}\r\n"
+
+func TestReadConfigBOM(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(dirtyConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, strict := range []bool{false, true} {
+		opts := StdinOptions{Strict: strict}
+		for src, read := range map[string]func() (Config, error){
+			"file":  func() (Config, error) { return ReadConfigWith(path, opts) },
+			"stdin": func() (Config, error) { return readStdinConfig(strings.NewReader(dirtyConfig), opts) },
+			"gzip": func() (Config, error) {
+				return readStdinConfig(bytes.NewReader(gzipBytes(t, []byte(dirtyConfig))), opts)
+			},
+		} {
+			config, err := read()
+			if err != nil || config.Token != "abc \t" || len(config.AppIDs) != 3 {
+				t.Errorf("%s strict=%v: token %q, app_ids %q, err %v", src, strict, config.Token, config.AppIDs, err)
+			}
+		}
+	}
+}
+
+func TestNormalizeConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config
+		want     Config
+		warnings int
+	}{
+		{
+			"clean",
+			Config{Repo: "a/b", Token: "abc", AppIDs: []string{"10"}, AppData: map[string][]string{"10": {"11_22"}}},
+			Config{Repo: "a/b", Token: "abc", AppIDs: []string{"10"}, AppData: map[string][]string{"10": {"11_22"}}},
+			0,
+		},
+		{
+			"dirty",
+			Config{
+				Repo: " a/b\t", Token: "\ufeffabc \t", AppIDs: []string{" 10 ", "\t20", "  "},
+				AppData: map[string][]string{" 10": {" 11_22 ", "", "12_33\t"}, "20": {"21_1"}},
+			},
+			Config{Repo: "a/b", Token: "abc", AppIDs: []string{"10", "20"}, AppData: map[string][]string{"10": {"11_22", "12_33"}, "20": {"21_1"}}},
+			4,
+		},
+		{
+			"token only",
+			Config{Token: "abc\n", AppIDs: []string{"10"}},
+			Config{Token: "abc", AppIDs: []string{"10"}},
+			1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			warnings := normalizeConfig(&config)
+			if config.Repo != tt.want.Repo || config.Token != tt.want.Token || !reflect.DeepEqual(config.AppIDs, tt.want.AppIDs) ||
+				len(config.AppData) != len(tt.want.AppData) || (len(tt.want.AppData) > 0 && !reflect.DeepEqual(config.AppData, tt.want.AppData)) {
+				t.Errorf("normalized = repo %q, token %q, app_ids %q, app_data %q", config.Repo, config.Token, config.AppIDs, config.AppData)
+			}
+			if len(warnings) != tt.warnings {
+				t.Errorf("warnings = %q, want %d", warnings, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestRunDirtyConfig(t *testing.T) {
+	// 带空白的 Token 原样发送会在每个请求上鉴权失败
+	r := newTestRepo(t, map[string]string{
+		"a/b/10/10.lua":         "-- 10",
+		"a/b/10/11_22.manifest": testManifest,
+		"a/b/10/12_33.manifest": testManifest,
+		"a/b/20/20.lua":         "-- 20",
+	})
+	r.srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "token abc" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.serve(w, req)
+	})
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(dirtyConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := ReadConfigWith(path, StdinOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := testConfig(t, nil)
+	cfg.LuaDir, cfg.ManifestDir, cfg.OutputPath = base.LuaDir, base.ManifestDir, base.OutputPath
+	cfg.DirectMode, cfg.DisableBranchDetect, cfg.RetryBaseMs, cfg.RetryMaxMs = true, true, 1, 1
+
+	var mu sync.Mutex
+	var trimmed []string
+	c := r.client()
+	c.OnEvent = func(e Event) {
+		if msg, _ := e.Fields["message"].(string); e.Type == "warning" && strings.Contains(msg, "首尾空白") {
+			mu.Lock()
+			trimmed = append(trimmed, msg)
+			mu.Unlock()
+		}
+	}
+	res, err := c.Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Summary.Apps != 2 || res.Summary.Lua != 2 || res.Summary.Manifest != 2 {
+		t.Errorf("summary = %+v, want both apps and both manifests", res.Summary)
+	}
+	if len(trimmed) != 4 {
+		t.Errorf("trim warnings = %q, want token, repo, app_ids and app_data", trimmed)
+	}
+}
@@ -0,0 +1,127 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// CONTENTS_API_ACCEPT 让 contents API 直接返回文件原始内容 (支持到 100 MB)，
+// 不需要解码 base64 的 content 字段，也不受该字段 1 MB 的限制
+const CONTENTS_API_ACCEPT = "application/vnd.github.raw"
+
+// 仓库的下载方式，由实际请求结果学习得到
+const (
+	repoModeUnknown = iota
+	repoModeRaw     // raw 地址成功过：之后的 404 是文件确实不存在，不再回退
+	repoModeAPI     // raw 失败而 API 成功：之后直接使用 API，避免每个文件都先请求一次 raw
+)
+
+type repoModes struct {
+	mu sync.Mutex
+	m  map[string]int
+}
+
+func (r *repoModes) get(repo string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.m[repo]
+}
+
+// preferred 判断 repo 是否已确认只能通过 API 下载
+func (r *repoModes) preferred(repo string) bool {
+	return r.get(repo) == repoModeAPI
+}
+
+// fallback 判断 raw 失败后是否值得改用 API
+func (r *repoModes) fallback(repo string) bool {
+	return r.get(repo) != repoModeRaw
+}
+
+func (r *repoModes) rawWorks(repo string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.m[repo] == repoModeUnknown {
+		r.m[repo] = repoModeRaw
+	}
+}
+
+// apiWorks 记录 repo 需要走 API，首次记录时返回 true
+func (r *repoModes) apiWorks(repo string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.m[repo] != repoModeUnknown {
+		return false
+	}
+	r.m[repo] = repoModeAPI
+	return true
+}
+
+// contentsURL 返回 GET /repos/{repo}/contents/{path}?ref={branch} 地址
+func (rn *run) contentsURL(repo, branch, path string) string {
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return fmt.Sprintf("%s/repos/%s/contents/%s?ref=%s", rn.apiBase, repo, strings.Join(parts, "/"), url.QueryEscape(branch))
+}
+
+// isContentsURL 判断地址是否为 contents API 请求 (需要带 raw 媒体类型)
+func (rn *run) isContentsURL(rawURL string) bool {
+	return strings.HasPrefix(rawURL, rn.apiBase+"/repos/") && strings.Contains(rawURL, "/contents/")
+}
+
+// MAX_CONTENTS_JSON_BYTES 是 contents API 以 JSON 返回时读取的上限：base64 的 content 字段只在 1 MB 以内的文件中提供
+const MAX_CONTENTS_JSON_BYTES = 4 << 20
+
+// contentsFile 是 contents API 的 JSON 响应中用到的字段
+type contentsFile struct {
+	Type     string `json:"type"`
+	Encoding string `json:"encoding"`
+	Content  string `json:"content"`
+}
+
+// contentsBody 处理忽略 raw 媒体类型、仍以 JSON 返回的 contents API (旧版 GitHub Enterprise 或改写 Accept 的代理)：
+// 解码 base64 的 content 字段；原始内容直接返回
+func contentsBody(resp *http.Response, body io.Reader, length int64) (io.Reader, int64, error) {
+	mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mt != "application/json" {
+		return body, length, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(body, MAX_CONTENTS_JSON_BYTES+1))
+	if err != nil {
+		return nil, 0, err
+	}
+	var f contentsFile
+	if len(data) > MAX_CONTENTS_JSON_BYTES || json.Unmarshal(data, &f) != nil || f.Type != "file" {
+		return nil, 0, &corruptError{reason: "contents API 返回的不是文件内容", permanent: true}
+	}
+	if f.Encoding != "base64" {
+		return nil, 0, &corruptError{reason: fmt.Sprintf("contents API 的编码 %q 不受支持 (文件超过 1 MB 时只能使用 raw 媒体类型)", f.Encoding), permanent: true}
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(f.Content, "\n", ""))
+	if err != nil {
+		return nil, 0, &corruptError{reason: "contents API 的 base64 内容无效"}
+	}
+	return bytes.NewReader(raw), int64(len(raw)), nil
+}
+
+// fetchContentsAPI 通过 contents API 下载文件，校验与写入流程和 raw 下载相同
+func (rn *run) fetchContentsAPI(ctx context.Context, repo, branch, path, destPath, token, etag string) (download, error) {
+	apiURL := rn.contentsURL(repo, branch, path)
+	rn.debugf("%s/%s 通过 contents API 获取: %s", branch, path, apiURL)
+	d, err := rn.downloadFileWithRetry(ctx, apiURL, destPath, token, etag)
+	if err != nil {
+		return download{URL: apiURL}, err
+	}
+	d.Repo = repo
+	return d, nil
+}
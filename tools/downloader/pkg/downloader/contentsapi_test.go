@@ -0,0 +1,124 @@
+package downloader
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestContentsAPIFallback(t *testing.T) {
+	files := map[string]string{
+		"10.lua":         "-- private 10",
+		"11_22.manifest": testManifest,
+		"12_33.manifest": testManifest + "12",
+	}
+	tests := []struct {
+		name string
+		json bool // 忽略 raw 媒体类型，以 base64 的 JSON 返回
+	}{
+		{"raw media type", false},
+		{"base64 json", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// raw 地址对私有仓库一律 404，只有带 Token 的 contents API 能取到文件
+			r := newTestRepo(t, nil)
+			var mu sync.Mutex
+			var auth, accept []string
+			for p, body := range files {
+				p, body := p, body
+				r.handle("repos/a/b/contents/"+p, func(w http.ResponseWriter, req *http.Request) {
+					mu.Lock()
+					auth = append(auth, req.Header.Get("Authorization"))
+					accept = append(accept, req.Header.Get("Accept"))
+					mu.Unlock()
+					if req.Header.Get("Authorization") != "token ghp_private" || req.URL.Query().Get("ref") != "10" {
+						http.NotFound(w, req)
+						return
+					}
+					if tt.json {
+						w.Header().Set("Content-Type", "application/json; charset=utf-8")
+						enc := base64.StdEncoding.EncodeToString([]byte(body))
+						// GitHub 每 60 个字符换行
+						var lines []string
+						for len(enc) > 60 {
+							lines, enc = append(lines, enc[:60]), enc[60:]
+						}
+						json.NewEncoder(w).Encode(contentsFile{Type: "file", Encoding: "base64", Content: strings.Join(append(lines, enc), "\n")})
+						return
+					}
+					w.Header().Set("Content-Type", "application/octet-stream")
+					w.Write([]byte(body))
+				})
+			}
+			cfg := testConfig(t, map[string][]string{"10": {"11_22", "12_33"}})
+			cfg.Token = "ghp_private"
+			res := r.download(t, cfg)
+			if res.Summary.Lua != 1 || res.Summary.Manifest != 2 {
+				t.Fatalf("summary = %+v, want 1 lua, 2 manifests", res.Summary)
+			}
+			for p, body := range files {
+				dir := cfg.ManifestDir
+				if strings.HasSuffix(p, ".lua") {
+					dir = cfg.LuaDir
+				}
+				data, err := os.ReadFile(filepath.Join(dir, p))
+				if err != nil || string(data) != body {
+					t.Errorf("%s = %d bytes (%v), want original content", p, len(data), err)
+				}
+			}
+			for i := range auth {
+				if auth[i] != "token ghp_private" || accept[i] != CONTENTS_API_ACCEPT {
+					t.Errorf("API request %d: Authorization %q, Accept %q", i, auth[i], accept[i])
+				}
+			}
+			// 确认该仓库只能走 API 后，其余文件不再先请求 raw
+			if n := r.count("a/b/10/11_22.manifest") + r.count("a/b/10/12_33.manifest"); n > 1 {
+				t.Errorf("raw manifests requested %d times after switching to the API", n)
+			}
+		})
+	}
+}
+
+func TestContentsAPINoTokenNoFallback(t *testing.T) {
+	r := newTestRepo(t, map[string]string{"repos/a/b/contents/10.lua": "-- 10"})
+	cfg := testConfig(t, map[string][]string{"10": nil})
+	res := r.download(t, cfg)
+	// 没有 Token 时 raw 的 404 就是文件不存在
+	if res.Summary.Lua != 0 || r.count("repos/a/b/contents/10.lua") != 0 {
+		t.Errorf("lua = %d, API requests = %d", res.Summary.Lua, r.count("repos/a/b/contents/10.lua"))
+	}
+}
+
+func TestContentsBody(t *testing.T) {
+	tests := []struct {
+		name, ctype, body string
+		want              string
+		wantErr           bool
+	}{
+		{"raw", "application/octet-stream", "abc", "abc", false},
+		{"base64", "application/json", `{"type":"file","encoding":"base64","content":"YW\nJj\n"}`, "abc", false},
+		{"directory", "application/json", `[{"type":"file"}]`, "", true},
+		{"unsupported encoding", "application/json", `{"type":"file","encoding":"none","content":""}`, "", true},
+		{"bad base64", "application/json", `{"type":"file","encoding":"base64","content":"!!"}`, "", true},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{"Content-Type": {tt.ctype}}}
+		r, _, err := contentsBody(resp, strings.NewReader(tt.body), int64(len(tt.body)))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v", tt.name, err)
+			continue
+		}
+		if err == nil {
+			if got, err := io.ReadAll(r); err != nil || string(got) != tt.want {
+				t.Errorf("%s: body = %q, want %q", tt.name, got, tt.want)
+			}
+		}
+	}
+}
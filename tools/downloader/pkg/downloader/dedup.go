@@ -0,0 +1,91 @@
+package downloader
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// manifestFlight 是一个正在进行的清单下载，其它 App 的同一条目等待它完成并复用结果
+type manifestFlight struct {
+	appID string
+	done  chan struct{}
+	out   manifestOutcome
+}
+
+// downloadManifestShared 下载一个清单条目 (含分发到 manifest_dirs)，同一条目正在被下载时等待并复用其结果。
+// 复用的结果同样计入本 App 的 Manifest/Skipped，因为文件已可供本 App 使用。
+// 先到者失败时条目被释放，其它 App 的等待者按自己的分支重新探测；同一 App 的等待者直接接受失败。
+func (rn *run) downloadManifestShared(ctx context.Context, config Config, cache *etagCache, appID, item string) manifestOutcome {
+	// "11_22" 与 "11_22.manifest" 落到同一个目标文件，视为同一条目；
+	// 清单目录不区分大小写时只有大小写不同的条目也落到同一个文件，由先到者下载
+	name := strings.TrimSuffix(strings.TrimSpace(item), ".manifest")
+	key := rn.destKey(config.ManifestDir, name)
+	if config.NestByApp && rn.remoteStorage(config.ManifestDir) != nil {
+		// 远程存储上无法把先到者的文件复制到本 App 的子目录，各 App 各自下载
+		key = rn.destKey(appManifestDir(config, appID), name)
+	}
+	for {
+		rn.manifestFlights.Lock()
+		f, ok := rn.manifestFlights.m[key]
+		if !ok {
+			f = &manifestFlight{appID: appID, done: make(chan struct{})}
+			rn.manifestFlights.m[key] = f
+			rn.manifestFlights.Unlock()
+
+			f.out = rn.downloadManifestItem(ctx, config, cache, appID, item)
+			if f.out.status != itemFailed && f.out.name != "" && len(config.ManifestDirs) > 0 {
+				f.out.targetErrs = rn.fanOutFile(appManifestDir(config, appID), f.out.name, appManifestTargets(config, appID))
+			}
+			if f.out.status == itemFailed {
+				rn.manifestFlights.Lock()
+				delete(rn.manifestFlights.m, key)
+				rn.manifestFlights.Unlock()
+			}
+			close(f.done)
+			return f.out
+		}
+		rn.manifestFlights.Unlock()
+
+		rn.debugf("%s 清单 %s 正由 %s 下载，等待复用", appID, item, f.appID)
+		select {
+		case <-ctx.Done():
+			return manifestOutcome{item: item, status: itemFailed, err: ctx.Err()}
+		case <-f.done:
+		}
+		if f.out.status != itemFailed || f.appID == appID || ctx.Err() != nil {
+			// 校验失败与分发错误只记在实际下载的 App 上
+			out := f.out
+			out.invalid, out.targetErrs, out.checksumFailed = nil, nil, 0
+			if out.name != "" && strings.TrimSuffix(strings.TrimSpace(out.item), ".manifest") != name {
+				// 只有大小写不同的条目用的是先到者的文件名，记为冲突而不是共享
+				return manifestOutcome{item: item, status: itemCollided, collision: rn.collisionNote(appID, manifestLocalName(name), out.name)}
+			}
+			if out.status != itemFailed && out.status != itemCollided {
+				if config.NestByApp && f.appID != appID && !rn.dryRun {
+					// 按 App 分目录时先到者的文件在它自己的子目录中，复制一份到本 App 的子目录
+					if err := rn.shareNested(config, f.appID, appID, out.name); err != nil {
+						return manifestOutcome{item: item, status: itemFailed, err: err}
+					}
+					if len(config.ManifestDirs) > 0 {
+						out.targetErrs = rn.fanOutFile(appManifestDir(config, appID), out.name, appManifestTargets(config, appID))
+					}
+				}
+				atomic.AddInt64(&rn.dedupHits, 1)
+			}
+			return out
+		}
+	}
+}
+
+// shareNested 把先到者 owner 子目录中的清单硬链接或复制到 appID 的子目录 (nest_by_app)
+func (rn *run) shareNested(config Config, owner, appID, name string) error {
+	src := filepath.Join(appManifestDir(config, owner), name)
+	dst := filepath.Join(appManifestDir(config, appID), name)
+	if err := rn.linkOrCopy(src, dst); err != nil {
+		rn.warnf("%s 复用 %s 的清单 %s 失败: %v", appID, owner, name, err)
+		return &diskError{err}
+	}
+	return nil
+}
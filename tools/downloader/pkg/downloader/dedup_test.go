@@ -0,0 +1,104 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSharedManifestFetchedOnce(t *testing.T) {
+	// 20 个 App 共享同一个 depot 清单 (常见的运行库 depot)
+	r := newTestRepo(t, map[string]string{"a/b/master/11_22.manifest": testManifest})
+	data := map[string][]string{}
+	for i := 0; i < 20; i++ {
+		data[strconv.Itoa(100+i)] = []string{"11_22"}
+	}
+	cfg := testConfig(t, data)
+	cfg.ManifestOnly = true
+	res := r.download(t, cfg)
+
+	if n := r.count("a/b/master/11_22.manifest"); n != 1 {
+		t.Errorf("shared manifest fetched %d times, want 1", n)
+	}
+	// 每个 App 都计入该清单，因为文件对它们都可用
+	for _, app := range res.Results {
+		if app.Manifest != 1 || app.Error != "" {
+			t.Errorf("app %s: manifest %d, error %q", app.AppID, app.Manifest, app.Error)
+		}
+	}
+	if res.Summary.Manifest != 20 || res.Summary.DedupHits != 19 {
+		t.Errorf("summary manifest %d, dedup_hits %d; want 20, 19", res.Summary.Manifest, res.Summary.DedupHits)
+	}
+	if got, _ := os.ReadFile(filepath.Join(cfg.ManifestDir, "11_22.manifest")); string(got) != testManifest {
+		t.Errorf("shared manifest is corrupted (%d bytes)", len(got))
+	}
+	if files := listFiles(t, cfg.ManifestDir); len(files) != 1 {
+		t.Errorf("manifest dir = %v", files)
+	}
+}
+
+func TestSharedManifestFailureReprobes(t *testing.T) {
+	// 清单只在 App 20 自己的分支中：App 10 先到时失败并释放条目，App 20 按自己的分支重新探测
+	r := newTestRepo(t, map[string]string{"a/b/20/11_22.manifest": testManifest})
+	cfg := testConfig(t, map[string][]string{"10": {"11_22"}, "20": {"11_22"}})
+	cfg.ManifestOnly = true
+	res := r.download(t, cfg)
+	for _, app := range res.Results {
+		if app.AppID == "20" && app.Manifest != 1 {
+			t.Errorf("app 20 = %+v, want the manifest from its own branch", app)
+		}
+	}
+	if n := r.count("a/b/20/11_22.manifest"); n != 1 {
+		t.Errorf("manifest fetched %d times, want 1", n)
+	}
+}
+
+func TestDedupByTargetFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		nestByApp bool
+	}{
+		{"flat", false},
+		// 按 App 分目录时先到者的文件复制到其它 App 的子目录，仍只请求一次
+		{"nest by app", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepo(t, map[string]string{"a/b/master/11_22.manifest": testManifest})
+			// 写法不同但目标文件相同的条目视为同一清单
+			cfg := testConfig(t, map[string][]string{"10": {"11_22"}, "20": {"11_22.manifest"}, "30": {" 11_22 "}})
+			cfg.ManifestOnly = true
+			cfg.NestByApp = tt.nestByApp
+			res := r.download(t, cfg)
+			if n := r.count("a/b/master/11_22.manifest"); n != 1 {
+				t.Errorf("fetched %d times, want 1", n)
+			}
+			if res.Summary.Manifest != 3 || res.Summary.DedupHits != 2 {
+				t.Errorf("summary manifest %d, dedup_hits %d; want 3, 2", res.Summary.Manifest, res.Summary.DedupHits)
+			}
+			for _, id := range cfg.AppIDs {
+				if got, _ := os.ReadFile(filepath.Join(appManifestDir(cfg, id), "11_22.manifest")); string(got) != testManifest {
+					t.Errorf("app %s has no copy of the manifest", id)
+				}
+			}
+			// 命中数单独写入结果的 summary
+			data, _ := os.ReadFile(cfg.OutputPath)
+			if !strings.Contains(string(data), `"dedup_hits":2`) {
+				t.Errorf("result summary has no dedup_hits: %s", data)
+			}
+		})
+	}
+}
+
+func TestDedupDistinctTargets(t *testing.T) {
+	// 不同的清单各自下载，不计为命中
+	r := newTestRepo(t, map[string]string{"a/b/master/11_22.manifest": testManifest, "a/b/master/11_23.manifest": testManifest})
+	cfg := testConfig(t, map[string][]string{"10": {"11_22"}, "20": {"11_23"}})
+	cfg.ManifestOnly = true
+	res := r.download(t, cfg)
+	if res.Summary.Manifest != 2 || res.Summary.DedupHits != 0 || r.count("a/b/master/11_22.manifest") != 1 || r.count("a/b/master/11_23.manifest") != 1 {
+		t.Errorf("summary manifest %d, dedup_hits %d", res.Summary.Manifest, res.Summary.DedupHits)
+	}
+}
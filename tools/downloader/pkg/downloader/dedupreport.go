@@ -0,0 +1,209 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// DEDUP_REPORT_MAX_CHUNKS 限制 dedup_report 跟踪的不同 chunk 数 (约 64 MB)，超出后新出现的 chunk 按不重复计入
+	DEDUP_REPORT_MAX_CHUNKS = 1 << 20
+	// DEDUP_REPORT_TOP_DEPOTS 是报告中列出的共享清单数
+	DEDUP_REPORT_TOP_DEPOTS = 10
+)
+
+// dedupReport 是 dedup_report 文件的内容：统计本次下载的全部清单中 chunk 的重复情况，不影响下载行为
+type dedupReport struct {
+	Apps          int               `json:"apps"`
+	Manifests     int               `json:"manifests"`           // 成功解析的清单数 (按 App 计，共享清单计多次)
+	Chunks        int64             `json:"chunks"`              // chunk 引用总数
+	UniqueChunks  int64             `json:"unique_chunks"`       // 不同 chunk 数
+	LogicalBytes  uint64            `json:"logical_bytes"`       // 各 App 内容大小之和
+	UniqueBytes   uint64            `json:"unique_bytes"`        // 不同 chunk 的大小之和
+	SharedPercent float64           `json:"shared_percent"`      // 1 - unique/logical，百分比
+	Truncated     bool              `json:"truncated,omitempty"` // chunk 表已满，unique_* 与重叠比例为近似值
+	TopShared     []dedupSharedFile `json:"top_shared_depots"`
+	PerApp        []dedupApp        `json:"apps_overlap"`
+	Errors        []string          `json:"errors,omitempty"` // 无法解析的清单
+}
+
+// dedupSharedFile 是被多个 App 同时引用的清单 (通常是公共运行库)
+type dedupSharedFile struct {
+	DepotID  string   `json:"depot_id"`
+	Manifest string   `json:"manifest"`
+	Apps     []string `json:"apps"`
+	Bytes    uint64   `json:"bytes"`
+}
+
+// dedupApp 是单个 App 的内容中与批次内其它 App 重叠的比例
+type dedupApp struct {
+	AppID          string  `json:"app_id"`
+	LogicalBytes   uint64  `json:"logical_bytes"`
+	SharedBytes    uint64  `json:"shared_bytes"`
+	OverlapPercent float64 `json:"overlap_percent"`
+}
+
+// chunkStat 记录一个 chunk 首次出现的 App 以及是否被其它 App 引用
+type chunkStat struct {
+	size   uint32
+	app    int32
+	shared bool
+}
+
+// recordDedupReport 记录一个 App 下载的清单，运行结束后统一解析
+func (rn *run) recordDedupReport(res AppResult, manifestDir string) {
+	var paths []string
+	for _, f := range res.Files {
+		if isManifestPath(f.Name) {
+			paths = append(paths, filepath.Join(manifestDir, f.Name))
+		}
+	}
+	if len(paths) == 0 {
+		return
+	}
+	rn.dedupReportMu.Lock()
+	defer rn.dedupReportMu.Unlock()
+	rn.dedupReportApps[res.AppID] = paths
+}
+
+// chunkKey 把 chunk SHA-1 转为定长键
+func chunkKey(sha []byte) [20]byte {
+	var k [20]byte
+	copy(k[:], sha)
+	return k
+}
+
+// buildDedupReport 按 order 的顺序解析各 App 的清单并统计 chunk 重复。
+// 第一遍建立 chunk 表，第二遍计算每个 App 与其它 App 共享的字节数；两遍都直接读文件，内存只随不同 chunk 数增长。
+func (rn *run) buildDedupReport(order []string) *dedupReport {
+	rn.dedupReportMu.Lock()
+	var ids []string
+	apps := make(map[string][]string)
+	for _, id := range order {
+		if paths, ok := rn.dedupReportApps[id]; ok {
+			ids = append(ids, id)
+			apps[id] = paths
+		}
+	}
+	rn.dedupReportMu.Unlock()
+
+	report := &dedupReport{Apps: len(ids), TopShared: []dedupSharedFile{}, PerApp: []dedupApp{}}
+	chunks := make(map[[20]byte]*chunkStat)
+	files := make(map[string]*dedupSharedFile)
+	bad := make(map[string]bool)
+	var fileOrder []string
+
+	for i, id := range ids {
+		for _, p := range apps[id] {
+			data, err := rn.readManifestData(p)
+			if err == nil {
+				var total uint64
+				err = manifestChunks(data, func(sha []byte, size uint64) {
+					total += size
+					report.Chunks++
+					k := chunkKey(sha)
+					if c, ok := chunks[k]; ok {
+						c.shared = c.shared || c.app != int32(i)
+						return
+					}
+					report.UniqueChunks++
+					report.UniqueBytes += size
+					if len(chunks) >= DEDUP_REPORT_MAX_CHUNKS {
+						report.Truncated = true
+						return
+					}
+					chunks[k] = &chunkStat{size: uint32(size), app: int32(i)}
+				})
+				if err == nil {
+					name := filepath.Base(p)
+					f, ok := files[name]
+					if !ok {
+						f = &dedupSharedFile{DepotID: strings.SplitN(name, "_", 2)[0], Manifest: name, Bytes: total}
+						files[name] = f
+						fileOrder = append(fileOrder, name)
+					}
+					f.Apps = append(f.Apps, id)
+					report.Manifests++
+					report.LogicalBytes += total
+				}
+			}
+			if err != nil {
+				bad[p] = true
+				report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", filepath.Base(p), err))
+			}
+		}
+	}
+	if report.LogicalBytes > 0 {
+		report.SharedPercent = percent(report.LogicalBytes-report.UniqueBytes, report.LogicalBytes)
+	}
+
+	for _, id := range ids {
+		a := dedupApp{AppID: id}
+		for _, p := range apps[id] {
+			if bad[p] {
+				continue
+			}
+			data, err := rn.readManifestData(p)
+			if err != nil {
+				continue
+			}
+			manifestChunks(data, func(sha []byte, size uint64) {
+				a.LogicalBytes += size
+				if c, ok := chunks[chunkKey(sha)]; ok && c.shared {
+					a.SharedBytes += size
+				}
+			})
+		}
+		a.OverlapPercent = percent(a.SharedBytes, a.LogicalBytes)
+		report.PerApp = append(report.PerApp, a)
+	}
+
+	// 共享清单按节省的字节数 (大小 × 额外引用的 App 数) 排序
+	for _, name := range fileOrder {
+		if f := files[name]; len(f.Apps) > 1 {
+			report.TopShared = append(report.TopShared, *f)
+		}
+	}
+	sort.SliceStable(report.TopShared, func(i, j int) bool {
+		a, b := report.TopShared[i], report.TopShared[j]
+		return a.Bytes*uint64(len(a.Apps)-1) > b.Bytes*uint64(len(b.Apps)-1)
+	})
+	if len(report.TopShared) > DEDUP_REPORT_TOP_DEPOTS {
+		report.TopShared = report.TopShared[:DEDUP_REPORT_TOP_DEPOTS]
+	}
+	return report
+}
+
+// percent 返回 part/total 的百分比，保留两位小数
+func percent(part, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(part)/float64(total)*10000) / 100
+}
+
+// writeDedupReport 生成并写入 dedup_report，先写临时文件再重命名
+func (rn *run) writeDedupReport(path string, order []string) (*dedupReport, error) {
+	report := rn.buildDedupReport(order)
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := rn.fsys.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, &diskError{err}
+	}
+	tmp := tempPath(path)
+	if err := rn.fsys.WriteFile(tmp, data, 0644); err != nil {
+		rn.fsys.Remove(tmp)
+		return nil, &diskError{err}
+	}
+	if err := rn.renameTemp(tmp, path); err != nil {
+		rn.fsys.Remove(tmp)
+		return nil, err
+	}
+	return report, nil
+}
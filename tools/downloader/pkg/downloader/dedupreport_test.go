@@ -0,0 +1,105 @@
+package downloader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testChunk 是夹具清单中的一个 chunk：SHA-1 为 20 个 id 字节
+type testChunk struct {
+	id   byte
+	size uint64
+}
+
+// buildChunkManifest 返回 payload 中每个 chunk 各占一个文件 (FileMapping) 的原始格式清单
+func buildChunkManifest(chunks ...testChunk) string {
+	pbBytes := func(b []byte, num int, v []byte) []byte {
+		b = binary.AppendUvarint(b, uint64(num<<3|2))
+		b = binary.AppendUvarint(b, uint64(len(v)))
+		return append(b, v...)
+	}
+	var payload []byte
+	for _, c := range chunks {
+		chunk := pbBytes(nil, 1, bytes.Repeat([]byte{c.id}, 20))
+		chunk = binary.AppendUvarint(append(chunk, 4<<3|0), c.size)
+		payload = pbBytes(payload, 1, pbBytes(nil, 6, chunk))
+	}
+	var out []byte
+	out = binary.LittleEndian.AppendUint32(out, MANIFEST_PAYLOAD_MAGIC)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(payload)))
+	out = append(out, payload...)
+	return string(binary.LittleEndian.AppendUint32(out, MANIFEST_END_MAGIC))
+}
+
+func TestManifestChunks(t *testing.T) {
+	var got []testChunk
+	err := manifestChunks([]byte(buildChunkManifest(testChunk{'a', 100}, testChunk{'b', 200})), func(sha []byte, size uint64) {
+		got = append(got, testChunk{sha[0], size})
+	})
+	if want := []testChunk{{'a', 100}, {'b', 200}}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("chunks = %v (%v), want %v", got, err, want)
+	}
+	if err := manifestChunks([]byte(buildManifest(11, 1)), func([]byte, uint64) {}); err != nil {
+		t.Errorf("empty payload: %v", err)
+	}
+	if err := manifestChunks([]byte(testManifest), func([]byte, uint64) {}); err == nil {
+		t.Error("truncated manifest parsed without error")
+	}
+}
+
+func TestDedupReport(t *testing.T) {
+	a, b, c, d, e := testChunk{'a', 100}, testChunk{'b', 200}, testChunk{'c', 50}, testChunk{'d', 400}, testChunk{'e', 1000}
+	r := newTestRepo(t, map[string]string{
+		// 11_1 是 App 10 与 20 共享的运行库清单；21_1 与它共享 chunk a
+		"a/b/master/11_1.manifest": buildChunkManifest(a, b),
+		"a/b/10/12_1.manifest":     buildChunkManifest(c),
+		"a/b/20/21_1.manifest":     buildChunkManifest(a, d),
+		"a/b/30/31_1.manifest":     buildChunkManifest(e),
+		"a/b/30/32_1.manifest":     testManifest, // 无法解析
+	})
+	cfg := testConfig(t, map[string][]string{"10": {"11_1", "12_1"}, "20": {"11_1", "21_1"}, "30": {"31_1", "32_1"}})
+	cfg.AppIDs = []string{"10", "20", "30"}
+	cfg.ManifestOnly = true
+	cfg.DedupReport = filepath.Join(t.TempDir(), "dedup_report.json")
+	res := r.download(t, cfg)
+	if res.Summary.Manifest != 6 {
+		t.Fatalf("summary = %+v", res.Summary)
+	}
+
+	data, err := os.ReadFile(cfg.DedupReport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got dedupReport
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("invalid report: %v\n%s", err, data)
+	}
+	want := dedupReport{
+		Apps:      3,
+		Manifests: 5,
+		Chunks:    8,
+		// a、b、c、d、e 各一次
+		UniqueChunks:  5,
+		LogicalBytes:  300 + 50 + 300 + 500 + 1000,
+		UniqueBytes:   1750,
+		SharedPercent: 18.6,
+		TopShared:     []dedupSharedFile{{DepotID: "11", Manifest: "11_1.manifest", Apps: []string{"10", "20"}, Bytes: 300}},
+		PerApp: []dedupApp{
+			{AppID: "10", LogicalBytes: 350, SharedBytes: 300, OverlapPercent: 85.71},
+			{AppID: "20", LogicalBytes: 800, SharedBytes: 400, OverlapPercent: 50},
+			{AppID: "30", LogicalBytes: 1000},
+		},
+	}
+	if len(got.Errors) != 1 {
+		t.Errorf("errors = %q, want 32_1.manifest", got.Errors)
+	}
+	got.Errors = nil
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("report =\n%+v\nwant\n%+v", got, want)
+	}
+}
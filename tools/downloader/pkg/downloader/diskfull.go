@@ -0,0 +1,13 @@
+//go:build !windows
+
+package downloader
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isDiskFull 判断写入错误是否因磁盘空间不足
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
@@ -0,0 +1,145 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// faultFS 让 under 中各目录下的文件创建或写入以 err 失败，其余操作交给 memFS
+type faultFS struct {
+	*memFS
+	under    []string
+	err      error
+	onCreate bool // 在 Create 时失败，否则在第一次 Write 时失败
+}
+
+func (f *faultFS) faulty(name string) bool {
+	for _, d := range f.under {
+		if strings.HasPrefix(name, d+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *faultFS) Create(name string) (File, error) {
+	if f.faulty(name) && f.onCreate {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: f.err}
+	}
+	file, err := f.memFS.Create(name)
+	if err != nil || !f.faulty(name) {
+		return file, err
+	}
+	return &faultFile{File: file, err: f.err}, nil
+}
+
+type faultFile struct {
+	File
+	err error
+}
+
+func (f *faultFile) Write([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: f.Name(), Err: f.err}
+}
+
+func TestClassifyDiskErrors(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&diskError{&fs.PathError{Op: "write", Path: "x", Err: syscall.ENOSPC}}, KIND_DISK_FULL},
+		{fmt.Errorf("a/b: %w", &diskError{syscall.ENOSPC}), KIND_DISK_FULL},
+		{&diskError{&fs.PathError{Op: "open", Path: "x", Err: syscall.EACCES}}, KIND_PERMISSION},
+		{&diskError{fs.ErrPermission}, KIND_PERMISSION},
+		{&diskError{&fs.PathError{Op: "write", Path: "x", Err: syscall.EIO}}, KIND_DISK},
+		// 不是写入本地文件时的错误，不算磁盘已满
+		{syscall.ENOSPC, KIND_NETWORK},
+	}
+	for _, tt := range tests {
+		if got := classifyError(tt.err); got != tt.want {
+			t.Errorf("classifyError(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestDiskFullAbortsRun(t *testing.T) {
+	for _, onCreate := range []bool{false, true} {
+		t.Run("create="+strconv.FormatBool(onCreate), func(t *testing.T) {
+			// 应用数远多于并发数：磁盘已满后剩余的 App 不再请求
+			const apps = 3 * DOWNLOAD_CONCURRENCY
+			files := map[string]string{}
+			appData := map[string][]string{}
+			for i := 0; i < apps; i++ {
+				id := strconv.Itoa(1000 + i)
+				files["a/b/"+id+"/"+id+".lua"] = "-- " + id
+				files["a/b/"+id+"/"+id+"1_2.manifest"] = testManifest
+				appData[id] = []string{id + "1_2"}
+			}
+			dl := newMemDownloader(files)
+			cfg := testConfig(t, appData)
+			fsys := &faultFS{memFS: newMemFS(), under: []string{cfg.LuaDir, cfg.ManifestDir}, err: syscall.ENOSPC, onCreate: onCreate}
+			c := memClient(dl, fsys.memFS)
+			c.FileSystem = fsys
+			res, err := c.Run(context.Background(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Success || res.ErrorKind != KIND_DISK_FULL || !strings.Contains(res.Error, "磁盘空间不足") {
+				t.Errorf("success = %v, error %q (%s); want disk_full abort", res.Success, res.Error, res.ErrorKind)
+			}
+			requested := 0
+			for id := range appData {
+				n := dl.count("a/b/" + id + "/" + id + ".lua")
+				if n > 1 {
+					t.Errorf("%s.lua requested %d times, disk full must not be retried", id, n)
+				}
+				requested += n
+			}
+			if requested >= apps {
+				t.Errorf("%d/%d apps requested after the disk filled up", requested, apps)
+			}
+			// 磁盘已满时不留下写了一半的临时文件
+			for _, p := range fsys.paths() {
+				if strings.HasPrefix(p, cfg.LuaDir) || strings.HasPrefix(p, cfg.ManifestDir) {
+					if !fsys.dirs[p] {
+						t.Errorf("file left behind: %s", p)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPermissionErrorReported(t *testing.T) {
+	dl := newMemDownloader(map[string]string{
+		"a/b/10/10.lua":         "-- 10",
+		"a/b/10/11_22.manifest": testManifest,
+		"a/b/20/20.lua":         "-- 20",
+	})
+	cfg := testConfig(t, map[string][]string{"10": {"11_22"}, "20": nil})
+	fsys := &faultFS{memFS: newMemFS(), under: []string{cfg.ManifestDir}, err: syscall.EACCES, onCreate: true}
+	c := memClient(dl, fsys.memFS)
+	c.FileSystem = fsys
+	res, err := c.Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 权限错误只让对应的文件失败，不中止整个运行
+	if res.ErrorKind != "" || res.Summary.Lua != 2 || res.Summary.Manifest != 0 {
+		t.Fatalf("result error %q (%s), summary %+v", res.Error, res.ErrorKind, res.Summary)
+	}
+	for _, r := range res.Results {
+		if r.AppID == "10" && r.ErrorKind != KIND_PERMISSION {
+			t.Errorf("app 10 error kind = %q (%s), want %q", r.ErrorKind, r.Error, KIND_PERMISSION)
+		}
+	}
+	if n := dl.count("a/b/10/11_22.manifest"); n != 1 {
+		t.Errorf("manifest requested %d times, permission errors must not be retried", n)
+	}
+}
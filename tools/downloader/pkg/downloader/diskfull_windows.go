@@ -0,0 +1,17 @@
+package downloader
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Windows 的磁盘已满错误码 (ERROR_HANDLE_DISK_FULL / ERROR_DISK_FULL)
+const (
+	errorHandleDiskFull syscall.Errno = 39
+	errorDiskFull       syscall.Errno = 112
+)
+
+// isDiskFull 判断写入错误是否因磁盘空间不足
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, errorDiskFull) || errors.Is(err, errorHandleDiskFull)
+}
@@ -0,0 +1,69 @@
+//go:build !slim
+
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// discoverDLCs 返回 include_dlc 要为 res 加入队列的 DLC AppID (按出现顺序，最多 limit 个)。
+// 优先使用已下载的 Lua：addappid 中既不是本 App、也没有解密密钥、也不是 setManifestid 的 depot 的 ID 视为 DLC；
+// Lua 不可用或没有 DLC 时查询 steam_info_url 的 extended.listofdlc。查询失败只记录警告
+func (rn *run) discoverDLCs(ctx context.Context, config Config, res *AppResult, limit int) []string {
+	var dlcs []string
+	if res.Lua > 0 && config.LuaDir != "" && rn.remoteStorage(config.LuaDir) == nil {
+		if info, err := rn.parseLuaFile(filepath.Join(config.LuaDir, res.AppID+".lua")); err == nil {
+			depots := make(map[string]bool)
+			for _, m := range info.Manifests {
+				if depot, _, ok := strings.Cut(m, "_"); ok {
+					depots[depot] = true
+				}
+			}
+			for _, id := range info.AppIDs {
+				if id != res.AppID && info.Keys[id] == "" && !depots[id] {
+					dlcs = append(dlcs, id)
+				}
+			}
+		}
+	}
+	if len(dlcs) == 0 {
+		ids, err := rn.fetchAppDLCs(ctx, res.AppID)
+		if err != nil {
+			rn.warnf("%s include_dlc 查询 DLC 列表失败: %v", res.AppID, err)
+			return nil
+		}
+		dlcs = ids
+	}
+	if len(dlcs) > limit {
+		rn.warnf("%s 有 %d 个 DLC，超过 max_dlc_per_app (%d)，只加入前 %d 个", res.AppID, len(dlcs), limit, limit)
+		dlcs = dlcs[:limit]
+	}
+	return dlcs
+}
+
+// fetchAppDLCs 返回 appinfo 中 extended.listofdlc 列出的 AppID
+func (rn *run) fetchAppDLCs(ctx context.Context, appID string) ([]string, error) {
+	raw, err := rn.fetchAppInfo(ctx, appID)
+	if err != nil {
+		return nil, err
+	}
+	var app struct {
+		Extended struct {
+			ListOfDLC string `json:"listofdlc"`
+		} `json:"extended"`
+	}
+	if err := json.Unmarshal(raw, &app); err != nil {
+		return nil, fmt.Errorf("appinfo 解析失败: %v", err)
+	}
+	var ids []string
+	for _, id := range strings.Split(app.Extended.ListOfDLC, ",") {
+		if id = strings.TrimSpace(id); isDigits(id) && id != appID {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
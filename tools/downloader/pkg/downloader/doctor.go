@@ -0,0 +1,343 @@
+//go:build !slim
+
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// -doctor 使用的公开测试文件 (保证存在，各镜像也能按 repo/branch/path 访问)
+const (
+	DOCTOR_PUBLIC_REPO   = "github/gitignore"
+	DOCTOR_PUBLIC_BRANCH = "main"
+	DOCTOR_PUBLIC_PATH   = "Go.gitignore"
+)
+
+// -doctor 的测量规模：总请求数约为 源数 × DOCTOR_SAMPLES + DOCTOR_PARALLEL + 2
+const (
+	DOCTOR_SAMPLES    = 2               // 每个源的顺序请求次数
+	DOCTOR_PARALLEL   = 8               // 并行吞吐测试的请求数
+	DOCTOR_DISK_BYTES = 4 * 1024 * 1024 // 每个目标目录写入测试的数据量
+)
+
+type doctorSource struct {
+	Base      string  `json:"base"`
+	OK        bool    `json:"ok"`
+	LatencyMs float64 `json:"latency_ms,omitempty"` // 顺序请求的平均耗时
+	Error     string  `json:"error,omitempty"`
+}
+
+type doctorParallel struct {
+	Source         string  `json:"source"`
+	Requests       int     `json:"requests"`
+	Succeeded      int     `json:"succeeded"`
+	WallMs         float64 `json:"wall_ms"`
+	RequestsPerSec float64 `json:"requests_per_second"`
+	Speedup        float64 `json:"speedup"` // 并行时每秒请求数 / 顺序时每秒请求数
+}
+
+type doctorToken struct {
+	Provided  bool   `json:"provided"`
+	Valid     bool   `json:"valid"`
+	Limit     string `json:"limit,omitempty"`
+	Remaining string `json:"remaining,omitempty"`
+	Reset     string `json:"reset,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type doctorDisk struct {
+	Dir         string  `json:"dir"`
+	MBPerSecond float64 `json:"mb_per_second,omitempty"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// doctorReport 是 -doctor 的输出
+type doctorReport struct {
+	Proxy      string                 `json:"proxy"`
+	Routes     []RouteStatus          `json:"routes,omitempty"` // 不使用代理时到直连源的 IPv4 / IPv6 连通性
+	Token      doctorToken            `json:"token"`
+	RepoStatus int                    `json:"repo_status,omitempty"` // 配置的首个仓库的 API 状态码
+	Sources    []doctorSource         `json:"sources"`
+	Parallel   *doctorParallel        `json:"parallel,omitempty"`
+	Disks      []doctorDisk           `json:"disks,omitempty"`
+	Requests   int                    `json:"requests"`
+	Suggested  map[string]interface{} `json:"suggested_config"`
+	Notes      []string               `json:"notes,omitempty"`
+}
+
+// runDoctor 测量网络与磁盘环境并输出建议配置。只发起少量请求，写入的测试文件会被删除，可重复运行。
+func (rn *run) runDoctor(config Config) int {
+	ctx := context.Background()
+	report := doctorReport{Suggested: make(map[string]interface{})}
+
+	report.Proxy = "none"
+	if rn.proxyURL != nil {
+		report.Proxy = rn.proxyURL.Redacted()
+	} else if req, err := http.NewRequest("GET", rn.rawBase, nil); err == nil {
+		if p, err := http.ProxyFromEnvironment(req); err == nil && p != nil {
+			report.Proxy = p.Redacted()
+		}
+	}
+
+	if report.Proxy == "none" {
+		if host, statuses, err := probeRoutes(ctx, rn.rawBase); err != nil {
+			report.Notes = append(report.Notes, fmt.Sprintf("无法解析 %s: %v", host, err))
+		} else {
+			report.Routes = statuses
+			switch a, b := statuses[0], statuses[1]; {
+			case !a.OK && !b.OK:
+				report.Notes = append(report.Notes, fmt.Sprintf("IPv4 与 IPv6 都无法连接 %s，请检查网络或配置 proxy / mirrors", host))
+			case a.OK != b.OK && len(a.Addrs) > 0 && len(b.Addrs) > 0:
+				working := a.Family
+				if b.OK {
+					working = b.Family
+				}
+				report.Notes = append(report.Notes, fmt.Sprintf("%s 只能通过 %s 连接，下载时会自动只使用该地址族", host, working))
+			}
+		}
+	}
+
+	report.Token = rn.doctorCheckToken(ctx, config.Token)
+	report.Requests++
+	if config.Repo != "" {
+		if status, _, _, err := rn.probeRepo(ctx, config.Token, config.Repo); err == nil {
+			report.RepoStatus = status
+			if status != http.StatusOK {
+				report.Notes = append(report.Notes, fmt.Sprintf("仓库 %s 的 API 返回 %d", config.Repo, status))
+			}
+		}
+		report.Requests++
+	}
+
+	// 逐个源顺序请求，测量延迟
+	var best *source
+	bestLatency := time.Duration(0)
+	for _, c := range rn.sources.ordered() {
+		s := c.src
+		ds := doctorSource{Base: s.base}
+		var total time.Duration
+		for i := 0; i < DOCTOR_SAMPLES; i++ {
+			d, err := rn.doctorGet(ctx, s.fileURL(DOCTOR_PUBLIC_REPO, DOCTOR_PUBLIC_BRANCH, DOCTOR_PUBLIC_PATH))
+			report.Requests++
+			if err != nil {
+				ds.Error = errorReason(err)
+				break
+			}
+			total += d
+		}
+		if ds.Error == "" {
+			avg := total / DOCTOR_SAMPLES
+			ds.OK, ds.LatencyMs = true, float64(avg.Microseconds())/1000
+			if best == nil || avg < bestLatency {
+				best, bestLatency = s, avg
+			}
+		}
+		report.Sources = append(report.Sources, ds)
+	}
+
+	// 对最快的源做一次小规模并行测试
+	if best != nil {
+		fileURL := best.fileURL(DOCTOR_PUBLIC_REPO, DOCTOR_PUBLIC_BRANCH, DOCTOR_PUBLIC_PATH)
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		ok := 0
+		start := time.Now()
+		for i := 0; i < DOCTOR_PARALLEL; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := rn.doctorGet(ctx, fileURL); err == nil {
+					mu.Lock()
+					ok++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		wall := time.Since(start)
+		report.Requests += DOCTOR_PARALLEL
+		p := &doctorParallel{Source: best.base, Requests: DOCTOR_PARALLEL, Succeeded: ok, WallMs: float64(wall.Microseconds()) / 1000}
+		if wall > 0 {
+			p.RequestsPerSec = float64(ok) / wall.Seconds()
+		}
+		if bestLatency > 0 {
+			p.Speedup = p.RequestsPerSec * bestLatency.Seconds()
+		}
+		report.Parallel = p
+	} else {
+		report.Notes = append(report.Notes, "所有下载源都无法访问测试文件，请检查网络或配置 mirrors")
+	}
+
+	// 目标目录写入速度 (远程存储的目标不测)
+	seen := make(map[string]bool)
+	for _, dir := range append([]string{config.LuaDir, config.ManifestDir}, config.ManifestDirs...) {
+		if dir == "" || seen[filepath.Clean(dir)] || rn.remoteStorage(dir) != nil {
+			continue
+		}
+		seen[filepath.Clean(dir)] = true
+		report.Disks = append(report.Disks, rn.doctorDiskSpeed(dir))
+	}
+
+	rn.doctorSuggest(&report, best, bestLatency)
+
+	data, _ := json.Marshal(report)
+	fmt.Println(string(data))
+	return 0
+}
+
+// doctorGet 下载 url 并丢弃内容，返回整个请求的耗时
+func (rn *run) doctorGet(ctx context.Context, fileURL string) (time.Duration, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, rn.requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, "GET", fileURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	rn.setRequestHeaders(req)
+	start := time.Now()
+	resp, err := rn.dl.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return 0, &statusError{code: resp.StatusCode}
+	}
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// doctorCheckToken 通过 /rate_limit 检查 Token 是否有效及剩余配额 (该接口本身不消耗配额)
+func (rn *run) doctorCheckToken(ctx context.Context, token string) doctorToken {
+	t := doctorToken{Provided: token != ""}
+	reqCtx, cancel := context.WithTimeout(ctx, rn.requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, "GET", rn.apiBase+"/rate_limit", nil)
+	if err != nil {
+		t.Error = err.Error()
+		return t
+	}
+	rn.setRequestHeaders(req)
+	if token != "" {
+		req.Header.Set("Authorization", "token "+token)
+	}
+	resp, err := rn.dl.Do(req)
+	if err != nil {
+		t.Error = err.Error()
+		return t
+	}
+	resp.Body.Close()
+	t.Valid = resp.StatusCode == http.StatusOK && token != ""
+	if resp.StatusCode != http.StatusOK {
+		t.Error = fmt.Sprintf("Status %d", resp.StatusCode)
+	}
+	t.Limit = resp.Header.Get("X-RateLimit-Limit")
+	t.Remaining = resp.Header.Get("X-RateLimit-Remaining")
+	if reset := resp.Header.Get("X-RateLimit-Reset"); reset != "" {
+		var sec int64
+		if _, err := fmt.Sscan(reset, &sec); err == nil {
+			t.Reset = time.Unix(sec, 0).Format(time.RFC3339)
+		}
+	}
+	return t
+}
+
+// doctorDiskSpeed 向 dir 写入测试文件 (含 fsync) 测量写入速度，完成后删除
+func (rn *run) doctorDiskSpeed(dir string) doctorDisk {
+	d := doctorDisk{Dir: dir}
+	if err := rn.fsys.MkdirAll(dir, 0755); err != nil {
+		d.Error = err.Error()
+		return d
+	}
+	f, err := rn.fsys.CreateTemp(dir, ".doctor-*.tmp")
+	if err != nil {
+		d.Error = err.Error()
+		return d
+	}
+	defer rn.fsys.Remove(f.Name())
+	buf := make([]byte, 64*1024)
+	start := time.Now()
+	for written := 0; written < DOCTOR_DISK_BYTES && err == nil; written += len(buf) {
+		_, err = f.Write(buf)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		d.Error = err.Error()
+		return d
+	}
+	if secs := time.Since(start).Seconds(); secs > 0 {
+		d.MBPerSecond = float64(DOCTOR_DISK_BYTES) / (1024 * 1024) / secs
+	}
+	return d
+}
+
+// doctorSuggest 根据测量结果生成建议配置
+func (rn *run) doctorSuggest(report *doctorReport, best *source, latency time.Duration) {
+	s := report.Suggested
+	if best == nil {
+		return
+	}
+
+	// 单个请求超时：平均延迟的 20 倍，限制在 15~120 秒
+	timeout := int(latency.Seconds()*20) + 1
+	if timeout < 15 {
+		timeout = 15
+	}
+	if timeout > 120 {
+		timeout = 120
+	}
+	s["request_timeout_seconds"] = timeout
+
+	// 并行几乎没有加速说明链路或代理在排队，降低每个主机的连接数
+	conns := DEFAULT_MAX_CONNS_PER_HOST
+	if p := report.Parallel; p != nil {
+		switch {
+		case p.Succeeded < p.Requests:
+			conns = 16
+			report.Notes = append(report.Notes, fmt.Sprintf("并行测试中 %d/%d 个请求失败，源可能限制并发", p.Requests-p.Succeeded, p.Requests))
+		case p.Speedup < 2:
+			conns = 16
+		case p.Speedup < DOCTOR_PARALLEL/2:
+			conns = 64
+		}
+	}
+	s["transport"] = map[string]int{"max_conns_per_host": conns}
+
+	// 按实测延迟排列可用的镜像；直连不可用时建议只用镜像
+	var working []doctorSource
+	for _, ds := range report.Sources {
+		if ds.OK && ds.Base != rn.rawBase {
+			working = append(working, ds)
+		}
+	}
+	sort.Slice(working, func(i, j int) bool { return working[i].LatencyMs < working[j].LatencyMs })
+	if len(working) > 0 {
+		mirrors := make([]string, 0, len(working))
+		for _, ds := range working {
+			mirrors = append(mirrors, ds.Base)
+		}
+		s["mirrors"] = mirrors
+	}
+	if len(report.Sources) > 0 && !report.Sources[0].OK && report.Sources[0].Base == rn.rawBase {
+		report.Notes = append(report.Notes, "直连 raw.githubusercontent.com 失败，下载将依赖镜像")
+	}
+	if report.Token.Provided && !report.Token.Valid {
+		report.Notes = append(report.Notes, "token 无效，私有仓库将无法访问")
+	}
+	report.Notes = append(report.Notes, fmt.Sprintf("App 并发数固定为 %d，可通过 transport 限制实际连接数", DOWNLOAD_CONCURRENCY))
+}
@@ -0,0 +1,682 @@
+package downloader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Config struct {
+	Token        string              `json:"token"`
+	Repo         string              `json:"repo"`
+	AppIDs       []string            `json:"app_ids"`
+	AppData      map[string][]string `json:"app_data"`
+	LuaDir       string              `json:"lua_dir"`
+	ManifestDir  string              `json:"manifest_dir"`
+	DirectMode   bool                `json:"direct_mode"`
+	ManifestOnly bool                `json:"manifest_only"`
+
+	// Only / Skip: 按 glob 模式 (path.Match 语义，如 "570"、"2280*") 选择本次实际处理的 app_ids，
+	// 对应命令行 -only / -skip。同时匹配两者时 skip 优先；被过滤的 App 列在结果的 skipped_by_filter 中
+	Only []string `json:"only"`
+	Skip []string `json:"skip"`
+	// DryRun: 按正常流程解析分支与候选名，但只用 HEAD (不支持时用 0 字节 Range GET) 确认文件存在，不写任何文件；
+	// 结果中的 lua / manifest 表示找到的数量，missing 列出找不到的清单条目。会写文件或依赖已下载文件的选项不生效。
+	// 同时开启 resolve_depots 时，分支文件列表中已有的清单直接记为找到，不再逐个探测
+	DryRun bool `json:"dry_run"`
+	// SkipExisting: 目标清单已存在且非空时跳过下载
+	SkipExisting bool `json:"skip_existing"`
+	// VerifyExisting: 更严格的跳过模式，使用缓存的 ETag 发送条件请求，仅在 304 时跳过
+	VerifyExisting bool `json:"verify_existing"`
+	// ConditionalSync: 对 Lua 与清单都做条件同步：记录每个下载文件的 ETag (lua_dir / manifest_dir 下的 .downloader_cache.json)，
+	// 再次运行时对已有文件发送 If-None-Match，304 沿用本地文件，200 替换并记录新 ETag；清单部分等同 verify_existing
+	ConditionalSync bool `json:"conditional_sync"`
+	// LowMemory: 结果逐条落盘并在输出时回放，适合超大批量 (结果顺序为完成顺序)
+	LowMemory bool `json:"low_memory"`
+	// Mirrors: 镜像/CDN 基础地址或含 %s 占位符 (repo, branch, path) 的 URL 模板，
+	// 直连失败 (网络错误或 5xx) 时依次尝试
+	Mirrors []string `json:"mirrors"`
+	// Debug: 向 stderr 输出调试日志 (包括每个文件的下载源选择原因)
+	Debug bool `json:"debug"`
+	// AutoDiscover: 下载 Lua 后解析其中的 setManifestid 行，自动补充清单列表
+	AutoDiscover bool `json:"auto_discover"`
+	// TimeoutSeconds: 整体运行时限 (秒)，0 表示不限；超时后仍输出已完成部分的结果
+	TimeoutSeconds int `json:"timeout_seconds"`
+	// TotalTimeoutSeconds: timeout_seconds 的别名，两者都设置时以 timeout_seconds 为准
+	TotalTimeoutSeconds int `json:"total_timeout_seconds"`
+	// ResultDetail: 最终结果的详细程度，"full" (默认) | "summary" | "failures_only"
+	ResultDetail string `json:"result_detail"`
+	// OutputPath: 最终结果写入的文件 (先写临时文件再重命名)，stdout 只保留日志；
+	// "-" 表示结果仍写 stdout，日志改写到 stderr，stdout 只有 JSON (命令行 -output 优先)
+	OutputPath string `json:"output_path"`
+	// CompressOutput: output_path 的结果以 gzip 压缩写入，文件名改为 .json.gz (-compress-output)；
+	// 结果写 stdout 时不压缩。output_path 本身以 .gz 结尾时同样压缩
+	CompressOutput bool `json:"compress_output"`
+	// ProgressFormat: "text" (默认) | "json"，json 时进度事件以 NDJSON 写入 stderr
+	ProgressFormat string `json:"progress_format"`
+	// LeakCheck: 调试用，运行结束后检查协程与计数是否全部释放，异常写入 warnings
+	LeakCheck bool `json:"leak_check"`
+	// ManifestDirs: 额外的清单目标目录 (例如 depotcache + 归档盘)，每个清单只下载一次再分发到各目录
+	ManifestDirs []string `json:"manifest_dirs"`
+	// NestByApp: 清单写入 manifest_dir/<appID>/ (manifest_dirs 同样)，结果中的文件名为 "<appID>/文件名"；
+	// 默认 false，所有 App 的清单写入同一目录
+	NestByApp bool `json:"nest_by_app"`
+	// Layout: 目标目录布局，flat (默认，同 nest_by_app 为 false) 或 per_app (清单写入 manifest_dir/<appID>/，
+	// Lua 仍为 lua_dir/<appID>.lua)，便于之后单独删除一个游戏
+	Layout string `json:"layout"`
+	// FlattenSymlinks: per_app 布局下再把每个清单硬链接 (失败时复制) 到 manifest_dir 根目录，供只读取平铺目录的工具使用
+	FlattenSymlinks bool `json:"flatten_symlinks"`
+	// RequestTimeoutSeconds: 单个请求 (含读取响应体) 的超时秒数，默认 60
+	RequestTimeoutSeconds int `json:"request_timeout_seconds"`
+	// Repos: 按优先级排列的多个仓库，某个仓库中全部候选路径都失败时尝试下一个 (repo 视为第一个)
+	Repos []string `json:"repos"`
+	// PrivateRepos: 私有仓库列表，运行前检查 token 是否具备访问权限 (无权限时 GitHub 只返回 404)
+	PrivateRepos []string `json:"private_repos"`
+	// SteamSafeWrites: 清单写入时校验大小与文件头、fsync 并重试重命名；目标为 depotcache 时自动启用
+	SteamSafeWrites bool `json:"steam_safe_writes"`
+	// BranchArchive: 每个 App 下载一次 appID 分支的 tarball 并解压，代替逐个文件探测
+	BranchArchive bool `json:"branch_archive"`
+	// Transport: HTTP 连接池参数，用于调优 (默认值见 transport.go)
+	Transport TransportConfig `json:"transport"`
+	// MaxConnsPerHost: 每个主机的连接上限，等同 transport.max_conns_per_host (两者都设置时以此为准)，
+	// 适合限制并发连接数的代理环境
+	MaxConnsPerHost int `json:"max_conns_per_host"`
+	// RepoCheck: 运行前通过 API 确认仓库仍然存在，整个仓库 404/451 时输出 repo_unavailable 记录而不是逐个 App 报错
+	RepoCheck bool `json:"repo_check"`
+	// MinManifestSize: 清单的最小字节数，低于该值的文件视为无效并删除 (默认 1，只拒绝 0 字节文件)
+	MinManifestSize int64 `json:"min_manifest_size"`
+	// MaxFileBytes: 单个下载文件的字节数上限 (按解压后计算)，超出时中止并删除临时文件，错误类型为 file_too_large；0 表示不限制
+	MaxFileBytes int64 `json:"max_file_bytes"`
+	// DisableContentCheck: 关闭清单内容检查 (错误页面、文本、文件头)，只保留大小校验
+	DisableContentCheck bool `json:"disable_content_check"`
+	// ProbeDelayMs: 同一清单条目相邻两次候选探测之间的间隔 (毫秒)，减轻小型自建镜像的压力，默认 0。
+	// 与 requests_per_second 同时设置时间隔计入令牌等待，两次探测相隔取两者中较大者而不是相加
+	ProbeDelayMs int `json:"probe_delay_ms"`
+	// ProbeWithHead: 清单候选先发 HEAD，只对存在的候选发 GET；不支持 HEAD (405/501) 的源自动改回 GET
+	ProbeWithHead bool `json:"probe_with_head"`
+	// UserAgent: 请求使用的 User-Agent，默认 steamunlocker-downloader/<版本>
+	UserAgent string `json:"user_agent"`
+	// Proxy: 全部请求使用的代理 (http://、https://、socks5://，可带 user:pass@)；未设置时使用 HTTP_PROXY / HTTPS_PROXY 环境变量。
+	// 调用方自带 HTTPClient 或 Downloader 时不生效
+	Proxy string `json:"proxy"`
+	// SourceBudgets: 下载源 (主机名，例如 "ghproxy.example") -> 本次运行可用的请求数与流量。
+	// 用完的源在剩余运行中停用，请求改走其它源或直连；用量写入结果的 summary.source_budgets
+	SourceBudgets map[string]SourceBudget `json:"source_budgets"`
+	// Headers: 附加到下载源与 GitHub API 请求的请求头 (例如企业代理要求的认证头)；token 的 Authorization 优先
+	Headers map[string]string `json:"headers"`
+	// Checksums: 文件的 SHA-256 (仓库内路径或本地文件名 -> sha256)，下载后校验，不符时重试并最终拒绝写入；
+	// 对 plan 条目优先于条目自带的 sha256 / git_sha
+	Checksums map[string]string `json:"checksums"`
+	// FetchKeys: 下载 appID 分支中的 key.vdf，把 depot 解密密钥写入结果的 keys
+	FetchKeys bool `json:"fetch_keys"`
+	// SteamConfigVDF: Steam 的 config/config.vdf 路径，设置后把获取到的密钥合并进其 depots 节点 (隐含 fetch_keys)
+	SteamConfigVDF string `json:"steam_config_vdf"`
+	// PatchLua: 下载完成后改写 Lua，使 setManifestid 指向实际下载的清单、addappid 带上 key.vdf 中的密钥 (原文件存为 .lua.bak)
+	PatchLua bool `json:"patch_lua"`
+	// Tokens: 多个 GitHub Token，请求在其间轮换以分摊限流配额 (token 视为第一个)
+	Tokens []string `json:"tokens"`
+	// GreenLumaDir: GreenLuma 的 AppList 目录，设置后为每个处理成功的 App (及 Lua 中发现的 DLC) 写入 N.txt
+	GreenLumaDir string `json:"greenluma_dir"`
+	// StructuredOutput: stdout 只输出 NDJSON 事件 (progress/app_done/info/warning...)，最后一行为 type=result 的结果
+	StructuredOutput bool `json:"structured_output"`
+	// Verbose: 向 stderr 输出每次下载尝试的地址、结果以及最终选中的候选 (同 -verbose)
+	Verbose bool `json:"verbose"`
+	// DetailedStats: 结果中为每个 App 输出 bytes、duration_seconds、retries、not_found_probes，
+	// 为每个清单输出耗时 (ms) 与请求数 (attempts)，summary 中汇总 retries 与 not_found_probes
+	DetailedStats bool `json:"detailed_stats"`
+	// IncludeDLC: 处理完 app_ids 中的 App 后，把它的 DLC 加入队列一并下载 (去重)。DLC 列表取自 Lua 中没有密钥、
+	// 也不是清单 depot 的 addappid，Lua 中没有时查询 steam_info_url。DLC 的结果带 parent_app，失败不影响所属 App
+	IncludeDLC bool `json:"include_dlc"`
+	// MaxDLCPerApp: include_dlc 为每个 App 最多加入的 DLC 数 (默认 64)
+	MaxDLCPerApp int `json:"max_dlc_per_app"`
+	// ResolveDepots: 从 steam_info_url 查询每个 App 的 depot，并列出仓库中该 App 分支的文件 (GitHub API)，
+	// 下载其中这些 depot 的 {depotid}_{manifestid}.manifest，不需要 app_data；
+	// 分支中没有清单的 depot 列在结果的 depots_without_manifest。查询失败时按原有条目下载
+	ResolveDepots bool `json:"resolve_depots"`
+	// SteamInfoURL: resolve_depots 查询的 appinfo 地址，{appid} 替换为 AppID，响应为 {"data":{"<appid>":{"depots":{...}}}}
+	// (默认 https://api.steamcmd.net/v1/info/{appid})
+	SteamInfoURL string `json:"steam_info_url"`
+	// ExtendedCandidates: 清单候选名增加 .MANIFEST、.bin 与 manifests/ 子目录变体 (探测次数相应增加)
+	ExtendedCandidates bool `json:"extended_candidates"`
+	// PruneOldManifests: App 的清单下载完成后，删除 manifest_dir 中同一 depot 的其它清单 ID
+	// (只处理本次至少成功下载了一个新清单的 depot，删除的文件列在结果的 pruned 中)
+	PruneOldManifests bool `json:"prune_old_manifests"`
+	// LogDir: 非空时把 [INFO]/[WARN]/[PROGRESS] (以及 verbose 的下载尝试) 同时写入该目录下本次运行的日志文件
+	LogDir string `json:"log_dir"`
+	// LogKeep: log_dir 中保留的最新日志数，更早的在运行开始时删除 (默认 10)
+	LogKeep int `json:"log_keep"`
+	// LogLevel: 终端日志级别 debug、info (默认)、warn、error 或 quiet (只输出最终结果 JSON)；
+	// debug 等同同时开启 debug 与 verbose。不影响 log_dir、log_file 与 JSON 进度事件
+	LogLevel string `json:"log_level"`
+	// LogFile: 非空时把全部级别的日志 (含 debug 级别的每个请求地址、状态码、重试与耗时) 写入该文件，每次运行覆盖
+	LogFile string `json:"log_file"`
+	// LuaPathTemplates: Lua 脚本在仓库中的路径模板，{appid} 替换为 AppID (默认 {appid}.lua、depots.lua、config.lua)。
+	// 同一分支内的模板并发请求，但只采用顺序最靠前的命中者并保存为 appID.lua，其余不会写入；
+	// 不含 {appid} 的模板只在以 AppID 命名的分支中尝试。命中的分支与模板记录在结果的 lua_branch / lua_template
+	LuaPathTemplates []string `json:"lua_path_templates"`
+	// LuaNamePatterns: lua_path_templates 的别名，两者都设置时以 lua_path_templates 为准
+	LuaNamePatterns []string `json:"lua_name_patterns"`
+	// Prefer: 同一分支中 Lua 与 SteamTools 的 {appid}.st 都存在时采用哪一个，lua (默认) 或 st。
+	// .st 原样保存为 appID.st，计入结果的 st 而不是 lua；不读取其内容 (auto_discover、patch_lua 等只处理 Lua)
+	Prefer string `json:"prefer"`
+	// ValidateKeys: 检查 Lua 与 key.vdf 中的 depot 密钥格式，并用下载的清单试解密加密文件名确认密钥匹配
+	ValidateKeys bool `json:"validate_keys"`
+	// EstimateInstallSize: 解析每个 App 的清单 (含 skip_existing 跳过的)，把 depot 解压后大小之和
+	// 写入结果的 estimated_install_bytes，并在汇总中给出整批合计
+	EstimateInstallSize bool `json:"estimate_install_size"`
+	// SummaryPath: 非空时在运行结束后写入汇总文件 (JSON)，列出每个成功 App 的 Lua、清单文件名与有密钥的 depot
+	SummaryPath string `json:"summary_path"`
+	// OutputProfiles: 运行结束后按解锁工具生成的产物 (steamtools 默认不生成、greenluma、lumaplay)，各写入 profile_dir/<名称>
+	OutputProfiles []string `json:"output_profiles"`
+	// ProfileDir: output_profiles 的输出根目录
+	ProfileDir string `json:"profile_dir"`
+	// StateFile: 续跑状态文件，记录每个 App 的完成情况；同一仓库与配置再次运行时跳过已完成的 App，
+	// 部分完成的只重试剩余清单 (-fresh 忽略并覆盖)
+	StateFile string `json:"state_file"`
+	// StatePath: state_file 的别名，两者都设置时以 state_file 为准
+	StatePath string `json:"state_path"`
+	// Force: 不跳过 state_file 中已完成的 App，全部重新下载；与 -fresh 不同，本次未涉及的 App 的记录保留
+	Force bool `json:"force"`
+	// ChangelogPath: 设置后把相对上一次运行的变化 (结果中的 changes) 写成 Markdown；需要 state_file。
+	// 设置后 state_file 中已完成的 App 不再跳过，每次都重新检查，否则日常运行永远报告没有变化
+	ChangelogPath string `json:"changelog_path"`
+	// Plan: 预先解析好的文件列表，逐条直接下载而不做任何探测；可与 app_data 同时使用，
+	// 只出现在 plan 中的 App 不会请求 Lua 或清单候选
+	Plan []PlanEntry `json:"plan"`
+	// IgnoreNetworkFilter: 检测到代理/网关拦截 (network_filtered) 时只警告并继续运行
+	IgnoreNetworkFilter bool `json:"ignore_network_filter"`
+	// MaxRetries: 每个地址的总尝试次数 (默认 3，1 表示不重试)
+	MaxRetries int `json:"max_retries"`
+	// RetryBaseMs / RetryMaxMs: 指数退避的初始与最大等待 (毫秒，默认 200 / 5000，实际等待在其间随机)
+	RetryBaseMs int `json:"retry_base_ms"`
+	RetryMaxMs  int `json:"retry_max_ms"`
+	// RequestsPerSecond: 对同一主机每秒最多发出的请求数 (所有协程共享，0 表示不限速)
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	// BundleDir: 非空时把每个成功的 App (Lua、清单、key.vdf) 打包为 <appid>_<name>.zip，附带 manifest.json
+	BundleDir string `json:"bundle_dir"`
+	// Branches: 清单探测的分支列表，按顺序尝试 (默认 appID、main、master)
+	Branches []string `json:"branches"`
+	// UseAppBranch: 配置了 branches 时是否先尝试以 AppID 命名的分支
+	UseAppBranch bool `json:"use_app_branch"`
+	// AppNames: 可选的 AppID -> 游戏名称，用于打包文件名
+	AppNames map[string]string `json:"app_names"`
+	// EventBufferSize: 内存中保留的最近事件数 (默认 10000，负数表示不保留)，供重连的调用方按 seq 补齐错过的事件
+	EventBufferSize int `json:"event_buffer_size"`
+	// DedupReport: 非空时在运行结束后解析本次下载的清单，把 chunk 级重复统计 (总量与去重后大小、共享最多的清单、
+	// 每个 App 的重叠比例) 写入该 JSON 文件，仅用于分析
+	DedupReport string `json:"dedup_report"`
+	// DisableBranchDetect: 不通过 API 查询仓库默认分支 (默认会查询一次并插入到 appID 分支之后)；配置了 branches 时不查询
+	DisableBranchDetect bool `json:"disable_branch_detect"`
+	// LuaStorage / ManifestStorage: lua_dir、manifest_dir 的存储后端 (s3、webdav，默认本地磁盘)，见 StorageConfig；
+	// 远程目标不支持需要在本地读取已下载文件的选项 (patch_lua、bundle_dir、dedup_report 等)
+	LuaStorage      *StorageConfig `json:"lua_storage"`
+	ManifestStorage *StorageConfig `json:"manifest_storage"`
+}
+
+type AppResult struct {
+	AppID     string `json:"app_id"`
+	ParentApp string `json:"parent_app,omitempty"` // include_dlc 加入的 DLC 所属的 App
+	Lua       int    `json:"lua"`
+	St        int    `json:"st,omitempty"` // Lua 阶段拿到的 SteamTools .st 脚本 (与 lua 互斥)
+	Manifest  int    `json:"manifest"`
+	Skipped   int    `json:"skipped"`
+	Error     string `json:"error,omitempty"`
+
+	ErrorKind       string   `json:"error_kind,omitempty"`       // 失败原因类别，见 KIND_*
+	FailedManifests []string `json:"failed_manifests,omitempty"` // 尝试完所有分支与候选名仍未获取的条目
+	TargetErrors    []string `json:"target_errors,omitempty"`    // 分发到额外目标目录失败的记录 (不影响下载计数)
+	SourceRepo      string   `json:"source_repo,omitempty"`      // 实际提供文件的仓库
+	TimedOut        bool     `json:"timed_out,omitempty"`        // 整体运行时限到达时尚未完成 (进行中被中止或未开始)
+	InvalidFiles    []string `json:"invalid_files,omitempty"`    // 下载后校验失败并已删除的清单 (空文件、错误页面等)
+	Collisions      []string `json:"collisions,omitempty"`       // 与已写入文件只有大小写不同、在不区分大小写的文件系统上未写入的文件 ("name -> 已有文件")
+	ChecksumFailed  int      `json:"checksum_failed,omitempty"`  // 与 checksums、plan 的 sha256 或 git_sha 不符而被拒绝的下载
+	Pruned          []string `json:"pruned,omitempty"`           // prune_old_manifests 删除的旧清单
+	NotModified     int      `json:"not_modified,omitempty"`     // 条件请求返回 304 而沿用本地的文件数 (conditional_sync / verify_existing)
+
+	Keys        map[string]string `json:"keys,omitempty"`         // key.vdf 中的 depot 解密密钥 (depot_id -> key)
+	LuaPatched  bool              `json:"lua_patched,omitempty"`  // patch_lua 修改了 Lua 文件
+	LuaBranch   string            `json:"lua_branch,omitempty"`   // 提供 Lua 的分支
+	LuaTemplate string            `json:"lua_template,omitempty"` // 命中的 lua_path_templates 模板
+
+	KeyWarnings map[string]string `json:"key_warnings,omitempty"` // validate_keys 发现问题的 depot -> key_format | key_mismatch
+
+	ResolvedDepots        []string `json:"resolved_depots,omitempty"`         // resolve_depots 从 appinfo 得到的 depot
+	DepotsWithoutManifest []string `json:"depots_without_manifest,omitempty"` // 其中仓库分支里没有任何清单的 depot (游戏无法启动的常见原因)
+
+	Missing []string `json:"missing,omitempty"` // dry_run: 所有仓库、分支与候选名都不存在的清单条目
+
+	// estimate_install_size: 按清单 metadata 估算的 Steam 安装大小 (同一 depot 只计一次)；未开启时省略。
+	// partial 表示有清单无法解析，估算偏小
+	EstimatedInstallBytes  *int64 `json:"estimated_install_bytes,omitempty"`
+	InstallEstimatePartial bool   `json:"install_estimate_partial,omitempty"`
+
+	QueueWaitSeconds float64 `json:"queue_wait_seconds"` // 从入队到被 worker 取出的等待时间
+	ExecutionSeconds float64 `json:"execution_seconds"`  // 从被取出到处理完成的时间
+
+	Files []FileInfo `json:"files,omitempty"` // 本次下载的清单文件
+
+	// 以下仅在 detailed_stats 时输出
+	Bytes           int64   `json:"bytes,omitempty"`            // 本 App 成功下载的字节数 (Lua、清单与 key.vdf)
+	DurationSeconds float64 `json:"duration_seconds,omitempty"` // 处理耗时 (同 execution_seconds)
+	Retries         int     `json:"retries,omitempty"`          // 请求失败后的重试次数
+	NotFoundProbes  int     `json:"not_found_probes,omitempty"` // 返回 404 的候选探测数 (HEAD 与 GET)
+
+	notFound []string // 所有候选都是 404 的清单条目 (state_file 据此判断 removed_upstream)
+	existing []string // skip_existing 跳过的已有清单 (相对 manifest_dir，供 estimate_install_size)
+}
+
+// FileInfo 描述一个已下载文件，供下游校验完整性
+type FileInfo struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+
+	Ms       int64 `json:"ms,omitempty"`       // detailed_stats: 该条目从开始探测到写入完成的耗时 (毫秒)
+	Attempts int   `json:"attempts,omitempty"` // detailed_stats: 该条目发出的请求数 (含 404 候选与重试)
+}
+
+// download 是单次成功下载的结果
+type download struct {
+	Repo   string
+	URL    string
+	ETag   string
+	Size   int64
+	SHA256 string
+}
+
+type Result struct {
+	Type      string        `json:"type,omitempty"` // structured_output 模式下为 "result"
+	Success   bool          `json:"success"`
+	Results   []AppResult   `json:"results"`
+	Summary   ResultSummary `json:"summary"`
+	Failed    []string      `json:"failed"`               // 没有下载到任何文件的 AppID，便于重试
+	Mirror    string        `json:"mirror,omitempty"`     // 提供文件最多的下载源
+	Cancelled bool          `json:"cancelled,omitempty"`  // 运行被中断或超时，结果只包含已处理的部分
+	DryRun    bool          `json:"dry_run,omitempty"`    // dry_run：lua / manifest 表示在仓库中找到，没有写入任何文件
+	Warnings  []string      `json:"warnings,omitempty"`   // 运行期间的警告 (预检、leak_check 等)
+	Error     string        `json:"error,omitempty"`      // 运行被提前终止的原因 (网络被过滤等)
+	ErrorKind string        `json:"error_kind,omitempty"` // 终止原因类别，见 KIND_*
+
+	RepoUnavailable []RepoStatus  `json:"repo_unavailable,omitempty"` // repo_check 判定整体不可用的仓库
+	Routes          []RouteStatus `json:"routes,omitempty"`           // 直连源有地址族不通时的路由检查结果
+
+	TotalBytes     int64   `json:"total_bytes"`      // 本次下载的总字节数
+	BytesPerSecond float64 `json:"bytes_per_second"` // 总字节数 / 总耗时
+
+	ProbeDelaySeconds float64    `json:"probe_delay_seconds,omitempty"` // probe_delay_ms 在限速等待之外额外增加的等待 (各协程之和)
+	Stats             RetryStats `json:"stats"`                         // 下载请求的重试、限流、最终失败与竞速落选次数
+	KeysMerged        int        `json:"keys_merged,omitempty"`         // 合并到 steam_config_vdf 的 depot 密钥数
+
+	AppIDMap        map[string][]string `json:"app_id_map,omitempty"`        // 规范 app_id -> 调用方传入的原始写法 (仅被改写的条目)
+	RejectedAppIDs  []string            `json:"rejected_app_ids,omitempty"`  // 非数字或为 0 而被忽略的 app_id
+	SkippedByFilter []string            `json:"skipped_by_filter,omitempty"` // 被 only / skip 过滤掉、本次未处理的 AppID
+
+	AppListCreated int            `json:"applist_created,omitempty"` // 新写入 GreenLuma AppList 的条目数
+	AppListPresent int            `json:"applist_present,omitempty"` // AppList 中已存在而跳过的条目数
+	Profiles       map[string]int `json:"profiles,omitempty"`        // output_profiles 中各配置生成文件的 App 数
+	ResumedDone    []string       `json:"resumed_done,omitempty"`    // state_file 中已完成、本次跳过的 AppID
+	Changes        *RunChanges    `json:"changes,omitempty"`         // 相对 state_file 中上一次运行的变化
+	TotalTime      float64        `json:"total_time_seconds"`
+}
+
+const (
+	DOWNLOAD_CONCURRENCY    = 100 // 主线程池：处理不同游戏的并发
+	MAX_RETRIES             = 3   // 默认每个地址的总尝试次数
+	DEFAULT_REQUEST_TIMEOUT = 60  // 单个请求默认超时 (秒)
+	DEFAULT_MAX_DLC_PER_APP = 64  // include_dlc 为每个 App 最多加入队列的 DLC 数
+)
+
+// DEFAULT_STEAM_INFO_URL 是 resolve_depots 与 include_dlc 查询 App 信息 (appinfo) 的地址，{appid} 替换为 AppID。
+// Steam 商店的 appdetails 不包含 depot，这里使用公开的 appinfo 镜像
+const DEFAULT_STEAM_INFO_URL = "https://api.steamcmd.net/v1/info/{appid}"
+
+// errNotModified 表示条件请求命中 (304)，本地文件仍是最新
+var errNotModified = errors.New("Status 304")
+
+// LUA_PATH_TEMPLATES 是 lua_path_templates 的默认值
+var LUA_PATH_TEMPLATES = []string{"{appid}.lua", "depots.lua", "config.lua"}
+
+// logMu 串行化终端与日志文件的输出行以及事件序号的分配；终端由进程共享，因此并发的多个运行共用这一把锁
+var logMu sync.Mutex
+
+// downloadFileWithRetry 下载文件并返回大小、SHA-256 与服务器给出的 ETag；etag 非空时发送条件请求
+// 重试次数与退避由 retry 策略决定 (max_retries / retry_base_ms / retry_max_ms)。
+// dry_run 时只探测文件是否存在 (probeFile)，不写 destPath
+func (rn *run) downloadFileWithRetry(ctx context.Context, url, destPath, token, etag string) (download, error) {
+	if rn.dryRun {
+		return rn.withRetry(ctx, url, func() (download, error) {
+			return rn.probeFile(ctx, url, token)
+		})
+	}
+	return rn.withRetry(ctx, url, func() (download, error) {
+		return rn.downloadFile(ctx, url, destPath, token, etag)
+	})
+}
+
+func (rn *run) downloadFile(ctx context.Context, url, destPath, token, etag string) (d download, err error) {
+	budget := rn.budgetFor(url)
+	if err := budget.acquire(); err != nil {
+		rn.verbosef("GET %s -> %v", url, err)
+		return download{}, err
+	}
+	// 限速等待不计入单个请求的超时
+	if err := rn.waitLimiter(ctx, url); err != nil {
+		return download{}, err
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, rn.requestTimeout)
+	defer cancel()
+	start := time.Now()
+	defer func() {
+		elapsed := time.Since(start).Round(time.Millisecond)
+		if err != nil {
+			rn.verbosef("GET %s -> %s (%s)", url, errorReason(err), elapsed)
+		} else {
+			rn.verbosef("GET %s -> 200 (%d 字节, %s)", url, d.Size, elapsed)
+			statsFrom(ctx).transferred(d.Size)
+			budget.consume(d.Size)
+		}
+	}()
+	defer func() {
+		// 区分单个请求超时与整体运行被取消
+		if err != nil && ctx.Err() == nil && errors.Is(reqCtx.Err(), context.DeadlineExceeded) {
+			err = &timeoutError{timeout: rn.requestTimeout, err: err}
+		}
+		rn.abortIfDiskFull(err)
+	}()
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(reqCtx, rn.connTrace), "GET", url, nil)
+	if err != nil {
+		return download{}, err
+	}
+	rn.setRequestHeaders(req)
+	var tok string
+	if token != "" && rn.isGitHubURL(url) {
+		tok = rn.authToken(token)
+		req.Header.Set("Authorization", "token "+tok)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if rn.isContentsURL(url) {
+		req.Header.Set("Accept", CONTENTS_API_ACCEPT)
+	}
+	safe := rn.useSteamSafe(destPath)
+	if safe {
+		// 要求原样传输，避免代理解压后重新压缩或改写内容
+		req.Header.Set("Accept-Encoding", "identity")
+	}
+
+	resp, err := rn.dl.Do(req)
+	if err != nil {
+		statsFrom(ctx).request(0)
+		return download{}, err
+	}
+	defer resp.Body.Close()
+	statsFrom(ctx).request(resp.StatusCode)
+
+	if resp.StatusCode == http.StatusNotModified {
+		return download{}, errNotModified
+	}
+	if resp.StatusCode != 200 {
+		return download{}, rn.responseError(url, tok, resp)
+	}
+
+	body, contentLength, err := decodeBody(resp)
+	if err != nil {
+		return download{}, err
+	}
+	if rn.isContentsURL(url) {
+		if body, contentLength, err = contentsBody(resp, body, contentLength); err != nil {
+			return download{}, err
+		}
+	}
+	// 大小按解压后的内容计算，避免压缩炸弹绕过上限
+	if body, err = rn.limitBody(body, contentLength); err != nil {
+		return download{}, err
+	}
+	if st, key := rn.storageFor(destPath); st != nil {
+		d, err := rn.storeDownload(reqCtx, st, key, url, destPath, body, contentLength)
+		d.ETag = resp.Header.Get("ETag")
+		return d, err
+	}
+
+	rn.fsys.MkdirAll(filepath.Dir(destPath), 0755)
+	// 先写同目录的临时文件，校验通过后再替换：失败的响应不会截断或删除已有的完好文件 (verify_existing、conditional_sync)，
+	// Steam 也不会读到不完整的清单。临时文件名每次尝试都不同 (见 tempPath)
+	writePath := tempPath(destPath)
+	out, err := rn.fsys.Create(writePath)
+	if err != nil {
+		return download{}, &diskError{err}
+	}
+	// 边写边算 SHA-256，不额外读一遍文件
+	hasher := sha256.New()
+	head := &headWriter{}
+	dw := &diskWriter{w: out}
+	writers := []io.Writer{dw, hasher, head}
+	exp := expectedFrom(ctx)
+	blob := exp.blobBuffer()
+	if blob != nil {
+		writers = append(writers, blob)
+	}
+	// 大小与 SHA-256 都按解压后的内容计算
+	n, err := io.Copy(io.MultiWriter(writers...), body)
+	if safe && err == nil {
+		if serr := out.Sync(); serr != nil {
+			err = &diskError{serr}
+		}
+	}
+	if cerr := out.Close(); cerr != nil && err == nil {
+		err = &diskError{cerr}
+	}
+	if dw.err != nil {
+		err = &diskError{dw.err}
+	}
+	if err == nil {
+		err = rn.checkVanished(writePath)
+	}
+	if err == nil && isManifestPath(destPath) {
+		err = rn.validateManifest(n, contentLength, head.head, safe)
+	}
+	sum := hex.EncodeToString(hasher.Sum(nil))
+	if err == nil {
+		err = exp.verify(url, destPath, n, sum, blob)
+	}
+	if err == nil {
+		err = rn.renameTemp(writePath, destPath)
+	}
+	if err != nil {
+		// 不留下写了一半的临时文件，destPath 保持原样
+		rn.fsys.Remove(writePath)
+		return download{}, err
+	}
+	atomic.AddInt64(&rn.totalBytes, n)
+	if safe {
+		rn.infof("steam-safe %s: %d 字节, 文件头 %s", filepath.Base(destPath), n, head.fingerprint())
+	}
+	return download{
+		URL:    url,
+		ETag:   resp.Header.Get("ETag"),
+		Size:   n,
+		SHA256: sum,
+	}, nil
+}
+
+// responseError 把非 200 响应转换为 statusError：限流时让 Token 冷却并发出 rate_limited 事件，403 交给过滤页检测
+func (rn *run) responseError(url, tok string, resp *http.Response) error {
+	se := &statusError{code: resp.StatusCode}
+	se.rateLimited = resp.StatusCode == 403 && resp.Header.Get("X-RateLimit-Remaining") == "0"
+	if resp.StatusCode == 429 || se.rateLimited {
+		if rn.tokens != nil && tok != "" {
+			rn.tokens.coolDown(tok, resp.Header.Get("X-RateLimit-Reset"))
+		}
+		rn.emitEvent("rate_limited", map[string]interface{}{"url": url, "status": resp.StatusCode, "reset": resp.Header.Get("X-RateLimit-Reset")})
+	}
+	return rn.filters.observe(url, resp, se)
+}
+
+// diskWriter 记录写文件时的错误，以便与读取响应体时的网络错误区分
+type diskWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (d *diskWriter) Write(p []byte) (int, error) {
+	n, err := d.w.Write(p)
+	if err != nil {
+		d.err = err
+	}
+	return n, err
+}
+
+// debugf 在调试模式 (debug 或 log_level debug) 下向 stderr 输出一行日志；设置了 log_file 时总是写入该文件
+func (rn *run) debugf(format string, args ...interface{}) {
+	if !rn.debugEnabled && !rn.logFileEnabled {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	logMu.Lock()
+	rn.debugLog.write("DEBUG", msg)
+	logMu.Unlock()
+	if !rn.debugEnabled {
+		return
+	}
+	if rn.eventsEnabled() {
+		rn.emitEvent("debug", map[string]interface{}{"message": msg})
+		return
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	fmt.Fprintf(os.Stderr, "[DEBUG] %s\n", msg)
+}
+
+// verbosef 在 -verbose 模式 (或 log_level debug) 下向 stderr 输出一行下载尝试日志 (每次请求及最终选中的候选)；
+// 设置了 log_file 时总是写入该文件
+func (rn *run) verbosef(format string, args ...interface{}) {
+	if !rn.verboseEnabled && !rn.logFileEnabled {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if !rn.verboseEnabled {
+		logMu.Lock()
+		rn.debugLog.write("VERBOSE", msg)
+		logMu.Unlock()
+		return
+	}
+	if rn.eventsEnabled() {
+		rn.emitEvent("verbose", map[string]interface{}{"message": msg})
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	rn.writeRunLog("VERBOSE", msg)
+	if !rn.eventsEnabled() {
+		fmt.Fprintf(os.Stderr, "[VERBOSE] %s\n", msg)
+	}
+}
+
+// errorReason 将下载错误压缩为简短原因，例如 "Status 404" -> "404"
+func errorReason(err error) string {
+	if code := statusCode(err); code != 0 {
+		return fmt.Sprint(code)
+	}
+	// 磁盘错误只保留原因，不同文件的同类错误归为一条
+	var de *diskError
+	if errors.As(err, &de) {
+		switch {
+		case isDiskFull(de.err):
+			return "磁盘空间不足"
+		case errors.Is(de.err, fs.ErrPermission):
+			var pe *fs.PathError
+			if errors.As(de.err, &pe) {
+				return "没有写入权限: " + filepath.Dir(pe.Path)
+			}
+			return "没有写入权限"
+		}
+	}
+	return err.Error()
+}
+
+// dominantReason 返回出现次数最多的失败原因
+func dominantReason(reasons []string) string {
+	counts := make(map[string]int)
+	best := ""
+	for _, r := range reasons {
+		counts[r]++
+		if counts[r] > counts[best] {
+			best = r
+		}
+	}
+	return best
+}
+
+// manifestCandidates 根据 "depot_manifest" (或单独的 manifest ID) 生成在线文件名候选，按优先级排列
+func (rn *run) manifestCandidates(appID, item string) []string {
+	parts := strings.Split(item, "_")
+	var depotID, manifestID string
+	if len(parts) == 2 {
+		depotID, manifestID = parts[0], parts[1]
+	} else {
+		manifestID = item
+	}
+
+	var onlineNames []string
+	if depotID != "" {
+		onlineNames = append(onlineNames, fmt.Sprintf("%s_%s.manifest", depotID, manifestID), fmt.Sprintf("%s_%s", depotID, manifestID))
+	}
+	if appID != depotID {
+		onlineNames = append(onlineNames, fmt.Sprintf("%s_%s.manifest", appID, manifestID), fmt.Sprintf("%s_%s", appID, manifestID))
+	}
+	onlineNames = append(onlineNames, manifestID+".manifest", manifestID)
+	if rn.extendedCandidates && depotID != "" {
+		// 路径区分大小写，部分仓库使用大写扩展名、.bin 或 manifests/ 子目录
+		base := depotID + "_" + manifestID
+		onlineNames = append(onlineNames, base+".MANIFEST", base+".bin", "manifests/"+base+".manifest")
+	}
+	return onlineNames
+}
+
+// luaCandidates 按 luaTemplates 的顺序返回 Lua 阶段的在线路径候选 (保存为 appID.lua，.st 模板保存为 appID.st)，下标与模板一一对应
+func (rn *run) luaCandidates(appID string) []string {
+	out := make([]string, len(rn.luaTemplates))
+	for i, t := range rn.luaTemplates {
+		out[i] = strings.ReplaceAll(t, "{appid}", appID)
+	}
+	return out
+}
+
+// luaBranchCandidates 返回 branch 中要尝试的 Lua 模板下标：depots.lua 这类不含 {appid} 的模板
+// 只在 App 专属分支中有意义，在 main 等共享分支中会取到其他游戏的脚本
+func (rn *run) luaBranchCandidates(appID, branch string) []int {
+	var out []int
+	for i, t := range rn.luaTemplates {
+		if branch == appID || strings.Contains(t, "{appid}") {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// manifestLocalName 返回在线文件名对应的本地保存名 (统一补全 .manifest 后缀)
+func manifestLocalName(oname string) string {
+	// 扩展候选名 (manifests/ 子目录、.MANIFEST、.bin) 同样保存为 depot_manifest.manifest
+	oname = path.Base(oname)
+	if ext := path.Ext(oname); ext == ".bin" || (ext != ".manifest" && strings.EqualFold(ext, ".manifest")) {
+		return strings.TrimSuffix(oname, ext) + ".manifest"
+	}
+	if !strings.HasSuffix(oname, ".manifest") && !strings.Contains(oname, ".manifest") {
+		return oname + ".manifest"
+	}
+	return oname
+}
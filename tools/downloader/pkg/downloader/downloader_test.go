@@ -0,0 +1,70 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDownloadFileDigest(t *testing.T) {
+	payload := testManifest + strings.Repeat("\x03", 1000)
+	tests := []struct {
+		name     string
+		encoding string // 响应的 Content-Encoding
+		body     []byte
+	}{
+		{"identity", "", []byte(payload)},
+		// 大小与摘要按解压后写入磁盘的内容计算
+		{"gzip", "gzip", gzipBytes(t, []byte(payload))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				w.Write(tt.body)
+			}))
+			defer srv.Close()
+			dest := filepath.Join(t.TempDir(), "11_22.manifest")
+			d, err := newRun().downloadFile(context.Background(), srv.URL+"/11_22.manifest", dest, "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Size != int64(len(payload)) || d.SHA256 != sha256Hex(payload) {
+				t.Errorf("size %d, sha256 %s; want %d, %s", d.Size, d.SHA256, len(payload), sha256Hex(payload))
+			}
+			if got, _ := os.ReadFile(dest); string(got) != payload {
+				t.Errorf("written file differs from the payload (%d bytes)", len(got))
+			}
+		})
+	}
+}
+
+func TestRunFileDigests(t *testing.T) {
+	other := testManifest + "\x05"
+	r := newTestRepo(t, map[string]string{
+		"a/b/10/10.lua":         "-- 10",
+		"a/b/10/11_22.manifest": testManifest,
+		"a/b/10/12_33.manifest": other,
+	})
+	res := r.download(t, testConfig(t, map[string][]string{"10": {"11_22", "12_33"}}))
+	if len(res.Results) != 1 {
+		t.Fatalf("results = %+v", res.Results)
+	}
+	want := map[string]string{"11_22.manifest": testManifest, "12_33.manifest": other}
+	files := res.Results[0].Files
+	if len(files) != len(want) {
+		t.Fatalf("files = %+v, want %d manifests", files, len(want))
+	}
+	for _, f := range files {
+		body, ok := want[f.Name]
+		if !ok || f.Size != int64(len(body)) || f.SHA256 != sha256Hex(body) {
+			t.Errorf("file %+v, want size %d and sha256 %s", f, len(body), sha256Hex(body))
+		}
+	}
+}
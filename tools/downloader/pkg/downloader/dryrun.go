@@ -0,0 +1,142 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dryRunOverrides 关闭 dry_run 下会写磁盘或依赖已下载文件的选项，返回被关闭的选项名
+func dryRunOverrides(config *Config) []string {
+	var off []string
+	disable := func(name string, set bool, reset func()) {
+		if set {
+			off = append(off, name)
+			reset()
+		}
+	}
+	disable("branch_archive", config.BranchArchive, func() { config.BranchArchive = false })
+	disable("skip_existing", config.SkipExisting, func() { config.SkipExisting = false })
+	disable("verify_existing", config.VerifyExisting, func() { config.VerifyExisting = false })
+	disable("conditional_sync", config.ConditionalSync, func() { config.ConditionalSync = false })
+	disable("auto_discover", config.AutoDiscover, func() { config.AutoDiscover = false })
+	disable("manifest_dirs", len(config.ManifestDirs) > 0, func() { config.ManifestDirs = nil })
+	disable("flatten_symlinks", config.FlattenSymlinks, func() { config.FlattenSymlinks = false })
+	disable("fetch_keys", config.FetchKeys, func() { config.FetchKeys = false })
+	disable("validate_keys", config.ValidateKeys, func() { config.ValidateKeys = false })
+	disable("steam_config_vdf", config.SteamConfigVDF != "", func() { config.SteamConfigVDF = "" })
+	disable("patch_lua", config.PatchLua, func() { config.PatchLua = false })
+	disable("prune_old_manifests", config.PruneOldManifests, func() { config.PruneOldManifests = false })
+	disable("greenluma_dir", config.GreenLumaDir != "", func() { config.GreenLumaDir = "" })
+	disable("summary_path", config.SummaryPath != "", func() { config.SummaryPath = "" })
+	disable("output_profiles", len(config.OutputProfiles) > 0, func() { config.OutputProfiles = nil })
+	disable("state_file", config.StateFile != "" || config.StatePath != "", func() { config.StateFile, config.StatePath = "", "" })
+	disable("changelog_path", config.ChangelogPath != "", func() { config.ChangelogPath = "" })
+	disable("bundle_dir", config.BundleDir != "", func() { config.BundleDir = "" })
+	disable("dedup_report", config.DedupReport != "", func() { config.DedupReport = "" })
+	disable("estimate_install_size", config.EstimateInstallSize, func() { config.EstimateInstallSize = false })
+	disable("low_memory", config.LowMemory, func() { config.LowMemory = false })
+	// 探测本身就是 HEAD，不需要先 HEAD 再 GET
+	config.ProbeWithHead = false
+	return off
+}
+
+// probeFile 是 dry_run 下代替 downloadFile 的存在性探测：先发 HEAD，源不支持 HEAD (405/501) 或拒绝 HEAD (403) 时
+// 改用 Range: bytes=0-0 的 GET，只读取一个字节。Size 取自 Content-Length 或 Content-Range，未知时为 0
+func (rn *run) probeFile(ctx context.Context, url, token string) (d download, err error) {
+	if !rn.headRejected(url) {
+		d, err = rn.probeRequest(ctx, "HEAD", url, token)
+		switch statusCode(err) {
+		case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+			rn.rejectHead(url)
+			rn.debugf("%s 不支持 HEAD，dry_run 改用 Range GET 探测", urlHost(url))
+		case http.StatusForbidden:
+		default:
+			return d, err
+		}
+	}
+	return rn.probeRequest(ctx, "GET", url, token)
+}
+
+// probeRequest 发出一次探测请求，预算、限速、超时、Token 与状态码的处理与 downloadFile 相同
+func (rn *run) probeRequest(ctx context.Context, method, url, token string) (d download, err error) {
+	budget := rn.budgetFor(url)
+	if err := budget.acquire(); err != nil {
+		rn.verbosef("%s %s -> %v", method, url, err)
+		return download{}, err
+	}
+	if err := rn.waitLimiter(ctx, url); err != nil {
+		return download{}, err
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, rn.requestTimeout)
+	defer cancel()
+	start := time.Now()
+	defer func() {
+		elapsed := time.Since(start).Round(time.Millisecond)
+		if err != nil {
+			rn.verbosef("%s %s -> %s (%s)", method, url, errorReason(err), elapsed)
+		} else {
+			rn.verbosef("%s %s -> 存在 (%d 字节, %s)", method, url, d.Size, elapsed)
+		}
+		if err != nil && ctx.Err() == nil && errors.Is(reqCtx.Err(), context.DeadlineExceeded) {
+			err = &timeoutError{timeout: rn.requestTimeout, err: err}
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(reqCtx, rn.connTrace), method, url, nil)
+	if err != nil {
+		return download{}, err
+	}
+	rn.setRequestHeaders(req)
+	var tok string
+	if token != "" && rn.isGitHubURL(url) {
+		tok = rn.authToken(token)
+		req.Header.Set("Authorization", "token "+tok)
+	}
+	if rn.isContentsURL(url) {
+		req.Header.Set("Accept", CONTENTS_API_ACCEPT)
+	}
+	if method == "GET" {
+		req.Header.Set("Range", "bytes=0-0")
+		req.Header.Set("Accept-Encoding", "identity")
+	}
+	resp, err := rn.dl.Do(req)
+	if err != nil {
+		statsFrom(ctx).request(0)
+		return download{}, err
+	}
+	defer resp.Body.Close()
+	statsFrom(ctx).request(resp.StatusCode)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return download{URL: url, ETag: resp.Header.Get("ETag"), Size: max(resp.ContentLength, 0)}, nil
+	case http.StatusPartialContent:
+		return download{URL: url, ETag: resp.Header.Get("ETag"), Size: contentRangeTotal(resp.Header.Get("Content-Range"))}, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		// 文件存在但为空
+		return download{URL: url}, nil
+	}
+	if method == "HEAD" && resp.StatusCode == http.StatusForbidden {
+		// 交给随后的 GET 判断：HEAD 没有响应体，不能用于过滤页检测
+		return download{}, &statusError{code: resp.StatusCode}
+	}
+	return download{}, rn.responseError(url, tok, resp)
+}
+
+// contentRangeTotal 返回 "bytes 0-0/1234" 中的总长度，未知 ("*") 时为 0
+func contentRangeTotal(v string) int64 {
+	_, total, ok := strings.Cut(v, "/")
+	if !ok {
+		return 0
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(total), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
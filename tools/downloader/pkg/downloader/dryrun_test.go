@@ -0,0 +1,116 @@
+package downloader
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestContentRangeTotal(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"bytes 0-0/1234", 1234},
+		{"bytes 0-0/*", 0},
+		{"bytes 0-0/ 7 ", 7},
+		{"", 0},
+		{"garbage", 0},
+	}
+	for _, tt := range tests {
+		if got := contentRangeTotal(tt.in); got != tt.want {
+			t.Errorf("contentRangeTotal(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+// methodLog 记录每个请求的方法与 Range 头
+type methodLog struct {
+	mu   sync.Mutex
+	reqs []string
+}
+
+func (l *methodLog) add(req *http.Request) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reqs = append(l.reqs, req.Method+" "+req.Header.Get("Range"))
+}
+
+func (l *methodLog) count(s string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, r := range l.reqs {
+		if r == s {
+			n++
+		}
+	}
+	return n
+}
+
+func TestDryRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		rejectHead bool // 源对 HEAD 返回 405，只能用 Range GET 探测
+	}{
+		{"head", false},
+		{"range get fallback", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepo(t, nil)
+			var log methodLog
+			serve := func(body string) http.HandlerFunc {
+				return func(w http.ResponseWriter, req *http.Request) {
+					log.add(req)
+					switch {
+					case req.Method == http.MethodHead && tt.rejectHead:
+						w.WriteHeader(http.StatusMethodNotAllowed)
+					case req.Method == http.MethodHead:
+						w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+					case req.Header.Get("Range") == "bytes=0-0":
+						w.Header().Set("Content-Range", "bytes 0-0/"+strconv.Itoa(len(body)))
+						w.WriteHeader(http.StatusPartialContent)
+						io.WriteString(w, body[:1])
+					default:
+						t.Errorf("dry_run sent a full %s", req.Method)
+						io.WriteString(w, body)
+					}
+				}
+			}
+			r.handle("a/b/10/10.lua", serve("-- lua"))
+			r.handle("a/b/10/11_22.manifest", serve(testManifest))
+			cfg := testConfig(t, map[string][]string{"10": {"11_22", "12_33"}})
+			cfg.DryRun = true
+			res := r.download(t, cfg)
+
+			if !res.DryRun || res.Summary.Lua != 1 || res.Summary.Manifest != 1 {
+				t.Fatalf("summary = %+v (dry_run %v), want 1 lua and 1 manifest found", res.Summary, res.DryRun)
+			}
+			app := res.Results[0]
+			if len(app.Missing) != 1 || app.Missing[0] != "12_33" {
+				t.Errorf("missing = %v, want [12_33]", app.Missing)
+			}
+			if len(app.Files) != 1 || app.Files[0].Size != int64(len(testManifest)) {
+				t.Errorf("files = %+v, want size %d from headers", app.Files, len(testManifest))
+			}
+			for _, dir := range []string{cfg.LuaDir, cfg.ManifestDir} {
+				if _, err := os.Stat(dir); err == nil {
+					t.Errorf("dry_run created %s", dir)
+				}
+			}
+			heads, gets := log.count("HEAD "), log.count("GET bytes=0-0")
+			if tt.rejectHead {
+				// 第一次 405 后同一主机不再发 HEAD
+				if heads != 1 || gets != 2 {
+					t.Errorf("HEAD %d, range GET %d; want 1 and 2", heads, gets)
+				}
+			} else if heads != 2 || gets != 0 {
+				t.Errorf("HEAD %d, range GET %d; want 2 and 0", heads, gets)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// memClient 返回通过内存 Downloader / FileSystem 运行的 Client，不访问网络与磁盘
+func memClient(dl *memDownloader, fsys *memFS) *Client {
+	return &Client{RawBase: "http://raw.test", APIBase: "http://api.test", Downloader: dl, FileSystem: fsys, LogLevel: "error"}
+}
+
+func TestRunInMemory(t *testing.T) {
+	dl := newMemDownloader(map[string]string{
+		"a/b/10/10.lua":         "-- lua 10",
+		"a/b/10/11_22.manifest": testManifest,
+		"a/b/20/20.lua":         "-- lua 20",
+	})
+	fsys := newMemFS()
+	// 配置中的路径指向真实临时目录，运行结束后它必须仍然为空
+	root := t.TempDir()
+	cfg := testConfig(t, map[string][]string{"10": {"11_22"}, "20": {"21_33"}})
+	cfg.LuaDir = filepath.Join(root, "lua")
+	cfg.ManifestDir = filepath.Join(root, "depotcache")
+	cfg.OutputPath = filepath.Join(root, "out", "result.json")
+	cfg.StateFile = filepath.Join(root, "state.json")
+	cfg.LogDir = filepath.Join(root, "logs")
+
+	res, err := memClient(dl, fsys).Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res.Summary.Lua != 2 || res.Summary.Manifest != 1 || res.Summary.Errors != 1 {
+		t.Errorf("summary = %+v, want 2 lua, 1 manifest, 1 error", res.Summary)
+	}
+
+	for name, want := range map[string]string{
+		filepath.Join(cfg.LuaDir, "10.lua"):              "-- lua 10",
+		filepath.Join(cfg.LuaDir, "20.lua"):              "-- lua 20",
+		filepath.Join(cfg.ManifestDir, "11_22.manifest"): testManifest,
+	} {
+		if got, ok := fsys.read(name); !ok || got != want {
+			t.Errorf("%s = %q (exists %v), want %q", name, got, ok, want)
+		}
+	}
+	data, ok := fsys.read(cfg.OutputPath)
+	var written Result
+	if !ok || json.Unmarshal([]byte(data), &written) != nil || written.Summary.Lua != 2 {
+		t.Errorf("output_path = %q (exists %v)", data, ok)
+	}
+	if _, ok := fsys.read(cfg.StateFile); !ok {
+		t.Error("state_file not written to FileSystem")
+	}
+	logs, err := fsys.ReadDir(cfg.LogDir)
+	if err != nil || len(logs) != 1 || !strings.HasPrefix(logs[0].Name(), RUN_LOG_PREFIX) {
+		t.Errorf("log_dir entries = %v, %v", logs, err)
+	}
+	for _, p := range fsys.paths() {
+		if strings.Contains(filepath.Base(p), ".tmp") || strings.HasSuffix(p, ".part") {
+			t.Errorf("temp file left behind: %s", p)
+		}
+	}
+	if entries, err := os.ReadDir(root); err != nil || len(entries) != 0 {
+		t.Errorf("real disk touched: %v, %v", entries, err)
+	}
+
+	// 第二次运行 (不续跑 state_file，skip_existing)：已有清单跳过，不再请求
+	before := dl.count("a/b/10/11_22.manifest")
+	cfg.StateFile, cfg.SkipExisting = "", true
+	res, err = memClient(dl, fsys).Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("second Run: %v", err)
+	}
+	if n := dl.count("a/b/10/11_22.manifest"); n != before {
+		t.Errorf("existing manifest requested again (%d -> %d)", before, n)
+	}
+	if res.Summary.Skipped == 0 {
+		t.Errorf("second run summary = %+v, want skipped manifests", res.Summary)
+	}
+}
+
+func TestRunInMemoryCancelled(t *testing.T) {
+	dl := newMemDownloader(map[string]string{"a/b/10/10.lua": "-- lua"})
+	fsys := newMemFS()
+	cfg := testConfig(t, map[string][]string{"10": nil})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	res, _ := memClient(dl, fsys).Run(ctx, cfg)
+	if res.Success {
+		t.Error("cancelled run reported success")
+	}
+	if _, ok := fsys.read(filepath.Join(cfg.LuaDir, "10.lua")); ok {
+		t.Error("cancelled run wrote 10.lua")
+	}
+}
@@ -0,0 +1,55 @@
+package downloader
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// decodeBody 按 Content-Encoding 解压响应体，返回解压后的内容与可用于校验的长度 (解压后未知时为 -1)。
+// Transport 自己请求 gzip 时已经透明解压 (resp.Uncompressed)；这里处理 steam-safe 要求 identity、
+// 或镜像/代理无视 Accept-Encoding 仍返回压缩内容的情况，否则保存下来的清单是压缩数据。
+func decodeBody(resp *http.Response) (io.Reader, int64, error) {
+	enc := strings.TrimSpace(resp.Header.Get("Content-Encoding"))
+	if resp.Uncompressed || enc == "" {
+		return resp.Body, resp.ContentLength, nil
+	}
+	var r io.Reader = resp.Body
+	// 多重编码按应用顺序列出，解码时倒序处理
+	codings := strings.Split(enc, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		switch c := strings.ToLower(strings.TrimSpace(codings[i])); c {
+		case "", "identity":
+		case "gzip", "x-gzip":
+			gz, err := gzip.NewReader(r)
+			if err != nil {
+				return nil, 0, fmt.Errorf("Content-Encoding %s 解压失败: %v", c, err)
+			}
+			r = gz
+		case "deflate":
+			r = deflateReader(r)
+		default:
+			return nil, 0, fmt.Errorf("不支持的 Content-Encoding: %s", c)
+		}
+	}
+	if r == resp.Body {
+		return r, resp.ContentLength, nil
+	}
+	return r, -1, nil
+}
+
+// deflateReader 解码 deflate 编码：标准为 zlib 封装，但不少服务器直接发送裸 deflate 流，按头两个字节区分
+func deflateReader(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	if head, err := br.Peek(2); err == nil && head[0]&0x0f == 8 && (uint16(head[0])<<8|uint16(head[1]))%31 == 0 {
+		if zr, err := zlib.NewReader(br); err == nil {
+			return zr
+		}
+	}
+	return flate.NewReader(br)
+}
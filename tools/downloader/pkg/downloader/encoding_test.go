@@ -0,0 +1,74 @@
+package downloader
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestDecodeBody(t *testing.T) {
+	plain := []byte(testManifest)
+	zlibBytes := func() []byte {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		zw.Write(plain)
+		zw.Close()
+		return buf.Bytes()
+	}
+	rawDeflate := func() []byte {
+		var buf bytes.Buffer
+		fw, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+		fw.Write(plain)
+		fw.Close()
+		return buf.Bytes()
+	}
+	tests := []struct {
+		name         string
+		encoding     string
+		body         []byte
+		uncompressed bool // Transport 已透明解压
+		length       int64
+		wantErr      bool
+	}{
+		{"identity", "", plain, false, int64(len(plain)), false},
+		{"explicit identity", "identity", plain, false, int64(len(plain)), false},
+		{"transport decoded", "gzip", plain, true, int64(len(plain)), false},
+		{"gzip", "gzip", gzipBytes(t, plain), false, -1, false},
+		{"x-gzip", "X-Gzip", gzipBytes(t, plain), false, -1, false},
+		{"zlib deflate", "deflate", zlibBytes(), false, -1, false},
+		{"raw deflate", "deflate", rawDeflate(), false, -1, false},
+		{"stacked", "deflate, gzip", gzipBytes(t, zlibBytes()), false, -1, false},
+		{"bad gzip", "gzip", plain, false, 0, true},
+		{"unsupported", "br", plain, false, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{
+				Header:        http.Header{},
+				Body:          io.NopCloser(bytes.NewReader(tt.body)),
+				ContentLength: int64(len(tt.body)),
+				Uncompressed:  tt.uncompressed,
+			}
+			if tt.encoding != "" && !tt.uncompressed {
+				resp.Header.Set("Content-Encoding", tt.encoding)
+			}
+			r, length, err := decodeBody(resp)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("err = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil || !bytes.Equal(got, plain) || length != tt.length {
+				t.Errorf("decoded %d bytes (match %v, %v), length %d; want length %d", len(got), bytes.Equal(got, plain), err, length, tt.length)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+)
+
+// 单个 App 的错误类别 (AppResult.ErrorKind)
+const (
+	KIND_NOT_FOUND    = "not_found"    // 所有候选路径都是 404 (仓库中没有该文件/分支)
+	KIND_AUTH         = "auth"         // 401/403，Token 无效或无权限
+	KIND_RATE_LIMITED = "rate_limited" // 429 或 GitHub 限流的 403
+	KIND_NETWORK      = "network"      // 连接失败、超时、5xx
+	KIND_DISK         = "disk"         // 本地创建/写入文件失败
+	KIND_DISK_FULL    = "disk_full"    // 磁盘空间不足 (运行被提前终止)
+	KIND_PERMISSION   = "permission"   // 目标目录没有写入权限
+	KIND_CORRUPT      = "corrupt"      // 下载内容不是有效清单 (steam-safe 校验失败)
+
+	KIND_NETWORK_FILTERED = "network_filtered"   // 代理/网关对多个地址返回同一个 403 页面
+	KIND_FILE_VANISHED    = "file_vanished"      // 临时文件写入后消失 (通常是杀毒软件隔离)
+	KIND_INVALID_NAME     = "invalid_name"       // 本地文件名不安全 (路径穿越、非法字符、设备名)
+	KIND_BUDGET_EXHAUSTED = "budget_exhausted"   // 可用下载源的 source_budgets 都已用完
+	KIND_FILE_TOO_LARGE   = "file_too_large"     // 文件超过 max_file_bytes
+	KIND_NO_ROUTE         = "no_route_to_github" // IPv4 与 IPv6 都无法连接直连源 (运行被提前终止)
+)
+
+// 配置错误代码 (ConfigError.Code)，出现在命令行错误输出的 "code" 字段
+const (
+	CODE_CONFIG_UNREADABLE = "config_unreadable"         // 配置文件或 URL 无法读取
+	CODE_BAD_JSON          = "bad_json"                  // 配置不是有效的 JSON
+	CODE_MISSING_FIELD     = "missing_field"             // 缺少必需的字段
+	CODE_INVALID_VALUE     = "invalid_value"             // 字段的值无效
+	CODE_UNSUPPORTED       = "unsupported_in_this_build" // 精简版 (slim) 构建不包含该功能
+	CODE_STDIN_TIMEOUT     = "stdin_timeout"             // 未指定 -config 时在时限内没有从 stdin 读到完整配置
+	CODE_STDIN_TOO_LARGE   = "stdin_too_large"           // stdin 中的配置超过大小上限
+	CODE_UNKNOWN_FIELD     = "unknown_field"             // -strict 模式下配置中出现未知字段 (Field 为该字段名)
+)
+
+// ConfigError 表示配置无效、运行无法开始。Field 为出错的配置字段 (JSON 键名，可能为空)，
+// Code 供调用方区分 "缺少 repo"、"缺少 app_ids"、"JSON 无效" 等情况
+type ConfigError struct {
+	Code  string
+	Field string
+	Msg   string
+}
+
+func (e *ConfigError) Error() string { return e.Msg }
+
+// statusError 表示服务器返回了非 200 状态码
+type statusError struct {
+	code        int
+	rateLimited bool // 403 且 X-RateLimit-Remaining 为 0
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("Status %d", e.code)
+}
+
+// statusCode 提取错误中的 HTTP 状态码，非状态码错误返回 0
+func statusCode(err error) int {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code
+	}
+	return 0
+}
+
+// diskError 表示写本地文件时出错，与网络读取错误区分开
+type diskError struct {
+	err error
+}
+
+func (e *diskError) Error() string { return "disk: " + e.err.Error() }
+func (e *diskError) Unwrap() error { return e.err }
+
+// timeoutError 表示单个请求超过 request_timeout_seconds 被中止
+type timeoutError struct {
+	timeout time.Duration
+	err     error
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("请求超时 (%s): %v", e.timeout, e.err)
+}
+func (e *timeoutError) Unwrap() error { return e.err }
+func (e *timeoutError) Timeout() bool { return true }
+
+// classifyError 将下载错误归类为 KIND_* 之一
+func classifyError(err error) string {
+	var se *statusError
+	var de *diskError
+	var ce *corruptError
+	var fe *filteredError
+	var ve *vanishedError
+	var ne *invalidNameError
+	var be *budgetError
+	var te *tooLargeError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &be):
+		return KIND_BUDGET_EXHAUSTED
+	case errors.As(err, &te):
+		return KIND_FILE_TOO_LARGE
+	case errors.As(err, &ne):
+		return KIND_INVALID_NAME
+	case errors.As(err, &ve):
+		return KIND_FILE_VANISHED
+	case errors.As(err, &fe):
+		return KIND_NETWORK_FILTERED
+	case errors.As(err, &de):
+		switch {
+		case isDiskFull(de.err):
+			return KIND_DISK_FULL
+		case errors.Is(de.err, fs.ErrPermission):
+			return KIND_PERMISSION
+		}
+		return KIND_DISK
+	case errors.As(err, &ce):
+		return KIND_CORRUPT
+	case errors.As(err, &se):
+		switch {
+		case se.code == 404:
+			return KIND_NOT_FOUND
+		case se.code == 429 || se.rateLimited:
+			return KIND_RATE_LIMITED
+		case se.code == 401 || se.code == 403:
+			return KIND_AUTH
+		}
+		return KIND_NETWORK
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return KIND_NETWORK
+	}
+	return KIND_NETWORK
+}
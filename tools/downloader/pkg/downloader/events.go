@@ -0,0 +1,149 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// 进度输出格式 (progress_format / -progress)
+const (
+	PROGRESS_TEXT = "text" // 默认：[INFO]/[PROGRESS] 文本行输出到 stdout
+	PROGRESS_JSON = "json" // 每行一个 JSON 事件输出到 stderr，stdout 只保留最终结果
+)
+
+// eventsEnabled 判断是否以 JSON 事件代替文本行输出
+func (rn *run) eventsEnabled() bool {
+	return rn.progressJSON || rn.structuredOutput
+}
+
+// DEFAULT_EVENT_BUFFER 是 event_buffer_size 未设置时保留的最近事件数
+const DEFAULT_EVENT_BUFFER = 10000
+
+// Event 是一条进度事件，Type 与 NDJSON 输出中的 "event"/"type" 字段相同 (app_start、file_done、app_done 等)。
+// Seq 在一次运行内对所有类型的事件从 1 开始连续递增，对应 NDJSON 中的 "seq" 字段。
+type Event struct {
+	Seq    int64
+	Type   string
+	Fields map[string]interface{}
+}
+
+// eventBuffer 是定长环形缓冲，保存最近的事件供重连后回放
+type eventBuffer struct {
+	items []Event
+	next  int // 下一次写入的位置
+	full  bool
+}
+
+func newEventBuffer(size int) *eventBuffer {
+	if size < 0 {
+		size = 0
+	}
+	return &eventBuffer{items: make([]Event, size)}
+}
+
+func (b *eventBuffer) add(e Event) {
+	if len(b.items) == 0 {
+		return
+	}
+	b.items[b.next] = e
+	b.next = (b.next + 1) % len(b.items)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// since 按顺序返回 seq 大于 since 的事件；complete 为 false 表示其中一部分已被覆盖
+func (b *eventBuffer) since(since, last int64) (out []Event, complete bool) {
+	n, start := b.next, 0
+	if b.full {
+		n, start = len(b.items), b.next
+	}
+	for i := 0; i < n; i++ {
+		if e := b.items[(start+i)%len(b.items)]; e.Seq > since {
+			out = append(out, e)
+		}
+	}
+	if last <= since {
+		return out, true
+	}
+	return out, len(out) > 0 && out[0].Seq == since+1
+}
+
+// resetEvents 按 event_buffer_size 重建事件缓冲并清零序号
+func (rn *run) resetEvents(size int) {
+	if size == 0 {
+		size = DEFAULT_EVENT_BUFFER
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	rn.eventSeq = 0
+	rn.events = newEventBuffer(size)
+}
+
+// eventsSince 返回 seq 大于 since 的缓冲事件
+func (rn *run) eventsSince(since int64) ([]Event, bool) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	return rn.events.since(since, rn.eventSeq)
+}
+
+// emitEvent 在 JSON 进度模式下向 stderr 写出一行事件；structured_output 模式下写到 stdout，键名为 "type"。
+// 每个事件都带有递增的 seq 并进入回放缓冲；回调在锁外调用，因此并发时到达回调的顺序可能与 seq 不同。
+func (rn *run) emitEvent(event string, fields map[string]interface{}) {
+	if !rn.eventsEnabled() && rn.eventHook == nil {
+		return
+	}
+	logMu.Lock()
+	rn.eventSeq++
+	e := Event{Seq: rn.eventSeq, Type: event, Fields: fields}
+	rn.events.add(e)
+	if rn.eventsEnabled() {
+		// 缓冲与回调持有 fields，输出时使用副本
+		out := make(map[string]interface{}, len(fields)+2)
+		for k, v := range fields {
+			out[k] = v
+		}
+		out["seq"] = e.Seq
+		w := os.Stderr
+		if rn.structuredOutput {
+			out["type"] = event
+			w = os.Stdout
+		} else {
+			out["event"] = event
+		}
+		if data, err := json.Marshal(out); err == nil {
+			w.Write(append(data, '\n'))
+		}
+	}
+	logMu.Unlock()
+	if rn.eventHook != nil {
+		rn.eventHook(e)
+	}
+}
+
+// infof 输出提示信息：文本模式向 logOut 写 [INFO] 行，JSON 模式转为 info 事件
+func (rn *run) infof(format string, args ...interface{}) {
+	rn.logLine(levelInfo, "INFO", "info", format, args...)
+}
+
+// warnf 输出警告：文本模式向 logOut 写 [WARN] 行，JSON 模式转为 warning 事件
+func (rn *run) warnf(format string, args ...interface{}) {
+	rn.logLine(levelWarn, "WARN", "warning", format, args...)
+}
+
+// logLine 把一行日志写入事件、日志文件与终端；低于 log_level 的行不写到终端
+func (rn *run) logLine(level int, tag, event, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if rn.eventsEnabled() || rn.eventHook != nil {
+		rn.emitEvent(event, map[string]interface{}{"message": msg})
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	rn.writeRunLog(tag, msg)
+	if rn.eventsEnabled() || level < rn.consoleLevel {
+		return
+	}
+	fmt.Fprintf(rn.logOut, "[%s] %s\n", tag, msg)
+	rn.logOut.Sync()
+}
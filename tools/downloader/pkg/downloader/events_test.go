@@ -0,0 +1,105 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureStdout 在 fn 执行期间把 os.Stdout 换成管道，返回写入的全部内容
+func captureStdout(t *testing.T, fn func(stdout io.Writer)) string {
+	t.Helper()
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := os.Stdout
+	os.Stdout = pw
+	done := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(pr)
+		done <- data
+	}()
+	defer func() {
+		os.Stdout = old
+	}()
+	fn(pw)
+	pw.Close()
+	return string(<-done)
+}
+
+func TestStructuredOutput(t *testing.T) {
+	known := map[string]bool{
+		"app_start": true, "app_done": true, "file_done": true, "progress": true,
+		"info": true, "warning": true, "result": true,
+	}
+	r := newTestRepo(t, map[string]string{
+		"a/b/10/10.lua":         "-- 10",
+		"a/b/10/11_22.manifest": testManifest,
+		"a/b/20/20.lua":         "-- 20",
+	})
+	for _, structured := range []bool{false, true} {
+		cfg := testConfig(t, map[string][]string{"10": {"11_22"}, "20": nil})
+		cfg.AppIDs = []string{"10", " 20"} // 触发一条 warning
+		cfg.OutputPath = ""
+		cfg.StructuredOutput = structured
+		out := captureStdout(t, func(stdout io.Writer) {
+			c := r.client()
+			c.Output, c.LogLevel = stdout, ""
+			if _, err := c.Run(context.Background(), cfg); err != nil {
+				t.Fatal(err)
+			}
+		})
+		lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+		var last map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil || last["success"] != true {
+			t.Fatalf("structured=%v: last line is not the result: %q", structured, lines[len(lines)-1])
+		}
+
+		if !structured {
+			// 默认的文本格式：[WARN]/[PROGRESS] 行，结果不带 type
+			if _, ok := last["type"]; ok || !strings.Contains(out, "[PROGRESS] 2/2\n") || !strings.Contains(out, "[WARN] ") {
+				t.Errorf("text output =\n%s", out)
+			}
+			continue
+		}
+		// 每一行都是完整的 JSON 事件 (logMu 保证并发写入不交错)
+		types := map[string]int{}
+		var lastSeq float64
+		for i, line := range lines {
+			var e map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &e); err != nil {
+				t.Fatalf("line %d is not JSON: %q", i, line)
+			}
+			typ, _ := e["type"].(string)
+			if !known[typ] {
+				t.Errorf("line %d has unknown type %q", i, line)
+			}
+			types[typ]++
+			if seq, ok := e["seq"].(float64); ok {
+				if seq <= lastSeq {
+					t.Errorf("line %d: seq %v after %v", i, seq, lastSeq)
+				}
+				lastSeq = seq
+			} else if typ != "result" {
+				t.Errorf("line %d has no seq: %q", i, line)
+			}
+			switch typ {
+			case "progress":
+				if e["done"] == nil || e["total"] != float64(2) {
+					t.Errorf("progress event = %q", line)
+				}
+			case "app_done":
+				if id := e["app_id"]; (id == "10" && e["manifest"] != float64(1)) || e["lua"] != float64(1) {
+					t.Errorf("app_done event = %q", line)
+				}
+			}
+		}
+		if types["result"] != 1 || last["type"] != "result" || types["progress"] != 2 || types["app_done"] != 2 || types["warning"] == 0 {
+			t.Errorf("event types = %v", types)
+		}
+	}
+}
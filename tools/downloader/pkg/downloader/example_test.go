@@ -0,0 +1,47 @@
+package downloader_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/steamunlocker/downloader/pkg/downloader"
+)
+
+// 嵌入方通过 Client 把下载指向自己的源 (这里是 httptest 服务器)，直接拿到 Result，
+// 不必执行命令行程序再解析 stdout
+func ExampleClient_Run() {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/owner/repo/730/730.lua" {
+			fmt.Fprint(w, "addappid(730)")
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	dir, err := os.MkdirTemp("", "downloader-example-")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	client := &downloader.Client{HTTPClient: srv.Client(), RawBase: srv.URL, APIBase: srv.URL, LogLevel: "error"}
+	res, err := client.Run(context.Background(), downloader.Config{
+		Repos:               []string{"owner/repo"},
+		AppIDs:              []string{"730"},
+		LuaDir:              filepath.Join(dir, "lua"),
+		ManifestDir:         filepath.Join(dir, "depotcache"),
+		DirectMode:          true,
+		DisableBranchDetect: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "lua", "730.lua"))
+	fmt.Println(res.Success, res.Summary.Lua, string(data))
+	// Output: true 1 addappid(730)
+}
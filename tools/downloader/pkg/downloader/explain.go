@@ -0,0 +1,53 @@
+package downloader
+
+import (
+	"fmt"
+	"strings"
+)
+
+// runExplain 模拟单个条目的下载源选择过程并打印决策树，不发起任何网络请求。
+// item 可以是 "depot_manifest"、单独的 manifest ID，或 "lua" 表示 Lua 脚本。
+func (rn *run) runExplain(config Config, appID, item string) {
+	appID = strings.TrimSpace(appID)
+	item = strings.TrimSpace(item)
+	if item == "" {
+		item = "lua"
+	}
+
+	var names []string
+	if item == "lua" {
+		names = rn.luaCandidates(appID)
+	} else {
+		names = rn.manifestCandidates(appID, item)
+	}
+
+	fmt.Printf("[EXPLAIN] app=%s item=%s repos=%s\n", appID, item, strings.Join(config.Repos, ", "))
+	fmt.Println("源顺序:")
+	order := rn.sources.ordered()
+	for i, c := range order {
+		fmt.Printf("  %d. %s — %s\n", i+1, c.src.base, c.reason)
+	}
+	fmt.Println("候选尝试顺序 (仓库 → 分支 → 文件名 → 源；首个 200 即停止，404 直接换下一个文件名，网络错误/5xx 换下一个源):")
+	for _, repo := range config.Repos {
+		fmt.Printf("  仓库 %s\n", repo)
+		for _, branch := range rn.manifestBranches(repo, appID) {
+			branchNames := names
+			if item == "lua" {
+				branchNames = nil
+				for _, i := range rn.luaBranchCandidates(appID, branch) {
+					branchNames = append(branchNames, names[i])
+				}
+				if len(branchNames) == 0 {
+					continue
+				}
+			}
+			fmt.Printf("    分支 %s\n", branch)
+			for _, name := range branchNames {
+				fmt.Printf("      %s\n", name)
+				for _, c := range order {
+					fmt.Printf("        -> %s\n", c.src.fileURL(repo, branch, name))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package downloader
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memDownloader 是内存中的 Downloader：files 的键与 testRepo 相同 ("repo/branch/path")，
+// 只看请求路径，不存在的路径返回 404
+type memDownloader struct {
+	mu    sync.Mutex
+	files map[string]string
+	hits  map[string]int
+}
+
+func newMemDownloader(files map[string]string) *memDownloader {
+	return &memDownloader{files: files, hits: map[string]int{}}
+}
+
+func (d *memDownloader) Do(req *http.Request) (*http.Response, error) {
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	p := strings.TrimPrefix(req.URL.Path, "/")
+	d.mu.Lock()
+	d.hits[p]++
+	body, ok := d.files[p]
+	d.mu.Unlock()
+	status := http.StatusOK
+	if !ok {
+		status, body = http.StatusNotFound, "404: Not Found"
+	}
+	resp := &http.Response{
+		Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+		ContentLength: int64(len(body)),
+		Body:          io.NopCloser(strings.NewReader(body)),
+		Request:       req,
+	}
+	if req.Method == http.MethodHead {
+		resp.Body = http.NoBody
+	}
+	return resp, nil
+}
+
+// count 返回 path 收到的请求数
+func (d *memDownloader) count(path string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.hits[path]
+}
+
+// requests 返回收到的请求总数
+func (d *memDownloader) requests() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := 0
+	for _, c := range d.hits {
+		n += c
+	}
+	return n
+}
+
+// memFS 是内存中的 FileSystem，路径经 filepath.Clean 后区分。
+// 打开的文件引用同一个 memNode，重命名与硬链接后仍然共享，与 os 的语义一致
+type memFS struct {
+	mu    sync.Mutex
+	files map[string]*memNode
+	dirs  map[string]bool
+	seq   int
+}
+
+type memNode struct {
+	data    []byte
+	modTime time.Time
+}
+
+func newMemFS() *memFS {
+	m := &memFS{files: map[string]*memNode{}, dirs: map[string]bool{}}
+	m.mkdirAll(os.TempDir())
+	return m
+}
+
+func memPathError(op, name string, err error) error {
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// mkdirAll 在持有 mu 时调用
+func (m *memFS) mkdirAll(p string) {
+	for p = filepath.Clean(p); !m.dirs[p]; p = filepath.Dir(p) {
+		m.dirs[p] = true
+	}
+}
+
+// parentOK 在持有 mu 时调用，判断 name 的上级目录是否存在
+func (m *memFS) parentOK(name string) bool {
+	return m.dirs[filepath.Dir(name)]
+}
+
+func (m *memFS) MkdirAll(path string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[filepath.Clean(path)]; ok {
+		return memPathError("mkdir", path, fs.ErrExist)
+	}
+	m.mkdirAll(path)
+	return nil
+}
+
+func (m *memFS) Create(name string) (File, error) {
+	return m.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (m *memFS) CreateTemp(dir, pattern string) (File, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	m.mu.Lock()
+	m.seq++
+	seq := m.seq
+	m.mu.Unlock()
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+	return m.OpenFile(filepath.Join(dir, prefix+strconv.Itoa(seq)+suffix), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+}
+
+func (m *memFS) Open(name string) (File, error) {
+	return m.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (m *memFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := filepath.Clean(name)
+	if m.dirs[p] {
+		return nil, memPathError("open", name, errors.New("is a directory"))
+	}
+	n, ok := m.files[p]
+	switch {
+	case ok && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, memPathError("open", name, fs.ErrExist)
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, memPathError("open", name, fs.ErrNotExist)
+	case !ok && !m.parentOK(p):
+		return nil, memPathError("open", name, fs.ErrNotExist)
+	case !ok:
+		n = &memNode{modTime: time.Now()}
+		m.files[p] = n
+	case flag&os.O_TRUNC != 0:
+		n.data, n.modTime = nil, time.Now()
+	}
+	f := &memFile{fs: m, node: n, name: name, append: flag&os.O_APPEND != 0, write: flag&(os.O_WRONLY|os.O_RDWR) != 0}
+	return f, nil
+}
+
+func (m *memFS) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.files[filepath.Clean(name)]
+	if !ok {
+		return nil, memPathError("open", name, fs.ErrNotExist)
+	}
+	return append([]byte(nil), n.data...), nil
+}
+
+func (m *memFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := filepath.Clean(name)
+	if !m.parentOK(p) || m.dirs[p] {
+		return memPathError("open", name, fs.ErrNotExist)
+	}
+	m.files[p] = &memNode{data: append([]byte(nil), data...), modTime: time.Now()}
+	return nil
+}
+
+func (m *memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dir := filepath.Clean(name)
+	if !m.dirs[dir] {
+		return nil, memPathError("open", name, fs.ErrNotExist)
+	}
+	var out []fs.DirEntry
+	for p, n := range m.files {
+		if filepath.Dir(p) == dir {
+			out = append(out, fs.FileInfoToDirEntry(memInfo{name: filepath.Base(p), size: int64(len(n.data)), modTime: n.modTime}))
+		}
+	}
+	for p := range m.dirs {
+		if p != dir && filepath.Dir(p) == dir {
+			out = append(out, fs.FileInfoToDirEntry(memInfo{name: filepath.Base(p), dir: true}))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name() < out[j].Name() })
+	return out, nil
+}
+
+func (m *memFS) Link(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.files[filepath.Clean(oldname)]
+	if !ok {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: fs.ErrNotExist}
+	}
+	p := filepath.Clean(newname)
+	if _, exists := m.files[p]; exists || !m.parentOK(p) {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: fs.ErrExist}
+	}
+	m.files[p] = n
+	return nil
+}
+
+func (m *memFS) Rename(oldpath, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, p := filepath.Clean(oldpath), filepath.Clean(newpath)
+	n, ok := m.files[o]
+	if !ok || !m.parentOK(p) {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrNotExist}
+	}
+	delete(m.files, o)
+	m.files[p] = n
+	return nil
+}
+
+func (m *memFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := filepath.Clean(name)
+	if _, ok := m.files[p]; ok {
+		delete(m.files, p)
+		return nil
+	}
+	if m.dirs[p] {
+		for q := range m.files {
+			if filepath.Dir(q) == p {
+				return memPathError("remove", name, errors.New("directory not empty"))
+			}
+		}
+		delete(m.dirs, p)
+		return nil
+	}
+	return memPathError("remove", name, fs.ErrNotExist)
+}
+
+func (m *memFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := filepath.Clean(name)
+	if n, ok := m.files[p]; ok {
+		return memInfo{name: filepath.Base(p), size: int64(len(n.data)), modTime: n.modTime}, nil
+	}
+	if m.dirs[p] {
+		return memInfo{name: filepath.Base(p), dir: true}, nil
+	}
+	return nil, memPathError("stat", name, fs.ErrNotExist)
+}
+
+// read 返回 name 的内容，不存在时 ok 为 false
+func (m *memFS) read(name string) (string, bool) {
+	data, err := m.ReadFile(name)
+	return string(data), err == nil
+}
+
+// paths 返回全部文件路径 (已排序)
+func (m *memFS) paths() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []string
+	for p := range m.files {
+		out = append(out, p)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// memFile 是 memFS 打开的文件
+type memFile struct {
+	fs     *memFS
+	node   *memNode
+	name   string
+	off    int64
+	append bool
+	write  bool
+	closed bool
+}
+
+func (f *memFile) Name() string { return f.name }
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if f.off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[f.off:])
+	f.off += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if !f.write {
+		return 0, memPathError("write", f.name, fs.ErrPermission)
+	}
+	if f.append {
+		f.off = int64(len(f.node.data))
+	}
+	if end := f.off + int64(len(p)); end > int64(len(f.node.data)) {
+		f.node.data = append(f.node.data, make([]byte, end-int64(len(f.node.data)))...)
+	}
+	copy(f.node.data[f.off:], p)
+	f.off += int64(len(p))
+	f.node.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += int64(len(f.node.data))
+	}
+	if offset < 0 {
+		return 0, memPathError("seek", f.name, fs.ErrInvalid)
+	}
+	f.off = offset
+	return offset, nil
+}
+
+func (f *memFile) Sync() error { return nil }
+
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.closed = true
+	return nil
+}
+
+// memInfo 是 memFS 的 fs.FileInfo
+type memInfo struct {
+	name    string
+	size    int64
+	dir     bool
+	modTime time.Time
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) ModTime() time.Time { return i.modTime }
+func (i memInfo) IsDir() bool        { return i.dir }
+func (i memInfo) Sys() interface{}   { return nil }
+
+func (i memInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
@@ -0,0 +1,151 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// 目标目录布局 (layout)
+const (
+	LAYOUT_FLAT    = "flat"    // 所有 App 的清单写入 manifest_dir
+	LAYOUT_PER_APP = "per_app" // 清单写入 manifest_dir/<appID>/ (即 nest_by_app)
+)
+
+// normalizeManifestDirs 合并 manifest_dir 与 manifest_dirs：第一个目录作为主目录 (ManifestDir)，
+// 其余去重后留在 ManifestDirs 中作为额外的分发目标
+func normalizeManifestDirs(config *Config) {
+	seen := make(map[string]bool)
+	var all []string
+	for _, d := range append([]string{config.ManifestDir}, config.ManifestDirs...) {
+		if d == "" {
+			continue
+		}
+		key := filepath.Clean(d)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		all = append(all, d)
+	}
+	if len(all) == 0 {
+		config.ManifestDir, config.ManifestDirs = "", nil
+		return
+	}
+	config.ManifestDir, config.ManifestDirs = all[0], all[1:]
+}
+
+// appManifestDir 返回 appID 的清单写入目录：nest_by_app 时为 manifest_dir/<appID>，否则为 manifest_dir 本身
+func appManifestDir(config Config, appID string) string {
+	if config.NestByApp && config.ManifestDir != "" {
+		return filepath.Join(config.ManifestDir, appID)
+	}
+	return config.ManifestDir
+}
+
+// appManifestTargets 返回 appID 的额外分发目录 (manifest_dirs)，nest_by_app 时同样按 AppID 分子目录
+func appManifestTargets(config Config, appID string) []string {
+	if !config.NestByApp {
+		return config.ManifestDirs
+	}
+	targets := make([]string, len(config.ManifestDirs))
+	for i, dir := range config.ManifestDirs {
+		targets[i] = filepath.Join(dir, appID)
+	}
+	return targets
+}
+
+// manifestFileName 返回结果中报告的清单文件名 (相对 manifest_dir)：nest_by_app 时为 "<appID>/文件名"
+func manifestFileName(config Config, appID, name string) string {
+	if config.NestByApp {
+		return appID + "/" + name
+	}
+	return name
+}
+
+// fanOutFile 把主目录中已下载好的 name 同步到每个额外目标目录 (优先硬链接，失败时复制)。
+// 每个目标独立计错，返回失败目标的说明；主目录中的文件不受影响。
+func (rn *run) fanOutFile(srcDir, name string, targets []string) []string {
+	var errs []string
+	src := filepath.Join(srcDir, name)
+	for _, dir := range targets {
+		dst := filepath.Join(dir, name)
+		if err := rn.linkOrCopy(src, dst); err != nil {
+			msg := fmt.Sprintf("%s: %s: %v", dir, name, err)
+			rn.warnf("分发清单失败 %s", msg)
+			errs = append(errs, msg)
+		}
+	}
+	return errs
+}
+
+// flattenManifests 是 flatten_symlinks：把 res 在 App 子目录中的清单 (本次下载与 skip_existing 跳过的)
+// 硬链接或复制到 manifest_dir 根目录。根目录中已有同一文件 (或同名同大小的副本) 时不再写入，
+// 因此多个 App 共享的清单只有一份。失败记入 res.TargetErrors
+func (rn *run) flattenManifests(config Config, res *AppResult) {
+	names := make([]string, 0, len(res.Files)+len(res.existing))
+	for _, f := range res.Files {
+		names = append(names, f.Name)
+	}
+	names = append(names, res.existing...)
+
+	rn.flattenMu.Lock()
+	defer rn.flattenMu.Unlock()
+	for _, name := range names {
+		if !isManifestPath(name) {
+			continue
+		}
+		src := filepath.Join(config.ManifestDir, filepath.FromSlash(name))
+		dst := filepath.Join(config.ManifestDir, path.Base(name))
+		if si, err := rn.fsys.Stat(src); err == nil {
+			if di, err := rn.fsys.Stat(dst); err == nil && (os.SameFile(si, di) || si.Size() == di.Size()) {
+				continue
+			}
+		}
+		if err := rn.linkOrCopy(src, dst); err != nil {
+			msg := fmt.Sprintf("%s: %s: %v", config.ManifestDir, path.Base(name), err)
+			rn.warnf("%s flatten_symlinks 失败 %s", res.AppID, msg)
+			res.TargetErrors = append(res.TargetErrors, msg)
+		}
+	}
+	sort.Strings(res.TargetErrors)
+}
+
+func (rn *run) linkOrCopy(src, dst string) error {
+	if err := rn.fsys.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	rn.fsys.Remove(dst)
+	if err := rn.fsys.Link(src, dst); err == nil {
+		return nil
+	}
+
+	// 跨盘符等情况无法硬链接，复制到临时文件后重命名
+	in, err := rn.fsys.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dst + ".tmp"
+	out, err := rn.fsys.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if rn.steamSafe && err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = rn.renameWithRetry(tmp, dst)
+	}
+	if err != nil {
+		rn.fsys.Remove(tmp)
+	}
+	return err
+}
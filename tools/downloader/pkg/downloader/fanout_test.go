@@ -0,0 +1,134 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+)
+
+// listFiles 返回 dir 下全部普通文件的相对路径 (斜杠分隔，已排序)，忽略索引等隐藏文件
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var out []string
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && info.Name()[0] != '.' {
+			rel, _ := filepath.Rel(dir, p)
+			out = append(out, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(out)
+	return out
+}
+
+func TestLayoutPerApp(t *testing.T) {
+	// 10 与 20 共享 depot 11 的同一个清单
+	files := map[string]string{
+		"a/b/10/10.lua":         "-- 10",
+		"a/b/10/11_22.manifest": testManifest,
+		"a/b/10/12_33.manifest": testManifest + "12",
+		"a/b/20/20.lua":         "-- 20",
+		"a/b/20/11_22.manifest": testManifest,
+	}
+	appData := map[string][]string{"10": {"11_22", "12_33"}, "20": {"11_22"}}
+	tests := []struct {
+		name     string
+		layout   string
+		flatten  bool
+		manifest []string // manifest_dir 下的文件
+	}{
+		{"flat default", "", false, []string{"11_22.manifest", "12_33.manifest"}},
+		{"per_app", LAYOUT_PER_APP, false, []string{"10/11_22.manifest", "10/12_33.manifest", "20/11_22.manifest"}},
+		{"per_app flatten", LAYOUT_PER_APP, true, []string{
+			"10/11_22.manifest", "10/12_33.manifest", "11_22.manifest", "12_33.manifest", "20/11_22.manifest",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepo(t, files)
+			cfg := testConfig(t, appData)
+			cfg.Layout, cfg.FlattenSymlinks = tt.layout, tt.flatten
+			res := r.download(t, cfg)
+			if got := listFiles(t, cfg.ManifestDir); !slices.Equal(got, tt.manifest) {
+				t.Errorf("manifest_dir = %v, want %v", got, tt.manifest)
+			}
+			// Lua 的命名与位置不随布局变化
+			if got := listFiles(t, cfg.LuaDir); !slices.Equal(got, []string{"10.lua", "20.lua"}) {
+				t.Errorf("lua_dir = %v", got)
+			}
+			for _, app := range res.Results {
+				for _, f := range app.Files {
+					if _, err := os.Stat(filepath.Join(cfg.ManifestDir, filepath.FromSlash(f.Name))); err != nil {
+						t.Errorf("%s reported %s: %v", app.AppID, f.Name, err)
+					}
+				}
+				if len(app.TargetErrors) != 0 {
+					t.Errorf("%s target errors: %v", app.AppID, app.TargetErrors)
+				}
+			}
+			if !tt.flatten {
+				return
+			}
+			// 平铺副本是硬链接，共享的清单在根目录只有一份
+			nested, _ := os.Stat(filepath.Join(cfg.ManifestDir, "10", "12_33.manifest"))
+			flat, err := os.Stat(filepath.Join(cfg.ManifestDir, "12_33.manifest"))
+			if err != nil || !os.SameFile(nested, flat) {
+				t.Errorf("12_33.manifest is not a hard link of 10/12_33.manifest (%v)", err)
+			}
+		})
+	}
+}
+
+func TestNestByApp(t *testing.T) {
+	files := map[string]string{
+		"a/b/10/10.lua":         "-- 10",
+		"a/b/10/11_22.manifest": testManifest,
+		"a/b/20/20.lua":         "-- 20",
+		"a/b/20/11_22.manifest": testManifest,
+		"a/b/20/21_44.manifest": testManifest + "21",
+	}
+	appData := map[string][]string{"10": {"11_22"}, "20": {"11_22", "21_44"}}
+	tests := []struct {
+		name     string
+		nest     bool
+		manifest []string            // manifest_dir 下的文件
+		reported map[string][]string // 每个 App 在结果中报告的清单名
+	}{
+		{"flat", false, []string{"11_22.manifest", "21_44.manifest"}, nil},
+		{"nested", true, []string{"10/11_22.manifest", "20/11_22.manifest", "20/21_44.manifest"},
+			map[string][]string{"10": {"10/11_22.manifest"}, "20": {"20/11_22.manifest", "20/21_44.manifest"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepo(t, files)
+			cfg := testConfig(t, appData)
+			cfg.NestByApp = tt.nest
+			res := r.download(t, cfg)
+			if got := listFiles(t, cfg.ManifestDir); !slices.Equal(got, tt.manifest) {
+				t.Errorf("manifest_dir = %v, want %v", got, tt.manifest)
+			}
+			for _, app := range res.Results {
+				var names []string
+				for _, f := range app.Files {
+					names = append(names, f.Name)
+				}
+				if want, ok := tt.reported[app.AppID]; ok && !slices.Equal(names, want) {
+					t.Errorf("%s reported %v, want %v", app.AppID, names, want)
+				}
+			}
+			// 两种布局下共享的清单都只请求一次
+			if n := r.count("a/b/20/11_22.manifest") + r.count("a/b/10/11_22.manifest"); n != 1 || res.Summary.DedupHits != 1 {
+				t.Errorf("shared manifest requested %d times, %d dedup hits", n, res.Summary.DedupHits)
+			}
+
+			// skip_existing 在对应的目录中查找已有清单
+			cfg.SkipExisting = true
+			res = r.download(t, cfg)
+			if res.Summary.Skipped != 3 || res.Summary.Manifest != 0 {
+				t.Errorf("second run summary = %+v, want 3 skipped", res.Summary)
+			}
+		})
+	}
+}
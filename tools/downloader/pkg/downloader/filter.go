@@ -0,0 +1,100 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// 企业网关/内容过滤器会对 GitHub 域名返回带品牌的 HTML 403。同一页面在
+// FILTER_MIN_URLS 个不同地址、FILTER_MIN_HOSTS 个不同主机上出现时判定网络被过滤。
+const (
+	FILTER_MIN_URLS   = 3
+	FILTER_MIN_HOSTS  = 2
+	FILTER_BODY_BYTES = 16 * 1024
+)
+
+var filterTitleRe = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// filteredError 表示请求被网络过滤器拦截
+type filteredError struct {
+	title string
+}
+
+func (e *filteredError) Error() string {
+	if e.title == "" {
+		return "网络被过滤 (代理/网关返回 403 页面)"
+	}
+	return fmt.Sprintf("网络被过滤 (代理/网关返回 403 页面: %s)", e.title)
+}
+
+// filterPage 是一种 403 页面 (按内容指纹区分) 出现过的地址与主机
+type filterPage struct {
+	title string
+	urls  map[string]bool
+	hosts map[string]bool
+}
+
+// filterDetector 统计非 GitHub 的 HTML 403 页面
+type filterDetector struct {
+	rn      *run
+	mu      sync.Mutex
+	pages   map[[32]byte]*filterPage
+	ignore  bool // ignore_network_filter：只警告，不终止运行
+	tripped *filteredError
+}
+
+func (rn *run) newFilterDetector(ignore bool) *filterDetector {
+	return &filterDetector{rn: rn, pages: make(map[[32]byte]*filterPage), ignore: ignore}
+}
+
+// isFilterPage 判断 403 响应是否可能来自过滤器：不是 GitHub 自己的响应 (没有 X-GitHub-Request-Id) 且为 HTML
+func isFilterPage(resp *http.Response) bool {
+	return resp.StatusCode == 403 && resp.Header.Get("X-GitHub-Request-Id") == "" &&
+		strings.Contains(strings.ToLower(resp.Header.Get("Content-Type")), "html")
+}
+
+// observe 记录一个 403 响应，判定网络被过滤后返回 filteredError 并终止运行，否则原样返回 se
+func (f *filterDetector) observe(rawURL string, resp *http.Response, se *statusError) error {
+	if se.rateLimited || !isFilterPage(resp) {
+		return se
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, FILTER_BODY_BYTES))
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		host = u.Host
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.tripped != nil && !f.ignore {
+		return f.tripped
+	}
+	sum := sha256.Sum256(body)
+	p, ok := f.pages[sum]
+	if !ok {
+		p = &filterPage{urls: make(map[string]bool), hosts: make(map[string]bool)}
+		if m := filterTitleRe.FindSubmatch(body); m != nil {
+			p.title = strings.Join(strings.Fields(string(m[1])), " ")
+		}
+		f.pages[sum] = p
+	}
+	p.urls[rawURL] = true
+	p.hosts[host] = true
+	if f.tripped != nil || len(p.urls) < FILTER_MIN_URLS || len(p.hosts) < FILTER_MIN_HOSTS {
+		return se
+	}
+	f.tripped = &filteredError{title: p.title}
+	if f.ignore {
+		f.rn.warnf("%v，ignore_network_filter 已开启，继续运行", f.tripped)
+		return se
+	}
+	f.rn.warnf("%v，停止运行", f.tripped)
+	f.rn.abortRun(&abortError{kind: KIND_NETWORK_FILTERED, msg: f.tripped.Error()})
+	return f.tripped
+}
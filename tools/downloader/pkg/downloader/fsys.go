@@ -0,0 +1,69 @@
+package downloader
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+)
+
+// Downloader 发出下载请求。默认实现是共享的 httpClient，测试或嵌入时可替换为内存实现，
+// 下载流程 (重试、换源、校验、写盘) 不依赖真实网络
+type Downloader interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// File 是 FileSystem 打开的本地文件
+type File interface {
+	io.Reader
+	io.Writer
+	io.Seeker
+	Name() string
+	Sync() error
+	Close() error
+}
+
+// FileSystem 是下载流程用到的文件操作，默认实现直接调用 os。
+// 输出目录、缓存、状态文件、日志与各类报告都经由它读写
+type FileSystem interface {
+	MkdirAll(path string, perm fs.FileMode) error
+	Create(name string) (File, error)
+	CreateTemp(dir, pattern string) (File, error)
+	Open(name string) (File, error)
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Link(oldname, newname string) error
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	Stat(name string) (fs.FileInfo, error)
+}
+
+// osFS 是 FileSystem 的真实实现
+type osFS struct{}
+
+func (osFS) MkdirAll(path string, perm fs.FileMode) error { return os.MkdirAll(path, perm) }
+func (osFS) Create(name string) (File, error)             { return osFile(os.Create(name)) }
+func (osFS) CreateTemp(dir, pattern string) (File, error) { return osFile(os.CreateTemp(dir, pattern)) }
+func (osFS) Open(name string) (File, error)               { return osFile(os.Open(name)) }
+func (osFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	return osFile(os.OpenFile(name, flag, perm))
+}
+func (osFS) ReadFile(name string) ([]byte, error) { return os.ReadFile(name) }
+func (osFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+func (osFS) Link(oldname, newname string) error         { return os.Link(oldname, newname) }
+func (osFS) Rename(oldpath, newpath string) error       { return os.Rename(oldpath, newpath) }
+func (osFS) Remove(name string) error                   { return os.Remove(name) }
+func (osFS) Stat(name string) (fs.FileInfo, error)      { return os.Stat(name) }
+
+// osFile 避免把 nil *os.File 包成非 nil 的 File
+func osFile(f *os.File, err error) (File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,11 @@
+//go:build !slim
+
+package downloader
+
+// SLIM_BUILD 表示当前是否为精简版构建 (go build -tags slim)
+const SLIM_BUILD = false
+
+// unsupportedOptions 返回配置中本构建不支持的选项；完整版支持全部选项
+func unsupportedOptions(config Config) []string {
+	return nil
+}
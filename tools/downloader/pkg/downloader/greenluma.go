@@ -0,0 +1,70 @@
+package downloader
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// recordAppList 记录一个处理成功的 App 及其 DLC，运行结束后统一写入 GreenLuma 的 AppList
+func (rn *run) recordAppList(appID string, dlcs []string) {
+	rn.appListMu.Lock()
+	defer rn.appListMu.Unlock()
+	rn.appListIDs[appID] = dlcs
+}
+
+// writeAppList 按 config.AppIDs 的顺序把记录的 ID (App 及其 DLC) 写入 AppList 目录，返回新建与已存在的条目数
+func (rn *run) writeAppList(dir string, order []string) (created, present int, err error) {
+	rn.appListMu.Lock()
+	var ids []string
+	for _, appID := range order {
+		if dlcs, ok := rn.appListIDs[appID]; ok {
+			ids = append(append(ids, appID), dlcs...)
+		}
+	}
+	rn.appListMu.Unlock()
+	return rn.writeAppListIDs(dir, ids)
+}
+
+// writeAppListIDs 把 ids 按顺序写成 AppList/N.txt (每个文件一个 ID)。
+// 已存在于目录中任意 txt 文件里的 ID 跳过；序号从最小的空闲编号开始，不留空洞。
+func (rn *run) writeAppListIDs(dir string, ids []string) (created, present int, err error) {
+	if err := rn.fsys.MkdirAll(dir, 0755); err != nil {
+		return 0, 0, &diskError{err}
+	}
+	entries, err := rn.fsys.ReadDir(dir)
+	if err != nil {
+		return 0, 0, &diskError{err}
+	}
+	used := make(map[int]bool)
+	have := make(map[string]bool)
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.EqualFold(filepath.Ext(name), ".txt") {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimSuffix(name, filepath.Ext(name))); err == nil {
+			used[n] = true
+		}
+		if data, err := rn.fsys.ReadFile(filepath.Join(dir, name)); err == nil {
+			have[strings.TrimSpace(string(stripBOM(data)))] = true
+		}
+	}
+
+	next := 0
+	for _, id := range ids {
+		if have[id] {
+			present++
+			continue
+		}
+		for used[next] {
+			next++
+		}
+		if err := rn.fsys.WriteFile(filepath.Join(dir, strconv.Itoa(next)+".txt"), []byte(id), 0644); err != nil {
+			return created, present, &diskError{err}
+		}
+		used[next], have[id] = true, true
+		created++
+	}
+	return created, present, nil
+}
@@ -0,0 +1,103 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// readAppList 按序号读出 AppList 目录中连续的 N.txt
+func readAppList(t *testing.T, dir string) []string {
+	t.Helper()
+	var ids []string
+	for i := 0; ; i++ {
+		data, err := os.ReadFile(filepath.Join(dir, strconv.Itoa(i)+".txt"))
+		if err != nil {
+			return ids
+		}
+		ids = append(ids, string(data))
+	}
+}
+
+func TestWriteAppListIDs(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing map[string]string // 目录中已有的文件
+		ids      []string
+		files    map[string]string // 写入后期望的新文件
+		created  int
+		present  int
+	}{
+		{"empty dir", nil, []string{"10", "11"}, map[string]string{"0.txt": "10", "1.txt": "11"}, 2, 0},
+		{
+			// 已有 ID 跳过 (含 BOM、空白与非数字文件名)，新条目填补最小的空闲编号
+			"fill gaps",
+			map[string]string{"0.txt": "\xef\xbb\xbf10\r\n", "2.txt": "99", "notes.txt": " 20 ", "5.TXT": "30"},
+			[]string{"10", "11", "20", "12", "13", "30"},
+			map[string]string{"1.txt": "11", "3.txt": "12", "4.txt": "13"},
+			3, 3,
+		},
+		{"duplicate ids", nil, []string{"10", "10"}, map[string]string{"0.txt": "10"}, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "AppList")
+			os.MkdirAll(dir, 0o755)
+			for name, body := range tt.existing {
+				os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644)
+			}
+			created, present, err := newRun().writeAppListIDs(dir, tt.ids)
+			if err != nil || created != tt.created || present != tt.present {
+				t.Errorf("created %d, present %d, err %v; want %d, %d", created, present, err, tt.created, tt.present)
+			}
+			for name, want := range tt.files {
+				if got, _ := os.ReadFile(filepath.Join(dir, name)); string(got) != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+			if n := len(listFiles(t, dir)); n != len(tt.existing)+len(tt.files) {
+				t.Errorf("%d files in AppList, want %d", n, len(tt.existing)+len(tt.files))
+			}
+		})
+	}
+}
+
+func TestRunGreenLumaDir(t *testing.T) {
+	tests := []struct {
+		name         string
+		autoDiscover bool
+		want         []string
+	}{
+		// 按 app_ids 的顺序写入成功的 App，失败的 App 30 不写
+		{"apps", false, []string{"20", "10"}},
+		// auto_discover 时加上 Lua 中 addappid 引用的其它 ID
+		{"with lua ids", true, []string{"20", "10", "11", "12"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepo(t, map[string]string{
+				"a/b/10/10.lua":         "addappid(10)\naddappid(11, 1, \"aa11\")\naddappid(12)\n",
+				"a/b/10/11_22.manifest": testManifest,
+				"a/b/20/20.lua":         "-- 20",
+			})
+			cfg := testConfig(t, map[string][]string{"10": {"11_22"}, "20": nil, "30": nil})
+			cfg.AppIDs = []string{"20", "10", "30"}
+			cfg.AutoDiscover = tt.autoDiscover
+			cfg.GreenLumaDir = filepath.Join(t.TempDir(), "AppList")
+			res := r.download(t, cfg)
+			if got := readAppList(t, cfg.GreenLumaDir); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AppList = %v, want %v", got, tt.want)
+			}
+			if res.AppListCreated != len(tt.want) || res.AppListPresent != 0 {
+				t.Errorf("created %d, present %d; want %d, 0", res.AppListCreated, res.AppListPresent, len(tt.want))
+			}
+
+			res = r.download(t, cfg)
+			if res.AppListCreated != 0 || res.AppListPresent != len(tt.want) || len(listFiles(t, cfg.GreenLumaDir)) != len(tt.want) {
+				t.Errorf("second run: created %d, present %d, files %v", res.AppListCreated, res.AppListPresent, listFiles(t, cfg.GreenLumaDir))
+			}
+		})
+	}
+}
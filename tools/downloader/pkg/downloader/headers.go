@@ -0,0 +1,33 @@
+package downloader
+
+import (
+	"net/http"
+	"strings"
+)
+
+// VERSION 是下载器的版本号，出现在启动日志与默认 User-Agent 中
+const VERSION = "2026-01-06-v17"
+
+// DEFAULT_USER_AGENT 是未配置 user_agent 时发送的 User-Agent (GitHub 会拦截缺失或可疑的 User-Agent)
+const DEFAULT_USER_AGENT = "steamunlocker-downloader/" + VERSION
+
+// setRequestHeaders 写入 User-Agent 与附加请求头；调用方随后设置的 Authorization 等请求头会覆盖这里的同名值
+func (rn *run) setRequestHeaders(req *http.Request) {
+	req.Header.Set("User-Agent", rn.userAgent)
+	for k, v := range rn.extraHeaders {
+		req.Header.Set(k, v)
+	}
+}
+
+// validHeaderName 判断 name 是否可以作为请求头名称 (HTTP token 字符)
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestRequestHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		ua      string
+		headers map[string]string
+		token   string
+		want    map[string]string // 服务器收到的请求头
+	}{
+		{"default user agent", "", nil, "", map[string]string{"User-Agent": DEFAULT_USER_AGENT}},
+		{"custom", "  my-tool/1.0 ", map[string]string{"X-Proxy-Auth": "secret", "accept-language": "zh"}, "",
+			map[string]string{"User-Agent": "my-tool/1.0", "X-Proxy-Auth": "secret", "Accept-Language": "zh"}},
+		{"authorization without token", "", map[string]string{"Authorization": "Bearer corp"}, "",
+			map[string]string{"Authorization": "Bearer corp"}},
+		// token 的 Authorization 不会被 headers 覆盖
+		{"token wins", "", map[string]string{"Authorization": "Bearer corp", "X-Extra": "1"}, "ghp_test",
+			map[string]string{"Authorization": "token ghp_test", "X-Extra": "1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepo(t, map[string]string{"a/b/10/10.lua": "-- 10"})
+			var mu sync.Mutex
+			var got []http.Header
+			for _, p := range []string{"a/b/10/10.lua", "a/b/10/11_22.manifest"} {
+				p := p
+				r.handle(p, func(w http.ResponseWriter, req *http.Request) {
+					mu.Lock()
+					got = append(got, req.Header.Clone())
+					mu.Unlock()
+					if p == "a/b/10/10.lua" {
+						io.WriteString(w, "-- 10")
+						return
+					}
+					io.WriteString(w, testManifest)
+				})
+			}
+			cfg := testConfig(t, map[string][]string{"10": {"11_22"}})
+			cfg.UserAgent, cfg.Headers, cfg.Token = tt.ua, tt.headers, tt.token
+			if res := r.download(t, cfg); res.Summary.Manifest != 1 {
+				t.Fatalf("summary = %+v", res.Summary)
+			}
+			if len(got) < 2 {
+				t.Fatalf("got %d requests, want Lua and manifest", len(got))
+			}
+			for _, h := range got {
+				for k, v := range tt.want {
+					if h.Get(k) != v {
+						t.Errorf("%s = %q, want %q", k, h.Get(k), v)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRequestHeadersValidation(t *testing.T) {
+	r := newTestRepo(t, nil)
+	for _, headers := range []map[string]string{
+		{"Bad Name": "x"},
+		{"X-Ok": "line\r\nInjected: 1"},
+		{"Host": "evil.test"},
+		{"": "x"},
+	} {
+		cfg := testConfig(t, map[string][]string{"10": nil})
+		cfg.Headers = headers
+		_, err := r.client().Run(context.Background(), cfg)
+		var ce *ConfigError
+		if !errors.As(err, &ce) || ce.Field != "headers" {
+			t.Errorf("headers %q: err = %v, want headers error", headers, err)
+		}
+	}
+}
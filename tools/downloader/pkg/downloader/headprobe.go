@@ -0,0 +1,62 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptrace"
+)
+
+// headRejected 判断 rawURL 所在的主机是否已确认不支持 HEAD
+func (rn *run) headRejected(rawURL string) bool {
+	rn.headUnsupported.Lock()
+	defer rn.headUnsupported.Unlock()
+	return rn.headUnsupported.m[urlHost(rawURL)]
+}
+
+// rejectHead 记录 rawURL 所在的主机不支持 HEAD
+func (rn *run) rejectHead(rawURL string) {
+	rn.headUnsupported.Lock()
+	rn.headUnsupported.m[urlHost(rawURL)] = true
+	rn.headUnsupported.Unlock()
+}
+
+// headMissing 用 HEAD 探测 fileURL，只有服务器明确返回 404 时才返回 true。
+// 其它结果 (200、重定向后的状态、限流、5xx、网络错误) 都交给随后的 GET 处理，保持原有的重试与换源逻辑
+func (rn *run) headMissing(ctx context.Context, base, fileURL, token string) bool {
+	if rn.headRejected(fileURL) {
+		return false
+	}
+	if rn.budgetFor(fileURL).acquire() != nil {
+		return false
+	}
+	if err := rn.waitLimiter(ctx, fileURL); err != nil {
+		return false
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, rn.requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(reqCtx, rn.connTrace), "HEAD", fileURL, nil)
+	if err != nil {
+		return false
+	}
+	rn.setRequestHeaders(req)
+	if token != "" && rn.isGitHubURL(fileURL) {
+		req.Header.Set("Authorization", "token "+rn.authToken(token))
+	}
+	resp, err := rn.dl.Do(req)
+	if err != nil {
+		statsFrom(ctx).request(0)
+		rn.verbosef("HEAD %s -> %s", fileURL, errorReason(err))
+		return false
+	}
+	resp.Body.Close()
+	statsFrom(ctx).request(resp.StatusCode)
+	rn.verbosef("HEAD %s -> %d", fileURL, resp.StatusCode)
+	switch resp.StatusCode {
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		rn.rejectHead(fileURL)
+		rn.debugf("源 %s 不支持 HEAD，改用 GET 探测", base)
+	case http.StatusNotFound:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestProbeWithHead(t *testing.T) {
+	tests := []struct {
+		name       string
+		probe      bool
+		headStatus int // 非 0 时所有 HEAD 请求返回该状态
+		wantGets   int // 对清单候选发出的 GET 数
+		wantHeads  int // -1 表示至少 2 个
+	}{
+		// 清单只在第三个分支 master 中：HEAD 排除其它候选后只 GET 一次
+		{"head probing", true, 0, 1, -1},
+		// 不支持 HEAD 的源只试一次 HEAD，之后全部改用 GET
+		{"head not allowed", true, http.StatusMethodNotAllowed, -1, 1},
+		{"head not implemented", true, http.StatusNotImplemented, -1, 1},
+		{"disabled", false, 0, -1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepo(t, map[string]string{"a/b/10/10.lua": "-- 10", "a/b/master/11_22.manifest": testManifest})
+			var mu sync.Mutex
+			heads, gets := 0, 0
+			r.srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if strings.Contains(req.URL.Path, "22") {
+					mu.Lock()
+					if req.Method == http.MethodHead {
+						heads++
+					} else {
+						gets++
+					}
+					mu.Unlock()
+				}
+				if req.Method == http.MethodHead && tt.headStatus != 0 {
+					w.WriteHeader(tt.headStatus)
+					return
+				}
+				r.serve(w, req)
+			})
+			cfg := testConfig(t, map[string][]string{"10": {"11_22"}})
+			cfg.ProbeWithHead = tt.probe
+			res := r.download(t, cfg)
+			if res.Summary.Manifest != 1 {
+				t.Fatalf("summary = %+v", res.Summary)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if tt.wantGets >= 0 && gets != tt.wantGets {
+				t.Errorf("GET requests = %d, want %d", gets, tt.wantGets)
+			}
+			if tt.wantGets < 0 && gets < 2 {
+				t.Errorf("GET requests = %d, want one per candidate", gets)
+			}
+			if (tt.wantHeads >= 0 && heads != tt.wantHeads) || (tt.wantHeads < 0 && heads < 2) {
+				t.Errorf("HEAD requests = %d, want %d", heads, tt.wantHeads)
+			}
+		})
+	}
+}
+
+func TestHeadMissing(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		want   bool
+	}{
+		{"found", http.StatusOK, false},
+		{"missing", http.StatusNotFound, true},
+		// 限流与服务器错误交给随后的 GET 处理重试与换源
+		{"rate limited", http.StatusTooManyRequests, false},
+		{"server error", http.StatusBadGateway, false},
+		{"forbidden", http.StatusForbidden, false},
+	}
+	for _, tt := range tests {
+		r := newTestRepo(t, nil)
+		r.handle("a/b/10/11_22.manifest", func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(tt.status)
+		})
+		rn := newRun()
+		if got := rn.headMissing(context.Background(), r.srv.URL, r.srv.URL+"/a/b/10/11_22.manifest", ""); got != tt.want {
+			t.Errorf("%s: headMissing = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
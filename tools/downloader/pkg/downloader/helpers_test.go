@@ -0,0 +1,102 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testManifest 是以 Steam 清单魔数开头、能通过内容校验的清单内容
+var testManifest = string(steamManifestMagics[0]) + strings.Repeat("\x01\x02", 64)
+
+// testRepo 是模拟 raw 源与 GitHub API 的 httptest 服务器。
+// files 的键为 "repo/branch/path"，例如 "a/b/10/10.lua"；不存在的路径返回 404
+type testRepo struct {
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	files    map[string]string
+	handlers map[string]http.HandlerFunc // 覆盖特定路径的响应
+	hits     map[string]int              // 每个路径收到的请求数 (含 HEAD)
+}
+
+func newTestRepo(t *testing.T, files map[string]string) *testRepo {
+	t.Helper()
+	r := &testRepo{files: files, handlers: map[string]http.HandlerFunc{}, hits: map[string]int{}}
+	r.srv = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.srv.Close)
+	return r
+}
+
+func (r *testRepo) serve(w http.ResponseWriter, req *http.Request) {
+	p := strings.TrimPrefix(req.URL.Path, "/")
+	r.mu.Lock()
+	r.hits[p]++
+	h := r.handlers[p]
+	body, ok := r.files[p]
+	r.mu.Unlock()
+	switch {
+	case h != nil:
+		h(w, req)
+	case ok:
+		io.WriteString(w, body)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// handle 让 path 的请求交给 h 处理
+func (r *testRepo) handle(path string, h http.HandlerFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[path] = h
+}
+
+// count 返回 path 收到的请求数
+func (r *testRepo) count(path string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.hits[path]
+}
+
+// client 返回指向该服务器的 Client
+func (r *testRepo) client() *Client {
+	return &Client{RawBase: r.srv.URL, APIBase: r.srv.URL, Output: io.Discard, LogLevel: "error"}
+}
+
+// testConfig 返回从仓库 a/b 直连下载、输出到临时目录的配置
+func testConfig(t *testing.T, appData map[string][]string) Config {
+	t.Helper()
+	dir := t.TempDir()
+	ids := make([]string, 0, len(appData))
+	for id := range appData {
+		ids = append(ids, id)
+	}
+	return Config{
+		Repos:               []string{"a/b"},
+		AppIDs:              ids,
+		AppData:             appData,
+		LuaDir:              filepath.Join(dir, "lua"),
+		ManifestDir:         filepath.Join(dir, "depotcache"),
+		OutputPath:          filepath.Join(dir, "result.json"),
+		DirectMode:          true,
+		DisableBranchDetect: true,
+		RetryBaseMs:         1,
+		RetryMaxMs:          1,
+	}
+}
+
+// download 用 r 的 Client 执行一次下载
+func (r *testRepo) download(t *testing.T, cfg Config) Result {
+	t.Helper()
+	res, err := r.client().Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	return res
+}
@@ -0,0 +1,40 @@
+package downloader
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// estimateInstallSize 是 estimate_install_size：读取 res 中本次下载与 skip_existing 跳过的清单的 metadata，
+// 按 depot 累加 cb_disk_original。同一 depot 有多个清单 (新旧版本) 时只计最大的一个；
+// 无法解析的清单不计入并把估算标记为 partial
+func (rn *run) estimateInstallSize(config Config, res *AppResult) {
+	names := make([]string, 0, len(res.Files)+len(res.existing))
+	for _, f := range res.Files {
+		names = append(names, f.Name)
+	}
+	names = append(names, res.existing...)
+
+	depots := make(map[string]uint64)
+	for _, name := range names {
+		if !isManifestPath(name) {
+			continue
+		}
+		meta, err := rn.readManifestMeta(filepath.Join(config.ManifestDir, filepath.FromSlash(name)))
+		if err != nil {
+			rn.debugf("%s estimate_install_size 无法解析清单 %s: %v", res.AppID, name, err)
+			res.InstallEstimatePartial = true
+			continue
+		}
+		depot := meta.DepotID
+		if depot == "" {
+			depot, _, _ = strings.Cut(filepath.Base(name), "_")
+		}
+		depots[depot] = max(depots[depot], meta.DiskBytes)
+	}
+	var total int64
+	for _, n := range depots {
+		total += int64(n)
+	}
+	res.EstimatedInstallBytes = &total
+}
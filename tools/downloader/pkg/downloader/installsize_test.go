@@ -0,0 +1,70 @@
+package downloader
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// buildManifest 返回只含空 payload 与 metadata (depot_id、cb_disk_original) 分段的原始格式清单
+func buildManifest(depot, diskBytes uint64) string {
+	var meta []byte
+	meta = binary.AppendUvarint(append(meta, 1<<3|0), depot)
+	meta = binary.AppendUvarint(append(meta, 5<<3|0), diskBytes)
+	var out []byte
+	for _, s := range []struct {
+		magic uint32
+		data  []byte
+	}{{MANIFEST_PAYLOAD_MAGIC, nil}, {MANIFEST_METADATA_MAGIC, meta}} {
+		out = binary.LittleEndian.AppendUint32(out, s.magic)
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(s.data)))
+		out = append(out, s.data...)
+	}
+	return string(binary.LittleEndian.AppendUint32(out, MANIFEST_END_MAGIC))
+}
+
+func TestEstimateInstallSize(t *testing.T) {
+	files := map[string]string{
+		"a/b/10/10.lua": "-- 10",
+		// 同一 depot 的两个版本只计较大的一个
+		"a/b/10/11_22.manifest": buildManifest(11, 1000),
+		"a/b/10/11_23.manifest": buildManifest(11, 1500),
+		"a/b/10/12_33.manifest": buildManifest(12, 200),
+		"a/b/20/20.lua":         "-- 20",
+		"a/b/20/21_44.manifest": testManifest, // 没有 metadata 分段，无法解析
+	}
+	appData := map[string][]string{"10": {"11_22", "11_23", "12_33"}, "20": {"21_44"}}
+
+	t.Run("enabled", func(t *testing.T) {
+		r := newTestRepo(t, files)
+		cfg := testConfig(t, appData)
+		cfg.EstimateInstallSize = true
+		res := r.download(t, cfg)
+		want := map[string]struct {
+			bytes   int64
+			partial bool
+		}{"10": {1700, false}, "20": {0, true}}
+		for _, app := range res.Results {
+			w := want[app.AppID]
+			if app.EstimatedInstallBytes == nil || *app.EstimatedInstallBytes != w.bytes || app.InstallEstimatePartial != w.partial {
+				t.Errorf("%s: estimate = %v (partial %v), want %d (partial %v)", app.AppID, app.EstimatedInstallBytes, app.InstallEstimatePartial, w.bytes, w.partial)
+			}
+		}
+		if s := res.Summary; s.EstimatedInstallBytes == nil || *s.EstimatedInstallBytes != 1700 || !s.InstallEstimatePartial {
+			t.Errorf("summary estimate = %v (partial %v), want 1700 (partial)", s.EstimatedInstallBytes, s.InstallEstimatePartial)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		// 未开启时省略字段，而不是输出 0
+		res := newTestRepo(t, files).download(t, testConfig(t, appData))
+		data, err := json.Marshal(res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "estimated_install_bytes") || strings.Contains(string(data), "install_estimate_partial") {
+			t.Errorf("result JSON contains the install estimate: %s", data)
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package downloader
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// validate_keys 的检查结果 (AppResult.KeyWarnings 的值)
+const (
+	KEY_FORMAT   = "key_format"   // 不是 64 位十六进制 (AES-256 密钥)
+	KEY_MISMATCH = "key_mismatch" // 用该密钥试解密清单中的加密文件名失败
+)
+
+// depotKeyBytes 解析十六进制 depot 密钥，长度必须为 32 字节
+func depotKeyBytes(key string) ([]byte, bool) {
+	b, err := hex.DecodeString(strings.TrimSpace(key))
+	return b, err == nil && len(b) == 32
+}
+
+// decryptManifestName 按 Steam 的对称加密格式试解密一个 base64 文件名：
+// 前 16 字节以 AES-ECB 解密得到 IV，其余部分 AES-CBC 解密并去掉 PKCS#7 填充
+func decryptManifestName(key []byte, encoded string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", err
+	}
+	if len(data) < 2*aes.BlockSize || len(data)%aes.BlockSize != 0 {
+		return "", errors.New("密文长度无效")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, aes.BlockSize)
+	block.Decrypt(iv, data[:aes.BlockSize])
+	out := make([]byte, len(data)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data[aes.BlockSize:])
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > aes.BlockSize {
+		return "", errors.New("填充无效")
+	}
+	for _, b := range out[len(out)-pad:] {
+		if int(b) != pad {
+			return "", errors.New("填充无效")
+		}
+	}
+	out = out[:len(out)-pad]
+	// 文件名以 NUL 结尾
+	name := strings.TrimRight(string(out), "\x00")
+	if !utf8.ValidString(name) || strings.ContainsAny(name, "\x00\r\n") {
+		return "", errors.New("解密结果不是文件名")
+	}
+	return name, nil
+}
+
+// validateDepotKeys 是 validate_keys：检查 Lua 与 key.vdf 中每个 depot 密钥的格式，
+// 本次下载的清单文件名被加密时用对应密钥试解密第一个文件名。返回 depot -> KEY_*，全部正常时返回 nil。
+func (rn *run) validateDepotKeys(config Config, res *AppResult) map[string]string {
+	var sets []map[string]string
+	if res.Lua > 0 && config.LuaDir != "" {
+		if info, err := rn.parseLuaFile(filepath.Join(config.LuaDir, res.AppID+".lua")); err == nil {
+			sets = append(sets, info.Keys)
+		}
+	}
+	sets = append(sets, res.Keys)
+
+	metas := make(map[string]*manifestMeta)
+	for _, f := range res.Files {
+		meta, err := rn.readManifestMeta(filepath.Join(config.ManifestDir, f.Name))
+		if err != nil {
+			rn.debugf("%s 无法解析清单 %s: %v", res.AppID, f.Name, err)
+			continue
+		}
+		if meta.Encrypted && meta.SampleName != "" {
+			metas[meta.DepotID] = meta
+		}
+	}
+
+	warnings := make(map[string]string)
+	for _, keys := range sets {
+		for depot, key := range keys {
+			kb, ok := depotKeyBytes(key)
+			if !ok {
+				warnings[depot] = KEY_FORMAT
+				continue
+			}
+			meta := metas[depot]
+			if meta == nil {
+				continue
+			}
+			if _, err := decryptManifestName(kb, meta.SampleName); err != nil {
+				warnings[depot] = KEY_MISMATCH
+				rn.warnf("%s depot %s 的密钥与清单不匹配 (%v)", res.AppID, depot, err)
+			}
+		}
+	}
+	if len(warnings) == 0 {
+		return nil
+	}
+	return warnings
+}
@@ -0,0 +1,96 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+)
+
+// KEY_FILE_NAMES 是分支中解密密钥文件的候选名
+var KEY_FILE_NAMES = []string{"key.vdf", "Key.vdf"}
+
+// wantKeys 判断是否需要获取 key.vdf (fetch_keys、patch_lua 或配置了 steam_config_vdf)
+func wantKeys(config Config) bool {
+	return config.FetchKeys || config.PatchLua || config.SteamConfigVDF != ""
+}
+
+// recordKeys 把一个 App 的密钥加入本次运行的集合
+func (rn *run) recordKeys(keys map[string]string) {
+	rn.keysMu.Lock()
+	defer rn.keysMu.Unlock()
+	for id, k := range keys {
+		rn.collectedKeys[id] = k
+	}
+}
+
+// fetchDepotKeys 从 appID 分支下载 key.vdf 并解析出 depot 密钥；preferRepo (已提供 Lua/清单的仓库) 优先
+func (rn *run) fetchDepotKeys(ctx context.Context, config Config, appID, preferRepo string) (map[string]string, error) {
+	repos := config.Repos
+	if preferRepo != "" {
+		repos = append([]string{preferRepo}, removeString(config.Repos, preferRepo)...)
+	}
+
+	tmp, err := rn.fsys.CreateTemp("", "downloader-key-*.vdf")
+	if err != nil {
+		return nil, &diskError{err}
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	defer rn.fsys.Remove(tmpPath)
+
+	var lastErr error
+	for _, repo := range repos {
+		for _, name := range KEY_FILE_NAMES {
+			_, err := rn.fetchFile(ctx, repo, appID, name, tmpPath, config.Token, "")
+			if err == nil {
+				data, err := rn.fsys.ReadFile(tmpPath)
+				if err != nil {
+					return nil, &diskError{err}
+				}
+				return parseDepotKeys(data)
+			}
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			if lastErr == nil || statusCode(err) != 404 {
+				lastErr = err
+			}
+		}
+	}
+	return nil, lastErr
+}
+
+func removeString(list []string, s string) []string {
+	var out []string
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// mergeKeysIntoConfig 把密钥合并进 Steam 的 config.vdf：先写 .bak 备份，再通过临时文件替换原文件。
+// 返回新增或更新的 depot 数量，全部密钥都已存在时不改动文件。
+func (rn *run) mergeKeysIntoConfig(path string, keys map[string]string) (int, error) {
+	data, err := rn.fsys.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	merged, changed, err := mergeDepotKeys(data, keys)
+	if err != nil || changed == 0 {
+		return 0, err
+	}
+	if err := rn.fsys.WriteFile(path+".bak", data, 0644); err != nil {
+		return 0, fmt.Errorf("无法创建备份: %v", err)
+	}
+	tmp := path + ".tmp"
+	if err := rn.fsys.WriteFile(tmp, merged, 0644); err != nil {
+		rn.fsys.Remove(tmp)
+		return 0, err
+	}
+	if err := rn.renameWithRetry(tmp, path); err != nil {
+		rn.fsys.Remove(tmp)
+		return 0, err
+	}
+	return changed, nil
+}
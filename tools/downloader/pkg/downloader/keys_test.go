@@ -0,0 +1,82 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFetchKeys(t *testing.T) {
+	keyVDF := "\"depots\"\n{\n\t\"11\"\n\t{\n\t\t\"DecryptionKey\"\t\t\"aa11\"\n\t}\n\t\"12\" { \"DecryptionKey\" \"bb12\" }\n}\n"
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  map[string]string
+	}{
+		{"key.vdf", map[string]string{"a/b/10/key.vdf": keyVDF}, map[string]string{"11": "aa11", "12": "bb12"}},
+		{"Key.vdf", map[string]string{"a/b/10/Key.vdf": keyVDF}, map[string]string{"11": "aa11", "12": "bb12"}},
+		{"missing", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{"a/b/10/10.lua": "-- 10", "a/b/10/11_22.manifest": testManifest}
+			for k, v := range tt.files {
+				files[k] = v
+			}
+			r := newTestRepo(t, files)
+			cfg := testConfig(t, map[string][]string{"10": {"11_22"}})
+			cfg.FetchKeys = true
+			res := r.download(t, cfg)
+			if res.Summary.Lua != 1 || res.Summary.Manifest != 1 {
+				t.Fatalf("summary = %+v", res.Summary)
+			}
+			if got := res.Results[0].Keys; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("keys = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSteamConfigVDFMerge(t *testing.T) {
+	keyVDF := "\"depots\"\n{\n\t\"11\" { \"DecryptionKey\" \"new\" }\n\t\"12\" { \"DecryptionKey\" \"same\" }\n\t\"14\" { \"DecryptionKey\" \"k14\" }\n}\n"
+	r := newTestRepo(t, map[string]string{
+		"a/b/10/10.lua":         "-- 10",
+		"a/b/10/11_22.manifest": testManifest,
+		"a/b/10/key.vdf":        keyVDF,
+	})
+	cfg := testConfig(t, map[string][]string{"10": {"11_22"}})
+	cfg.SteamConfigVDF = filepath.Join(t.TempDir(), "config.vdf")
+	if err := os.WriteFile(cfg.SteamConfigVDF, []byte(configVDF), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	res := r.download(t, cfg)
+	// 11 改为新密钥、12 不变、14 追加到 depots 末尾
+	if res.KeysMerged != 2 {
+		t.Errorf("KeysMerged = %d, want 2", res.KeysMerged)
+	}
+	got, _ := os.ReadFile(cfg.SteamConfigVDF)
+	newDepot := tabs("                    \"14\"\n                    {\n                        \"DecryptionKey\"        \"k14\"\n                    }\n")
+	depotsClose := tabs("                }\n                \"Accounts\"")
+	want := strings.Replace(strings.Replace(configVDF, `"old"`, `"new"`, 1), depotsClose, newDepot+depotsClose, 1)
+	if string(got) != want {
+		t.Errorf("config.vdf =\n%s\nwant\n%s", got, want)
+	}
+	if bak, _ := os.ReadFile(cfg.SteamConfigVDF + ".bak"); string(bak) != configVDF {
+		t.Errorf("backup does not hold the original config.vdf:\n%s", bak)
+	}
+
+	// 再次运行：密钥都已存在，文件与备份都不再改动，也不会重复插入 depot
+	os.Remove(cfg.SteamConfigVDF + ".bak")
+	res = r.download(t, cfg)
+	if res.KeysMerged != 0 {
+		t.Errorf("second run KeysMerged = %d, want 0", res.KeysMerged)
+	}
+	if again, _ := os.ReadFile(cfg.SteamConfigVDF); string(again) != want {
+		t.Errorf("second run changed config.vdf:\n%s", again)
+	}
+	if _, err := os.Stat(cfg.SteamConfigVDF + ".bak"); !os.IsNotExist(err) {
+		t.Errorf("second run wrote a backup: %v", err)
+	}
+}
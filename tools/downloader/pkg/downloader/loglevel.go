@@ -0,0 +1,47 @@
+package downloader
+
+import "strings"
+
+// 日志级别 (log_level / -log-level)，从低到高；quiet 不输出任何日志行，只保留最终的结果 JSON
+const (
+	LOG_DEBUG = "debug"
+	LOG_INFO  = "info"
+	LOG_WARN  = "warn"
+	LOG_ERROR = "error"
+	LOG_QUIET = "quiet"
+)
+
+// 级别的内部取值，按严重程度递增
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+	levelQuiet
+)
+
+var logLevels = map[string]int{
+	LOG_DEBUG: levelDebug,
+	LOG_INFO:  levelInfo,
+	LOG_WARN:  levelWarn,
+	LOG_ERROR: levelError,
+	LOG_QUIET: levelQuiet,
+}
+
+// parseLogLevel 解析 log_level，空字符串为 info；"warning" 视为 warn
+func parseLogLevel(s string) (int, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "":
+		return levelInfo, true
+	case "warning":
+		s = LOG_WARN
+	}
+	level, ok := logLevels[s]
+	return level, ok
+}
+
+// errorf 输出错误：文本模式向 logOut 写 [ERROR] 行，JSON 模式转为 error 事件
+func (rn *run) errorf(format string, args ...interface{}) {
+	rn.logLine(levelError, "ERROR", "error", format, args...)
+}
@@ -0,0 +1,149 @@
+package downloader
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	setManifestRe = regexp.MustCompile(`setManifestid\s*\(\s*(\d+)\s*,\s*["'](\d+)["']\s*(?:,[^)]*)?\)`)
+	addAppRe      = regexp.MustCompile(`addappid\s*\(\s*(\d+)\s*(?:,\s*\d+\s*(?:,\s*["']([0-9a-fA-F]*)["']\s*)?)?\)`)
+)
+
+// luaInfo 是从 SteamTools Lua 脚本中提取出的信息
+type luaInfo struct {
+	Manifests []string          // "depot_manifest"，按出现顺序去重
+	Keys      map[string]string // depotID -> 解密密钥 (addappid 第三个参数)
+	AppIDs    []string          // addappid 出现的全部 ID，按出现顺序去重
+	Malformed []int             // 无法解析的 setManifestid/addappid 行号
+}
+
+// parseLuaFile 读取并解析 Lua 脚本
+func (rn *run) parseLuaFile(path string) (*luaInfo, error) {
+	f, err := rn.fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info := &luaInfo{Keys: make(map[string]string)}
+	seenManifest := make(map[string]bool)
+	seenApp := make(map[string]bool)
+	inBlockComment := false
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if lineNo == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		line, inBlockComment = stripLuaComments(line, inBlockComment)
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if strings.Contains(line, "setManifestid") {
+			matches := setManifestRe.FindAllStringSubmatch(line, -1)
+			if len(matches) != strings.Count(line, "setManifestid") {
+				info.Malformed = append(info.Malformed, lineNo)
+			}
+			for _, m := range matches {
+				item := m[1] + "_" + m[2]
+				if !seenManifest[item] {
+					seenManifest[item] = true
+					info.Manifests = append(info.Manifests, item)
+				}
+			}
+		}
+		if strings.Contains(line, "addappid") {
+			matches := addAppRe.FindAllStringSubmatch(line, -1)
+			if len(matches) != strings.Count(line, "addappid") {
+				info.Malformed = append(info.Malformed, lineNo)
+			}
+			for _, m := range matches {
+				if !seenApp[m[1]] {
+					seenApp[m[1]] = true
+					info.AppIDs = append(info.AppIDs, m[1])
+				}
+				if m[2] != "" {
+					info.Keys[m[1]] = m[2]
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// stripLuaComments 去掉一行中的 "--" 行注释与 "--[[ ]]" 块注释，返回剩余内容及是否仍处于块注释中
+func stripLuaComments(line string, inBlock bool) (string, bool) {
+	var b strings.Builder
+	for len(line) > 0 {
+		if inBlock {
+			end := strings.Index(line, "]]")
+			if end < 0 {
+				return b.String(), true
+			}
+			line = line[end+2:]
+			inBlock = false
+			continue
+		}
+		start := strings.Index(line, "--")
+		if start < 0 {
+			b.WriteString(line)
+			break
+		}
+		b.WriteString(line[:start])
+		if strings.HasPrefix(line[start:], "--[[") {
+			line = line[start+4:]
+			inBlock = true
+			continue
+		}
+		break
+	}
+	return b.String(), inBlock
+}
+
+// malformedSummary 将格式错误的行号压缩为简短说明
+func (info *luaInfo) malformedSummary() string {
+	if len(info.Malformed) == 0 {
+		return ""
+	}
+	shown := info.Malformed
+	if len(shown) > 5 {
+		shown = shown[:5]
+	}
+	parts := make([]string, len(shown))
+	for i, n := range shown {
+		parts[i] = fmt.Sprint(n)
+	}
+	msg := fmt.Sprintf("lua 有 %d 行格式错误 (第 %s 行", len(info.Malformed), strings.Join(parts, ", "))
+	if len(info.Malformed) > len(shown) {
+		msg += " 等"
+	}
+	return msg + ")"
+}
+
+// mergeManifestItems 合并两组 "depot_manifest" 条目并去重，保持先后顺序
+func mergeManifestItems(base, extra []string) []string {
+	seen := make(map[string]bool, len(base)+len(extra))
+	merged := make([]string, 0, len(base)+len(extra))
+	for _, list := range [][]string{base, extra} {
+		for _, item := range list {
+			item = strings.TrimSpace(item)
+			if item == "" || seen[item] {
+				continue
+			}
+			seen[item] = true
+			merged = append(merged, item)
+		}
+	}
+	return merged
+}
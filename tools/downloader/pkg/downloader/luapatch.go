@@ -0,0 +1,155 @@
+package downloader
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// patchManifestRe 比 setManifestRe 宽松：manifest ID 可以不带引号
+	patchManifestRe = regexp.MustCompile(`setManifestid\s*\(\s*(\d+)\s*,\s*["']?(\d+)["']?\s*((?:,[^)]*)?)\)`)
+	patchAddAppRe   = regexp.MustCompile(`addappid\s*\(\s*(\d+)\s*(?:,\s*(\d+)\s*(?:,\s*["']([^"']*)["']\s*)?)?\)`)
+)
+
+// downloadedManifests 从本次下载的清单文件名中提取 depot -> manifest ID
+func downloadedManifests(files []FileInfo) map[string]string {
+	out := make(map[string]string)
+	for _, f := range files {
+		parts := strings.Split(strings.TrimSuffix(path.Base(f.Name), ".manifest"), "_")
+		if len(parts) == 2 && isDigits(parts[0]) && isDigits(parts[1]) {
+			out[parts[0]] = parts[1]
+		}
+	}
+	return out
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// patchLuaFile 让 Lua 与实际下载的文件一致：每个已下载的 depot 都有指向该 manifest 的 setManifestid，
+// 有密钥的 depot 的 addappid 带上 key.vdf 中的密钥。已有的行就地更新，缺少的追加到文件末尾，
+// 因此重复执行不会产生重复行。内容有变化时原文件保存为 .lua.bak，返回是否修改了文件。
+func (rn *run) patchLuaFile(path string, manifests, keys map[string]string) (bool, error) {
+	if len(manifests) == 0 && len(keys) == 0 {
+		return false, nil
+	}
+	data, err := rn.fsys.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	orig := string(data)
+	nl := "\n"
+	if strings.Contains(orig, "\r\n") {
+		nl = "\r\n"
+	}
+
+	hasManifest := make(map[string]bool)
+	hasApp := make(map[string]bool)
+	lines := strings.SplitAfter(orig, "\n")
+	inBlock := false
+	for i, line := range lines {
+		body := strings.TrimRight(line, "\r\n")
+		wasBlock := inBlock
+		var code string
+		code, inBlock = stripLuaComments(body, inBlock)
+		if wasBlock || !strings.HasPrefix(body, code) {
+			// 行首处于块注释中或含行内块注释，无法安全改写
+			continue
+		}
+		patchedCode := patchManifestRe.ReplaceAllStringFunc(code, func(m string) string {
+			sub := patchManifestRe.FindStringSubmatch(m)
+			want, ok := manifests[sub[1]]
+			if !ok {
+				return m
+			}
+			hasManifest[sub[1]] = true
+			if sub[2] == want {
+				return m
+			}
+			rest := sub[3]
+			if rest == "" {
+				rest = ", 0"
+			}
+			return fmt.Sprintf(`setManifestid(%s, "%s"%s)`, sub[1], want, rest)
+		})
+		patchedCode = patchAddAppRe.ReplaceAllStringFunc(patchedCode, func(m string) string {
+			sub := patchAddAppRe.FindStringSubmatch(m)
+			key, ok := keys[sub[1]]
+			if !ok {
+				return m
+			}
+			hasApp[sub[1]] = true
+			if strings.EqualFold(sub[3], key) {
+				return m
+			}
+			flag := sub[2]
+			if flag == "" {
+				flag = "1"
+			}
+			return fmt.Sprintf(`addappid(%s, %s, "%s")`, sub[1], flag, key)
+		})
+		comment := body[len(code):]
+		lines[i] = patchedCode + comment + line[len(body):]
+	}
+
+	var b strings.Builder
+	b.WriteString(strings.Join(lines, ""))
+	var missing []string
+	for _, depot := range sortedKeys(keys) {
+		if !hasApp[depot] {
+			missing = append(missing, fmt.Sprintf(`addappid(%s, 1, "%s")`, depot, keys[depot]))
+		}
+	}
+	for _, depot := range sortedKeys(manifests) {
+		if !hasManifest[depot] {
+			missing = append(missing, fmt.Sprintf(`setManifestid(%s, "%s", 0)`, depot, manifests[depot]))
+		}
+	}
+	if len(missing) > 0 {
+		if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+			b.WriteString(nl)
+		}
+		for _, l := range missing {
+			b.WriteString(l + nl)
+		}
+	}
+
+	patched := b.String()
+	if patched == orig {
+		return false, nil
+	}
+	if err := rn.fsys.WriteFile(path+".bak", data, 0644); err != nil {
+		return false, &diskError{err}
+	}
+	tmp := path + ".tmp"
+	if err := rn.fsys.WriteFile(tmp, []byte(patched), 0644); err != nil {
+		rn.fsys.Remove(tmp)
+		return false, &diskError{err}
+	}
+	if err := rn.renameWithRetry(tmp, path); err != nil {
+		rn.fsys.Remove(tmp)
+		return false, &diskError{err}
+	}
+	return true, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
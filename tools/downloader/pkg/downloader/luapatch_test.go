@@ -0,0 +1,127 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPatchLuaFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		in        string
+		manifests map[string]string
+		keys      map[string]string
+		want      string // 与 in 相同表示不修改
+	}{
+		{
+			name: "nothing to patch",
+			in:   "addappid(10)\n",
+			want: "addappid(10)\n",
+		},
+		{
+			name:      "already up to date",
+			in:        "addappid(11, 1, \"AA11\")\nsetManifestid(11, \"22\", 0)\n",
+			manifests: map[string]string{"11": "22"},
+			keys:      map[string]string{"11": "aa11"},
+			want:      "addappid(11, 1, \"AA11\")\nsetManifestid(11, \"22\", 0)\n",
+		},
+		{
+			name:      "update manifest id",
+			in:        "addappid(10)\nsetManifestid(11, \"21\", 123)\nsetManifestid(12, \"5\")\n",
+			manifests: map[string]string{"11": "22", "12": "33"},
+			want:      "addappid(10)\nsetManifestid(11, \"22\", 123)\nsetManifestid(12, \"33\", 0)\n",
+		},
+		{
+			name:      "unquoted manifest id",
+			in:        "setManifestid(11, 21)\n",
+			manifests: map[string]string{"11": "22"},
+			want:      "setManifestid(11, \"22\", 0)\n",
+		},
+		{
+			name:      "append missing lines",
+			in:        "addappid(10)\naddappid(11)",
+			manifests: map[string]string{"12": "33", "11": "22"},
+			keys:      map[string]string{"11": "aa11", "13": "cc13"},
+			want:      "addappid(10)\naddappid(11, 1, \"aa11\")\naddappid(13, 1, \"cc13\")\nsetManifestid(11, \"22\", 0)\nsetManifestid(12, \"33\", 0)\n",
+		},
+		{
+			name: "update key keeps flag",
+			in:   "addappid(11, 0, \"old\") -- 注释 setManifestid(11, \"1\")\n",
+			keys: map[string]string{"11": "aa11"},
+			want: "addappid(11, 0, \"aa11\") -- 注释 setManifestid(11, \"1\")\n",
+		},
+		{
+			// 块注释中的行不改写，缺少的行照常追加
+			name:      "block comment",
+			in:        "--[[\nsetManifestid(11, \"1\")\n]]\n",
+			manifests: map[string]string{"11": "22"},
+			want:      "--[[\nsetManifestid(11, \"1\")\n]]\nsetManifestid(11, \"22\", 0)\n",
+		},
+		{
+			name:      "crlf",
+			in:        "addappid(10)\r\nsetManifestid(11, \"1\")\r\n",
+			manifests: map[string]string{"11": "22", "12": "33"},
+			want:      "addappid(10)\r\nsetManifestid(11, \"22\", 0)\r\nsetManifestid(12, \"33\", 0)\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "10.lua")
+			if err := os.WriteFile(path, []byte(tt.in), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			rn := newRun()
+			changed, err := rn.patchLuaFile(path, tt.manifests, tt.keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := os.ReadFile(path)
+			if string(got) != tt.want || changed != (tt.want != tt.in) {
+				t.Errorf("patched (changed %v) =\n%q\nwant\n%q", changed, got, tt.want)
+			}
+			bak, err := os.ReadFile(path + ".bak")
+			if changed && string(bak) != tt.in {
+				t.Errorf(".lua.bak = %q, want the original", bak)
+			}
+			if !changed && !os.IsNotExist(err) {
+				t.Errorf("unchanged file got a backup: %v", err)
+			}
+			// 幂等：再次执行不再修改
+			if again, err := rn.patchLuaFile(path, tt.manifests, tt.keys); again || err != nil {
+				after, _ := os.ReadFile(path)
+				t.Errorf("second patch changed = %v (%v):\n%q", again, err, after)
+			}
+		})
+	}
+}
+
+func TestDownloadedManifests(t *testing.T) {
+	files := []FileInfo{{Name: "11_22.manifest"}, {Name: "10/12_33.manifest"}, {Name: "x_1.manifest"}, {Name: "13.manifest"}}
+	if got, want := downloadedManifests(files), map[string]string{"11": "22", "12": "33"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("downloadedManifests = %v, want %v", got, want)
+	}
+}
+
+func TestRunPatchLua(t *testing.T) {
+	lua := "addappid(10)\naddappid(11)\nsetManifestid(11, \"1\", 0)\n"
+	r := newTestRepo(t, map[string]string{
+		"a/b/10/10.lua":         lua,
+		"a/b/10/11_22.manifest": testManifest,
+		"a/b/10/key.vdf":        "\"depots\" { \"11\" { \"DecryptionKey\" \"aa11\" } }",
+	})
+	cfg := testConfig(t, map[string][]string{"10": {"11_22"}})
+	cfg.PatchLua = true
+	res := r.download(t, cfg)
+	if len(res.Results) != 1 || !res.Results[0].LuaPatched {
+		t.Fatalf("results = %+v, want lua_patched", res.Results)
+	}
+	got, _ := os.ReadFile(filepath.Join(cfg.LuaDir, "10.lua"))
+	if want := "addappid(10)\naddappid(11, 1, \"aa11\")\nsetManifestid(11, \"22\", 0)\n"; string(got) != want {
+		t.Errorf("10.lua =\n%s\nwant\n%s", got, want)
+	}
+	if bak, _ := os.ReadFile(filepath.Join(cfg.LuaDir, "10.lua.bak")); string(bak) != lua {
+		t.Errorf("10.lua.bak = %q, want the downloaded original", bak)
+	}
+}
@@ -0,0 +1,232 @@
+package downloader
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Steam 清单 (ContentManifest) 的分段魔数：每段为 魔数 (uint32 LE) + 长度 (uint32 LE) + protobuf 数据
+const (
+	MANIFEST_PAYLOAD_MAGIC   = 0x71F617D0
+	MANIFEST_METADATA_MAGIC  = 0x1F4812BE
+	MANIFEST_SIGNATURE_MAGIC = 0x1B81B817
+	MANIFEST_END_MAGIC       = 0x32C415AB
+)
+
+// manifestMeta 是从清单中读取的、校验密钥所需的最少信息
+type manifestMeta struct {
+	DepotID    string
+	Encrypted  bool   // filenames_encrypted：文件名经 depot 密钥加密 (base64)
+	SampleName string // 第一个文件映射的文件名，加密时为密文
+	DiskBytes  uint64 // cb_disk_original：depot 全部文件解压后的大小
+}
+
+// readManifestMeta 解析本地清单文件，支持原始格式与 CDN 的 zip 压缩格式
+func (rn *run) readManifestMeta(p string) (*manifestMeta, error) {
+	data, err := rn.readManifestData(p)
+	if err != nil {
+		return nil, err
+	}
+	return parseManifestMeta(data)
+}
+
+// readManifestData 读取本地清单文件，CDN 的 zip 压缩格式解压后返回
+func (rn *run) readManifestData(p string) ([]byte, error) {
+	data, err := rn.fsys.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return data, nil
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	if len(zr.File) == 0 {
+		return nil, errors.New("zip 清单为空")
+	}
+	rc, err := zr.File[0].Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// manifestSections 依次以魔数与数据回调清单的每个分段，遇到结束魔数或数据末尾时停止
+func manifestSections(data []byte, fn func(magic uint32, section []byte) error) error {
+	for len(data) >= 4 {
+		magic := binary.LittleEndian.Uint32(data)
+		if magic == MANIFEST_END_MAGIC {
+			break
+		}
+		if len(data) < 8 {
+			return errors.New("清单分段头被截断")
+		}
+		n := int(binary.LittleEndian.Uint32(data[4:]))
+		if n < 0 || 8+n > len(data) {
+			return fmt.Errorf("清单分段 %08x 长度 %d 超出文件", magic, n)
+		}
+		section := data[8 : 8+n]
+		data = data[8+n:]
+		switch magic {
+		case MANIFEST_PAYLOAD_MAGIC, MANIFEST_METADATA_MAGIC, MANIFEST_SIGNATURE_MAGIC:
+			if err := fn(magic, section); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("未知清单分段 %08x", magic)
+		}
+	}
+	return nil
+}
+
+func parseManifestMeta(data []byte) (*manifestMeta, error) {
+	meta := &manifestMeta{}
+	gotMeta := false
+	err := manifestSections(data, func(magic uint32, section []byte) error {
+		switch magic {
+		case MANIFEST_PAYLOAD_MAGIC:
+			// ContentManifestPayload.mappings (1) -> FileMapping.filename (1)
+			return pbFields(section, func(num, wire int, v uint64, b []byte) bool {
+				if num != 1 || wire != 2 || meta.SampleName != "" {
+					return true
+				}
+				pbFields(b, func(num, wire int, v uint64, s []byte) bool {
+					if num == 1 && wire == 2 {
+						meta.SampleName = string(s)
+						return false
+					}
+					return true
+				})
+				return meta.SampleName == ""
+			})
+		case MANIFEST_METADATA_MAGIC:
+			// ContentManifestMetadata.depot_id (1)、filenames_encrypted (4)、cb_disk_original (5)
+			gotMeta = true
+			return pbFields(section, func(num, wire int, v uint64, b []byte) bool {
+				switch {
+				case num == 1 && wire == 0:
+					meta.DepotID = strconv.FormatUint(v, 10)
+				case num == 4 && wire == 0:
+					meta.Encrypted = v != 0
+				case num == 5 && wire == 0:
+					meta.DiskBytes = v
+				}
+				return true
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !gotMeta {
+		return nil, errors.New("清单中没有 metadata 分段")
+	}
+	return meta, nil
+}
+
+// manifestChunks 对清单中每个文件的每个 chunk 回调其 SHA-1 与解压后大小
+// (FileMapping.chunks (6) -> ChunkData.sha (1)、cb_original (4))。
+// 同一 chunk 被多个文件引用时会回调多次。
+func manifestChunks(data []byte, fn func(sha []byte, size uint64)) error {
+	gotPayload := false
+	err := manifestSections(data, func(magic uint32, section []byte) error {
+		if magic != MANIFEST_PAYLOAD_MAGIC {
+			return nil
+		}
+		gotPayload = true
+		var perr error
+		err := pbFields(section, func(num, wire int, v uint64, mapping []byte) bool {
+			if num != 1 || wire != 2 {
+				return true
+			}
+			err := pbFields(mapping, func(num, wire int, v uint64, chunk []byte) bool {
+				if num != 6 || wire != 2 {
+					return true
+				}
+				var sha []byte
+				var size uint64
+				if err := pbFields(chunk, func(num, wire int, v uint64, b []byte) bool {
+					switch {
+					case num == 1 && wire == 2:
+						sha = b
+					case num == 4 && wire == 0:
+						size = v
+					}
+					return true
+				}); err != nil {
+					perr = err
+					return false
+				}
+				if len(sha) > 0 {
+					fn(sha, size)
+				}
+				return true
+			})
+			if err != nil {
+				perr = err
+			}
+			return perr == nil
+		})
+		if err != nil {
+			return err
+		}
+		return perr
+	})
+	if err == nil && !gotPayload {
+		err = errors.New("清单中没有 payload 分段")
+	}
+	return err
+}
+
+// pbFields 遍历 protobuf 数据的顶层字段：varint 以 v 传入，length-delimited 以 b 传入；fn 返回 false 时停止
+func pbFields(data []byte, fn func(num, wire int, v uint64, b []byte) bool) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errors.New("protobuf 字段头无效")
+		}
+		data = data[n:]
+		num, wire := int(key>>3), int(key&7)
+		var v uint64
+		var b []byte
+		switch wire {
+		case 0:
+			v, n = binary.Uvarint(data)
+			if n <= 0 {
+				return errors.New("protobuf varint 无效")
+			}
+			data = data[n:]
+		case 1:
+			if len(data) < 8 {
+				return errors.New("protobuf fixed64 被截断")
+			}
+			v, data = binary.LittleEndian.Uint64(data), data[8:]
+		case 2:
+			l, n := binary.Uvarint(data)
+			if n <= 0 || l > uint64(len(data)-n) {
+				return errors.New("protobuf 长度字段无效")
+			}
+			b, data = data[n:n+int(l)], data[n+int(l):]
+		case 5:
+			if len(data) < 4 {
+				return errors.New("protobuf fixed32 被截断")
+			}
+			v, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		default:
+			return fmt.Errorf("不支持的 protobuf wire type %d", wire)
+		}
+		if !fn(num, wire, v, b) {
+			return nil
+		}
+	}
+	return nil
+}
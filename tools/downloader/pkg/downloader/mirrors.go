@@ -0,0 +1,201 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+// RAW_BASE 是 GitHub 原始文件直连地址
+const RAW_BASE = "https://raw.githubusercontent.com"
+
+// MIRROR_DEMOTE_THRESHOLD: 连续失败达到该次数的源被降级到队尾
+const MIRROR_DEMOTE_THRESHOLD = 5
+
+// source 是一个下载源 (直连或镜像/CDN)
+type source struct {
+	base     string
+	template bool  // base 为含三个 %s (repo, branch, path) 的 URL 模板
+	jsdelivr bool  // jsDelivr 使用 user/repo@branch/path 格式
+	fails    int64 // 连续失败次数 (成功后清零)
+	served   int64 // 成功提供的文件数
+}
+
+// fileURL 按源的格式拼接文件地址
+func (s *source) fileURL(repo, branch, path string) string {
+	if s.template {
+		return fmt.Sprintf(s.base, repo, branch, path)
+	}
+	if s.jsdelivr {
+		return fmt.Sprintf("%s/%s@%s/%s", s.base, repo, branch, path)
+	}
+	return fmt.Sprintf("%s/%s/%s/%s", s.base, repo, branch, path)
+}
+
+// sourceSet 按优先级保存全部下载源，直连始终排在首位
+type sourceSet struct {
+	rn      *run
+	sources []*source
+}
+
+// MIRROR_TEMPLATE_ARGS 是镜像模板中 %s 的个数 (repo, branch, path)
+const MIRROR_TEMPLATE_ARGS = 3
+
+// validateMirrors 检查 mirrors 中的模板：含 %s 的地址必须恰好有三个 %s 且没有其它格式动词，
+// 否则每个请求都会拼出 %!s(MISSING) 之类的地址并静默失败
+func validateMirrors(mirrors []string) error {
+	for _, m := range mirrors {
+		m = strings.TrimSpace(m)
+		if !strings.Contains(m, "%s") {
+			continue
+		}
+		if n := strings.Count(m, "%s"); n != MIRROR_TEMPLATE_ARGS {
+			return fmt.Errorf("%q 必须恰好包含 %d 个 %%s (repo、branch、path)，实际 %d 个", m, MIRROR_TEMPLATE_ARGS, n)
+		}
+		if strings.Contains(fmt.Sprintf(m, "r", "b", "p"), "%!") {
+			return fmt.Errorf("%q 含有 %%s 以外的格式占位符 (字面的 %% 需写成 %%%%)", m)
+		}
+	}
+	return nil
+}
+
+func (rn *run) newSourceSet(mirrors []string) *sourceSet {
+	set := &sourceSet{rn: rn, sources: []*source{{base: rn.rawBase}}}
+	for _, m := range mirrors {
+		m = strings.TrimRight(strings.TrimSpace(m), "/")
+		if m == "" || m == rn.rawBase {
+			continue
+		}
+		if strings.Contains(m, "%s") {
+			// 模板形式，例如 "https://cdn.jsdelivr.net/gh/%s@%s/%s"
+			set.sources = append(set.sources, &source{base: m, template: true})
+			continue
+		}
+		set.sources = append(set.sources, &source{base: m, jsdelivr: strings.Contains(m, "jsdelivr")})
+	}
+	return set
+}
+
+// sourceChoice 记录某个源在本次尝试顺序中的位置及原因，供调试输出
+type sourceChoice struct {
+	src    *source
+	reason string
+}
+
+// ordered 返回本次请求的尝试顺序：健康源保持配置顺序，连续失败过多的源排在最后，source_budgets 用完的源不再出现
+func (set *sourceSet) ordered() []sourceChoice {
+	healthy := make([]sourceChoice, 0, len(set.sources))
+	var demoted []sourceChoice
+	for i, s := range set.sources {
+		if set.rn.budgetFor(s.base).spent() {
+			continue
+		}
+		fails := atomic.LoadInt64(&s.fails)
+		if fails >= MIRROR_DEMOTE_THRESHOLD {
+			demoted = append(demoted, sourceChoice{s, fmt.Sprintf("连续失败 %d 次，已降级到队尾", fails)})
+		} else if i == 0 {
+			healthy = append(healthy, sourceChoice{s, "直连，配置顺序第 1 位"})
+		} else {
+			healthy = append(healthy, sourceChoice{s, fmt.Sprintf("镜像，配置顺序第 %d 位 (连续失败 %d 次)", i+1, fails)})
+		}
+	}
+	return append(healthy, demoted...)
+}
+
+// busiest 返回提供文件最多的源，没有任何成功时返回空串
+func (set *sourceSet) busiest() string {
+	best, bestCount := "", int64(0)
+	for _, s := range set.sources {
+		if n := atomic.LoadInt64(&s.served); n > bestCount {
+			best, bestCount = s.base, n
+		}
+	}
+	return best
+}
+
+// isSourceFailure 判断错误是否应归咎于下载源本身 (网络错误、5xx、被拦截的 403 或限流 429)，此时换下一个源
+func isSourceFailure(err error) bool {
+	var de *diskError
+	var fe *filteredError
+	if errors.Is(err, errNotModified) || errors.As(err, &de) || errors.As(err, &fe) {
+		return false
+	}
+	code := statusCode(err)
+	return code == 0 || code >= 500 || code == 403 || code == 429
+}
+
+// isGitHubURL 判断地址是否属于 GitHub (或 Client 指定的直连/API 地址)，Token 只发送给 GitHub 而不泄露给第三方镜像
+func (rn *run) isGitHubURL(rawURL string) bool {
+	if strings.HasPrefix(rawURL, rn.rawBase+"/") || strings.HasPrefix(rawURL, rn.apiBase+"/") {
+		return true
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	return host == "raw.githubusercontent.com" || host == "api.github.com" || host == "github.com"
+}
+
+// fetchFile 依次从各下载源获取 repo/branch/path，返回下载信息 (含实际使用的地址)
+func (rn *run) fetchFile(ctx context.Context, repo, branch, path, destPath, token, etag string) (download, error) {
+	if token != "" && rn.contentsAPI.preferred(repo) {
+		return rn.fetchContentsAPI(ctx, repo, branch, path, destPath, token, etag)
+	}
+	d, err := rn.fetchFromSources(ctx, repo, branch, path, destPath, token, etag)
+	if err == nil {
+		rn.contentsAPI.rawWorks(repo)
+		return d, nil
+	}
+	if code := statusCode(err); token == "" || (code != 404 && code != 403) || !rn.contentsAPI.fallback(repo) || ctx.Err() != nil {
+		return d, err
+	}
+	// 私有仓库的 raw 地址对部分 Token 返回 404/403，而 API 可以正常访问
+	ad, aerr := rn.fetchContentsAPI(ctx, repo, branch, path, destPath, token, etag)
+	if aerr != nil {
+		return d, err
+	}
+	if rn.contentsAPI.apiWorks(repo) {
+		rn.infof("仓库 %s 的 raw 地址不可用，改用 contents API 下载", repo)
+	}
+	return ad, nil
+}
+
+// fetchFromSources 依次尝试各下载源
+func (rn *run) fetchFromSources(ctx context.Context, repo, branch, path, destPath, token, etag string) (download, error) {
+	var lastErr error
+	for _, c := range rn.sources.ordered() {
+		s := c.src
+		fileURL := s.fileURL(repo, branch, path)
+		rn.debugf("%s/%s 选择源 %s: %s", branch, path, s.base, c.reason)
+		if rn.probeWithHead && etag == "" && isManifestPath(destPath) && rn.headMissing(ctx, s.base, fileURL, token) {
+			// 与 GET 返回 404 相同：文件不存在于该路径，不再尝试其它源
+			return download{URL: fileURL}, &statusError{code: http.StatusNotFound}
+		}
+		d, err := rn.downloadFileWithRetry(ctx, fileURL, destPath, token, etag)
+		if err == nil {
+			d.Repo = repo
+			atomic.StoreInt64(&s.fails, 0)
+			atomic.AddInt64(&s.served, 1)
+			return d, nil
+		}
+		if ctx.Err() != nil {
+			return download{URL: fileURL}, ctx.Err()
+		}
+		if !isSourceFailure(err) {
+			rn.debugf("%s/%s 源 %s 返回 %v，文件不存在于该路径，不再尝试其它源", branch, path, s.base, err)
+			return download{URL: fileURL}, err
+		}
+		atomic.AddInt64(&s.fails, 1)
+		rn.debugf("%s/%s 源 %s 失败 (%v)，切换下一个源", branch, path, s.base, err)
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = &budgetError{}
+	}
+	return download{}, lastErr
+}
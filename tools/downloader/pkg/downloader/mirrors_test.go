@@ -0,0 +1,131 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestSourceFileURL(t *testing.T) {
+	rn := newRun()
+	rn.rawBase = "https://raw.test"
+	set := rn.newSourceSet([]string{
+		"https://cdn.jsdelivr.net/gh/",
+		"https://mirror.test/raw/%s/%s/%s",
+		" https://raw.test ", // 与直连相同，忽略
+		"",
+		"https://ghproxy.test/",
+	})
+	want := []string{
+		"https://raw.test/a/b/10/11_22.manifest",
+		"https://cdn.jsdelivr.net/gh/a/b@10/11_22.manifest",
+		"https://mirror.test/raw/a/b/10/11_22.manifest",
+		"https://ghproxy.test/a/b/10/11_22.manifest",
+	}
+	if len(set.sources) != len(want) {
+		t.Fatalf("sources = %d, want %d", len(set.sources), len(want))
+	}
+	for i, s := range set.sources {
+		if got := s.fileURL("a/b", "10", "11_22.manifest"); got != want[i] {
+			t.Errorf("source %d url = %q, want %q", i, got, want[i])
+		}
+	}
+}
+
+func TestValidateMirrors(t *testing.T) {
+	tests := []struct {
+		mirror string
+		ok     bool
+	}{
+		{"https://cdn.jsdelivr.net/gh", true},
+		{"https://cdn.jsdelivr.net/gh/%s@%s/%s", true},
+		{"https://mirror.test/%s/%s/%s?x=100%%", true},
+		{"https://mirror.test/%s/%s", false},
+		{"https://mirror.test/%s/%s/%s/%s", false},
+		{"https://mirror.test/%s/%s/%s?n=%d", false},
+		{"https://mirror.test/%s/%s/%s?x=100%", false},
+	}
+	for _, tt := range tests {
+		if err := validateMirrors([]string{tt.mirror}); (err == nil) != tt.ok {
+			t.Errorf("validateMirrors(%q) = %v, want ok %v", tt.mirror, err, tt.ok)
+		}
+	}
+}
+
+func TestSourceOrderDemotesFailing(t *testing.T) {
+	rn := newRun()
+	rn.rawBase = "https://raw.test"
+	set := rn.newSourceSet([]string{"https://m1.test", "https://m2.test"})
+	set.sources[0].fails = MIRROR_DEMOTE_THRESHOLD
+	set.sources[1].fails = MIRROR_DEMOTE_THRESHOLD - 1
+	var got []string
+	for _, c := range set.ordered() {
+		got = append(got, c.src.base)
+	}
+	// 连续失败达到阈值的直连排到最后，其余保持配置顺序
+	if want := []string{"https://m1.test", "https://m2.test", "https://raw.test"}; !slices.Equal(got, want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+}
+
+func TestMirrorFallback(t *testing.T) {
+	const page = "a/b/10/11_22.manifest"
+	tests := []struct {
+		name       string
+		primary    int // 直连对清单返回的状态
+		m1         int // 第一个镜像的状态，0 表示正常提供文件
+		wantM1     bool
+		wantM2     bool
+		wantResult bool
+	}{
+		{"primary 503, first mirror serves", http.StatusServiceUnavailable, 0, true, false, true},
+		{"primary and first mirror 503", http.StatusServiceUnavailable, http.StatusBadGateway, true, true, true},
+		{"primary 429", http.StatusTooManyRequests, 0, true, false, true},
+		// 404 表示文件不存在，不是源的问题，不再尝试镜像
+		{"primary 404", http.StatusNotFound, 0, false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := newTestRepo(t, map[string]string{"a/b/10/10.lua": "-- 10"})
+			primary.handle(page, func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(tt.primary)
+			})
+			// 第一个镜像为 jsDelivr 风格模板，第二个为 raw 风格基础地址
+			m1 := newTestRepo(t, map[string]string{"gh/a/b@10/11_22.manifest": testManifest})
+			if tt.m1 != 0 {
+				m1.handle("gh/a/b@10/11_22.manifest", func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(tt.m1) })
+			}
+			m2 := newTestRepo(t, map[string]string{page: testManifest})
+			cfg := testConfig(t, map[string][]string{"10": {"11_22"}})
+			cfg.Mirrors = []string{m1.srv.URL + "/gh/%s@%s/%s", m2.srv.URL}
+			res := primary.download(t, cfg)
+
+			if got := m1.count("gh/a/b@10/11_22.manifest") > 0; got != tt.wantM1 {
+				t.Errorf("first mirror used = %v, want %v", got, tt.wantM1)
+			}
+			if got := m2.count(page) > 0; got != tt.wantM2 {
+				t.Errorf("second mirror used = %v, want %v", got, tt.wantM2)
+			}
+			if got := res.Summary.Manifest == 1; got != tt.wantResult {
+				t.Fatalf("manifest downloaded = %v, want %v", got, tt.wantResult)
+			}
+			if primary.count(page) == 0 {
+				t.Error("primary not tried first")
+			}
+		})
+	}
+}
+
+func TestRunRejectsBadMirrorTemplate(t *testing.T) {
+	r := newTestRepo(t, nil)
+	cfg := testConfig(t, map[string][]string{"10": nil})
+	cfg.Mirrors = []string{"https://cdn.jsdelivr.net/gh/%s@%s"}
+	_, err := r.client().Run(context.Background(), cfg)
+	var ce *ConfigError
+	if !errors.As(err, &ce) || ce.Code != CODE_INVALID_VALUE || ce.Field != "mirrors" {
+		t.Errorf("err = %v, want invalid mirrors", err)
+	}
+}
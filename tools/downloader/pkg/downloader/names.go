@@ -0,0 +1,88 @@
+package downloader
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// windowsReservedNames 是 Windows 上不能用作文件名的设备名 (不区分大小写，带扩展名同样保留)
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// invalidNameError 表示由 app_data/仓库内容得到的本地文件名不安全 (路径穿越、NTFS 非法字符、设备名)
+type invalidNameError struct {
+	name   string
+	reason string
+}
+
+func (e *invalidNameError) Error() string {
+	return fmt.Sprintf("文件名 %q 无效: %s", e.name, e.reason)
+}
+
+// checkLocalName 校验单个本地文件名：不含路径分隔符与 ".."，不含 Windows 非法字符，
+// 不是保留设备名，且不以点或空格结尾 (NTFS 会静默去掉)
+func checkLocalName(name string) error {
+	switch {
+	case name == "" || name == "." || name == "..":
+		return &invalidNameError{name, "空文件名或目录名"}
+	case strings.ContainsAny(name, `/\`):
+		return &invalidNameError{name, "包含路径分隔符"}
+	case strings.ContainsAny(name, `<>:"|?*`):
+		return &invalidNameError{name, "包含 Windows 不允许的字符"}
+	case strings.HasSuffix(name, ".") || strings.HasSuffix(name, " "):
+		return &invalidNameError{name, "以点或空格结尾"}
+	}
+	for _, c := range name {
+		if c < 0x20 {
+			return &invalidNameError{name, "包含控制字符"}
+		}
+	}
+	stem := name
+	if i := strings.IndexByte(stem, '.'); i >= 0 {
+		stem = stem[:i]
+	}
+	if windowsReservedNames[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		return &invalidNameError{name, "是 Windows 保留设备名"}
+	}
+	return nil
+}
+
+// safeJoin 校验 name 后拼接到 dir 下，并确认解析后的绝对路径仍在 dir 之内
+func safeJoin(dir, name string) (string, error) {
+	if err := checkLocalName(name); err != nil {
+		return "", err
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	p := filepath.Join(absDir, name)
+	rel, err := filepath.Rel(absDir, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return "", &invalidNameError{name, "超出目标目录"}
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// archiveEntryName 校验仓库内的文件路径 (tar 条目、plan 的 path) 并返回扁平化后的文件名。
+// GitHub 的分支包以 "owner-repo-sha/" 为顶层目录，子目录中的文件同样按文件名落盘。
+func archiveEntryName(name string) (string, error) {
+	if strings.Contains(name, "\\") || path.IsAbs(name) {
+		return "", fmt.Errorf("非法路径")
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("非法路径 (路径穿越)")
+		}
+	}
+	base := path.Base(path.Clean(name))
+	if base == "." || base == "/" || base == "" {
+		return "", fmt.Errorf("空文件名")
+	}
+	return base, nil
+}
@@ -0,0 +1,115 @@
+package downloader
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSafeJoin(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name   string
+		reason string // 为空表示应通过
+	}{
+		{"10.lua", ""},
+		{"11_22.manifest", ""},
+		{"Half Life.lua", ""},
+		{"con.txt.bak", "保留设备名"},
+		{"..", "空文件名"},
+		{".", "空文件名"},
+		{"", "空文件名"},
+		{"../10.lua", "路径分隔符"},
+		{"..\\10.lua", "路径分隔符"},
+		{"a/../../10.lua", "路径分隔符"},
+		{"/etc/passwd", "路径分隔符"},
+		{`C:\Windows\10.lua`, "路径分隔符"},
+		{"C:10.lua", "Windows 不允许的字符"},
+		{"10\x00.lua", "控制字符"},
+		{"10\n.lua", "控制字符"},
+		{`a"b.lua`, "Windows 不允许的字符"},
+		{"a<b>.lua", "Windows 不允许的字符"},
+		{"a|b?.lua", "Windows 不允许的字符"},
+		{"*.manifest", "Windows 不允许的字符"},
+		{"CON", "保留设备名"},
+		{"con.manifest", "保留设备名"},
+		{"NUL.lua", "保留设备名"},
+		{"COM1.manifest", "保留设备名"},
+		{"lpt9", "保留设备名"},
+		{"AUX .lua", "保留设备名"},
+		{"COM10.manifest", ""},
+		{"CONSOLE.lua", ""},
+		{"10.lua.", "以点或空格结尾"},
+		{"10.lua ", "以点或空格结尾"},
+	}
+	for _, tt := range tests {
+		got, err := safeJoin(dir, tt.name)
+		if tt.reason == "" {
+			if err != nil || got != filepath.Join(dir, tt.name) {
+				t.Errorf("safeJoin(%q) = %q, %v; want %q", tt.name, got, err, filepath.Join(dir, tt.name))
+			}
+			continue
+		}
+		var ne *invalidNameError
+		if !errors.As(err, &ne) || !strings.Contains(ne.reason, tt.reason) {
+			t.Errorf("safeJoin(%q) = %q, %v; want invalid name (%s)", tt.name, got, err, tt.reason)
+			continue
+		}
+		if classifyError(err) != KIND_INVALID_NAME {
+			t.Errorf("classifyError(safeJoin(%q)) = %q, want %q", tt.name, classifyError(err), KIND_INVALID_NAME)
+		}
+	}
+}
+
+func TestSafeJoinRelativeDir(t *testing.T) {
+	// 相对目录同样按解析后的绝对路径检查，返回值保持相对形式
+	got, err := safeJoin(filepath.Join("out", "depotcache"), "11_22.manifest")
+	if err != nil || got != filepath.Join("out", "depotcache", "11_22.manifest") {
+		t.Errorf("safeJoin = %q, %v", got, err)
+	}
+}
+
+func TestArchiveEntryName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string // 为空表示应拒绝
+	}{
+		{"owner-repo-sha/10.lua", "10.lua"},
+		{"owner-repo-sha/sub/11_22.manifest", "11_22.manifest"},
+		{"10.lua", "10.lua"},
+		{"owner-repo-sha/../../10.lua", ""},
+		{"../10.lua", ""},
+		{"/etc/passwd", ""},
+		{`owner-repo-sha\10.lua`, ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got, err := archiveEntryName(tt.name)
+		if (err != nil) != (tt.want == "") || got != tt.want {
+			t.Errorf("archiveEntryName(%q) = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestUnsafeManifestNameFailsItem(t *testing.T) {
+	r := newTestRepo(t, map[string]string{"a/b/10/10.lua": "-- 10", "a/b/10/11_22.manifest": testManifest})
+	cfg := testConfig(t, map[string][]string{"10": {"11_22", "a|b", "x:y"}})
+	res := r.download(t, cfg)
+	// 不安全的条目单独失败，不影响同一 App 的其他文件
+	if res.Summary.Manifest != 1 || len(res.Results) != 1 {
+		t.Fatalf("summary = %+v", res.Summary)
+	}
+	failed := strings.Join(res.Results[0].FailedManifests, ",")
+	if !strings.Contains(failed, "a|b") || !strings.Contains(failed, "x:y") {
+		t.Errorf("failed manifests = %q, want a|b and x:y", failed)
+	}
+	for path := range r.hits {
+		if strings.ContainsAny(path, "|:") {
+			t.Errorf("unsafe item was requested: %s", path)
+		}
+	}
+	if got := listFiles(t, cfg.ManifestDir); len(got) != 1 {
+		t.Errorf("manifest dir = %v, want only 11_22.manifest", got)
+	}
+}
@@ -0,0 +1,190 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ROUTE_CHECK_TIMEOUT 是运行前检查到直连源的 IPv4 / IPv6 路由的总时限 (含 DNS 解析)
+	ROUTE_CHECK_TIMEOUT = 2 * time.Second
+	// ROUTE_CHECK_ADDRS 是每个地址族最多尝试连接的地址数
+	ROUTE_CHECK_ADDRS = 2
+)
+
+// RouteStatus 是一个地址族到直连源 (raw.githubusercontent.com) 的 TCP 连通性
+type RouteStatus struct {
+	Family string   `json:"family"`          // ipv4 | ipv6
+	Addrs  []string `json:"addrs,omitempty"` // 尝试连接的地址
+	OK     bool     `json:"ok"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// routedDial 包装拨号函数：目标在 routeNetworks 中时只用可用的地址族连接，
+// 避免每个新连接都先等待不通的地址族超时
+func (rn *run) routedDial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if network == "tcp" {
+			rn.routeNetworks.RLock()
+			if n, ok := rn.routeNetworks.m[addr]; ok {
+				network = n
+			}
+			rn.routeNetworks.RUnlock()
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// usingProxy 判断访问 rawURL 是否经过代理 (proxy 配置或环境变量)；经过代理时本机路由与下载无关
+func (rn *run) usingProxy(rawURL string) bool {
+	if rn.proxyURL != nil {
+		return true
+	}
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return false
+	}
+	p, err := http.ProxyFromEnvironment(req)
+	return err == nil && p != nil
+}
+
+// probeRoutes 解析 rawURL 的主机并分别用 IPv4 与 IPv6 建立 TCP 连接 (不发送请求)，返回 "host:port" 与两个地址族的结果。
+// DNS 解析失败时返回 error
+func probeRoutes(ctx context.Context, rawURL string) (string, []RouteStatus, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", nil, err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	hostPort := net.JoinHostPort(u.Hostname(), port)
+
+	ctx, cancel := context.WithTimeout(ctx, ROUTE_CHECK_TIMEOUT)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return hostPort, nil, err
+	}
+	statuses := []RouteStatus{{Family: "ipv4"}, {Family: "ipv6"}}
+	for _, ip := range ips {
+		i := 0
+		if ip.IP.To4() == nil {
+			i = 1
+		}
+		if len(statuses[i].Addrs) < ROUTE_CHECK_ADDRS {
+			statuses[i].Addrs = append(statuses[i].Addrs, net.JoinHostPort(ip.String(), port))
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i, network := range []string{"tcp4", "tcp6"} {
+		s := &statuses[i]
+		if len(s.Addrs) == 0 {
+			s.Error = "DNS 没有该地址族的记录"
+			continue
+		}
+		wg.Add(1)
+		go func(network string) {
+			defer wg.Done()
+			var dialer net.Dialer
+			for _, addr := range s.Addrs {
+				conn, err := dialer.DialContext(ctx, network, addr)
+				if err == nil {
+					conn.Close()
+					s.OK, s.Error = true, ""
+					return
+				}
+				s.Error = err.Error()
+			}
+		}(network)
+	}
+	wg.Wait()
+	return hostPort, statuses, nil
+}
+
+// checkRoute 在下载前检查到直连源的 IPv4 / IPv6 路由。只有一个地址族可用时限定拨号使用该地址族；
+// 都不可用时，没有配置 mirrors 则以 no_route_to_github 终止运行 (代替之后逐个文件的超时)，否则只警告。
+// 使用代理时不检查。返回写入结果的路由状态 (没有不通的地址族时为 nil) 与警告
+func (rn *run) checkRoute(ctx context.Context, config Config) ([]RouteStatus, []string) {
+	if rn.usingProxy(rn.rawBase) {
+		return nil, nil
+	}
+	start := time.Now()
+	hostPort, statuses, err := probeRoutes(ctx, rn.rawBase)
+	if ctx.Err() != nil {
+		return nil, nil
+	}
+	var working []string
+	var attempted []string
+	for _, s := range statuses {
+		if s.OK {
+			working = append(working, s.Family)
+		}
+		attempted = append(attempted, s.Addrs...)
+	}
+	rn.debugf("路由检查 %s: 可用 %v (%s)", hostPort, working, time.Since(start).Round(time.Millisecond))
+
+	switch {
+	case err == nil && len(working) == 2:
+		return nil, nil
+	case err == nil && len(working) == 1:
+		var restricted []RouteStatus
+		for _, s := range statuses {
+			if !s.OK && len(s.Addrs) > 0 {
+				// 只有另一个地址族有记录但连不上时才需要限定；没有记录时系统本来就不会尝试
+				network := "tcp4"
+				if working[0] == "ipv6" {
+					network = "tcp6"
+				}
+				rn.routeNetworks.Lock()
+				rn.routeNetworks.m[hostPort] = network
+				rn.routeNetworks.Unlock()
+				rn.infof("%s 的 %s 不可用 (%s)，本次运行只使用 %s 连接", hostPort, s.Family, s.Error, working[0])
+				restricted = statuses
+			}
+		}
+		return restricted, nil
+	}
+
+	e := &noRouteError{host: hostPort, attempted: attempted, err: err}
+	if err == nil {
+		var reasons []string
+		for _, s := range statuses {
+			reasons = append(reasons, s.Family+": "+s.Error)
+		}
+		e.err = errors.New(strings.Join(reasons, "; "))
+	}
+	if len(config.Mirrors) > 0 {
+		msg := KIND_NO_ROUTE + ": " + e.Error() + "，仅使用 mirrors 下载"
+		rn.warnf("%s", msg)
+		return statuses, []string{msg}
+	}
+	rn.abortRun(&abortError{kind: KIND_NO_ROUTE, msg: e.Error()})
+	return statuses, nil
+}
+
+// noRouteError 表示 IPv4 与 IPv6 都无法连接到直连源 (或 DNS 解析失败)
+type noRouteError struct {
+	host      string
+	attempted []string
+	err       error
+}
+
+func (e *noRouteError) Error() string {
+	if len(e.attempted) == 0 {
+		return fmt.Sprintf("无法连接 %s: %v", e.host, e.err)
+	}
+	return fmt.Sprintf("没有到 %s 的 IPv4 或 IPv6 路由 (尝试了 %s): %v", e.host, strings.Join(e.attempted, ", "), e.err)
+}
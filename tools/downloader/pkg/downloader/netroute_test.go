@@ -0,0 +1,124 @@
+package downloader
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+// closedAddr 返回一个当前没有监听者的本地地址
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestProbeRoutes(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	tests := []struct {
+		name   string
+		addr   string
+		ipv4OK bool
+	}{
+		{"listening", ln.Addr().String(), true},
+		{"closed", closedAddr(t), false},
+	}
+	for _, tt := range tests {
+		hostPort, statuses, err := probeRoutes(context.Background(), "http://"+tt.addr)
+		if err != nil {
+			t.Fatalf("%s: probeRoutes: %v", tt.name, err)
+		}
+		if hostPort != tt.addr || len(statuses) != 2 {
+			t.Fatalf("%s: hostPort = %q, statuses = %+v", tt.name, hostPort, statuses)
+		}
+		v4, v6 := statuses[0], statuses[1]
+		if v4.Family != "ipv4" || v4.OK != tt.ipv4OK || len(v4.Addrs) != 1 || v4.Addrs[0] != tt.addr {
+			t.Errorf("%s: ipv4 = %+v, want ok %v", tt.name, v4, tt.ipv4OK)
+		}
+		if !tt.ipv4OK && v4.Error == "" {
+			t.Errorf("%s: ipv4 failure without error", tt.name)
+		}
+		// IPv4 字面量没有 AAAA 记录，IPv6 不会被尝试
+		if v6.Family != "ipv6" || v6.OK || len(v6.Addrs) != 0 || v6.Error == "" {
+			t.Errorf("%s: ipv6 = %+v", tt.name, v6)
+		}
+	}
+}
+
+func TestCheckRouteNoRoute(t *testing.T) {
+	addr := closedAddr(t)
+	tests := []struct {
+		name    string
+		mirrors []string
+		abort   bool
+	}{
+		{"direct only", nil, true},
+		{"with mirrors", []string{"https://mirror.example/%s/%s/%s"}, false},
+	}
+	for _, tt := range tests {
+		rn := newRun()
+		rn.rawBase = "http://" + addr
+		rn.consoleLevel = levelError
+		var aborted *abortError
+		rn.abortRun = func(err *abortError) { aborted = err }
+		statuses, warnings := rn.checkRoute(context.Background(), Config{Mirrors: tt.mirrors})
+		if len(statuses) != 2 || statuses[0].OK || statuses[1].OK {
+			t.Errorf("%s: statuses = %+v, want both families down", tt.name, statuses)
+		}
+		if got := aborted != nil; got != tt.abort {
+			t.Fatalf("%s: aborted = %v, want %v", tt.name, aborted, tt.abort)
+		}
+		if tt.abort {
+			if aborted.kind != KIND_NO_ROUTE || !strings.Contains(aborted.msg, addr) {
+				t.Errorf("%s: abort = %+v, want no_route_to_github naming %s", tt.name, aborted, addr)
+			}
+		} else if len(warnings) != 1 || !strings.HasPrefix(warnings[0], KIND_NO_ROUTE) {
+			t.Errorf("%s: warnings = %v, want a no_route_to_github warning", tt.name, warnings)
+		}
+	}
+}
+
+func TestCheckRouteWorking(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	rn := newRun()
+	rn.rawBase = "http://" + ln.Addr().String()
+	rn.abortRun = func(err *abortError) { t.Errorf("aborted: %+v", err) }
+	// 只有 IPv4 记录时不需要限定地址族，也不写入结果
+	if statuses, warnings := rn.checkRoute(context.Background(), Config{}); statuses != nil || warnings != nil {
+		t.Errorf("checkRoute = %+v, %v; want nil", statuses, warnings)
+	}
+	if len(rn.routeNetworks.m) != 0 {
+		t.Errorf("routeNetworks = %v, want empty", rn.routeNetworks.m)
+	}
+}
+
+func TestRoutedDial(t *testing.T) {
+	rn := newRun()
+	rn.routeNetworks.m["raw.example:443"] = "tcp6"
+	var got []string
+	dial := rn.routedDial(func(_ context.Context, network, addr string) (net.Conn, error) {
+		got = append(got, network+" "+addr)
+		return nil, nil
+	})
+	dial(context.Background(), "tcp", "raw.example:443")
+	dial(context.Background(), "tcp", "api.example:443")
+	dial(context.Background(), "udp", "raw.example:443")
+	want := []string{"tcp6 raw.example:443", "tcp api.example:443", "udp raw.example:443"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("dialed %v, want %v", got, want)
+	}
+}
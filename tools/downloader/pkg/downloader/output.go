@@ -0,0 +1,75 @@
+package downloader
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// OUTPUT_STDOUT 作为 output_path 时结果仍写到 stdout，但 [INFO]/[WARN]/[ERROR]/[PROGRESS] 改写到 stderr，stdout 只有 JSON
+const OUTPUT_STDOUT = "-"
+
+// writeOutputFile 把 write 写出的内容先写入同目录的临时文件再重命名为 path，读取方不会看到写了一半的结果
+func (rn *run) writeOutputFile(path string, write func(w io.Writer) error) error {
+	if err := rn.fsys.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return &diskError{err}
+	}
+	tmp := tempPath(path)
+	f, err := rn.fsys.Create(tmp)
+	if err != nil {
+		return &diskError{err}
+	}
+	err = write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		rn.fsys.Remove(tmp)
+		return &diskError{err}
+	}
+	if err := rn.renameTemp(tmp, path); err != nil {
+		rn.fsys.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// CompressedOutputPath 返回 compress_output (-compress-output) 使用的结果文件名：.json 换为 .json.gz，
+// 其它名称追加 .json.gz，已以 .gz 结尾时不变。空路径与 "-" (stdout，不压缩) 原样返回。
+// 结果是幂等的 (对已转换的路径再调用不变)，命令行与 prepare 各自调用不会叠加后缀
+func CompressedOutputPath(path string) string {
+	if path == "" || path == OUTPUT_STDOUT || strings.HasSuffix(strings.ToLower(path), ".gz") {
+		return path
+	}
+	return strings.TrimSuffix(path, ".json") + ".json.gz"
+}
+
+// writeResultFile 与 writeOutputFile 相同，但 path 以 .gz 结尾时以 gzip 压缩写入 (compress_output)
+func (rn *run) writeResultFile(path string, write func(w io.Writer) error) error {
+	if !strings.HasSuffix(strings.ToLower(path), ".gz") {
+		return rn.writeOutputFile(path, write)
+	}
+	return rn.writeOutputFile(path, func(w io.Writer) error {
+		zw := gzip.NewWriter(w)
+		if err := write(zw); err != nil {
+			return err
+		}
+		return zw.Close()
+	})
+}
+
+// WriteOutput 把一行 JSON 写到 output_path：为空或 "-" 时写 stdout，否则原子地写入文件 (.gz 结尾时压缩)。
+// 命令行用它输出无法开始运行时的错误结果，与正常结果走同一个去向
+func WriteOutput(path string, data []byte) error {
+	line := append(data, '\n')
+	if path == "" || path == OUTPUT_STDOUT {
+		_, err := os.Stdout.Write(line)
+		return err
+	}
+	return newRun().writeResultFile(path, func(w io.Writer) error {
+		_, err := w.Write(line)
+		return err
+	})
+}
@@ -0,0 +1,120 @@
+package downloader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompressedOutputPath(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{OUTPUT_STDOUT, OUTPUT_STDOUT},
+		{"result.json", "result.json.gz"},
+		{"result", "result.json.gz"},
+		{"result.txt", "result.txt.json.gz"},
+		{"result.json.gz", "result.json.gz"},
+		{"RESULT.GZ", "RESULT.GZ"},
+		{filepath.Join("out", "r.json"), filepath.Join("out", "r.json.gz")},
+	}
+	for _, tt := range tests {
+		got := CompressedOutputPath(tt.in)
+		if got != tt.want {
+			t.Errorf("CompressedOutputPath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		// 命令行与 prepare 都会调用，必须幂等
+		if again := CompressedOutputPath(got); again != got {
+			t.Errorf("CompressedOutputPath(%q) = %q, not idempotent", got, again)
+		}
+	}
+}
+
+func TestWriteOutputCompressesGzPath(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte(`{"success":true}`)
+	tests := []struct {
+		name string
+		gz   bool
+	}{
+		{"r.json", false},
+		{"r.json.gz", true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if err := WriteOutput(path, data); err != nil {
+			t.Fatalf("WriteOutput(%s): %v", tt.name, err)
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := bytes.HasPrefix(raw, gzipMagic); got != tt.gz {
+			t.Fatalf("%s: gzip = %v, want %v", tt.name, got, tt.gz)
+		}
+		if tt.gz {
+			zr, err := gzip.NewReader(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if raw, err = io.ReadAll(zr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if want := string(data) + "\n"; string(raw) != want {
+			t.Errorf("%s: content = %q, want %q", tt.name, raw, want)
+		}
+	}
+}
+
+func TestRunCompressOutput(t *testing.T) {
+	// compress_output 的结果解压后与不压缩时的结果相同
+	r := newTestRepo(t, map[string]string{
+		"a/b/10/10.lua":         "-- lua",
+		"a/b/10/11_22.manifest": testManifest,
+	})
+	var results []Result
+	for _, compress := range []bool{false, true} {
+		cfg := testConfig(t, map[string][]string{"10": {"11_22"}, "20": nil})
+		cfg.CompressOutput = compress
+		r.download(t, cfg)
+		path := cfg.OutputPath
+		if compress {
+			path = CompressedOutputPath(path)
+			if _, err := os.Stat(cfg.OutputPath); err == nil {
+				t.Errorf("uncompressed %s written with compress_output", cfg.OutputPath)
+			}
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := bytes.HasPrefix(raw, gzipMagic); got != compress {
+			t.Fatalf("compress %v: gzip = %v", compress, got)
+		}
+		if compress {
+			zr, err := gzip.NewReader(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if raw, err = io.ReadAll(zr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var res Result
+		if err := json.Unmarshal(raw, &res); err != nil {
+			t.Fatalf("compress %v: %v", compress, err)
+		}
+		results = append(results, res)
+	}
+	plain, gz := results[0], results[1]
+	if plain.Success != gz.Success || plain.Summary.Lua != gz.Summary.Lua || plain.Summary.Manifest != gz.Summary.Manifest ||
+		plain.TotalBytes != gz.TotalBytes || len(plain.Results) != len(gz.Results) || len(plain.Failed) != len(gz.Failed) {
+		t.Errorf("compressed result %+v differs from %+v", gz.Summary, plain.Summary)
+	}
+}
@@ -0,0 +1,94 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// checkStartupPaths 确认程序所在目录与工作目录能在文件系统中原样找到。
+// 在 GBK 等代码页的系统上，路径中含有 emoji 或生僻字时可能被转换坏，相对路径 (lua_dir、manifest_dir 等)
+// 随之落到错误的目录而没有任何报错；这里提前给出警告，并以码点列出有问题的路径段。
+func checkStartupPaths() []string {
+	var warnings []string
+	if exe, err := os.Executable(); err == nil {
+		if w := checkPathRoundTrip("程序目录", filepath.Dir(exe)); w != "" {
+			warnings = append(warnings, w)
+		}
+	}
+	if wd, err := os.Getwd(); err != nil {
+		warnings = append(warnings, "无法获取工作目录: "+err.Error())
+	} else if w := checkPathRoundTrip("工作目录", wd); w != "" {
+		warnings = append(warnings, w)
+	}
+	return warnings
+}
+
+// checkPathRoundTrip 逐级检查 p 的每个非 ASCII 路径段：读取父目录列表，确认其中存在字节完全相同的条目。
+// 全部通过时返回空串，否则返回描述第一个问题段的警告。
+func checkPathRoundTrip(label, p string) string {
+	p = filepath.Clean(p)
+	if !filepath.IsAbs(p) {
+		return ""
+	}
+	vol := filepath.VolumeName(p)
+	parent := vol + string(filepath.Separator)
+	for _, part := range strings.Split(strings.TrimPrefix(p[len(vol):], string(filepath.Separator)), string(filepath.Separator)) {
+		if part == "" {
+			continue
+		}
+		if !isASCII(part) && !dirHasEntry(parent, part) {
+			return fmt.Sprintf("%s %s 中的路径段 \"%s\" (%s) 无法在文件系统中原样找到，可能是控制台代码页无法表示的字符；"+
+				"相对路径可能写到错误的目录，建议把程序放到只含 ASCII 字符的路径下", label, escapePath(p), escapePath(part), codePoints(part))
+		}
+		parent = filepath.Join(parent, part)
+	}
+	return ""
+}
+
+// dirHasEntry 判断目录 dir 中是否有名字与 name 字节完全相同的条目；无法读取目录时按存在处理，不误报
+func dirHasEntry(dir, name string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return true
+	}
+	for _, e := range entries {
+		if e.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// codePoints 以 U+XXXX 列出 s 中的非 ASCII 字符，非法 UTF-8 字节显示为 \xNN
+func codePoints(s string) string {
+	var parts []string
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			parts = append(parts, fmt.Sprintf("\\x%02X", s[i]))
+		case r >= utf8.RuneSelf:
+			parts = append(parts, fmt.Sprintf("%U", r))
+		}
+		i += size
+	}
+	return strings.Join(parts, " ")
+}
+
+// escapePath 把路径中的非 ASCII 字符转为 \u 转义，保证在任何代码页的控制台上都能原样显示
+func escapePath(p string) string {
+	q := fmt.Sprintf("%+q", p)
+	return q[1 : len(q)-1]
+}
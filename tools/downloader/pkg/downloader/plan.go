@@ -0,0 +1,231 @@
+package downloader
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PlanEntry 是调用方预先解析好的一个文件 (plan)：直接下载，不做任何分支/候选名探测
+type PlanEntry struct {
+	AppID  string `json:"app_id"`           // 文件归属的 App
+	URL    string `json:"url,omitempty"`    // 完整下载地址，与 repo/branch/path 二选一
+	Repo   string `json:"repo,omitempty"`   // 默认使用 config.repo
+	Branch string `json:"branch,omitempty"` // 与 path 一起经下载源 (含镜像) 获取
+	Path   string `json:"path,omitempty"`
+	Name   string `json:"name"`              // 本地文件名 (.lua/.vdf/.st 写入 lua_dir，.manifest 写入 manifest_dir)
+	SHA256 string `json:"sha256,omitempty"`  // 可选，下载后校验
+	GitSHA string `json:"git_sha,omitempty"` // 可选，Git blob SHA-1 (来自仓库的 tree 列表)，没有 sha256 时校验
+	Size   int64  `json:"size,omitempty"`    // 可选，下载后校验
+}
+
+// normalizePlan 校验 plan 条目并按 App 分组；不在 app_ids 中的 App 追加到 app_ids 末尾并标记为只执行 plan
+func (rn *run) normalizePlan(config *Config) error {
+	inAppIDs := make(map[string]bool)
+	for _, id := range config.AppIDs {
+		inAppIDs[id] = true
+	}
+	for i, e := range config.Plan {
+		id, ok := canonicalAppID(e.AppID)
+		if !ok {
+			return fmt.Errorf("plan[%d]: app_id 无效: %q", i, e.AppID)
+		}
+		e.AppID = id
+		if e.Repo == "" {
+			e.Repo = config.Repo
+		}
+		if err := validatePlanEntry(*config, e); err != nil {
+			return fmt.Errorf("plan[%d] (%s): %v", i, e.Name, err)
+		}
+		if !inAppIDs[id] {
+			inAppIDs[id] = true
+			rn.planOnly[id] = true
+			config.AppIDs = append(config.AppIDs, id)
+		}
+		rn.planByApp[id] = append(rn.planByApp[id], e)
+	}
+	return nil
+}
+
+// validatePlanEntry 按与分支包解压相同的路径安全规则检查条目
+func validatePlanEntry(config Config, e PlanEntry) error {
+	if err := checkLocalName(e.Name); err != nil {
+		return err
+	}
+	if planDir(config, e.AppID, e.Name) == "" {
+		return fmt.Errorf("不支持的文件类型 (仅 .lua/.manifest/.vdf/.st) 或对应的 lua_dir/manifest_dir 未设置")
+	}
+	if e.SHA256 != "" && len(e.SHA256) != sha256.Size*2 {
+		return fmt.Errorf("sha256 长度无效")
+	}
+	if e.GitSHA != "" && len(e.GitSHA) != sha1.Size*2 {
+		return fmt.Errorf("git_sha 长度无效")
+	}
+	if e.URL != "" {
+		u, err := url.Parse(e.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("url 无效: %s", e.URL)
+		}
+		return nil
+	}
+	if e.Repo == "" || e.Branch == "" || e.Path == "" {
+		return fmt.Errorf("需要 url 或 repo/branch/path")
+	}
+	if _, err := archiveEntryName(e.Path); err != nil {
+		return fmt.Errorf("path %v", err)
+	}
+	return nil
+}
+
+// fileSHA256 返回本地文件的 SHA-256，读取失败时返回空串
+func (rn *run) fileSHA256(p string) string {
+	f, err := rn.fsys.Open(p)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// planDir 返回 appID 的文件写入的目录，不支持的类型返回空串
+func planDir(config Config, appID, name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".lua", ".vdf", ".st":
+		return config.LuaDir
+	case ".manifest":
+		return appManifestDir(config, appID)
+	}
+	return ""
+}
+
+// runPlan 下载 appID 的全部 plan 条目并把结果计入 res，返回失败说明
+func (rn *run) runPlan(ctx context.Context, config Config, appID string, res *AppResult) []string {
+	var failReasons, failKinds []string
+	for _, e := range rn.planByApp[appID] {
+		if ctx.Err() != nil {
+			break
+		}
+		dir := planDir(config, appID, e.Name)
+		destPath := filepath.Join(dir, e.Name)
+		ext := strings.ToLower(path.Ext(e.Name))
+		isScript := ext == ".lua" || ext == ".st"
+		if prev, ok := rn.claimDestination(dir, e.Name); !ok {
+			res.Collisions = append(res.Collisions, rn.collisionNote(appID, e.Name, prev))
+			continue
+		}
+		exp := rn.planExpected(e)
+		if config.SkipExisting && rn.fileIsUsable(destPath) && (exp.sha256 == "" || rn.storedSHA256(destPath) == exp.sha256) {
+			if isScript {
+				recordScript(res, e.Name)
+			} else {
+				res.Skipped++
+				if isManifestPath(e.Name) {
+					res.existing = append(res.existing, manifestFileName(config, appID, e.Name))
+				}
+			}
+			continue
+		}
+		d, err := rn.fetchPlanEntry(ctx, config, e, destPath)
+		if err != nil {
+			rn.releaseDestination(dir, e.Name)
+			var ce *corruptError
+			if errors.As(err, &ce) {
+				res.InvalidFiles = append(res.InvalidFiles, e.Name)
+			}
+			var ke *checksumError
+			if errors.As(err, &ke) {
+				res.ChecksumFailed++
+			}
+			if !isScript {
+				res.FailedManifests = append(res.FailedManifests, e.Name)
+			}
+			failReasons = append(failReasons, e.Name+": "+errorReason(err))
+			failKinds = append(failKinds, classifyError(err))
+			continue
+		}
+		if d.Repo != "" && res.SourceRepo == "" {
+			res.SourceRepo = d.Repo
+		}
+		switch ext {
+		case ".lua", ".st":
+			recordScript(res, e.Name)
+		case ".manifest":
+			res.Manifest++
+			res.Files = append(res.Files, FileInfo{Name: manifestFileName(config, appID, e.Name), Size: d.Size, SHA256: d.SHA256})
+			if len(config.ManifestDirs) > 0 {
+				res.TargetErrors = append(res.TargetErrors, rn.fanOutFile(dir, e.Name, appManifestTargets(config, appID))...)
+			}
+		case ".vdf":
+			if wantKeys(config) && strings.EqualFold(e.Name, "key.vdf") {
+				if data, err := rn.fsys.ReadFile(destPath); err == nil {
+					if keys, err := parseDepotKeys(data); err == nil {
+						res.Keys = keys
+						rn.recordKeys(keys)
+					}
+				}
+			}
+		}
+		rn.emitEvent("file_done", map[string]interface{}{"app_id": appID, "file": e.Name, "bytes": d.Size})
+	}
+	sort.Slice(res.Files, func(i, j int) bool { return res.Files[i].Name < res.Files[j].Name })
+	sort.Strings(res.FailedManifests)
+	sort.Strings(res.InvalidFiles)
+	sort.Strings(res.Collisions)
+	if len(failReasons) == 0 {
+		return nil
+	}
+	if res.ErrorKind == "" {
+		res.ErrorKind = dominantReason(failKinds)
+	}
+	return []string{fmt.Sprintf("%d/%d plan 文件失败: %s", len(failReasons), len(rn.planByApp[appID]), strings.Join(failReasons, ", "))}
+}
+
+// planExpected 返回条目下载后要满足的大小与校验和：checksums 中有该文件时以它代替条目的 sha256/git_sha
+func (rn *run) planExpected(e PlanEntry) expected {
+	if sha := rn.checksumFor(e.Path, e.Name); sha != "" {
+		return expected{rn: rn, size: e.Size, sha256: sha, source: "checksums"}
+	}
+	return expected{rn: rn, size: e.Size, sha256: strings.ToLower(e.SHA256), gitSHA: strings.ToLower(e.GitSHA), source: "plan"}
+}
+
+// fetchPlanEntry 按条目给出的地址下载 (url 直接请求，repo/branch/path 经下载源)，并校验大小与校验和。
+// 先写入同目录的 .part- 文件，校验通过后才替换 destPath，校验失败不会删掉已有的同名文件。
+// 远程存储的目标直接写入 destPath，校验在提交上传前完成，语义相同；dry_run 只探测，不经过 .part- 文件。
+func (rn *run) fetchPlanEntry(ctx context.Context, config Config, e PlanEntry, destPath string) (download, error) {
+	ctx = withExpected(ctx, rn.planExpected(e))
+	if st, _ := rn.storageFor(destPath); st != nil || rn.dryRun {
+		if e.URL != "" {
+			return rn.downloadFileWithRetry(ctx, e.URL, destPath, config.Token, "")
+		}
+		return rn.fetchFile(ctx, e.Repo, e.Branch, e.Path, destPath, config.Token, "")
+	}
+	partPath := filepath.Join(filepath.Dir(destPath), ".part-"+e.Name)
+	var d download
+	var err error
+	if e.URL != "" {
+		d, err = rn.downloadFileWithRetry(ctx, e.URL, partPath, config.Token, "")
+	} else {
+		d, err = rn.fetchFile(ctx, e.Repo, e.Branch, e.Path, partPath, config.Token, "")
+	}
+	if err != nil {
+		return d, err
+	}
+	if err := rn.renameTemp(partPath, destPath); err != nil {
+		rn.fsys.Remove(partPath)
+		return download{}, err
+	}
+	return d, nil
+}
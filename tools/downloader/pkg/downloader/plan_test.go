@@ -0,0 +1,102 @@
+package downloader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestRunPlanOnly(t *testing.T) {
+	r := newTestRepo(t, map[string]string{
+		"cdn/570.lua":            "-- 570",
+		"a/b/570/571_1.manifest": testManifest,
+	})
+	cfg := testConfig(t, nil)
+	// 没有 repo / app_ids：全部文件来自 plan，不做任何探测
+	cfg.Repos, cfg.AppIDs = nil, nil
+	cfg.Plan = []PlanEntry{
+		{AppID: "570", URL: r.srv.URL + "/cdn/570.lua", Name: "570.lua"},
+		{AppID: "570", Repo: "a/b", Branch: "570", Path: "571_1.manifest", Name: "571_1.manifest", SHA256: sha256Hex(testManifest)},
+	}
+	res := r.download(t, cfg)
+	if !res.Success || len(res.Failed) != 0 {
+		t.Fatalf("success = %v, failed = %v", res.Success, res.Failed)
+	}
+	if len(res.Results) != 1 || res.Results[0].Lua != 1 || res.Results[0].Manifest != 1 {
+		t.Errorf("results = %+v", res.Results)
+	}
+	for _, p := range []string{filepath.Join(cfg.LuaDir, "570.lua"), filepath.Join(cfg.ManifestDir, "571_1.manifest")} {
+		if _, err := os.Stat(p); err != nil {
+			t.Error(err)
+		}
+	}
+	// 只请求 plan 中的两个文件
+	r.mu.Lock()
+	hits := len(r.hits)
+	r.mu.Unlock()
+	if hits != 2 {
+		t.Errorf("requested %d paths, want 2: %v", hits, r.hits)
+	}
+}
+
+func TestRunPlanWithAppData(t *testing.T) {
+	r := newTestRepo(t, map[string]string{
+		"a/b/10/10.lua":          "-- 10",
+		"a/b/10/11_22.manifest":  testManifest,
+		"a/b/570/571_1.manifest": testManifest + "571",
+	})
+	cfg := testConfig(t, map[string][]string{"10": {"11_22"}})
+	cfg.Plan = []PlanEntry{
+		{AppID: "570", Branch: "570", Path: "571_1.manifest", Name: "571_1.manifest", SHA256: sha256Hex(testManifest)},
+	}
+	res := r.download(t, cfg)
+	got := map[string]AppResult{}
+	for _, app := range res.Results {
+		got[app.AppID] = app
+	}
+	if got["10"].Lua != 1 || got["10"].Manifest != 1 {
+		t.Errorf("app 10 = %+v", got["10"])
+	}
+	// plan 中的 sha256 与内容不符：文件不保留
+	if got["570"].ChecksumFailed != 1 || r.count("a/b/570/570.lua") != 0 {
+		t.Errorf("app 570 = %+v", got["570"])
+	}
+	if _, err := os.Stat(filepath.Join(cfg.ManifestDir, "571_1.manifest")); err == nil {
+		t.Error("manifest with mismatched sha256 was kept")
+	}
+}
+
+func TestPlanValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry PlanEntry
+	}{
+		{"traversal name", PlanEntry{AppID: "570", URL: "http://x.test/a", Name: "../570.lua"}},
+		{"unknown type", PlanEntry{AppID: "570", URL: "http://x.test/a", Name: "570.exe"}},
+		{"bad app", PlanEntry{AppID: "abc", URL: "http://x.test/a", Name: "570.lua"}},
+		{"bad url", PlanEntry{AppID: "570", URL: "file:///etc/passwd", Name: "570.lua"}},
+		{"traversal path", PlanEntry{AppID: "570", Repo: "a/b", Branch: "570", Path: "../../x", Name: "570.lua"}},
+		{"no source", PlanEntry{AppID: "570", Name: "570.lua"}},
+		{"short sha", PlanEntry{AppID: "570", URL: "http://x.test/a", Name: "570.lua", SHA256: "abc"}},
+	}
+	r := newTestRepo(t, nil)
+	for _, tt := range tests {
+		cfg := testConfig(t, nil)
+		cfg.Repos, cfg.AppIDs = nil, nil
+		cfg.Plan = []PlanEntry{tt.entry}
+		_, err := r.client().Run(context.Background(), cfg)
+		var ce *ConfigError
+		if !errors.As(err, &ce) || ce.Field != "plan" {
+			t.Errorf("%s: err = %v, want plan error", tt.name, err)
+		}
+	}
+}
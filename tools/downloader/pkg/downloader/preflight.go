@@ -0,0 +1,81 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// API_BASE 是 GitHub REST API 地址
+const API_BASE = "https://api.github.com"
+
+// checkTokenAccess 在正式下载前检查 Token 能否访问 private_repos 中的每个私有仓库。
+// GitHub 对无权限的私有仓库一律返回 404，下载阶段无法区分"文件不存在"与"没有权限"，
+// 因此这里读取 X-OAuth-Scopes (经典 PAT) 并探测仓库元数据，给出明确的警告。
+func (rn *run) checkTokenAccess(ctx context.Context, config Config) []string {
+	if len(config.PrivateRepos) == 0 {
+		return nil
+	}
+	if config.Token == "" {
+		return []string{fmt.Sprintf("token_missing_scope: 配置了私有仓库 %s 但未提供 token", strings.Join(config.PrivateRepos, ", "))}
+	}
+
+	var warnings []string
+	for _, repo := range config.PrivateRepos {
+		status, scopes, hasScopes, err := rn.probeRepo(ctx, config.Token, repo)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("token_check_failed: 无法检查仓库 %s: %v", repo, err))
+			continue
+		}
+		switch {
+		case status == http.StatusOK:
+			continue
+		case status == http.StatusUnauthorized:
+			warnings = append(warnings, "token_invalid: token 无效或已过期")
+			return warnings
+		case hasScopes && !scopeGrantsRepo(scopes):
+			warnings = append(warnings, fmt.Sprintf("token_missing_scope: 经典 PAT 缺少 repo 权限 (当前: %q)，无法访问私有仓库 %s，请重新生成带 repo 权限的 token", scopes, repo))
+		case hasScopes:
+			warnings = append(warnings, fmt.Sprintf("token_no_access: token 具备 repo 权限但无法访问 %s (HTTP %d)，请确认仓库名称及账号是否被授权", repo, status))
+		default:
+			warnings = append(warnings, fmt.Sprintf("token_missing_scope: fine-grained token 未授权仓库 %s (HTTP %d)，请在 token 设置中添加该仓库的 Contents 读取权限", repo, status))
+		}
+	}
+	for _, w := range warnings {
+		rn.warnf("%s", w)
+	}
+	return warnings
+}
+
+// probeRepo 请求仓库元数据，返回状态码与 X-OAuth-Scopes (hasScopes 表示响应中是否带有该头)
+func (rn *run) probeRepo(ctx context.Context, token, repo string) (int, string, bool, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, rn.requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, "GET", rn.apiBase+"/repos/"+repo, nil)
+	if err != nil {
+		return 0, "", false, err
+	}
+	rn.setRequestHeaders(req)
+	if token != "" {
+		req.Header.Set("Authorization", "token "+token)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := rn.dl.Do(req)
+	if err != nil {
+		return 0, "", false, err
+	}
+	resp.Body.Close()
+	scopes, hasScopes := resp.Header["X-Oauth-Scopes"]
+	return resp.StatusCode, strings.Join(scopes, ","), hasScopes, nil
+}
+
+// scopeGrantsRepo 判断经典 PAT 的权限列表是否包含私有仓库读取所需的 repo 权限
+func scopeGrantsRepo(scopes string) bool {
+	for _, s := range strings.Split(scopes, ",") {
+		if strings.TrimSpace(s) == "repo" {
+			return true
+		}
+	}
+	return false
+}
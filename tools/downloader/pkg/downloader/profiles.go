@@ -0,0 +1,201 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// 输出配置 (output_profiles)：同一份下载结果按不同解锁工具的要求生成产物，各自写入 profile_dir/<名称>
+const (
+	PROFILE_STEAMTOOLS = "steamtools" // 默认：Lua 与清单按原样使用，不生成额外文件
+	PROFILE_GREENLUMA  = "greenluma"  // AppList/N.txt 与带 DecryptionKey 的 config.vdf
+	PROFILE_LUMAPLAY   = "lumaplay"   // 每个 App 一个 <appid>.ini
+)
+
+// profileApp 是生成工具产物所需的单个 App 数据，来自下载结果与 Lua 解析
+type profileApp struct {
+	AppID     string
+	DLCs      []string          // Lua 中 addappid 引用的其它 ID
+	Keys      map[string]string // depotID -> 解密密钥 (Lua 与 key.vdf)
+	Manifests map[string]string // depotID -> manifestID
+}
+
+// profileGenerator 把全部成功 App 的数据写入 dir，返回写入的 App 数
+type profileGenerator func(rn *run, dir string, apps []profileApp) (int, error)
+
+// profileGenerators 是支持的输出配置；steamtools 没有生成器
+var profileGenerators = map[string]profileGenerator{
+	PROFILE_STEAMTOOLS: nil,
+	PROFILE_GREENLUMA:  (*run).generateGreenLuma,
+	PROFILE_LUMAPLAY:   (*run).generateLumaplay,
+}
+
+// needProfiles 判断是否配置了需要生成文件的输出配置
+func needProfiles(config Config) bool {
+	for _, name := range config.OutputProfiles {
+		if profileGenerators[name] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// validateProfiles 检查 output_profiles 的名称，需要生成文件时要求 profile_dir
+func validateProfiles(config Config) error {
+	for _, name := range config.OutputProfiles {
+		if _, ok := profileGenerators[name]; !ok {
+			return &ConfigError{Code: CODE_INVALID_VALUE, Field: "output_profiles", Msg: "output_profiles 无效: " + name + " (支持 steamtools、greenluma、lumaplay)"}
+		}
+	}
+	if needProfiles(config) && config.ProfileDir == "" {
+		return &ConfigError{Code: CODE_MISSING_FIELD, Field: "profile_dir", Msg: "output_profiles 需要 profile_dir"}
+	}
+	return nil
+}
+
+// recordProfile 收集一个成功 App 的 depot、密钥与清单数据，Lua 在本地时解析其中的 addappid 与 setManifestid
+func (rn *run) recordProfile(config Config, res AppResult) {
+	if appFailed(res) {
+		return
+	}
+	app := profileApp{AppID: res.AppID, Keys: make(map[string]string), Manifests: make(map[string]string)}
+	if res.Lua > 0 && rn.remoteStorage(config.LuaDir) == nil {
+		if info, err := rn.parseLuaFile(filepath.Join(config.LuaDir, res.AppID+".lua")); err == nil {
+			for _, id := range info.AppIDs {
+				if id != res.AppID {
+					app.DLCs = append(app.DLCs, id)
+				}
+			}
+			for id, k := range info.Keys {
+				app.Keys[id] = k
+			}
+			for _, m := range info.Manifests {
+				if depot, manifest, ok := strings.Cut(m, "_"); ok {
+					app.Manifests[depot] = manifest
+				}
+			}
+		} else {
+			rn.debugf("%s output_profiles 解析 Lua 失败: %v", res.AppID, err)
+		}
+	}
+	for id, k := range res.Keys {
+		app.Keys[id] = k
+	}
+	for _, f := range res.Files {
+		if depot, manifest, ok := strings.Cut(strings.TrimSuffix(path.Base(f.Name), ".manifest"), "_"); ok {
+			app.Manifests[depot] = manifest
+		}
+	}
+	rn.profilesMu.Lock()
+	defer rn.profilesMu.Unlock()
+	rn.profileApps[res.AppID] = app
+}
+
+// writeProfiles 按 order 的顺序为每个输出配置生成产物，返回各配置写入的 App 数与失败说明
+func (rn *run) writeProfiles(config Config, order []string) (map[string]int, []string) {
+	rn.profilesMu.Lock()
+	var apps []profileApp
+	for _, id := range order {
+		if a, ok := rn.profileApps[id]; ok {
+			apps = append(apps, a)
+		}
+	}
+	rn.profilesMu.Unlock()
+
+	written := make(map[string]int)
+	var warnings []string
+	for _, name := range config.OutputProfiles {
+		gen := profileGenerators[name]
+		if gen == nil {
+			continue
+		}
+		dir := filepath.Join(config.ProfileDir, name)
+		n, err := gen(rn, dir, apps)
+		if err != nil {
+			rn.warnf("输出配置 %s 生成失败: %v", name, err)
+			warnings = append(warnings, "output_profiles "+name+": "+err.Error())
+			continue
+		}
+		written[name] = n
+		rn.infof("输出配置 %s: 已为 %d 个 App 生成文件到 %s", name, n, dir)
+	}
+	return written, warnings
+}
+
+// GREENLUMA_CONFIG_SKELETON 是 profile 目录中还没有 config.vdf 时使用的最小结构
+const GREENLUMA_CONFIG_SKELETON = "\"InstallConfigStore\"\n{\n\t\"Software\"\n\t{\n\t\t\"Valve\"\n\t\t{\n\t\t\t\"Steam\"\n\t\t\t{\n\t\t\t}\n\t\t}\n\t}\n}\n"
+
+// generateGreenLuma 写入 AppList/N.txt (App 与 DLC) 与 config.vdf (depots 节点下的 DecryptionKey)。
+// 目录中已有的 AppList 条目与 config.vdf 保留，只追加或更新本次的数据
+func (rn *run) generateGreenLuma(dir string, apps []profileApp) (int, error) {
+	var ids []string
+	keys := make(map[string]string)
+	for _, a := range apps {
+		ids = append(append(ids, a.AppID), a.DLCs...)
+		for id, k := range a.Keys {
+			keys[id] = k
+		}
+	}
+	if _, _, err := rn.writeAppListIDs(filepath.Join(dir, "AppList"), ids); err != nil {
+		return 0, err
+	}
+	if len(keys) > 0 {
+		path := filepath.Join(dir, "config.vdf")
+		data, err := rn.fsys.ReadFile(path)
+		if os.IsNotExist(err) {
+			data, err = []byte(GREENLUMA_CONFIG_SKELETON), nil
+		}
+		if err != nil {
+			return 0, &diskError{err}
+		}
+		merged, changed, err := mergeDepotKeys(data, keys)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %v", path, err)
+		}
+		if changed > 0 {
+			if err := rn.writeOutputFile(path, func(w io.Writer) error {
+				_, err := w.Write(merged)
+				return err
+			}); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(apps), nil
+}
+
+// generateLumaplay 为每个 App 写入 <appid>.ini：[app] 中的 appid 与 dlc 列表，
+// [depots] 中每行 depotID=manifestID，[keys] 中每行 depotID=解密密钥
+func (rn *run) generateLumaplay(dir string, apps []profileApp) (int, error) {
+	for _, a := range apps {
+		var b strings.Builder
+		fmt.Fprintf(&b, "[app]\nappid=%s\n", a.AppID)
+		if len(a.DLCs) > 0 {
+			fmt.Fprintf(&b, "dlc=%s\n", strings.Join(a.DLCs, ","))
+		}
+		writeIniSection(&b, "depots", a.Manifests)
+		writeIniSection(&b, "keys", a.Keys)
+		if err := rn.writeOutputFile(filepath.Join(dir, a.AppID+".ini"), func(w io.Writer) error {
+			_, err := io.WriteString(w, b.String())
+			return err
+		}); err != nil {
+			return 0, err
+		}
+	}
+	return len(apps), nil
+}
+
+// writeIniSection 按键排序写入一个 ini 节，m 为空时不写
+func writeIniSection(b *strings.Builder, name string, m map[string]string) {
+	if len(m) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "\n[%s]\n", name)
+	for _, id := range sortedKeys(m) {
+		fmt.Fprintf(b, "%s=%s\n", id, m[id])
+	}
+}
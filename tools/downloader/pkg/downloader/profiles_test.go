@@ -0,0 +1,110 @@
+package downloader
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestValidateProfiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		profiles []string
+		dir      string
+		field    string // 期望出错的字段，空表示通过
+	}{
+		{"none", nil, "", ""},
+		{"steamtools only", []string{PROFILE_STEAMTOOLS}, "", ""},
+		{"greenluma", []string{PROFILE_GREENLUMA}, "out", ""},
+		{"greenluma without dir", []string{PROFILE_STEAMTOOLS, PROFILE_GREENLUMA}, "", "profile_dir"},
+		{"lumaplay without dir", []string{PROFILE_LUMAPLAY}, "", "profile_dir"},
+		{"unknown", []string{"greenluma", "creamapi"}, "out", "output_profiles"},
+	}
+	for _, tt := range tests {
+		err := validateProfiles(Config{OutputProfiles: tt.profiles, ProfileDir: tt.dir})
+		var ce *ConfigError
+		if tt.field == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+		} else if !errors.As(err, &ce) || ce.Field != tt.field {
+			t.Errorf("%s: err = %v, want field %s", tt.name, err, tt.field)
+		}
+	}
+}
+
+func TestOutputProfiles(t *testing.T) {
+	lua := "addappid(10)\naddappid(11, 1, \"aa11\")\nsetManifestid(11, \"22\")\naddappid(15)\n"
+	r := newTestRepo(t, map[string]string{
+		"a/b/10/10.lua":         lua,
+		"a/b/10/11_22.manifest": testManifest,
+		"a/b/10/12_33.manifest": testManifest,
+		"a/b/10/key.vdf":        "\"depots\" { \"12\" { \"DecryptionKey\" \"bb12\" } }",
+	})
+	cfg := testConfig(t, map[string][]string{"10": {"11_22", "12_33"}, "20": nil})
+	cfg.FetchKeys = true
+	cfg.ProfileDir = filepath.Join(t.TempDir(), "profiles")
+	cfg.OutputProfiles = []string{PROFILE_STEAMTOOLS, PROFILE_GREENLUMA, PROFILE_LUMAPLAY}
+	res := r.download(t, cfg)
+	// 失败的 App 20 不生成产物
+	if want := map[string]int{PROFILE_GREENLUMA: 1, PROFILE_LUMAPLAY: 1}; !reflect.DeepEqual(res.Profiles, want) {
+		t.Errorf("profiles = %v, want %v", res.Profiles, want)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.ProfileDir, PROFILE_STEAMTOOLS)); !os.IsNotExist(err) {
+		t.Errorf("steamtools profile wrote files: %v", err)
+	}
+
+	appList := filepath.Join(cfg.ProfileDir, PROFILE_GREENLUMA, "AppList")
+	wantList := []string{"10", "11", "15"}
+	checkAppList := func() {
+		t.Helper()
+		var got []string
+		for i := 0; ; i++ {
+			data, err := os.ReadFile(filepath.Join(appList, strconv.Itoa(i)+".txt"))
+			if err != nil {
+				break
+			}
+			got = append(got, string(data))
+		}
+		if !reflect.DeepEqual(got, wantList) || len(listFiles(t, appList)) != len(wantList) {
+			t.Errorf("AppList = %v, want %v", got, wantList)
+		}
+	}
+	checkAppList()
+
+	vdfPath := filepath.Join(cfg.ProfileDir, PROFILE_GREENLUMA, "config.vdf")
+	vdf, err := os.ReadFile(vdfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := parseVDF(vdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]string{}
+	for _, d := range root.path("InstallConfigStore", "Software", "Valve", "Steam", "depots").children {
+		keys[d.key] = d.child("DecryptionKey").value
+	}
+	if want := map[string]string{"11": "aa11", "12": "bb12"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("config.vdf keys = %v, want %v", keys, want)
+	}
+
+	ini, _ := os.ReadFile(filepath.Join(cfg.ProfileDir, PROFILE_LUMAPLAY, "10.ini"))
+	wantIni := "[app]\nappid=10\ndlc=11,15\n\n[depots]\n11=22\n12=33\n\n[keys]\n11=aa11\n12=bb12\n"
+	if string(ini) != wantIni {
+		t.Errorf("10.ini =\n%s\nwant\n%s", ini, wantIni)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.ProfileDir, PROFILE_LUMAPLAY, "20.ini")); !os.IsNotExist(err) {
+		t.Errorf("failed app got an ini: %v", err)
+	}
+
+	// 再次运行：AppList 不重复追加，config.vdf 不变
+	r.download(t, cfg)
+	checkAppList()
+	if again, _ := os.ReadFile(vdfPath); string(again) != string(vdf) {
+		t.Errorf("second run changed config.vdf:\n%s", again)
+	}
+}
@@ -0,0 +1,226 @@
+package downloader
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// DEFAULT_SOCKS5_PORT 是 proxy 中的 socks5 地址没有端口时使用的端口
+const DEFAULT_SOCKS5_PORT = "1080"
+
+// parseProxy 解析并校验 proxy 配置 (http、https、socks5、socks5h)，错误信息中不包含密码
+func parseProxy(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, errors.New("不是有效的 URL")
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("不支持的协议 %q (支持 http、https、socks5)", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("%s 缺少主机", u.Redacted())
+	}
+	if p := u.Port(); p != "" {
+		if n, err := strconv.Atoi(p); err != nil || n <= 0 || n > 65535 {
+			return nil, fmt.Errorf("%s 的端口无效", u.Redacted())
+		}
+	}
+	return u, nil
+}
+
+// applyProxy 按代理地址设置 Transport：http(s) 代理通过 Proxy 函数，socks5 通过 DialContext 建立隧道。
+// proxy 为 nil 时沿用环境变量
+func applyProxy(t *http.Transport, dialer *net.Dialer, proxy *url.URL) {
+	switch {
+	case proxy == nil:
+		t.Proxy = http.ProxyFromEnvironment
+	case proxy.Scheme == "socks5" || proxy.Scheme == "socks5h":
+		s := &socks5Dialer{addr: proxy.Host, dialer: dialer}
+		if proxy.Port() == "" {
+			s.addr = net.JoinHostPort(proxy.Hostname(), DEFAULT_SOCKS5_PORT)
+		}
+		if proxy.User != nil {
+			s.user = proxy.User.Username()
+			s.pass, _ = proxy.User.Password()
+		}
+		t.Proxy = nil
+		t.DialContext = s.DialContext
+	default:
+		t.Proxy = http.ProxyURL(proxy)
+	}
+}
+
+// socks5Dialer 通过 SOCKS5 代理 (RFC 1928，用户名密码认证见 RFC 1929) 建立 TCP 连接。
+// 目标主机名原样交给代理解析，本地不做 DNS 查询
+type socks5Dialer struct {
+	addr       string
+	user, pass string
+	dialer     *net.Dialer
+}
+
+// SOCKS5 协议常量
+const (
+	SOCKS5_VERSION       = 0x05
+	SOCKS5_AUTH_NONE     = 0x00
+	SOCKS5_AUTH_PASSWORD = 0x02
+	SOCKS5_AUTH_REJECTED = 0xff
+	SOCKS5_CMD_CONNECT   = 0x01
+	SOCKS5_ATYP_IPV4     = 0x01
+	SOCKS5_ATYP_DOMAIN   = 0x03
+	SOCKS5_ATYP_IPV6     = 0x04
+)
+
+// SOCKS5_REPLIES 是 CONNECT 失败时代理返回的错误码说明
+var SOCKS5_REPLIES = map[byte]string{
+	0x01: "代理内部错误",
+	0x02: "代理规则不允许",
+	0x03: "网络不可达",
+	0x04: "主机不可达",
+	0x05: "连接被拒绝",
+	0x06: "TTL 过期",
+	0x07: "不支持的命令",
+	0x08: "不支持的地址类型",
+}
+
+// DialContext 连接代理并完成握手，返回到 addr 的隧道连接；握手期间 ctx 取消或到期时立即中断
+func (s *socks5Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := s.dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop, exited := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+	err = s.handshake(conn, addr)
+	close(stop)
+	<-exited
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("socks5 代理 %s: %v", s.addr, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// handshake 协商认证方式并发送 CONNECT 请求
+func (s *socks5Dialer) handshake(conn net.Conn, addr string) error {
+	methods := []byte{SOCKS5_AUTH_NONE}
+	if s.user != "" {
+		methods = []byte{SOCKS5_AUTH_NONE, SOCKS5_AUTH_PASSWORD}
+	}
+	if _, err := conn.Write(append([]byte{SOCKS5_VERSION, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+	if buf[0] != SOCKS5_VERSION {
+		return fmt.Errorf("不是 SOCKS5 代理 (版本 %d)", buf[0])
+	}
+	switch buf[1] {
+	case SOCKS5_AUTH_NONE:
+	case SOCKS5_AUTH_PASSWORD:
+		if s.user == "" {
+			return errors.New("代理要求用户名密码")
+		}
+		if len(s.user) > 255 || len(s.pass) > 255 {
+			return errors.New("用户名或密码过长")
+		}
+		req := append([]byte{0x01, byte(len(s.user))}, s.user...)
+		req = append(append(req, byte(len(s.pass))), s.pass...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return err
+		}
+		if buf[1] != 0x00 {
+			return errors.New("用户名或密码错误")
+		}
+	case SOCKS5_AUTH_REJECTED:
+		return errors.New("没有可用的认证方式")
+	default:
+		return fmt.Errorf("不支持的认证方式 %d", buf[1])
+	}
+
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return fmt.Errorf("端口无效: %s", portStr)
+	}
+	req := []byte{SOCKS5_VERSION, SOCKS5_CMD_CONNECT, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(append(req, SOCKS5_ATYP_IPV4), ip4...)
+		} else {
+			req = append(append(req, SOCKS5_ATYP_IPV6), ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("主机名过长: %s", host)
+		}
+		req = append(append(req, SOCKS5_ATYP_DOMAIN, byte(len(host))), host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// 应答: VER REP RSV ATYP BND.ADDR BND.PORT，绑定地址不使用，读完丢弃
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return err
+	}
+	if head[1] != 0x00 {
+		reason := SOCKS5_REPLIES[head[1]]
+		if reason == "" {
+			reason = fmt.Sprintf("错误码 %d", head[1])
+		}
+		return fmt.Errorf("连接 %s 失败: %s", addr, reason)
+	}
+	var skip int
+	switch head[3] {
+	case SOCKS5_ATYP_IPV4:
+		skip = net.IPv4len
+	case SOCKS5_ATYP_IPV6:
+		skip = net.IPv6len
+	case SOCKS5_ATYP_DOMAIN:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return err
+		}
+		skip = int(buf[0])
+	default:
+		return fmt.Errorf("应答的地址类型无效 %d", head[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
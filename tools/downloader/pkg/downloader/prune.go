@@ -0,0 +1,58 @@
+package downloader
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// pruneOldManifests 删除 manifest_dir (nest_by_app 时为 App 的子目录) 中属于 appID 的 depot 的旧清单 (prune_old_manifests)。
+// 只处理本次至少成功下载了一个新清单的 depot：其它 {depotid}_*.manifest 中，清单 ID 既不在本次条目
+// (app_data 与 Lua) 中、也不是本次下载的文件时删除。返回删除的文件名
+func (rn *run) pruneOldManifests(config Config, items []string, res *AppResult) []string {
+	if rn.remoteStorage(config.ManifestDir) != nil {
+		return nil
+	}
+	dir := appManifestDir(config, res.AppID)
+	keep := make(map[string]bool) // 本次条目与下载的文件名 (小写)
+	for _, item := range items {
+		keep[strings.ToLower(manifestLocalName(strings.TrimSpace(item)))] = true
+	}
+	fresh := make(map[string]bool) // 本次有新清单的 depot
+	for _, f := range res.Files {
+		name := path.Base(f.Name)
+		keep[strings.ToLower(name)] = true
+		if depot, _, ok := strings.Cut(name, "_"); ok {
+			fresh[depot] = true
+		}
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+
+	entries, err := rn.fsys.ReadDir(dir)
+	if err != nil {
+		rn.warnf("%s prune_old_manifests 无法读取 %s: %v", res.AppID, dir, err)
+		return nil
+	}
+	var pruned []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.EqualFold(filepath.Ext(name), ".manifest") || keep[strings.ToLower(name)] {
+			continue
+		}
+		depot, _, ok := strings.Cut(name, "_")
+		if !ok || !fresh[depot] {
+			continue
+		}
+		if err := rn.fsys.Remove(filepath.Join(dir, name)); err != nil {
+			rn.warnf("%s 删除旧清单 %s 失败: %v", res.AppID, name, err)
+			continue
+		}
+		rn.infof("%s 已删除旧清单 %s", res.AppID, name)
+		pruned = append(pruned, manifestFileName(config, res.AppID, name))
+	}
+	sort.Strings(pruned)
+	return pruned
+}
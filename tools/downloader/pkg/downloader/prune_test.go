@@ -0,0 +1,53 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPruneOldManifests(t *testing.T) {
+	tests := []struct {
+		name   string
+		prune  bool
+		pruned []string
+	}{
+		{"disabled", false, nil},
+		// 只删除本次有新清单的 depot 11 的旧清单；depot 12 的新清单下载失败，旧的保留
+		{"enabled", true, []string{"11_1.manifest", "11_3.MANIFEST"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepo(t, map[string]string{
+				"a/b/10/10.lua":         "-- 10",
+				"a/b/10/11_22.manifest": testManifest,
+			})
+			cfg := testConfig(t, map[string][]string{"10": {"11_22", "11_2", "12_6"}})
+			cfg.PruneOldManifests = tt.prune
+			existing := []string{"11_1.manifest", "11_3.MANIFEST", "11_2.manifest", "12_5.manifest", "13_7.manifest", "11_1.txt"}
+			os.MkdirAll(cfg.ManifestDir, 0o755)
+			for _, name := range existing {
+				os.WriteFile(filepath.Join(cfg.ManifestDir, name), []byte(testManifest), 0o644)
+			}
+			res := r.download(t, cfg)
+			if res.Summary.Manifest != 1 {
+				t.Fatalf("summary = %+v", res.Summary)
+			}
+			if got := res.Results[0].Pruned; !reflect.DeepEqual(got, tt.pruned) {
+				t.Errorf("pruned = %v, want %v", got, tt.pruned)
+			}
+			removed := map[string]bool{}
+			for _, name := range tt.pruned {
+				removed[name] = true
+			}
+			// app_data 中列出的 11_2、其它 depot 与非清单文件都保留
+			for _, name := range append(existing, "11_22.manifest") {
+				_, err := os.Stat(filepath.Join(cfg.ManifestDir, name))
+				if exists := err == nil; exists == removed[name] {
+					t.Errorf("%s exists = %v, want %v", name, exists, !removed[name])
+				}
+			}
+		})
+	}
+}
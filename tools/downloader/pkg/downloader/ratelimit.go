@@ -0,0 +1,147 @@
+package downloader
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// clock 是限速与探测间隔使用的时间来源，测试中替换为可控的假时钟
+type clock interface {
+	Now() time.Time
+	// Sleep 等待 d，ctx 先结束时返回 ctx.Err()
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// hostLimiter 是按主机划分的令牌桶限速器 (requests_per_second)，所有 worker 与清单协程共享，
+// 无论并发多少个协程，对同一主机的请求速率都被平滑到配置值以下
+type hostLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // 每秒补充的令牌数
+	burst   float64 // 桶容量
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newHostLimiter 返回每秒 rps 个请求的限速器，rps <= 0 时返回 nil (不限速)。
+// 桶容量为 1 秒的请求量 (至少 1 个)，允许短暂突发但长期速率不超过 rps。
+func newHostLimiter(rps float64) *hostLimiter {
+	if rps <= 0 {
+		return nil
+	}
+	burst := rps
+	if burst < 1 {
+		burst = 1
+	}
+	return &hostLimiter{rate: rps, burst: burst, buckets: make(map[string]*bucket)}
+}
+
+// reserve 在 now 时刻取走 host 的一个令牌，返回需要等待的时间 (令牌欠账时为正)
+func (l *hostLimiter) reserve(host string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[host]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[host] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / l.rate * float64(time.Second))
+}
+
+// cancel 归还一个未使用的令牌 (等待期间运行被取消)
+func (l *hostLimiter) cancel(host string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, ok := l.buckets[host]; ok {
+		b.tokens++
+	}
+}
+
+// Wait 阻塞到 rawURL 所在主机有可用令牌，且距调用至少过去 min (探测间隔，0 表示没有)。
+// 两者同时计时，实际等待取较大者而不是相加，返回 min 超出令牌等待的部分。
+// 运行被取消时归还令牌并返回 ctx.Err()；l 为 nil (不限速) 时只等待 min
+func (l *hostLimiter) Wait(ctx context.Context, clk clock, rawURL string, min time.Duration) (time.Duration, error) {
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	var wait time.Duration
+	if l != nil {
+		wait = l.reserve(host, clk.Now())
+	}
+	added := min - wait
+	if added > 0 {
+		wait = min
+	} else {
+		added = 0
+	}
+	if wait <= 0 {
+		return 0, nil
+	}
+	if err := clk.Sleep(ctx, wait); err != nil {
+		if l != nil {
+			l.cancel(host)
+		}
+		return 0, err
+	}
+	return added, nil
+}
+
+// probeDelayKey 是 context 中 withProbeDelay 的键
+type probeDelayKey struct{}
+
+// withProbeDelay 让 ctx 中的第一个请求 (HEAD 或 GET，含换源) 在限速等待时至少等待 d，
+// 该候选随后的请求与重试不再等待
+func withProbeDelay(ctx context.Context, d time.Duration) context.Context {
+	pending := int64(d)
+	return context.WithValue(ctx, probeDelayKey{}, &pending)
+}
+
+// takeProbeDelay 取出 ctx 中尚未消耗的探测间隔
+func takeProbeDelay(ctx context.Context) time.Duration {
+	if pending, ok := ctx.Value(probeDelayKey{}).(*int64); ok {
+		return time.Duration(atomic.SwapInt64(pending, 0))
+	}
+	return 0
+}
+
+// waitLimiter 在发出请求前等待限速令牌。probe_delay_ms 的间隔与令牌等待重叠计算，
+// 同一主机的探测速率取两者中较慢者，而不是在令牌等待之外再叠加间隔；
+// 间隔超出令牌等待的部分计入 probeDelayTotal
+func (rn *run) waitLimiter(ctx context.Context, rawURL string) error {
+	added, err := rn.limiter.Wait(ctx, rn.clock, rawURL, takeProbeDelay(ctx))
+	if added > 0 {
+		atomic.AddInt64(&rn.probeDelayTotal, int64(added))
+	}
+	return err
+}
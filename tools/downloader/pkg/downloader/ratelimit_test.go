@@ -0,0 +1,181 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock 是测试用的时钟：Sleep 不阻塞，直接把时间向前推进
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock { return &fakeClock{now: time.Unix(1700000000, 0)} }
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.advance(d)
+	return nil
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func TestLimiterWaitWithProbeDelay(t *testing.T) {
+	type step struct {
+		advance time.Duration // 调用前时钟前进的时间
+		min     time.Duration // 探测间隔
+		sleep   time.Duration // 期望的实际等待
+		added   time.Duration // 期望间隔额外增加的等待
+	}
+	tests := []struct {
+		name  string
+		rps   float64
+		steps []step
+	}{
+		{"limited", 1, []step{
+			{0, 0, 0, 0},
+			// 令牌等待 1s 已覆盖 300ms 的间隔，不叠加为 1.3s
+			{0, 300 * time.Millisecond, time.Second, 0},
+			// 桶已补满：只等间隔
+			{2 * time.Second, 500 * time.Millisecond, 500 * time.Millisecond, 500 * time.Millisecond},
+			// 令牌等待 0.5s，间隔 1.5s：取较大者，额外增加 1s
+			{0, 1500 * time.Millisecond, 1500 * time.Millisecond, time.Second},
+		}},
+		{"unlimited", 0, []step{
+			{0, 0, 0, 0},
+			{0, 300 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := newFakeClock()
+			l := newHostLimiter(tt.rps)
+			for i, s := range tt.steps {
+				clk.advance(s.advance)
+				start := clk.Now()
+				added, err := l.Wait(context.Background(), clk, "https://mirror.example/a/b", s.min)
+				if slept := clk.Now().Sub(start); err != nil || slept != s.sleep || added != s.added {
+					t.Errorf("step %d: slept %v, added %v, err %v; want %v, %v", i, slept, added, err, s.sleep, s.added)
+				}
+			}
+		})
+	}
+}
+
+func TestLimiterWaitCanceled(t *testing.T) {
+	clk := newFakeClock()
+	l := newHostLimiter(1)
+	l.Wait(context.Background(), clk, "https://mirror.example/x", 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.Wait(ctx, clk, "https://mirror.example/x", 0); err != context.Canceled {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	// 取消的等待归还令牌，1s 后桶中已有令牌，无需等待
+	clk.advance(time.Second)
+	start := clk.Now()
+	l.Wait(context.Background(), clk, "https://mirror.example/x", 0)
+	if slept := clk.Now().Sub(start); slept != 0 {
+		t.Errorf("slept %v after a canceled wait, want 0", slept)
+	}
+}
+
+func TestProbeDelayThroughLimiter(t *testing.T) {
+	clk := newFakeClock()
+	rn := newRun()
+	rn.clock = clk
+	rn.limiter = newHostLimiter(1)
+	ctx := context.Background()
+	url := "https://mirror.example/a/b/10/11_22.manifest"
+
+	start := clk.Now()
+	rn.waitLimiter(ctx, url)
+	// 第二个候选：先 HEAD 后 GET，间隔只由第一个请求消耗，并被令牌等待覆盖
+	probe := withProbeDelay(ctx, 400*time.Millisecond)
+	rn.waitLimiter(probe, url)
+	rn.waitLimiter(probe, url)
+	if elapsed := clk.Now().Sub(start); elapsed != 2*time.Second || rn.probeDelayTotal != 0 {
+		t.Errorf("elapsed %v, probe delay %v; want 2s, 0", elapsed, time.Duration(rn.probeDelayTotal))
+	}
+
+	// 桶空闲时间隔才额外增加等待，并计入 probe_delay_seconds
+	clk.advance(5 * time.Second)
+	start = clk.Now()
+	rn.waitLimiter(withProbeDelay(ctx, 400*time.Millisecond), url)
+	if elapsed := clk.Now().Sub(start); elapsed != 400*time.Millisecond || time.Duration(rn.probeDelayTotal) != 400*time.Millisecond {
+		t.Errorf("elapsed %v, probe delay %v; want 400ms", elapsed, time.Duration(rn.probeDelayTotal))
+	}
+}
+
+func TestLimiterPerHost(t *testing.T) {
+	clk := newFakeClock()
+	l := newHostLimiter(2)
+	wait := func(url string) time.Duration {
+		start := clk.Now()
+		l.Wait(context.Background(), clk, url, 0)
+		return clk.Now().Sub(start)
+	}
+	// 桶容量为 1 秒的量：前 2 个请求不等待，之后每个等 1/rps
+	for i, want := range []time.Duration{0, 0, 500 * time.Millisecond, 500 * time.Millisecond} {
+		if got := wait("https://raw.example/a/" + string(rune('a'+i))); got != want {
+			t.Errorf("request %d waited %v, want %v", i, got, want)
+		}
+	}
+	// 其它主机有自己的桶
+	if got := wait("https://mirror.example/a"); got != 0 {
+		t.Errorf("other host waited %v, want 0", got)
+	}
+}
+
+func TestRequestsPerSecond(t *testing.T) {
+	const rps = 10
+	files := map[string]string{}
+	data := map[string][]string{}
+	for i := 0; i < 5; i++ {
+		id := strconv.Itoa(100 + i)
+		files["a/b/"+id+"/"+id+".lua"] = "-- " + id
+		data[id] = nil
+	}
+	r := newTestRepo(t, files)
+	var mu sync.Mutex
+	requests := 0
+	r.srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		r.serve(w, req)
+	})
+	cfg := testConfig(t, data)
+	cfg.RequestsPerSecond = rps
+
+	start := time.Now()
+	res := r.download(t, cfg)
+	elapsed := time.Since(start)
+	if res.Summary.Lua != len(data) {
+		t.Fatalf("summary = %+v", res.Summary)
+	}
+	// 100 个 worker 共享同一个桶：除去 1 秒的突发容量，其余请求按 rps 排队
+	mu.Lock()
+	n := requests
+	mu.Unlock()
+	if min := time.Duration(float64(n-rps) / rps * float64(time.Second)); elapsed < min-50*time.Millisecond {
+		t.Errorf("%d requests took %v, want at least %v at %d/s", n, elapsed, min, rps)
+	}
+}
@@ -0,0 +1,119 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+// STATE_FILE_NAME 记录各仓库最后一次确认可用的时间，用于仓库被删除/下架后生成墓碑记录
+const STATE_FILE_NAME = ".downloader_state.json"
+
+// RepoStatus 描述一个整体不可用 (404/451) 的仓库
+type RepoStatus struct {
+	Repo          string `json:"repo"`
+	Status        int    `json:"status"`
+	Evidence      string `json:"evidence"`                  // 判定依据，例如 "GET https://api.github.com/repos/x/y 返回 451"
+	Tombstone     bool   `json:"tombstone,omitempty"`       // 以前的运行中该仓库可用，现已消失
+	LastKnownGood string `json:"last_known_good,omitempty"` // 最后一次确认可用的时间 (RFC 3339)
+}
+
+// repoState 是状态文件的内容
+type repoState struct {
+	Repos map[string]repoStateEntry `json:"repos"`
+}
+
+type repoStateEntry struct {
+	LastOK string `json:"last_ok"`
+}
+
+func (rn *run) loadRepoState(path string) repoState {
+	st := repoState{Repos: make(map[string]repoStateEntry)}
+	data, err := rn.fsys.ReadFile(path)
+	if err != nil {
+		return st
+	}
+	if json.Unmarshal(data, &st) != nil || st.Repos == nil {
+		st.Repos = make(map[string]repoStateEntry)
+	}
+	return st
+}
+
+func (rn *run) saveRepoState(path string, st repoState) {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return
+	}
+	tmp := path + ".tmp"
+	if err := rn.fsys.WriteFile(tmp, data, 0644); err != nil {
+		return
+	}
+	if err := rn.fsys.Rename(tmp, path); err != nil {
+		rn.fsys.Remove(tmp)
+	}
+}
+
+// stateDir 返回状态文件所在目录 (优先 ManifestDir)，两者都未配置或都使用远程存储时返回空串
+func (rn *run) stateDir(config Config) string {
+	for _, dir := range []string{config.ManifestDir, config.LuaDir} {
+		if dir != "" && rn.remoteStorage(dir) == nil {
+			return dir
+		}
+	}
+	return ""
+}
+
+// checkRepos 是 repo_check 模式的仓库级预检：通过 API 确认每个仓库是否仍然存在。
+// 返回 404/451 的仓库从 config.Repos 中移除并记录为 RepoStatus，其余网络错误或状态码不下结论。
+func (rn *run) checkRepos(ctx context.Context, config *Config) []RepoStatus {
+	var st repoState
+	statePath := ""
+	if dir := rn.stateDir(*config); dir != "" {
+		statePath = filepath.Join(dir, STATE_FILE_NAME)
+		st = rn.loadRepoState(statePath)
+	} else {
+		st = repoState{Repos: make(map[string]repoStateEntry)}
+	}
+
+	var unavailable []RepoStatus
+	var remaining []string
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, repo := range config.Repos {
+		status, _, _, err := rn.probeRepo(ctx, config.Token, repo)
+		if err != nil {
+			rn.debugf("仓库 %s 预检失败 (%v)，按可用处理", repo, err)
+			remaining = append(remaining, repo)
+			continue
+		}
+		switch status {
+		case http.StatusOK:
+			st.Repos[repo] = repoStateEntry{LastOK: now}
+			remaining = append(remaining, repo)
+		case http.StatusNotFound, http.StatusUnavailableForLegalReasons:
+			rs := RepoStatus{
+				Repo:     repo,
+				Status:   status,
+				Evidence: fmt.Sprintf("GET %s/repos/%s 返回 %d", rn.apiBase, repo, status),
+			}
+			if e, ok := st.Repos[repo]; ok {
+				rs.Tombstone, rs.LastKnownGood = true, e.LastOK
+			}
+			rn.warnf("仓库 %s 不可用 (HTTP %d)，本次运行跳过", repo, status)
+			unavailable = append(unavailable, rs)
+		default:
+			remaining = append(remaining, repo)
+		}
+	}
+	if statePath != "" {
+		rn.saveRepoState(statePath, st)
+	}
+
+	config.Repos = remaining
+	if len(remaining) > 0 {
+		config.Repo = remaining[0]
+	}
+	return unavailable
+}
@@ -0,0 +1,226 @@
+//go:build !slim
+
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// depotResolution 是 resolve_depots 对一个 App 的结果
+type depotResolution struct {
+	depots  []string // appinfo 中的 depot ID
+	items   []string // 仓库分支中存在的 "depot_manifest" 条目
+	missing []string // 仓库分支中没有任何清单的 depot (只在成功列出分支文件时给出)
+
+	repo   string           // 列出文件的仓库
+	listed map[string]int64 // 分支根目录中的全部清单文件名 -> 大小 (dry_run 据此确认条目，不再逐个探测)
+}
+
+// resolveDepots 查询 appID 的 depot 列表，再列出仓库中该 App 分支的文件，找出已有的 {depotid}_{manifestid}.manifest。
+// known 是 app_data 等已知的条目，其中的 depot 不计入 missing。
+// Steam 或 GitHub API 请求失败时只记录警告并返回已得到的部分，下载按原有条目进行
+func (rn *run) resolveDepots(ctx context.Context, config Config, appID string, known []string) depotResolution {
+	var r depotResolution
+	depots, err := rn.fetchAppDepots(ctx, appID)
+	if err != nil {
+		rn.warnf("%s resolve_depots 查询 depot 失败，按已知条目下载: %v", appID, err)
+		return r
+	}
+	r.depots = depots
+	if len(depots) == 0 {
+		rn.debugf("%s resolve_depots: appinfo 中没有 depot", appID)
+		return r
+	}
+
+	files, repo, branch, err := rn.listAppManifests(ctx, config, appID)
+	if err != nil {
+		rn.warnf("%s resolve_depots 列出仓库文件失败，按已知条目下载: %v", appID, err)
+		return r
+	}
+	r.repo, r.listed = repo, files
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	found := make(map[string]bool)
+	for _, item := range known {
+		if depot, _, ok := strings.Cut(manifestLocalName(strings.TrimSpace(item)), "_"); ok {
+			found[depot] = true
+		}
+	}
+	want := make(map[string]bool, len(depots))
+	for _, d := range depots {
+		want[d] = true
+	}
+	for _, name := range names {
+		depot, _, _ := strings.Cut(name, "_")
+		if want[depot] {
+			r.items = append(r.items, strings.TrimSuffix(name, ".manifest"))
+			found[depot] = true
+		}
+	}
+	for _, d := range depots {
+		if !found[d] {
+			r.missing = append(r.missing, d)
+		}
+	}
+	rn.infof("%s resolve_depots: %d 个 depot，分支 %s@%s 中找到 %d 个清单，%d 个 depot 没有清单", appID, len(depots), repo, branch, len(r.items), len(r.missing))
+	return r
+}
+
+// fetchAppInfo 请求 steam_info_url 并返回 data.<appid> 节点 (resolve_depots 与 include_dlc 共用)
+func (rn *run) fetchAppInfo(ctx context.Context, appID string) (json.RawMessage, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, rn.requestTimeout)
+	defer cancel()
+	u := strings.ReplaceAll(rn.steamInfoURL, "{appid}", url.PathEscape(appID))
+	req, err := http.NewRequestWithContext(reqCtx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	rn.setRequestHeaders(req)
+	req.Header.Set("Accept", "application/json")
+	resp, err := rn.dl.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	rn.verbosef("GET %s -> %d", u, resp.StatusCode)
+	if resp.StatusCode != 200 {
+		return nil, &statusError{code: resp.StatusCode}
+	}
+	var info struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("appinfo 解析失败: %v", err)
+	}
+	app, ok := info.Data[appID]
+	if !ok {
+		return nil, errors.New("appinfo 中没有该 App")
+	}
+	return app, nil
+}
+
+// fetchAppDepots 返回 appinfo 中 depots 节点下的数字 depot ID (已排序)
+func (rn *run) fetchAppDepots(ctx context.Context, appID string) ([]string, error) {
+	raw, err := rn.fetchAppInfo(ctx, appID)
+	if err != nil {
+		return nil, err
+	}
+	var app struct {
+		Depots map[string]json.RawMessage `json:"depots"`
+	}
+	if err := json.Unmarshal(raw, &app); err != nil {
+		return nil, fmt.Errorf("appinfo 解析失败: %v", err)
+	}
+	var depots []string
+	for id := range app.Depots {
+		// depots 节点下还有 branches、baselanguages 等非 depot 键
+		if isDigits(id) {
+			depots = append(depots, id)
+		}
+	}
+	sort.Strings(depots)
+	return depots, nil
+}
+
+// listAppManifests 按仓库与分支的探测顺序，通过 GitHub API 列出首个存在的分支中的 .manifest 文件 (文件名 -> 大小)。
+// 分支不存在 (404/422) 时尝试下一个，其它错误直接返回
+func (rn *run) listAppManifests(ctx context.Context, config Config, appID string) (map[string]int64, string, string, error) {
+	var lastErr error
+	for _, repo := range config.Repos {
+		for _, branch := range rn.manifestBranches(repo, appID) {
+			files, err := rn.fetchTreeManifests(ctx, config.Token, repo, branch)
+			if err == nil {
+				return files, repo, branch, nil
+			}
+			lastErr = err
+			if c := statusCode(err); c != 404 && c != 422 {
+				return nil, "", "", err
+			}
+		}
+	}
+	if lastErr == nil {
+		lastErr = errors.New("没有可列出的分支")
+	}
+	return nil, "", "", lastErr
+}
+
+// fetchTreeManifests 请求分支根目录的 Git tree，返回其中 {depotid}_{manifestid}.manifest 形式的文件名及大小
+func (rn *run) fetchTreeManifests(ctx context.Context, token, repo, branch string) (map[string]int64, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, rn.requestTimeout)
+	defer cancel()
+	u := rn.apiBase + "/repos/" + repo + "/git/trees/" + url.PathEscape(branch)
+	req, err := http.NewRequestWithContext(reqCtx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	rn.setRequestHeaders(req)
+	if token != "" {
+		req.Header.Set("Authorization", "token "+rn.authToken(token))
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := rn.dl.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	rn.verbosef("GET %s -> %d", u, resp.StatusCode)
+	if resp.StatusCode != 200 {
+		return nil, &statusError{code: resp.StatusCode}
+	}
+	var tree struct {
+		Tree []struct {
+			Path string `json:"path"`
+			Type string `json:"type"`
+			Size int64  `json:"size"`
+		} `json:"tree"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tree); err != nil {
+		return nil, err
+	}
+	files := make(map[string]int64)
+	for _, e := range tree.Tree {
+		if e.Type != "blob" || !strings.HasSuffix(e.Path, ".manifest") {
+			continue
+		}
+		depot, manifest, ok := strings.Cut(strings.TrimSuffix(e.Path, ".manifest"), "_")
+		if ok && isDigits(depot) && isDigits(manifest) {
+			files[e.Path] = e.Size
+		}
+	}
+	return files, nil
+}
+
+// takeListed 在 dry_run 且 resolve_depots 已列出分支文件时，直接把列表中存在的条目记为找到，不再逐个探测；
+// 返回仍需探测的条目
+func (rn *run) takeListed(config Config, appID string, items []string, r depotResolution, res *AppResult) []string {
+	if len(r.listed) == 0 {
+		return items
+	}
+	var rest []string
+	for _, item := range items {
+		name := manifestLocalName(strings.TrimSpace(item))
+		size, ok := r.listed[name]
+		if !ok {
+			rest = append(rest, item)
+			continue
+		}
+		res.Manifest++
+		res.Files = append(res.Files, FileInfo{Name: manifestFileName(config, appID, name), Size: size})
+		if res.SourceRepo == "" {
+			res.SourceRepo = r.repo
+		}
+	}
+	sort.Slice(res.Files, func(i, j int) bool { return res.Files[i].Name < res.Files[j].Name })
+	rn.debugf("%s dry_run: %d 个清单由分支文件列表确认，%d 个需要探测", appID, len(items)-len(rest), len(rest))
+	return rest
+}
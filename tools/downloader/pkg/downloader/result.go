@@ -0,0 +1,288 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"sync"
+)
+
+// resultSpool 在 low_memory 模式下把已完成的 AppResult 逐行写入临时文件，
+// 避免整个运行期间在内存中保留所有结果
+type resultSpool struct {
+	mu      sync.Mutex
+	fsys    FileSystem
+	f       File
+	w       *bufio.Writer
+	detail  string
+	failed  []string
+	lost    []string // 写入临时文件失败、结果已丢失的 AppID
+	summary ResultSummary
+}
+
+func (rn *run) newResultSpool(detail string) (*resultSpool, error) {
+	f, err := rn.fsys.CreateTemp("", "downloader-results-*.ndjson")
+	if err != nil {
+		return nil, err
+	}
+	return &resultSpool{fsys: rn.fsys, f: f, w: bufio.NewWriter(f), detail: detail, failed: []string{}}, nil
+}
+
+func (s *resultSpool) add(r AppResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.summary.add(r)
+	if appFailed(r) && r.ParentApp == "" {
+		s.failed = append(s.failed, r.AppID)
+	}
+	if !keepResult(s.detail, r) {
+		return nil
+	}
+	data, err := json.Marshal(r)
+	if err == nil {
+		if _, err = s.w.Write(data); err == nil {
+			err = s.w.WriteByte('\n')
+		}
+	}
+	if err != nil {
+		s.lost = append(s.lost, r.AppID)
+		s.summary.SpoolLost++
+	}
+	return err
+}
+
+// each 依次回放已写入的每条结果 (原始 JSON)
+func (s *resultSpool) each(fn func(raw []byte) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(s.f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 1 {
+			if ferr := fn(bytes.TrimSuffix(line, []byte("\n"))); ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *resultSpool) close() {
+	s.f.Close()
+	s.fsys.Remove(s.f.Name())
+}
+
+// 结果详细程度 (result_detail)
+const (
+	DETAIL_FULL          = "full"          // 默认：包含全部 AppResult
+	DETAIL_SUMMARY       = "summary"       // 仅汇总，省略 results 数组
+	DETAIL_FAILURES_ONLY = "failures_only" // 仅包含出错或没有拿到任何文件的 App
+)
+
+// ResultSummary 是整批运行的汇总数据
+type ResultSummary struct {
+	Apps     int `json:"apps"`
+	Lua      int `json:"lua"`
+	St       int `json:"st,omitempty"`
+	Manifest int `json:"manifest"`
+	Skipped  int `json:"skipped"`
+	Failed   int `json:"failed"` // 没有拿到任何文件的 App 数
+	Errors   int `json:"errors"` // 带有错误信息的 App 数
+
+	// DLCApps / DLCFailed: include_dlc 加入的 DLC 数与其中没有拿到任何文件的数，不计入 apps 与 failed
+	DLCApps   int `json:"dlc_apps,omitempty"`
+	DLCFailed int `json:"dlc_failed,omitempty"`
+
+	FileVanished int64 `json:"file_vanished,omitempty"` // 临时文件写入后消失的次数 (通常是杀毒软件隔离)
+	DedupHits    int64 `json:"dedup_hits,omitempty"`    // 与其它 App 共享、复用已有下载结果的清单数
+	Collisions   int   `json:"collisions,omitempty"`    // 因大小写冲突未写入的文件数
+	NotModified  int   `json:"not_modified,omitempty"`  // 条件请求返回 304 而沿用本地的文件数
+	SpoolLost    int   `json:"spool_lost,omitempty"`    // low_memory: 写入结果临时文件失败、结果未能输出的 App 数
+
+	Retries        int `json:"retries,omitempty"`          // detailed_stats: 全部 App 的重试次数
+	NotFoundProbes int `json:"not_found_probes,omitempty"` // detailed_stats: 全部 App 返回 404 的候选探测数
+
+	// QueueWait / Execution: 各 App 排队等待与执行耗时的分位数 (秒)。
+	// 排队等待占主导时提高并发有帮助，执行占主导时则没有。
+	QueueWait *TimingStats `json:"queue_wait,omitempty"`
+	Execution *TimingStats `json:"execution,omitempty"`
+
+	SourceBudgets map[string]BudgetUsage `json:"source_budgets,omitempty"` // source_budgets 中各源的用量
+
+	// EstimatedInstallBytes / InstallEstimatePartial: estimate_install_size 的整批合计 (含 DLC)，未开启时省略
+	EstimatedInstallBytes  *int64 `json:"estimated_install_bytes,omitempty"`
+	InstallEstimatePartial bool   `json:"install_estimate_partial,omitempty"`
+
+	waits, execs []float64
+}
+
+// TimingStats 是一组耗时的分位数 (秒)
+type TimingStats struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// newTimingStats 按最近秩法计算分位数，values 为空时返回 nil；会对 values 原地排序
+func newTimingStats(values []float64) *TimingStats {
+	if len(values) == 0 {
+		return nil
+	}
+	sort.Float64s(values)
+	at := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(values)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return values[i]
+	}
+	return &TimingStats{P50: at(0.5), P90: at(0.9), P99: at(0.99), Max: values[len(values)-1]}
+}
+
+// finish 根据累计的耗时计算分位数
+func (s *ResultSummary) finish() {
+	s.QueueWait = newTimingStats(s.waits)
+	s.Execution = newTimingStats(s.execs)
+}
+
+func (s *ResultSummary) add(r AppResult) {
+	if r.ParentApp != "" {
+		s.DLCApps++
+		if appFailed(r) {
+			s.DLCFailed++
+		}
+	} else {
+		s.Apps++
+	}
+	s.Lua += r.Lua
+	s.St += r.St
+	s.Manifest += r.Manifest
+	s.Skipped += r.Skipped
+	s.Collisions += len(r.Collisions)
+	s.NotModified += r.NotModified
+	s.Retries += r.Retries
+	s.NotFoundProbes += r.NotFoundProbes
+	if r.EstimatedInstallBytes != nil {
+		if s.EstimatedInstallBytes == nil {
+			s.EstimatedInstallBytes = new(int64)
+		}
+		*s.EstimatedInstallBytes += *r.EstimatedInstallBytes
+		s.InstallEstimatePartial = s.InstallEstimatePartial || r.InstallEstimatePartial
+	}
+	s.waits = append(s.waits, r.QueueWaitSeconds)
+	s.execs = append(s.execs, r.ExecutionSeconds)
+	if appFailed(r) && r.ParentApp == "" {
+		s.Failed++
+	}
+	if r.Error != "" {
+		s.Errors++
+	}
+}
+
+// keepResult 判断在给定详细程度下某条 AppResult 是否写入最终输出
+func keepResult(detail string, r AppResult) bool {
+	switch detail {
+	case DETAIL_SUMMARY:
+		return false
+	case DETAIL_FAILURES_ONLY:
+		return r.Error != "" || appFailed(r)
+	}
+	return true
+}
+
+// appFailed 判断某个 App 是否没有拿到任何文件
+func appFailed(r AppResult) bool {
+	return r.Lua == 0 && r.St == 0 && r.Manifest == 0 && r.Skipped == 0
+}
+
+// summarize 汇总全部结果，并列出没有下载到任何文件的 AppID
+func summarize(results []AppResult) (ResultSummary, []string) {
+	var summary ResultSummary
+	failed := []string{}
+	for _, r := range results {
+		summary.add(r)
+		if appFailed(r) && r.ParentApp == "" {
+			failed = append(failed, r.AppID)
+		}
+	}
+	return summary, failed
+}
+
+// writeResult 以流式方式输出最终结果：除 results 数组外的字段一次性编码，
+// results 中的每个条目逐个编码写出，不在内存中拼出整个 JSON。
+// spool 非空时从临时文件回放结果，output.Results 被忽略。输出仍为单行 JSON。
+// detail 为 summary 时省略 results 字段，为 failures_only 时只写出失败的 App。
+func (rn *run) writeResult(w io.Writer, output Result, spool *resultSpool, detail string) error {
+	results := output.Results
+	output.Results = nil
+	if rn.structuredOutput {
+		output.Type = "result"
+	}
+	envelope, err := json.Marshal(output)
+	if err != nil {
+		return err
+	}
+	head, tail, _ := bytes.Cut(envelope, []byte(`"results":null`))
+
+	bw := bufio.NewWriter(w)
+	if detail == DETAIL_SUMMARY {
+		if bytes.HasPrefix(tail, []byte(",")) {
+			tail = tail[1:]
+		} else {
+			head = bytes.TrimSuffix(head, []byte(","))
+		}
+		bw.Write(head)
+		bw.Write(tail)
+		bw.WriteByte('\n')
+		return bw.Flush()
+	}
+
+	bw.Write(head)
+	bw.WriteString(`"results":[`)
+	first := true
+	writeItem := func(raw []byte) error {
+		if !first {
+			bw.WriteByte(',')
+		}
+		first = false
+		_, err := bw.Write(raw)
+		return err
+	}
+	if spool != nil {
+		if err := spool.each(writeItem); err != nil {
+			return err
+		}
+	} else {
+		for i := range results {
+			if !keepResult(detail, results[i]) {
+				continue
+			}
+			raw, err := json.Marshal(&results[i])
+			if err != nil {
+				return err
+			}
+			if err := writeItem(raw); err != nil {
+				return err
+			}
+		}
+	}
+	bw.WriteByte(']')
+	bw.Write(tail)
+	bw.WriteByte('\n')
+	return bw.Flush()
+}
@@ -0,0 +1,252 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// failingWriter 模拟写满或已损坏的临时文件
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestResultSpoolRecordsLostResults(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "spool.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// 缓冲区极小，第一条结果就会写穿到 failingWriter
+	s := &resultSpool{f: f, w: bufio.NewWriterSize(failingWriter{}, 16), detail: DETAIL_FULL, failed: []string{}}
+
+	if err := s.add(AppResult{AppID: "10", Lua: 1}); err == nil {
+		t.Fatal("add() = nil, want write error")
+	}
+	if err := s.add(AppResult{AppID: "20"}); err == nil {
+		t.Fatal("add() = nil, want write error")
+	}
+	if got := s.summary.SpoolLost; got != 2 {
+		t.Errorf("SpoolLost = %d, want 2", got)
+	}
+	if len(s.lost) != 2 || s.lost[0] != "10" || s.lost[1] != "20" {
+		t.Errorf("lost = %v, want [10 20]", s.lost)
+	}
+	// 汇总仍然计入丢失的结果
+	if s.summary.Lua != 1 || len(s.failed) != 1 || s.failed[0] != "20" {
+		t.Errorf("summary.Lua = %d, failed = %v", s.summary.Lua, s.failed)
+	}
+}
+
+func TestResultSpoolSkipsDroppedDetail(t *testing.T) {
+	s := &resultSpool{w: bufio.NewWriterSize(failingWriter{}, 16), detail: DETAIL_SUMMARY, failed: []string{}}
+	// summary 模式不写临时文件，也就不会丢失
+	if err := s.add(AppResult{AppID: "10", Lua: 1}); err != nil {
+		t.Fatalf("add() = %v, want nil", err)
+	}
+	if s.summary.SpoolLost != 0 {
+		t.Errorf("SpoolLost = %d, want 0", s.summary.SpoolLost)
+	}
+}
+
+// syntheticResults 生成 apps 个 App、每个 App files 个清单的结果
+func syntheticResults(apps, files int) []AppResult {
+	results := make([]AppResult, apps)
+	for i := range results {
+		r := AppResult{AppID: fmt.Sprint(1000 + i), Lua: 1, Manifest: files}
+		for j := 0; j < files; j++ {
+			r.Files = append(r.Files, FileInfo{Name: fmt.Sprintf("%d_%d.manifest", 1000+i, j), Size: 4096, SHA256: strings.Repeat("ab", 32)})
+		}
+		results[i] = r
+	}
+	return results
+}
+
+// jsonValue 把 JSON 解码为通用值，用于忽略字段顺序与空白比较
+func jsonValue(t *testing.T, data []byte) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, data)
+	}
+	return v
+}
+
+func TestWriteResult(t *testing.T) {
+	results := []AppResult{
+		{AppID: "10", Lua: 1, Manifest: 1, Files: []FileInfo{{Name: "11_22.manifest", Size: 3, SHA256: "aa"}}},
+		{AppID: "20", Error: "not found"},
+		{AppID: "30", Lua: 1},
+	}
+	tests := []struct {
+		detail string
+		want   []string // 期望写出的 AppID，nil 表示省略 results 字段
+	}{
+		{DETAIL_FULL, []string{"10", "20", "30"}},
+		{DETAIL_FAILURES_ONLY, []string{"20"}},
+		{DETAIL_SUMMARY, nil},
+	}
+	for _, tt := range tests {
+		for _, spooled := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s spool=%v", tt.detail, spooled), func(t *testing.T) {
+				summary, failed := summarize(results)
+				output := Result{Success: true, Results: results, Summary: summary, Failed: failed, Mirror: "github"}
+				rn := newRun()
+				var spool *resultSpool
+				if spooled {
+					s, err := rn.newResultSpool(tt.detail)
+					if err != nil {
+						t.Fatal(err)
+					}
+					defer s.close()
+					for _, r := range results {
+						s.add(r)
+					}
+					spool = s
+				}
+
+				var buf bytes.Buffer
+				if err := rn.writeResult(&buf, output, spool, tt.detail); err != nil {
+					t.Fatal(err)
+				}
+				if n := strings.Count(buf.String(), "\n"); n != 1 || !strings.HasSuffix(buf.String(), "\n") {
+					t.Errorf("output is %d lines, want a single line", n)
+				}
+				// 与整体 json.Marshal 的结果等价
+				want := output
+				want.Results = nil
+				for _, r := range results {
+					for _, id := range tt.want {
+						if r.AppID == id {
+							want.Results = append(want.Results, r)
+						}
+					}
+				}
+				wantJSON, _ := json.Marshal(want)
+				got, exp := jsonValue(t, buf.Bytes()), jsonValue(t, wantJSON)
+				if tt.want == nil {
+					delete(exp.(map[string]interface{}), "results")
+				}
+				if !reflect.DeepEqual(got, exp) {
+					t.Errorf("writeResult =\n%s\nwant\n%s", buf.Bytes(), wantJSON)
+				}
+			})
+		}
+	}
+}
+
+// writeRecorder 记录单次 Write 的最大长度
+type writeRecorder struct {
+	max, total int
+}
+
+func (w *writeRecorder) Write(p []byte) (int, error) {
+	if len(p) > w.max {
+		w.max = len(p)
+	}
+	w.total += len(p)
+	return len(p), nil
+}
+
+func TestWriteResultStreams(t *testing.T) {
+	// 1000 个 App 共 10 万个清单：每次写出的数据不超过单个 App 的 JSON 或缓冲区大小，不会一次写出整个结果
+	results := syntheticResults(1000, 100)
+	largest := 4096
+	for i := range results {
+		data, _ := json.Marshal(&results[i])
+		if len(data) > largest {
+			largest = len(data)
+		}
+	}
+	summary, failed := summarize(results)
+	var w writeRecorder
+	if err := newRun().writeResult(&w, Result{Success: true, Results: results, Summary: summary, Failed: failed}, nil, DETAIL_FULL); err != nil {
+		t.Fatal(err)
+	}
+	if w.max > largest {
+		t.Errorf("largest write = %d bytes, want at most %d", w.max, largest)
+	}
+	if w.total < 100*largest {
+		t.Errorf("total = %d bytes for 100k files", w.total)
+	}
+}
+
+func TestRunLowMemory(t *testing.T) {
+	r := newTestRepo(t, map[string]string{
+		"a/b/10/10.lua":         "-- 10",
+		"a/b/10/11_22.manifest": testManifest,
+		"a/b/20/20.lua":         "-- 20",
+	})
+	read := func(lowMemory bool) Result {
+		cfg := testConfig(t, map[string][]string{"10": {"11_22"}, "20": nil, "30": nil})
+		cfg.LowMemory = lowMemory
+		r.download(t, cfg)
+		data, err := os.ReadFile(cfg.OutputPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res Result
+		if err := json.Unmarshal(data, &res); err != nil {
+			t.Fatalf("output_path is not valid JSON: %v\n%s", err, data)
+		}
+		// low_memory 的结果顺序为完成顺序，计时字段每次不同
+		sort.Slice(res.Results, func(i, j int) bool { return res.Results[i].AppID < res.Results[j].AppID })
+		for i := range res.Results {
+			res.Results[i].QueueWaitSeconds, res.Results[i].ExecutionSeconds = 0, 0
+			for j := range res.Results[i].Files {
+				res.Results[i].Files[j].Ms = 0
+			}
+		}
+		sort.Strings(res.Failed)
+		res.Summary.QueueWait, res.Summary.Execution = nil, nil
+		return res
+	}
+	spoolFiles := func() []string {
+		matches, _ := filepath.Glob(filepath.Join(os.TempDir(), "downloader-results-*.ndjson"))
+		return matches
+	}
+	before := len(spoolFiles())
+	want, got := read(false), read(true)
+	if len(got.Results) != 3 || !reflect.DeepEqual(got.Results, want.Results) {
+		t.Errorf("low_memory results = %+v\nwant %+v", got.Results, want.Results)
+	}
+	if !reflect.DeepEqual(got.Summary, want.Summary) || !reflect.DeepEqual(got.Failed, want.Failed) {
+		t.Errorf("low_memory summary = %+v, failed %v; want %+v, %v", got.Summary, got.Failed, want.Summary, want.Failed)
+	}
+	// 临时文件在输出后删除
+	if after := spoolFiles(); len(after) != before {
+		t.Errorf("spool files left behind: %v", after)
+	}
+}
+
+func BenchmarkWriteResult(b *testing.B) {
+	results := syntheticResults(1000, 100)
+	summary, failed := summarize(results)
+	output := Result{Success: true, Results: results, Summary: summary, Failed: failed}
+	// max-write-B 是一次性交给 Writer 的最大字节块，即输出时额外占用内存的上限
+	b.Run("stream", func(b *testing.B) {
+		b.ReportAllocs()
+		var w writeRecorder
+		for i := 0; i < b.N; i++ {
+			newRun().writeResult(&w, output, nil, DETAIL_FULL)
+		}
+		b.ReportMetric(float64(w.max), "max-write-B")
+	})
+	b.Run("marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		var w writeRecorder
+		for i := 0; i < b.N; i++ {
+			data, _ := json.Marshal(output)
+			w.Write(data)
+		}
+		b.ReportMetric(float64(w.max), "max-write-B")
+	})
+}
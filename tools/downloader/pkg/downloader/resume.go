@@ -0,0 +1,198 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// STATE_SAVE_INTERVAL 是 state_file 的定期保存间隔，崩溃时最多丢失这段时间内完成的 App
+const STATE_SAVE_INTERVAL = 5 * time.Second
+
+// App 在 state_file 中的状态
+const (
+	STATE_DONE    = "done"    // 全部文件已获取，续跑时跳过
+	STATE_PARTIAL = "partial" // 部分清单失败，续跑时只重试 remaining
+	STATE_FAILED  = "failed"  // 没有拿到任何文件，续跑时完整重试
+)
+
+// appState 是单个 App 的续跑记录
+type appState struct {
+	Status    string            `json:"status"`
+	Remaining []string          `json:"remaining,omitempty"` // partial 时尚未获取的清单条目
+	Files     map[string]string `json:"files,omitempty"`     // 已获取的文件 -> SHA-256，用于下一次运行的 changes
+}
+
+// runState 是 state_file 的内容：与同一仓库、同一配置的上一次运行对应
+type runState struct {
+	rn     *run
+	mu     sync.Mutex
+	path   string
+	dirty  bool
+	luaDir string
+
+	// prior 表示读到了上一次运行的记录，此时 changes 记录本次的变化
+	prior   bool
+	since   string
+	changes RunChanges
+
+	Repo       string               `json:"repo"`
+	ConfigHash string               `json:"config_hash"`
+	Updated    string               `json:"updated"`
+	Apps       map[string]*appState `json:"apps"`
+}
+
+// stateConfigHash 计算影响下载内容的配置的指纹；app_ids 不计入，追加 App 后仍可续跑
+func stateConfigHash(config Config) string {
+	data, _ := json.Marshal(struct {
+		Repos        []string
+		AppData      map[string][]string
+		LuaDir       string
+		ManifestDir  string
+		ManifestOnly bool
+		DirectMode   bool
+		Branches     []string
+		NestByApp    bool `json:",omitempty"` // 默认值不计入，保持旧状态文件的指纹不变
+	}{config.Repos, config.AppData, config.LuaDir, config.ManifestDir, config.ManifestOnly, config.DirectMode, config.Branches, config.NestByApp})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// openRunState 读取 state_file；fresh、文件不存在或与当前仓库/配置不符时从空状态开始
+func (rn *run) openRunState(path string, config Config, fresh bool) *runState {
+	st := &runState{rn: rn, path: path, luaDir: config.LuaDir, Repo: config.Repo, ConfigHash: stateConfigHash(config), Apps: make(map[string]*appState)}
+	if fresh {
+		return st
+	}
+	data, err := rn.fsys.ReadFile(path)
+	if err != nil {
+		return st
+	}
+	var prev runState
+	if err := json.Unmarshal(data, &prev); err != nil {
+		rn.warnf("state_file %s 无法解析，重新开始: %v", path, err)
+		return st
+	}
+	if prev.Repo != st.Repo || prev.ConfigHash != st.ConfigHash {
+		rn.warnf("state_file %s 对应的仓库或配置已变化，重新开始", path)
+		return st
+	}
+	if prev.Apps != nil {
+		st.Apps = prev.Apps
+	}
+	st.prior, st.since = true, prev.Updated
+	return st
+}
+
+// apply 按记录调整本次任务：done 的 App 从 app_ids 中移除并返回，partial 的 App 只保留剩余清单
+func (st *runState) apply(config *Config) []string {
+	var done []string
+	ids := config.AppIDs[:0:0]
+	for _, id := range config.AppIDs {
+		s := st.Apps[id]
+		switch {
+		case s == nil:
+		case s.Status == STATE_DONE:
+			done = append(done, id)
+			continue
+		case s.Status == STATE_PARTIAL && config.AppData != nil:
+			config.AppData[id] = s.Remaining
+			st.rn.manifestsOnly[id] = true
+		}
+		ids = append(ids, id)
+	}
+	config.AppIDs = ids
+	return done
+}
+
+// record 记录一个处理完的 App；运行取消后没有拿到任何文件的 App 保留原有记录
+func (st *runState) record(res AppResult, cancelled bool) {
+	s := &appState{Status: STATE_DONE}
+	switch {
+	case len(res.FailedManifests) > 0 && (!appFailed(res) || st.rn.manifestsOnly[res.AppID]):
+		// 续跑的 partial App 即使这次一个都没拿到，之前获取的文件仍然有效
+		s.Status, s.Remaining = STATE_PARTIAL, res.FailedManifests
+	case appFailed(res) && st.rn.manifestsOnly[res.AppID] && cancelled:
+		return
+	case appFailed(res):
+		if cancelled {
+			return
+		}
+		s.Status = STATE_FAILED
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var prevFiles map[string]string
+	if old := st.Apps[res.AppID]; old != nil {
+		prevFiles = old.Files
+	}
+	s.Files = st.diffApp(res, prevFiles)
+	st.Apps[res.AppID] = s
+	st.dirty = true
+}
+
+// save 写入同目录的临时文件后重命名，保存中途崩溃不会损坏已有的状态文件
+func (st *runState) save() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if !st.dirty {
+		return nil
+	}
+	st.Updated = time.Now().UTC().Format(time.RFC3339)
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(st.path)
+	if err := st.rn.fsys.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := st.rn.fsys.CreateTemp(dir, filepath.Base(st.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = st.rn.renameWithRetry(tmp.Name(), st.path)
+	}
+	if err != nil {
+		st.rn.fsys.Remove(tmp.Name())
+		return err
+	}
+	st.dirty = false
+	return nil
+}
+
+// autosave 每隔 STATE_SAVE_INTERVAL 保存一次，返回的函数停止定期保存并做最后一次保存
+func (st *runState) autosave() func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		t := time.NewTicker(STATE_SAVE_INTERVAL)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				if err := st.save(); err != nil {
+					st.rn.warnf("保存 state_file 失败: %v", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		if err := st.save(); err != nil {
+			st.rn.warnf("保存 state_file 失败: %v", err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// readState 读取 state_file 中每个 App 的状态
+func readState(t *testing.T, path string) map[string]string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("state_file: %v", err)
+	}
+	var st runState
+	if err := json.Unmarshal(data, &st); err != nil {
+		t.Fatalf("state_file: %v", err)
+	}
+	out := map[string]string{}
+	for id, s := range st.Apps {
+		out[id] = s.Status
+	}
+	return out
+}
+
+func TestStatePathIncremental(t *testing.T) {
+	r := newTestRepo(t, map[string]string{
+		"a/b/10/10.lua":         "-- 10",
+		"a/b/10/11_22.manifest": testManifest,
+		"a/b/20/20.lua":         "-- 20",
+		"a/b/20/21_44.manifest": testManifest + "21",
+		// 20/22_55 第一次运行时不存在，20 只部分完成
+	})
+	cfg := testConfig(t, map[string][]string{"10": {"11_22"}, "20": {"21_44", "22_55"}})
+	cfg.StatePath = filepath.Join(t.TempDir(), "state.json")
+
+	steps := []struct {
+		name    string
+		before  func()
+		force   bool
+		fresh   bool
+		resumed []string          // result 中的 resumed_done
+		fetched []string          // 本次请求的路径
+		state   map[string]string // 运行后 state_file 中的状态
+	}{
+		{name: "fresh",
+			fetched: []string{"a/b/10/10.lua", "a/b/10/11_22.manifest", "a/b/20/20.lua", "a/b/20/21_44.manifest", "a/b/20/22_55.manifest"},
+			state:   map[string]string{"10": STATE_DONE, "20": STATE_PARTIAL}},
+		// partial 的 App 只重试剩余清单，不再请求 Lua
+		{name: "partial", before: func() {
+			r.handle("a/b/20/22_55.manifest", func(w http.ResponseWriter, _ *http.Request) { io.WriteString(w, testManifest+"22") })
+		},
+			resumed: []string{"10"},
+			fetched: []string{"a/b/20/22_55.manifest"},
+			state:   map[string]string{"10": STATE_DONE, "20": STATE_DONE}},
+		{name: "fully cached",
+			resumed: []string{"10", "20"},
+			state:   map[string]string{"10": STATE_DONE, "20": STATE_DONE}},
+		{name: "force", force: true,
+			fetched: []string{"a/b/10/10.lua", "a/b/10/11_22.manifest", "a/b/20/20.lua", "a/b/20/21_44.manifest", "a/b/20/22_55.manifest"},
+			state:   map[string]string{"10": STATE_DONE, "20": STATE_DONE}},
+		// -fresh 丢弃未涉及的 App 的记录，force 则保留
+		{name: "fresh flag", fresh: true, before: func() { cfg.AppIDs = []string{"10"} },
+			fetched: []string{"a/b/10/10.lua", "a/b/10/11_22.manifest"},
+			state:   map[string]string{"10": STATE_DONE}},
+	}
+	paths := []string{"a/b/10/10.lua", "a/b/10/11_22.manifest", "a/b/20/20.lua", "a/b/20/21_44.manifest", "a/b/20/22_55.manifest"}
+	for _, step := range steps {
+		if step.before != nil {
+			step.before()
+		}
+		before := map[string]int{}
+		for _, p := range paths {
+			before[p] = r.count(p)
+		}
+		cfg.Force = step.force
+		c := r.client()
+		c.Fresh = step.fresh
+		res, err := c.Run(context.Background(), cfg)
+		if err != nil {
+			t.Fatalf("%s: Run: %v", step.name, err)
+		}
+		resumed := slices.Clone(res.ResumedDone)
+		slices.Sort(resumed)
+		if !slices.Equal(resumed, step.resumed) {
+			t.Errorf("%s: resumed_done = %v, want %v", step.name, resumed, step.resumed)
+		}
+		var fetched []string
+		for _, p := range paths {
+			if r.count(p) > before[p] {
+				fetched = append(fetched, p)
+			}
+		}
+		if !slices.Equal(fetched, step.fetched) {
+			t.Errorf("%s: fetched %v, want %v", step.name, fetched, step.fetched)
+		}
+		if got := readState(t, cfg.StatePath); !reflect.DeepEqual(got, step.state) {
+			t.Errorf("%s: state = %v, want %v", step.name, got, step.state)
+		}
+	}
+}
+
+func TestStateFileConfigChanged(t *testing.T) {
+	r := newTestRepo(t, map[string]string{"a/b/10/10.lua": "-- 10", "a/b/10/11_22.manifest": testManifest})
+	cfg := testConfig(t, map[string][]string{"10": {"11_22"}})
+	cfg.StateFile = filepath.Join(t.TempDir(), "state.json")
+	r.download(t, cfg)
+	// 影响下载内容的配置变化后不沿用旧记录
+	cfg.ManifestDir = filepath.Join(t.TempDir(), "other")
+	if res := r.download(t, cfg); len(res.ResumedDone) != 0 || r.count("a/b/10/11_22.manifest") != 2 {
+		t.Errorf("resumed_done = %v after config change", res.ResumedDone)
+	}
+}
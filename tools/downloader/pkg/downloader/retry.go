@@ -0,0 +1,152 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// 重试退避默认值 (retry_base_ms / retry_max_ms)
+const (
+	DEFAULT_RETRY_BASE_MS = 200
+	DEFAULT_RETRY_MAX_MS  = 5000
+)
+
+// retryPolicy 决定单个地址的尝试次数与退避时间：指数退避 + 完全抖动 (full jitter)
+type retryPolicy struct {
+	attempts int           // 总尝试次数 (max_retries，含第一次)
+	base     time.Duration // 第一次重试前退避上限
+	max      time.Duration // 单次退避上限
+}
+
+// defaultRetry 是未配置重试参数时的策略
+var defaultRetry = retryPolicy{
+	attempts: MAX_RETRIES,
+	base:     DEFAULT_RETRY_BASE_MS * time.Millisecond,
+	max:      DEFAULT_RETRY_MAX_MS * time.Millisecond,
+}
+
+// RetryStats 是本次运行中所有下载请求的重试计数 (结果的 stats)，用于判断运行变慢的原因
+type RetryStats struct {
+	Retries     int64 `json:"retries"`      // 重试次数 (不含每个地址的第一次尝试)
+	RateLimited int64 `json:"rate_limited"` // 返回 429 或 GitHub 限流 403 的尝试数
+	Failures    int64 `json:"failures"`     // 重试用完或不可重试而最终失败的地址数 (不含 404、304 与运行取消)
+	Cancelled   int64 `json:"cancelled"`    // 同一条目的其它候选胜出后被中止的请求数 (不计入 failures)
+}
+
+// snapshot 返回当前计数
+func (s *RetryStats) snapshot() RetryStats {
+	return RetryStats{
+		Retries:     atomic.LoadInt64(&s.Retries),
+		RateLimited: atomic.LoadInt64(&s.RateLimited),
+		Failures:    atomic.LoadInt64(&s.Failures),
+		Cancelled:   atomic.LoadInt64(&s.Cancelled),
+	}
+}
+
+// newRetryPolicy 按配置构造重试策略，未设置 (<= 0) 的字段使用默认值
+func newRetryPolicy(attempts, baseMs, maxMs int) retryPolicy {
+	p := defaultRetry
+	if attempts > 0 {
+		p.attempts = attempts
+	}
+	if baseMs > 0 {
+		p.base = time.Duration(baseMs) * time.Millisecond
+	}
+	if maxMs > 0 {
+		p.max = time.Duration(maxMs) * time.Millisecond
+	}
+	if p.max < p.base {
+		p.max = p.base
+	}
+	return p
+}
+
+// backoff 返回第 attempt 次失败 (从 1 开始) 后的等待时间：[0, min(max, base*2^(attempt-1))) 内随机
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	ceil := p.base
+	for i := 1; i < attempt && ceil < p.max; i++ {
+		ceil *= 2
+	}
+	if ceil > p.max {
+		ceil = p.max
+	}
+	if ceil <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceil)))
+}
+
+// retryable 判断错误是否值得对同一地址重试：网络错误、超时、408、429、5xx 与传输损坏重试；
+// 404/304、磁盘错误、401 与非限流的 403 (凭据问题)、其它 4xx 以及内容本身无效 (错误页面、未知文件头) 立即返回
+func (rn *run) retryable(err error) bool {
+	var de *diskError
+	var fe *filteredError
+	var be *budgetError
+	var te *tooLargeError
+	if errors.Is(err, errNotModified) || errors.As(err, &de) || errors.As(err, &fe) || errors.As(err, &be) || errors.As(err, &te) {
+		return false
+	}
+	var ce *corruptError
+	if errors.As(err, &ce) && ce.permanent {
+		return false
+	}
+	var se *statusError
+	if !errors.As(err, &se) {
+		return true
+	}
+	switch {
+	case se.code == 408 || se.code == 429 || se.code >= 500:
+		return true
+	case se.rateLimited:
+		// 有多个 Token 时重试会换用未被限流的 Token
+		return rn.tokens != nil
+	}
+	return false
+}
+
+// withRetry 按 retry 策略执行 fn，运行被取消时立即返回
+func (rn *run) withRetry(ctx context.Context, url string, fn func() (download, error)) (download, error) {
+	p := rn.retry
+	var lastErr error
+	for attempt := 1; attempt <= p.attempts; attempt++ {
+		d, err := fn()
+		if err == nil {
+			return d, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			if errors.Is(context.Cause(ctx), errLostRace) {
+				atomic.AddInt64(&rn.retryStats.Cancelled, 1)
+			}
+			return download{}, err
+		}
+		if statusCode(err) == 429 || isRateLimited(err) {
+			atomic.AddInt64(&rn.retryStats.RateLimited, 1)
+		}
+		if !rn.retryable(err) || attempt == p.attempts {
+			break
+		}
+		atomic.AddInt64(&rn.retryStats.Retries, 1)
+		statsFrom(ctx).retry()
+		wait := p.backoff(attempt)
+		rn.verbosef("GET %s 第 %d/%d 次尝试失败 (%s)，%s 后重试", url, attempt, p.attempts, errorReason(err), wait.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return download{}, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	if ctx.Err() == nil && statusCode(lastErr) != 404 && !errors.Is(lastErr, errNotModified) {
+		atomic.AddInt64(&rn.retryStats.Failures, 1)
+	}
+	return download{}, lastErr
+}
+
+// isRateLimited 判断错误是否为 GitHub 的限流 403 (X-RateLimit-Remaining: 0)
+func isRateLimited(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && se.rateLimited
+}
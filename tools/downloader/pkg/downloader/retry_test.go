@@ -0,0 +1,375 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"network", errors.New("connection reset"), true},
+		{"timeout", &timeoutError{err: context.DeadlineExceeded}, true},
+		{"500", &statusError{code: 500}, true},
+		{"503", &statusError{code: 503}, true},
+		{"429", &statusError{code: 429}, true},
+		{"408", &statusError{code: 408}, true},
+		{"404", &statusError{code: 404}, false},
+		{"401", &statusError{code: 401}, false},
+		{"403", &statusError{code: 403}, false},
+		{"304", errNotModified, false},
+		{"disk", &diskError{err: errors.New("no space")}, false},
+		{"too large", &tooLargeError{limit: 1}, false},
+		{"truncated", &corruptError{reason: "长度不符"}, true},
+		{"checksum", &checksumError{reason: "sha256 不符"}, true},
+		{"error page", &corruptError{reason: "内容是错误页面", permanent: true}, false},
+		{"wrapped error page", fmt.Errorf("a/b: %w", &corruptError{reason: "未知文件头", permanent: true}), false},
+	}
+	rn := newRun()
+	for _, tt := range tests {
+		if got := rn.retryable(tt.err); got != tt.want {
+			t.Errorf("retryable(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateManifestPermanence(t *testing.T) {
+	tests := []struct {
+		name      string
+		n, length int64
+		head      string
+		strict    bool
+		permanent bool
+	}{
+		{"empty", 0, 0, "", false, false},
+		{"short transfer", 10, 20, testManifest[:10], false, false},
+		{"html", 20, 20, "<html><body>404", false, true},
+		{"api error", 20, 20, `{"message":"Not Found"}`, false, true},
+		{"text", 20, 20, "just some text here", false, true},
+		{"unknown magic (steam-safe)", 20, 20, "\x00\x01\x02\x03binary", true, true},
+	}
+	rn := newRun()
+	for _, tt := range tests {
+		err := rn.validateManifest(tt.n, tt.length, []byte(tt.head), tt.strict)
+		var ce *corruptError
+		if !errors.As(err, &ce) {
+			t.Fatalf("%s: err = %v, want corruptError", tt.name, err)
+		}
+		if ce.permanent != tt.permanent {
+			t.Errorf("%s: permanent = %v, want %v", tt.name, ce.permanent, tt.permanent)
+		}
+	}
+}
+
+func TestErrorPageNotRetried(t *testing.T) {
+	r := newTestRepo(t, map[string]string{"a/b/10/10.lua": "-- lua"})
+	page := "a/b/10/11_22.manifest"
+	r.handle(page, func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, "<!DOCTYPE html><html>rate limited</html>")
+	})
+	cfg := testConfig(t, map[string][]string{"10": {"11_22"}})
+	cfg.MaxRetries = 3
+	res := r.download(t, cfg)
+	if got := r.count(page); got != 1 {
+		t.Errorf("error page requested %d times, want 1 (no retry)", got)
+	}
+	if len(res.Results) != 1 || len(res.Results[0].InvalidFiles) != 1 {
+		t.Fatalf("results = %+v, want one invalid file", res.Results)
+	}
+}
+
+func TestBackoffGrowsWithJitter(t *testing.T) {
+	p := newRetryPolicy(8, 100, 1000)
+	const samples = 2000
+	prevMean := time.Duration(0)
+	for attempt, ceil := 1, 100*time.Millisecond; attempt <= 6; attempt++ {
+		var sum, max time.Duration
+		seen := map[time.Duration]bool{}
+		for i := 0; i < samples; i++ {
+			d := p.backoff(attempt)
+			if d < 0 || d >= ceil {
+				t.Fatalf("backoff(%d) = %v, want [0, %v)", attempt, d, ceil)
+			}
+			sum += d
+			seen[d] = true
+			if d > max {
+				max = d
+			}
+		}
+		mean := sum / samples
+		if len(seen) < samples/2 {
+			t.Errorf("backoff(%d) returned only %d distinct delays, want jitter", attempt, len(seen))
+		}
+		if max < ceil*8/10 {
+			t.Errorf("backoff(%d) max = %v, want close to %v", attempt, max, ceil)
+		}
+		// 达到 retry_max_ms 之前，平均等待随尝试次数翻倍
+		if attempt > 1 && ceil < p.max && mean < prevMean*3/2 {
+			t.Errorf("backoff(%d) mean = %v, previous %v: want growth", attempt, mean, prevMean)
+		}
+		prevMean = mean
+		if ceil *= 2; ceil > p.max {
+			ceil = p.max
+		}
+	}
+}
+
+func TestRetryDelaysBetweenAttempts(t *testing.T) {
+	// 记录每次尝试到达服务器的时间：间隔不超过各次的退避上限，且互不相同 (带抖动)
+	r := newTestRepo(t, map[string]string{"a/b/10/10.lua": "-- lua"})
+	page := "a/b/10/11_22.manifest"
+	var mu sync.Mutex
+	var arrivals []time.Time
+	r.handle(page, func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		arrivals = append(arrivals, time.Now())
+		n := len(arrivals)
+		mu.Unlock()
+		if n < 5 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, testManifest)
+	})
+	cfg := testConfig(t, map[string][]string{"10": {"11_22"}})
+	cfg.MaxRetries, cfg.RetryBaseMs, cfg.RetryMaxMs = 5, 40, 160
+	res := r.download(t, cfg)
+
+	if len(arrivals) != 5 || res.Summary.Manifest != 1 {
+		t.Fatalf("attempts = %d, manifest = %d; want 5 attempts and a manifest", len(arrivals), res.Summary.Manifest)
+	}
+	if res.Stats.Retries != 4 {
+		t.Errorf("stats.retries = %d, want 4", res.Stats.Retries)
+	}
+	const slack = 100 * time.Millisecond
+	gaps := map[time.Duration]bool{}
+	for i, ceil := 1, 40*time.Millisecond; i < len(arrivals); i++ {
+		gap := arrivals[i].Sub(arrivals[i-1])
+		if gap > ceil+slack {
+			t.Errorf("gap %d = %v, want below %v", i, gap, ceil)
+		}
+		gaps[gap] = true
+		if ceil *= 2; ceil > 160*time.Millisecond {
+			ceil = 160 * time.Millisecond
+		}
+	}
+	if len(gaps) < 2 {
+		t.Errorf("all gaps equal (%v), want jitter", gaps)
+	}
+}
+
+func TestNotFoundNotRetried(t *testing.T) {
+	r := newTestRepo(t, map[string]string{"a/b/10/10.lua": "-- lua"})
+	cfg := testConfig(t, map[string][]string{"10": {"11_22"}})
+	cfg.MaxRetries = 5
+	res := r.download(t, cfg)
+	if got := r.count("a/b/10/11_22.manifest"); got != 1 {
+		t.Errorf("missing manifest requested %d times, want 1", got)
+	}
+	if res.Stats.Retries != 0 || res.Stats.Failures != 0 {
+		t.Errorf("stats = %+v, want no retries or failures for 404", res.Stats)
+	}
+}
+
+func TestRetryStatsCounters(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int // 依次返回的状态码，用完后返回清单
+		want     RetryStats
+		manifest int
+	}{
+		{"success", nil, RetryStats{}, 1},
+		{"two transient", []int{503, 502}, RetryStats{Retries: 2}, 1},
+		{"rate limited", []int{429}, RetryStats{Retries: 1, RateLimited: 1}, 1},
+		{"exhausted", []int{500, 500, 500}, RetryStats{Retries: 2, Failures: 1}, 0},
+		{"not retryable", []int{401}, RetryStats{Failures: 1}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepo(t, map[string]string{"a/b/10/10.lua": "-- lua"})
+			var mu sync.Mutex
+			n := 0
+			r.handle("a/b/10/11_22.manifest", func(w http.ResponseWriter, _ *http.Request) {
+				mu.Lock()
+				i := n
+				n++
+				mu.Unlock()
+				if i < len(tt.statuses) {
+					w.Header().Set("Retry-After", "0")
+					w.WriteHeader(tt.statuses[i])
+					return
+				}
+				io.WriteString(w, testManifest)
+			})
+			cfg := testConfig(t, map[string][]string{"10": {"11_22"}})
+			cfg.MaxRetries = 3
+			res := r.download(t, cfg)
+			got := res.Stats
+			got.Cancelled = 0 // 竞速落选的候选 (depots.lua 等) 与本用例无关
+			if got != tt.want {
+				t.Errorf("stats = %+v, want %+v", res.Stats, tt.want)
+			}
+			if res.Summary.Manifest != tt.manifest {
+				t.Errorf("manifest = %d, want %d", res.Summary.Manifest, tt.manifest)
+			}
+		})
+	}
+}
+
+func TestRetryAttemptsLogged(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries int
+		fail       int // 前 fail 次返回 status
+		status     int
+		attempts   int
+		manifest   int
+	}{
+		{"first try", 5, 0, 0, 1, 1},
+		{"fails twice", 5, 2, 503, 3, 1},
+		{"fails four times", 5, 4, 408, 5, 1},
+		{"single attempt", 1, 1, 503, 1, 0},
+		{"404 short-circuits", 5, 5, 404, 1, 0},
+		{"401 short-circuits", 5, 5, 401, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepo(t, map[string]string{"a/b/10/10.lua": "-- lua"})
+			page := "a/b/10/11_22.manifest"
+			var mu sync.Mutex
+			n := 0
+			r.handle(page, func(w http.ResponseWriter, _ *http.Request) {
+				mu.Lock()
+				n++
+				i := n
+				mu.Unlock()
+				if i <= tt.fail {
+					w.WriteHeader(tt.status)
+					return
+				}
+				io.WriteString(w, testManifest)
+			})
+			cfg := testConfig(t, map[string][]string{"10": {"11_22"}})
+			cfg.MaxRetries = tt.maxRetries
+			// log_file 总是记录 verbose 的下载尝试日志
+			cfg.LogFile = filepath.Join(t.TempDir(), "run.log")
+			res := r.download(t, cfg)
+			if got := r.count(page); got != tt.attempts {
+				t.Errorf("attempts = %d, want %d", got, tt.attempts)
+			}
+			if res.Summary.Manifest != tt.manifest {
+				t.Errorf("manifest = %d, want %d", res.Summary.Manifest, tt.manifest)
+			}
+			data, err := os.ReadFile(cfg.LogFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var retries []string
+			for _, line := range strings.Split(string(data), "\n") {
+				if strings.Contains(line, "11_22.manifest 第 ") {
+					retries = append(retries, line)
+				}
+			}
+			// 每次重试前记录一行，带尝试序号与总次数
+			if len(retries) != tt.attempts-1 {
+				t.Fatalf("retry log lines = %q, want %d", retries, tt.attempts-1)
+			}
+			for i, line := range retries {
+				if want := fmt.Sprintf("第 %d/%d 次尝试失败 (%d)", i+1, tt.maxRetries, tt.status); !strings.Contains(line, want) {
+					t.Errorf("retry log %q, want %q", line, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	tests := []struct {
+		name  string
+		stall func(w http.ResponseWriter) // 在 handler 阻塞之前发送的内容
+	}{
+		{"before headers", func(http.ResponseWriter) {}},
+		{"mid body", func(w http.ResponseWriter) {
+			// 超时同样覆盖读取响应体
+			w.Header().Set("Content-Length", "1000")
+			io.WriteString(w, testManifest)
+			w.(http.Flusher).Flush()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepo(t, nil)
+			r.handle("slow", func(w http.ResponseWriter, req *http.Request) {
+				tt.stall(w)
+				select {
+				case <-req.Context().Done():
+				case <-time.After(5 * time.Second):
+				}
+			})
+			rn := newRun()
+			rn.requestTimeout = 50 * time.Millisecond
+			dest := filepath.Join(t.TempDir(), "11_22.manifest")
+			start := time.Now()
+			_, err := rn.downloadFile(context.Background(), r.srv.URL+"/slow", dest, "", "")
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("request took %v, want it aborted after the timeout", elapsed)
+			}
+			var te *timeoutError
+			if !errors.As(err, &te) || te.timeout != rn.requestTimeout {
+				t.Fatalf("err = %v, want timeoutError", err)
+			}
+			if classifyError(err) != KIND_NETWORK || !rn.retryable(err) {
+				t.Errorf("kind = %q, retryable = %v", classifyError(err), rn.retryable(err))
+			}
+			if got := listFiles(t, filepath.Dir(dest)); len(got) != 0 {
+				t.Errorf("files left after timeout: %v", got)
+			}
+		})
+	}
+}
+
+func TestRequestTimeoutVersusCancel(t *testing.T) {
+	// 整体运行被取消时不应报告为单个请求超时
+	r := newTestRepo(t, nil)
+	r.handle("slow", func(w http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+	})
+	rn := newRun()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := rn.downloadFile(ctx, r.srv.URL+"/slow", filepath.Join(t.TempDir(), "x"), "", "")
+	var te *timeoutError
+	if err == nil || errors.As(err, &te) {
+		t.Errorf("err = %v, want a cancellation rather than timeoutError", err)
+	}
+}
+
+func TestRequestTimeoutConfig(t *testing.T) {
+	r := newTestRepo(t, map[string]string{"a/b/10/10.lua": "-- 10"})
+	r.handle("a/b/10/11_22.manifest", func(w http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+	})
+	cfg := testConfig(t, map[string][]string{"10": {"11_22"}})
+	cfg.RequestTimeoutSeconds = 1
+	cfg.MaxRetries = 2
+	start := time.Now()
+	res := r.download(t, cfg)
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("run took %v with request_timeout_seconds 1", elapsed)
+	}
+	if res.Summary.Lua != 1 || res.Summary.Manifest != 0 || r.count("a/b/10/11_22.manifest") != 2 {
+		t.Errorf("summary = %+v, manifest requests = %d; want the manifest to time out on both attempts", res.Summary, r.count("a/b/10/11_22.manifest"))
+	}
+}
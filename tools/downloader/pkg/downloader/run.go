@@ -0,0 +1,316 @@
+package downloader
+
+import (
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// run 是一次 Run/Explain/Doctor 的全部运行状态：配置派生的参数、计数、去重表与源统计。
+// 每次调用由 Client 新建一个 run，下载流程中的函数都是它的方法，
+// 因此同一进程内的多个 Client (或同一 Client 的多次调用) 可以并发运行、互不影响
+type run struct {
+	// abortRun 取消整个运行并记录原因，只有第一次调用生效；Run 中替换为实际的取消函数
+	abortRun func(err *abortError)
+
+	// branches / useAppBranch 是配置的清单分支列表 (branches / use_app_branch)，为空时使用默认顺序
+	branches     []string
+	useAppBranch bool
+	// defaultBranches 是通过 API 查到的各仓库默认分支，在工作协程启动前写入，之后只读
+	defaultBranches map[string]string
+
+	// deadBranches 记录 (仓库, 分支, App) 中已确认没有该 App 文件的分支：某个清单条目在该分支上的候选全部 404、
+	// 随后在同一仓库的其它分支找到时，后续条目直接跳过该分支，不再逐个候选发出 404 请求。
+	// 同一 App 的清单条目协程并发读写，因此加锁。
+	deadBranches struct {
+		sync.Mutex
+		m map[string]bool
+	}
+
+	// budgets 是 source_budgets 按主机名 (小写，可带端口) 建立的预算，在 prepare 中设置，运行期间只读
+	budgets map[string]*sourceBudget
+
+	// luaETags 是 conditional_sync 时 lua_dir 的 ETag 索引，在 processAllApps 中设置，未开启时为 nil
+	luaETags *etagCache
+
+	// caseProbe 判断目录所在的文件系统是否不区分大小写 (Windows、macOS 默认)。
+	// 默认实现在目录中写一个探测文件；测试可替换为固定结果，在任何系统上覆盖两种情况。
+	caseProbe func(dir string) bool
+
+	// caseDirs 缓存每个目标目录的探测结果，每次运行只探测一次
+	caseDirs struct {
+		sync.Mutex
+		m map[string]bool
+	}
+
+	// destinations 以 destKey 为键记录本次运行写入的全部目标文件。
+	// 不区分大小写的文件系统上 228990_ABC.manifest 与 228990_abc.manifest 是同一个文件，
+	// 后到的写入按与分支包扁平化同名相同的规则处理：保留先出现的一个，并记为冲突。
+	destinations struct {
+		sync.Mutex
+		m map[string]*destClaim
+	}
+
+	// checksums 是 checksums 配置 (仓库内路径或本地文件名 -> SHA-256)，键已规范化
+	checksums map[string]string
+
+	// contentsAPI 记录每个仓库的下载方式。raw 地址对私有仓库的 Authorization 支持不稳定，
+	// 带 Token 时 raw 返回 404/403 的请求会通过 contents API 再试一次。
+	contentsAPI *repoModes
+
+	// dedupHits 是复用其它 App 下载结果 (未再次请求) 的清单数
+	dedupHits int64
+	// manifestFlights 以 "depot_manifest" 为键在整个运行期间去重：多个 App 共享同一 depot
+	// (常见于公共运行库) 时只下载一次，避免多个协程同时写同一个目标文件
+	manifestFlights struct {
+		sync.Mutex
+		m map[string]*manifestFlight
+	}
+
+	dedupReportMu   sync.Mutex
+	dedupReportApps map[string][]string // AppID -> 本次下载的清单路径
+
+	// httpClient 在所有请求间共享以复用连接；超时由每个请求的 context 控制
+	httpClient *http.Client
+
+	// requestTimeout 是单个请求的超时时间 (request_timeout_seconds)
+	requestTimeout time.Duration
+
+	// extendedCandidates 为 true 时清单候选名额外包含大小写与扩展名变体 (extended_candidates)
+	extendedCandidates bool
+
+	// luaTemplates 是本次运行使用的 Lua 路径模板 (lua_path_templates)
+	luaTemplates []string
+
+	// probeDelay 是同一条目相邻候选探测之间的间隔 (probe_delay_ms)
+	probeDelay time.Duration
+
+	downloadedCount int64
+	totalTaskCount  int64
+	totalBytes      int64 // 全部成功写入的字节数 (含二级并行)
+	probeDelayTotal int64 // probe_delay_ms 在限速等待之外额外增加的等待时间 (纳秒，各协程之和)
+	debugEnabled    bool
+	verboseEnabled  bool
+
+	// dryRun 对应 dry_run / -dry-run：按正常流程解析分支与候选名，但只确认文件是否存在，不写任何文件。
+	// 结果中的 lua / manifest 表示 "找到" 而不是 "已下载"
+	dryRun bool
+
+	// progressJSON 对应 progress_format: json
+	progressJSON bool
+
+	// structuredOutput 为 true 时 (structured_output) 所有输出都是写入 stdout 的 NDJSON，
+	// 每行带 "type" 字段，最后一行为 {"type":"result",...}；优先于 progress_format
+	structuredOutput bool
+
+	// eventHook 由 Client.OnEvent 设置；不论输出格式如何都会收到全部事件
+	eventHook func(Event)
+
+	// eventSeq 与 events 由 logMu 保护：分配序号、写入缓冲与输出在同一把锁内完成，输出行的 seq 严格递增
+	eventSeq int64
+	events   *eventBuffer
+
+	// flattenMu 串行化 flatten_symlinks 对根目录的写入：共享同一清单的 App 会链接到同一个文件
+	flattenMu sync.Mutex
+
+	// filters 统计非 GitHub 的 HTML 403 页面，判定网络被过滤时终止运行 (ignore_network_filter 时只警告)
+	filters *filterDetector
+
+	// dl 与 fsys 是全部请求与文件读写使用的实现，默认为 httpClient 与 osFS；
+	// prepare 重建 httpClient 后同步更新 dl，Client 设置了 Downloader / FileSystem 时改用它们
+	dl   Downloader
+	fsys FileSystem
+
+	appListMu  sync.Mutex
+	appListIDs map[string][]string // 处理成功的 AppID -> Lua 中发现的 DLC ID
+
+	userAgent    string            // user_agent 配置，默认 DEFAULT_USER_AGENT
+	extraHeaders map[string]string // headers 配置的附加请求头
+
+	// probeWithHead 为 true 时清单候选先用 HEAD 确认存在，只对返回 200 的候选发 GET (probe_with_head)
+	probeWithHead bool
+
+	// headUnsupported 按主机 (urlHost) 记录对 HEAD 返回 405/501 的下载源，本次运行对它们直接发 GET。
+	// probe_with_head 与 dry_run 共用，只通过 headRejected / rejectHead 访问
+	headUnsupported struct {
+		sync.Mutex
+		m map[string]bool
+	}
+
+	keysMu        sync.Mutex
+	collectedKeys map[string]string // 本次运行获取到的全部 depot 密钥，供合并到 config.vdf
+
+	// consoleLevel 是文本模式下写到终端 (logOut 与 debug/verbose 的 stderr) 的最低级别 (默认 info)。
+	// 只影响终端输出：log_dir / log_file、JSON 进度事件与 OnEvent 回调不受影响
+	consoleLevel int
+
+	// logFileEnabled 表示设置了 log_file：debug 与 verbose 日志即使不输出到终端也写入该文件。
+	// 在 prepare 中设置，运行期间只读
+	logFileEnabled bool
+
+	// rawBase 是本次运行实际使用的直连地址，默认 RAW_BASE，可由 Client.RawBase 覆盖 (例如指向测试服务器)
+	rawBase string
+
+	// sources 是直连与 mirrors 组成的下载源，连续失败过多的源排在最后
+	sources *sourceSet
+
+	// routeNetworks 是路由检查后限定地址族的 "host:port" -> tcp4 | tcp6，只有一个地址族可用时才设置。
+	// 在下载开始前写入，Transport 拨号时读取
+	routeNetworks struct {
+		sync.RWMutex
+		m map[string]string
+	}
+
+	// logOut 是文本模式下 [INFO]/[WARN]/[ERROR]/[PROGRESS] 行的去向：默认与结果一起写 stdout (调用方取最后一行 JSON)，
+	// output_path 为 "-" 或 progress_format 为 json 时改写到 stderr
+	logOut *os.File
+
+	planByApp map[string][]PlanEntry // AppID -> plan 条目
+	planOnly  map[string]bool        // 只出现在 plan 中的 App，跳过全部探测
+
+	// apiBase 是本次运行实际使用的 API 地址，默认 API_BASE，可由 Client.APIBase 覆盖
+	apiBase string
+
+	profilesMu  sync.Mutex
+	profileApps map[string]profileApp
+
+	// proxyURL 是 proxy 配置解析后的代理地址，为 nil 时使用 HTTP_PROXY / HTTPS_PROXY 环境变量
+	proxyURL *url.URL
+
+	// limiter 为 nil 表示不限速
+	limiter *hostLimiter
+	// clock 是限速与探测间隔的时间来源
+	clock clock
+
+	// steamInfoURL 是本次运行使用的 steam_info_url
+	steamInfoURL string
+
+	// resumeState 非空时记录每个 App 的完成状态 (state_file)
+	resumeState *runState
+
+	// manifestsOnly 是续跑中只需重试剩余清单的 App，不再请求 Lua
+	manifestsOnly map[string]bool
+
+	// retry 是本次运行的重试策略
+	retry retryPolicy
+
+	// retryStats 是 RetryStats 的运行期计数，以原子操作更新
+	retryStats RetryStats
+
+	// runLog 是本次运行的日志文件 (log_dir)；debugLog 是 log_file，额外记录 debug 级别的每次请求、状态码、重试与耗时
+	runLog, debugLog logSink
+
+	// detailedStats 对应 detailed_stats：记录每个 App、每个清单的字节数、耗时与请求次数
+	detailedStats bool
+
+	// steamSafe 为 true 时清单文件走 steam-safe 写入路径 (steam_safe_writes 或检测到 depotcache 目录)
+	steamSafe bool
+
+	// storageMounts 是本次运行中使用远程存储的目标目录，不在其中的路径都写本地磁盘
+	storageMounts []storageMount
+
+	summaryMu   sync.Mutex
+	summaryApps map[string]summaryApp
+
+	// tokens 在配置了两个以上 Token 时非空
+	tokens *tokenPool
+
+	// 连接复用统计，-verbose 时在运行结束输出，用于衡量连接池参数的效果
+	connsOpened   int64 // 新建的连接数
+	connsReused   int64 // 复用空闲连接的请求数
+	tlsHandshakes int64 // TLS 握手次数
+
+	// connTrace 挂在每个下载请求上统计连接复用。不设置 Accept-Encoding 时 Transport 会自动请求 gzip 并透明解压。
+	connTrace *httptrace.ClientTrace
+
+	// minManifestSize 是有效清单的最小字节数 (min_manifest_size，默认 1 即只拒绝 0 字节)
+	minManifestSize int64
+
+	// maxFileBytes 是单个下载文件的字节数上限 (max_file_bytes)，0 表示不限制
+	maxFileBytes int64
+
+	// contentCheck 为 false 时 (disable_content_check) 只校验大小，不检查错误页面、文本与文件头
+	contentCheck bool
+
+	fileVanishedCount int64 // 写完后、重命名前临时文件消失的次数
+
+	activeAppWorkers  int64 // 正在运行的 App 工作协程数
+	activeItemWorkers int64 // 正在运行的清单条目协程数
+}
+
+// newRun 返回未应用任何配置的运行状态 (与不带配置的命令行默认值相同)，prepare 再按配置修改
+func newRun() *run {
+	rn := &run{
+		abortRun:        func(err *abortError) {},
+		defaultBranches: make(map[string]string),
+		deadBranches: struct {
+			sync.Mutex
+			m map[string]bool
+		}{m: make(map[string]bool)},
+		caseDirs: struct {
+			sync.Mutex
+			m map[string]bool
+		}{m: make(map[string]bool)},
+		destinations: struct {
+			sync.Mutex
+			m map[string]*destClaim
+		}{m: make(map[string]*destClaim)},
+		contentsAPI: &repoModes{m: make(map[string]int)},
+		manifestFlights: struct {
+			sync.Mutex
+			m map[string]*manifestFlight
+		}{m: make(map[string]*manifestFlight)},
+		dedupReportApps: make(map[string][]string),
+		requestTimeout:  DEFAULT_REQUEST_TIMEOUT * time.Second,
+		luaTemplates:    LUA_PATH_TEMPLATES,
+		events:          newEventBuffer(0),
+		fsys:            osFS{},
+		clock:           realClock{},
+		appListIDs:      make(map[string][]string),
+		userAgent:       DEFAULT_USER_AGENT,
+		headUnsupported: struct {
+			sync.Mutex
+			m map[string]bool
+		}{m: make(map[string]bool)},
+		collectedKeys: make(map[string]string),
+		consoleLevel:  levelInfo,
+		rawBase:       RAW_BASE,
+		routeNetworks: struct {
+			sync.RWMutex
+			m map[string]string
+		}{m: make(map[string]string)},
+		logOut:          os.Stdout,
+		planByApp:       make(map[string][]PlanEntry),
+		planOnly:        make(map[string]bool),
+		apiBase:         API_BASE,
+		profileApps:     make(map[string]profileApp),
+		steamInfoURL:    DEFAULT_STEAM_INFO_URL,
+		manifestsOnly:   make(map[string]bool),
+		retry:           defaultRetry,
+		summaryApps:     make(map[string]summaryApp),
+		minManifestSize: 1,
+		contentCheck:    true,
+	}
+	rn.caseProbe = rn.probeCaseInsensitive
+	rn.httpClient = &http.Client{Transport: rn.newTransport(TransportConfig{}, nil)}
+	rn.dl = rn.httpClient
+	rn.filters = rn.newFilterDetector(false)
+	rn.sources = rn.newSourceSet(nil)
+	rn.connTrace = &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				atomic.AddInt64(&rn.connsReused, 1)
+			} else {
+				atomic.AddInt64(&rn.connsOpened, 1)
+			}
+		},
+		TLSHandshakeStart: func() {
+			atomic.AddInt64(&rn.tlsHandshakes, 1)
+		},
+	}
+	return rn
+}
@@ -0,0 +1,114 @@
+package downloader
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DEFAULT_LOG_KEEP 是 log_dir 中默认保留的运行日志数
+const DEFAULT_LOG_KEEP = 10
+
+// 运行日志的文件名为 run-<时间>-<pid>.log，按文件名排序即按时间排序
+const (
+	RUN_LOG_PREFIX = "run-"
+	RUN_LOG_SUFFIX = ".log"
+)
+
+// logSink 是一个带缓冲的日志文件，所有写入都在持有 logMu 时进行
+type logSink struct {
+	w      *bufio.Writer
+	f      File
+	layout string // 行首时间的格式
+}
+
+func (s *logSink) open(f File, layout string) {
+	logMu.Lock()
+	s.w, s.f, s.layout = bufio.NewWriter(f), f, layout
+	logMu.Unlock()
+}
+
+// write 把一行日志加上时间写入文件；调用方须持有 logMu
+func (s *logSink) write(tag, msg string) {
+	if s.w != nil {
+		fmt.Fprintf(s.w, "%s [%s] %s\n", time.Now().Format(s.layout), tag, msg)
+	}
+}
+
+// close 写出缓冲并关闭文件
+func (s *logSink) close() {
+	logMu.Lock()
+	defer logMu.Unlock()
+	if s.w == nil {
+		return
+	}
+	s.w.Flush()
+	s.f.Close()
+	s.w, s.f = nil, nil
+}
+
+// openRunLog 在 dir 中创建本次运行的日志文件，并删除较旧的日志，只保留最新的 keep 个 (含本次)
+func (rn *run) openRunLog(dir string, keep int) (string, error) {
+	if err := rn.fsys.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s%s-%d%s", RUN_LOG_PREFIX, time.Now().Format("20060102-150405"), os.Getpid(), RUN_LOG_SUFFIX)
+	path := filepath.Join(dir, name)
+	f, err := rn.fsys.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return "", err
+	}
+	rn.runLog.open(f, "2006-01-02 15:04:05")
+	rn.pruneRunLogs(dir, keep)
+	return path, nil
+}
+
+// openDebugLog 创建 (覆盖) log_file，时间精确到毫秒以便对照请求耗时
+func (rn *run) openDebugLog(path string) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := rn.fsys.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	f, err := rn.fsys.Create(path)
+	if err != nil {
+		return err
+	}
+	rn.debugLog.open(f, "2006-01-02 15:04:05.000")
+	return nil
+}
+
+// pruneRunLogs 按文件名从旧到新删除多出 keep 个的运行日志
+func (rn *run) pruneRunLogs(dir string, keep int) {
+	entries, err := rn.fsys.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var logs []string
+	for _, e := range entries {
+		if name := e.Name(); !e.IsDir() && strings.HasPrefix(name, RUN_LOG_PREFIX) && strings.HasSuffix(name, RUN_LOG_SUFFIX) {
+			logs = append(logs, name)
+		}
+	}
+	sort.Strings(logs)
+	for i := 0; i < len(logs)-keep; i++ {
+		if err := rn.fsys.Remove(filepath.Join(dir, logs[i])); err != nil {
+			rn.debugf("删除旧日志 %s 失败: %v", logs[i], err)
+		}
+	}
+}
+
+// writeRunLog 把一行日志写入运行日志与 log_file；调用方须持有 logMu
+func (rn *run) writeRunLog(tag, msg string) {
+	rn.runLog.write(tag, msg)
+	rn.debugLog.write(tag, msg)
+}
+
+// closeRunLog 写出缓冲并关闭运行日志
+func (rn *run) closeRunLog() {
+	rn.runLog.close()
+}
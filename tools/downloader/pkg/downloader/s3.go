@@ -0,0 +1,342 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// S3_PART_SIZE 是分段上传的分段大小；小于一个分段的对象用单次 PUT 上传 (S3 要求除最后一段外不小于 5 MiB)
+	S3_PART_SIZE = 8 << 20
+	// S3_DEFAULT_REGION 是未设置 region 时用于签名的区域 (MinIO 默认同样使用该值)
+	S3_DEFAULT_REGION = "us-east-1"
+	// S3_META_SHA256 是保存内容 SHA-256 的用户元数据
+	S3_META_SHA256 = "x-amz-meta-sha256"
+)
+
+// s3Storage 是 S3 兼容的对象存储，请求使用 AWS Signature V4 签名
+type s3Storage struct {
+	rn            *run
+	client        *http.Client
+	endpoint      *url.URL
+	bucket        string
+	region        string
+	accessKey     string
+	secretKey     string
+	virtualHosted bool
+}
+
+func (rn *run) newS3Storage(sc StorageConfig) (*s3Storage, error) {
+	region := sc.Region
+	if region == "" {
+		region = S3_DEFAULT_REGION
+	}
+	endpoint := sc.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	u, err := url.Parse(strings.TrimRight(endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("需要 http(s)://host 形式的地址")
+	}
+	return &s3Storage{rn: rn,
+		client: &http.Client{}, endpoint: u, bucket: sc.Bucket, region: region,
+		accessKey: sc.AccessKey, secretKey: sc.SecretKey, virtualHosted: sc.VirtualHosted,
+	}, nil
+}
+
+// objectURL 返回对象 (key 为空时为 bucket) 的地址
+func (s *s3Storage) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	p := "/" + s.bucket + "/" + key
+	if s.virtualHosted {
+		u.Host = s.bucket + "." + u.Host
+		p = "/" + key
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + p
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = s3CanonicalQuery(query)
+	return &u
+}
+
+// do 发送一个已签名的请求；body 为 nil 时不带请求体。非 2xx 响应转为 s3Error
+func (s *s3Storage) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := s.objectURL(key, query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	s.sign(req, body, time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, readS3Error(method, key, resp)
+	}
+	return resp, nil
+}
+
+// sign 按 AWS Signature V4 为请求签名，签入 host 与全部 x-amz-* 头
+func (s *s3Storage) sign(req *http.Request, body []byte, now time.Time) {
+	sum := sha256.Sum256(body)
+	payload := hex.EncodeToString(sum[:])
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-amz-") {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery, canonHeaders.String(), signed, payload}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	creq := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(creq[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signed, sig))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3EscapePath 按 SigV4 的规则编码路径：除未保留字符与 "/" 外全部百分号编码
+func s3EscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3CanonicalQuery 返回按键排序、按 SigV4 规则编码的查询串 (空值保留 "=")
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, strings.ReplaceAll(s3EscapePath(k), "/", "%2F")+"="+strings.ReplaceAll(s3EscapePath(v), "/", "%2F"))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Error 是 S3 返回的错误响应
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+	status  int
+	op      string
+}
+
+func (e *s3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3 %s: Status %d", e.op, e.status)
+	}
+	return fmt.Sprintf("s3 %s: Status %d %s: %s", e.op, e.status, e.Code, e.Message)
+}
+
+func readS3Error(method, key string, resp *http.Response) error {
+	e := &s3Error{status: resp.StatusCode, op: method + " " + key}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	xml.Unmarshal(data, e)
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %v", fs.ErrNotExist, e)
+	}
+	return e
+}
+
+// Put 把不超过一个分段的内容用单次 PUT 上传，更大的内容分段上传，只有全部分段成功后才完成上传
+func (s *s3Storage) Put(ctx context.Context, p string, r io.Reader, meta StorageMeta) error {
+	key := strings.TrimLeft(p, "/")
+	buf := make([]byte, S3_PART_SIZE)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		sum := sha256.Sum256(buf[:n])
+		h := s3Header(meta)
+		h.Set(S3_META_SHA256, hex.EncodeToString(sum[:]))
+		resp, err := s.do(ctx, "PUT", key, nil, h, buf[:n])
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.putMultipart(ctx, key, r, meta, buf)
+}
+
+// putMultipart 分段上传；first 是已读出的第一个完整分段。任何一步失败都放弃上传，不留下不完整的对象
+func (s *s3Storage) putMultipart(ctx context.Context, key string, r io.Reader, meta StorageMeta, first []byte) error {
+	resp, err := s.do(ctx, "POST", key, url.Values{"uploads": {""}}, s3Header(meta), nil)
+	if err != nil {
+		return err
+	}
+	var init struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&init)
+	resp.Body.Close()
+	if err != nil || init.UploadID == "" {
+		return fmt.Errorf("s3 CreateMultipartUpload %s: 响应无效", key)
+	}
+	abort := func() {
+		actx, cancel := s.rn.storageContext()
+		defer cancel()
+		if resp, err := s.do(actx, "DELETE", key, url.Values{"uploadId": {init.UploadID}}, nil, nil); err == nil {
+			resp.Body.Close()
+		}
+	}
+
+	type part struct {
+		XMLName    xml.Name `xml:"Part"`
+		PartNumber int      `xml:"PartNumber"`
+		ETag       string   `xml:"ETag"`
+	}
+	var parts []part
+	hasher := sha256.New()
+	data := first
+	for num := 1; ; num++ {
+		hasher.Write(data)
+		q := url.Values{"partNumber": {strconv.Itoa(num)}, "uploadId": {init.UploadID}}
+		resp, err := s.do(ctx, "PUT", key, q, nil, data)
+		if err != nil {
+			abort()
+			return err
+		}
+		resp.Body.Close()
+		parts = append(parts, part{PartNumber: num, ETag: resp.Header.Get("ETag")})
+
+		buf := make([]byte, S3_PART_SIZE)
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			abort()
+			return err
+		}
+		data = buf[:n]
+	}
+
+	body, _ := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part
+	}{Parts: parts})
+	resp, err = s.do(ctx, "POST", key, url.Values{"uploadId": {init.UploadID}}, nil, body)
+	if err != nil {
+		abort()
+		return err
+	}
+	// CompleteMultipartUpload 可能以 200 返回错误
+	data, _ = io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if bytes.Contains(data, []byte("<Error>")) {
+		e := &s3Error{status: resp.StatusCode, op: "CompleteMultipartUpload " + key}
+		xml.Unmarshal(data, e)
+		abort()
+		return e
+	}
+
+	// 分段上传开始时内容哈希未知，完成后以原地复制补写元数据；失败不影响已完成的对象
+	h := s3Header(meta)
+	h.Set(S3_META_SHA256, hex.EncodeToString(hasher.Sum(nil)))
+	h.Set("X-Amz-Copy-Source", s3EscapePath("/"+s.bucket+"/"+key))
+	h.Set("X-Amz-Metadata-Directive", "REPLACE")
+	if resp, err := s.do(ctx, "PUT", key, nil, h, nil); err != nil {
+		s.rn.debugf("s3 %s 补写 sha256 元数据失败: %v", key, err)
+	} else {
+		resp.Body.Close()
+	}
+	return nil
+}
+
+// s3Header 返回创建对象时的请求头
+func s3Header(meta StorageMeta) http.Header {
+	h := http.Header{}
+	if meta.ContentType != "" {
+		h.Set("Content-Type", meta.ContentType)
+	}
+	if meta.Source != "" {
+		h.Set("X-Amz-Meta-Source", meta.Source)
+	}
+	return h
+}
+
+func (s *s3Storage) Exists(ctx context.Context, p string) (bool, error) {
+	_, err := s.Stat(ctx, p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *s3Storage) Stat(ctx context.Context, p string) (StorageInfo, error) {
+	resp, err := s.do(ctx, "HEAD", strings.TrimLeft(p, "/"), nil, nil, nil)
+	if err != nil {
+		return StorageInfo{}, err
+	}
+	resp.Body.Close()
+	info := StorageInfo{Size: resp.ContentLength, SHA256: resp.Header.Get(S3_META_SHA256)}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = t
+	}
+	return info, nil
+}
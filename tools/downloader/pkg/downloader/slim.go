@@ -0,0 +1,71 @@
+//go:build slim
+
+package downloader
+
+import (
+	"context"
+	"errors"
+)
+
+// 精简版 (go build -tags slim) 只保留"配置输入、文件输出"的核心下载流程，
+// 不包含分支 tarball 解压 (branch_archive)、zip 打包 (bundle_dir)、环境诊断 (-doctor)
+// 以及 Steam appinfo 查询 (resolve_depots、include_dlc、steam_info_url)，以减小体积并降低杀毒软件误报。
+// 配置中使用这些选项时直接报 unsupported_in_this_build 错误。
+
+// SLIM_BUILD 表示当前是否为精简版构建 (go build -tags slim)
+const SLIM_BUILD = true
+
+// unsupportedOptions 返回配置中精简版不支持的选项 (JSON 键名)
+func unsupportedOptions(config Config) []string {
+	var out []string
+	if config.BranchArchive {
+		out = append(out, "branch_archive")
+	}
+	if config.BundleDir != "" {
+		out = append(out, "bundle_dir")
+	}
+	if config.ResolveDepots {
+		out = append(out, "resolve_depots")
+	}
+	if config.IncludeDLC {
+		out = append(out, "include_dlc")
+	}
+	if config.SteamInfoURL != "" {
+		out = append(out, "steam_info_url")
+	}
+	return out
+}
+
+var errSlimBuild = errors.New("精简版不支持该功能")
+
+// 以下为精简版中被裁掉的子系统的占位实现；配置校验已拒绝相关选项，正常情况下不会被调用
+
+func (rn *run) downloadBranchArchive(ctx context.Context, config Config, appID string, items []string, res *AppResult) error {
+	return errSlimBuild
+}
+
+type bundler struct{}
+
+func (rn *run) newBundler(config Config) *bundler { return &bundler{} }
+func (b *bundler) add(res AppResult)              {}
+func (b *bundler) wait() []string                 { return nil }
+
+func (rn *run) runDoctor(config Config) int {
+	return 1
+}
+
+type depotResolution struct {
+	depots, items, missing []string
+}
+
+func (rn *run) resolveDepots(ctx context.Context, config Config, appID string, known []string) depotResolution {
+	return depotResolution{}
+}
+
+func (rn *run) takeListed(config Config, appID string, items []string, r depotResolution, res *AppResult) []string {
+	return items
+}
+
+func (rn *run) discoverDLCs(ctx context.Context, config Config, res *AppResult, limit int) []string {
+	return nil
+}
@@ -0,0 +1,76 @@
+package downloader
+
+import (
+	"path"
+	"strings"
+)
+
+// ST_PATH_TEMPLATE 是 SteamTools 加密脚本的路径模板。Lua 阶段在同一分支中与 Lua 模板一起查找，
+// 命中后原样保存为 appID.st (不解密)，计入 st 而不是 lua
+const ST_PATH_TEMPLATE = "{appid}.st"
+
+// Lua 阶段同时存在 Lua 与 .st 时的取舍 (prefer)
+const (
+	PREFER_LUA = "lua" // 默认：.st 排在全部 Lua 模板之后
+	PREFER_ST  = "st"  // .st 排在全部 Lua 模板之前
+)
+
+// withSTTemplate 按 prefer 把 ST_PATH_TEMPLATE 加入 Lua 模板；templates 中已有 .st 模板时原样返回
+func withSTTemplate(templates []string, preferST bool) []string {
+	for _, t := range templates {
+		if isSTTemplate(t) {
+			return templates
+		}
+	}
+	out := make([]string, 0, len(templates)+1)
+	if preferST {
+		out = append(out, ST_PATH_TEMPLATE)
+	}
+	out = append(out, templates...)
+	if !preferST {
+		out = append(out, ST_PATH_TEMPLATE)
+	}
+	return out
+}
+
+// isSTTemplate 判断 Lua 阶段的模板是否指向 .st 脚本
+func isSTTemplate(t string) bool {
+	return strings.EqualFold(path.Ext(t), ".st")
+}
+
+// scriptName 返回 Lua 阶段命中 template 的文件保存名：appID.st 或 appID.lua
+func scriptName(appID, template string) string {
+	if isSTTemplate(template) {
+		return appID + ".st"
+	}
+	return appID + ".lua"
+}
+
+// scriptNames 返回 appID 在 lua_dir 中可能的保存名，luaTemplates 以 .st 模板开头 (prefer: st) 时 .st 在前
+func (rn *run) scriptNames(appID string) []string {
+	lua, st := appID+".lua", appID+".st"
+	if len(rn.luaTemplates) > 0 && isSTTemplate(rn.luaTemplates[0]) {
+		return []string{st, lua}
+	}
+	return []string{lua, st}
+}
+
+// recordScript 把 Lua 阶段命中 template 的文件计入 res 的 lua 或 st
+func recordScript(res *AppResult, template string) {
+	if isSTTemplate(template) {
+		res.St = 1
+	} else {
+		res.Lua = 1
+	}
+}
+
+// savedScript 返回 App 本次在 lua_dir 中拿到的脚本文件名，没有时返回空串
+func savedScript(res AppResult) string {
+	switch {
+	case res.Lua > 0:
+		return res.AppID + ".lua"
+	case res.St > 0:
+		return res.AppID + ".st"
+	}
+	return ""
+}
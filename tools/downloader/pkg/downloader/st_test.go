@@ -0,0 +1,68 @@
+package downloader
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWithSTTemplate(t *testing.T) {
+	tests := []struct {
+		name      string
+		templates []string
+		preferST  bool
+		want      []string
+	}{
+		{"prefer lua", []string{"{appid}.lua", "depots.lua"}, false, []string{"{appid}.lua", "depots.lua", ST_PATH_TEMPLATE}},
+		{"prefer st", []string{"{appid}.lua", "depots.lua"}, true, []string{ST_PATH_TEMPLATE, "{appid}.lua", "depots.lua"}},
+		{"already has st", []string{"scripts/{appid}.ST", "{appid}.lua"}, false, []string{"scripts/{appid}.ST", "{appid}.lua"}},
+	}
+	for _, tt := range tests {
+		if got := withSTTemplate(tt.templates, tt.preferST); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: withSTTemplate = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFetchSTScripts(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		prefer   string
+		wantFile string // 保存到 lua_dir 的文件名
+		lua, st  int
+	}{
+		{"st only", map[string]string{"a/b/10/10.st": "st-bytes"}, "", "10.st", 0, 1},
+		{"both, default lua", map[string]string{"a/b/10/10.st": "st-bytes", "a/b/10/10.lua": "-- lua"}, "", "10.lua", 1, 0},
+		{"both, prefer st", map[string]string{"a/b/10/10.st": "st-bytes", "a/b/10/10.lua": "-- lua"}, "st", "10.st", 0, 1},
+		{"lua only, prefer st", map[string]string{"a/b/10/10.lua": "-- lua"}, "st", "10.lua", 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepo(t, tt.files)
+			cfg := testConfig(t, map[string][]string{"10": nil})
+			cfg.Prefer = tt.prefer
+			res := r.download(t, cfg)
+			if len(res.Results) != 1 || res.Results[0].Lua != tt.lua || res.Results[0].St != tt.st {
+				t.Fatalf("results = %+v, want lua %d st %d", res.Results, tt.lua, tt.st)
+			}
+			if res.Summary.Lua != tt.lua || res.Summary.St != tt.st {
+				t.Errorf("summary lua %d st %d, want %d %d", res.Summary.Lua, res.Summary.St, tt.lua, tt.st)
+			}
+			want := tt.files["a/b/10/"+tt.wantFile]
+			if data, err := os.ReadFile(filepath.Join(cfg.LuaDir, tt.wantFile)); err != nil || string(data) != want {
+				t.Errorf("%s = %q, %v; want %q", tt.wantFile, data, err, want)
+			}
+			// 只保存胜出的一个脚本，原样保存不解密
+			other := "10.lua"
+			if tt.wantFile == other {
+				other = "10.st"
+			}
+			if _, err := os.Stat(filepath.Join(cfg.LuaDir, other)); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("%s also saved (%v)", other, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+)
+
+// statsKey 是 context 中 withStats 的键
+type statsKey struct{}
+
+// transferStats 是一个 App 或一个清单条目处理过程中的请求计数。
+// 每个 App / 条目各有一份，只在处理它的协程 (及其候选探测) 之间共享，结束后合并到 AppResult，
+// 不经过全局锁
+type transferStats struct {
+	bytes    int64 // 成功下载的字节数
+	attempts int64 // 发出的请求数 (HEAD 与 GET，含重试)
+	retries  int64 // 重试次数
+	notFound int64 // 返回 404 的探测数
+}
+
+// withStats 在 detailed_stats 开启时为 ctx 挂上一份新的计数，未开启时原样返回 ctx 与 nil
+func (rn *run) withStats(ctx context.Context) (context.Context, *transferStats) {
+	if !rn.detailedStats {
+		return ctx, nil
+	}
+	s := &transferStats{}
+	return context.WithValue(ctx, statsKey{}, s), s
+}
+
+// statsFrom 返回 ctx 中的计数，没有时返回 nil (各计数方法对 nil 不做任何事)
+func statsFrom(ctx context.Context) *transferStats {
+	s, _ := ctx.Value(statsKey{}).(*transferStats)
+	return s
+}
+
+// request 记录一次请求，status 为 0 表示没有拿到响应
+func (s *transferStats) request(status int) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.attempts, 1)
+	if status == http.StatusNotFound {
+		atomic.AddInt64(&s.notFound, 1)
+	}
+}
+
+func (s *transferStats) retry() {
+	if s != nil {
+		atomic.AddInt64(&s.retries, 1)
+	}
+}
+
+func (s *transferStats) transferred(n int64) {
+	if s != nil {
+		atomic.AddInt64(&s.bytes, n)
+	}
+}
+
+// addTo 把计数合并到 App 结果
+func (s *transferStats) addTo(res *AppResult) {
+	if s == nil {
+		return
+	}
+	res.Bytes += atomic.LoadInt64(&s.bytes)
+	res.Retries += int(atomic.LoadInt64(&s.retries))
+	res.NotFoundProbes += int(atomic.LoadInt64(&s.notFound))
+}
@@ -0,0 +1,34 @@
+package downloader
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RENAME_RETRIES: steam-safe 模式下重命名被占用 (杀毒软件扫描、Steam 正在读取) 时的重试次数
+const RENAME_RETRIES = 5
+
+// isDepotcacheDir 判断目录是否为 Steam 的 depotcache
+func isDepotcacheDir(dir string) bool {
+	return dir != "" && strings.EqualFold(filepath.Base(filepath.Clean(dir)), "depotcache")
+}
+
+// useSteamSafe 判断 destPath 是否需要 steam-safe 写入 (只对清单生效，Lua 等文本文件不受影响)
+func (rn *run) useSteamSafe(destPath string) bool {
+	return rn.steamSafe && isManifestPath(destPath)
+}
+
+// renameWithRetry 重命名文件，目标被其它进程占用时按递增间隔重试；源文件不存在时立即返回
+func (rn *run) renameWithRetry(src, dst string) error {
+	var err error
+	for i := 0; i < RENAME_RETRIES; i++ {
+		if err = rn.fsys.Rename(src, dst); err == nil || errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		time.Sleep(time.Duration(100*(i+1)) * time.Millisecond)
+	}
+	return err
+}
@@ -0,0 +1,396 @@
+package downloader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 存储后端类型 (StorageConfig.Type)
+const (
+	STORAGE_LOCAL  = "local"  // 默认：本地文件系统
+	STORAGE_S3     = "s3"     // S3 兼容的对象存储 (AWS S3、MinIO 等)
+	STORAGE_WEBDAV = "webdav" // WebDAV 服务器
+)
+
+// Storage 是一个输出目标 (lua_dir 或 manifest_dir) 的存放位置。path 是目标内以 "/" 分隔的对象路径。
+// Put 只有在 r 完整读完且没有出错时才让 path 可见：本地为临时文件 + 重命名，S3 为完成分段上传，
+// WebDAV 为上传到临时名后 MOVE；r 返回的错误原样作为 Put 的错误，已有的同名对象不受影响
+type Storage interface {
+	Put(ctx context.Context, path string, r io.Reader, meta StorageMeta) error
+	Exists(ctx context.Context, path string) (bool, error)
+	Stat(ctx context.Context, path string) (StorageInfo, error)
+}
+
+// StorageMeta 是随对象保存的附加信息
+type StorageMeta struct {
+	ContentType string
+	Source      string // 下载地址
+}
+
+// StorageInfo 描述一个已存在的对象；SHA256 为 Put 时记录的内容哈希，后端无法提供时为空
+type StorageInfo struct {
+	Size    int64
+	ModTime time.Time
+	SHA256  string
+}
+
+// StorageConfig 选择一个输出目标的存储后端 (config 中的 "lua_storage" / "manifest_storage"，未设置时为本地磁盘)。
+// 远程后端中的对象路径为 prefix/文件名，lua_dir/manifest_dir 只用来区分目标
+type StorageConfig struct {
+	Type   string `json:"type"`   // "local" (默认) | "s3" | "webdav"
+	Prefix string `json:"prefix"` // 对象路径前缀
+
+	// S3: endpoint 为空时使用 AWS (https://s3.<region>.amazonaws.com)；默认 path-style 地址 (MinIO)
+	Endpoint      string `json:"endpoint"`
+	Bucket        string `json:"bucket"`
+	Region        string `json:"region"` // 默认 us-east-1
+	AccessKey     string `json:"access_key"`
+	SecretKey     string `json:"secret_key"`
+	VirtualHosted bool   `json:"virtual_hosted"` // 使用 bucket.endpoint 形式的地址
+
+	// WebDAV: url 为集合地址，username 非空时使用 Basic 认证
+	URL      string `json:"url"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// storageMount 把一个目标目录映射到远程存储
+type storageMount struct {
+	dir    string
+	prefix string
+	st     Storage
+}
+
+// newStorage 按配置创建远程存储；field 为配置中的键名，用于错误信息。本地存储返回 nil
+func (rn *run) newStorage(field string, sc StorageConfig) (Storage, error) {
+	missing := func(name string) error {
+		return &ConfigError{Code: CODE_MISSING_FIELD, Field: field + "." + name, Msg: fmt.Sprintf("%s 缺少 %s", field, name)}
+	}
+	switch strings.ToLower(sc.Type) {
+	case "", STORAGE_LOCAL:
+		return nil, nil
+	case STORAGE_S3:
+		switch {
+		case sc.Bucket == "":
+			return nil, missing("bucket")
+		case sc.AccessKey == "" || sc.SecretKey == "":
+			return nil, missing("access_key/secret_key")
+		}
+		st, err := rn.newS3Storage(sc)
+		if err != nil {
+			return nil, &ConfigError{Code: CODE_INVALID_VALUE, Field: field + ".endpoint", Msg: field + ".endpoint 无效: " + err.Error()}
+		}
+		return st, nil
+	case STORAGE_WEBDAV:
+		if sc.URL == "" {
+			return nil, missing("url")
+		}
+		st, err := rn.newWebDAVStorage(sc)
+		if err != nil {
+			return nil, &ConfigError{Code: CODE_INVALID_VALUE, Field: field + ".url", Msg: field + ".url 无效: " + err.Error()}
+		}
+		return st, nil
+	}
+	return nil, &ConfigError{Code: CODE_INVALID_VALUE, Field: field + ".type", Msg: field + ".type 无效: " + sc.Type}
+}
+
+// setupStorage 创建 lua_storage / manifest_storage 并检查与之冲突的选项 (这些功能需要在本地读写目标目录)
+func (rn *run) setupStorage(config *Config) error {
+	rn.storageMounts = nil
+	targets := []struct {
+		field string
+		dir   string
+		sc    *StorageConfig
+		local []string // 需要本地目录的选项
+	}{
+		{"manifest_storage", config.ManifestDir, config.ManifestStorage, localManifestOptions(*config)},
+		{"lua_storage", config.LuaDir, config.LuaStorage, localLuaOptions(*config)},
+	}
+	for _, t := range targets {
+		if t.sc == nil {
+			continue
+		}
+		st, err := rn.newStorage(t.field, *t.sc)
+		if err != nil || st == nil {
+			return err
+		}
+		dirField := strings.TrimSuffix(t.field, "_storage") + "_dir"
+		if t.dir == "" {
+			return &ConfigError{Code: CODE_MISSING_FIELD, Field: dirField, Msg: t.field + " 需要同时设置 " + dirField}
+		}
+		if len(t.local) > 0 {
+			return &ConfigError{Code: CODE_INVALID_VALUE, Field: t.local[0],
+				Msg: fmt.Sprintf("%s 使用远程存储时不支持以下选项: %s", t.field, strings.Join(t.local, ", "))}
+		}
+		dir := filepath.Clean(t.dir)
+		if rn.remoteStorage(dir) != nil {
+			return &ConfigError{Code: CODE_INVALID_VALUE, Field: t.field, Msg: "lua_dir 与 manifest_dir 相同时只能使用同一个存储"}
+		}
+		rn.storageMounts = append(rn.storageMounts, storageMount{dir: dir, prefix: strings.Trim(t.sc.Prefix, "/"), st: st})
+	}
+	return nil
+}
+
+// localManifestOptions 返回已启用的、需要本地清单目录的选项
+func localManifestOptions(config Config) []string {
+	var opts []string
+	for _, o := range []struct {
+		name string
+		on   bool
+	}{
+		{"manifest_dirs", len(config.ManifestDirs) > 0},
+		{"verify_existing", config.VerifyExisting},
+		{"conditional_sync", config.ConditionalSync},
+		{"flatten_symlinks", config.FlattenSymlinks},
+		{"branch_archive", config.BranchArchive},
+		{"bundle_dir", config.BundleDir != ""},
+		{"validate_keys", config.ValidateKeys},
+		{"dedup_report", config.DedupReport != ""},
+		{"estimate_install_size", config.EstimateInstallSize},
+	} {
+		if o.on {
+			opts = append(opts, o.name)
+		}
+	}
+	return opts
+}
+
+// localLuaOptions 返回已启用的、需要本地 Lua 目录的选项
+func localLuaOptions(config Config) []string {
+	var opts []string
+	for _, o := range []struct {
+		name string
+		on   bool
+	}{
+		{"auto_discover", config.AutoDiscover},
+		{"conditional_sync", config.ConditionalSync},
+		{"patch_lua", config.PatchLua},
+		{"branch_archive", config.BranchArchive},
+		{"bundle_dir", config.BundleDir != ""},
+		{"validate_keys", config.ValidateKeys},
+	} {
+		if o.on {
+			opts = append(opts, o.name)
+		}
+	}
+	return opts
+}
+
+// storageFor 返回 p 所在的远程存储与其中的对象路径；p 不属于任何远程目标时返回 nil
+func (rn *run) storageFor(p string) (Storage, string) {
+	p = filepath.Clean(p)
+	for _, m := range rn.storageMounts {
+		rel, err := filepath.Rel(m.dir, p)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		return m.st, path.Join(m.prefix, filepath.ToSlash(rel))
+	}
+	return nil, ""
+}
+
+// remoteStorage 返回目录 dir 对应的远程存储，本地目录返回 nil
+func (rn *run) remoteStorage(dir string) Storage {
+	if dir == "" {
+		return nil
+	}
+	for _, m := range rn.storageMounts {
+		if m.dir == filepath.Clean(dir) {
+			return m.st
+		}
+	}
+	return nil
+}
+
+// storageContext 返回单次存储请求使用的超时 context
+func (rn *run) storageContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), rn.requestTimeout)
+}
+
+// statStored 返回 p 的大小等信息：远程目标查询存储，本地文件读取文件系统 (不计算 SHA-256)
+func (rn *run) statStored(p string) (StorageInfo, error) {
+	if st, key := rn.storageFor(p); st != nil {
+		ctx, cancel := rn.storageContext()
+		defer cancel()
+		return st.Stat(ctx, key)
+	}
+	return localStorage{rn: rn}.Stat(context.Background(), p)
+}
+
+// storedSHA256 返回 p 的内容哈希：远程目标使用 Put 时记录的值 (没有时为空)，本地文件直接计算
+func (rn *run) storedSHA256(p string) string {
+	if st, key := rn.storageFor(p); st != nil {
+		ctx, cancel := rn.storageContext()
+		defer cancel()
+		info, err := st.Stat(ctx, key)
+		if err != nil {
+			return ""
+		}
+		return info.SHA256
+	}
+	return rn.fileSHA256(p)
+}
+
+// commitFile 把本地临时文件 tmp 移到 dest：本地目标直接重命名，远程目标上传后删除 tmp
+func (rn *run) commitFile(ctx context.Context, tmp, dest string) error {
+	st, key := rn.storageFor(dest)
+	if st == nil {
+		return rn.fsys.Rename(tmp, dest)
+	}
+	defer rn.fsys.Remove(tmp)
+	f, err := rn.fsys.Open(tmp)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ctx, cancel := context.WithTimeout(ctx, rn.requestTimeout)
+	defer cancel()
+	return st.Put(ctx, key, f, StorageMeta{ContentType: contentTypeFor(dest)})
+}
+
+// stagingPath 返回写入 dest 前使用的临时文件：本地目标放在同一目录，远程目标放在系统临时目录
+func (rn *run) stagingPath(dest, suffix string) string {
+	if st, _ := rn.storageFor(dest); st != nil {
+		return filepath.Join(os.TempDir(), fmt.Sprintf("downloader-%d-%s%s", os.Getpid(), filepath.Base(dest), suffix))
+	}
+	return dest + suffix
+}
+
+// contentTypeFor 返回对象的 Content-Type
+func contentTypeFor(p string) string {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".lua", ".vdf", ".st":
+		return "text/plain; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+// storeReader 在下载内容流向远程存储时计算大小与 SHA-256 并记录文件头；
+// 读到末尾时先执行 check，check 失败时以该错误代替 io.EOF，存储后端因此不会提交对象
+type storeReader struct {
+	sha256Reader
+	n     int64
+	head  headWriter
+	check func(n int64, sha string, head []byte) error
+}
+
+func (s *storeReader) Read(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	n, err := s.sha256Reader.Read(p)
+	s.n += int64(n)
+	s.head.Write(p[:n])
+	if err == io.EOF {
+		if cerr := s.check(s.n, s.sum(), s.head.head); cerr != nil {
+			s.err, err = cerr, cerr
+		}
+	}
+	return n, err
+}
+
+// storeDownload 把响应体直接写入远程存储中的 key，大小、校验和 (withExpected) 与清单校验在提交前完成
+func (rn *run) storeDownload(ctx context.Context, st Storage, key, url, destPath string, body io.Reader, contentLength int64) (download, error) {
+	exp := expectedFrom(ctx)
+	blob := exp.blobBuffer()
+	if blob != nil {
+		body = io.TeeReader(body, blob)
+	}
+	sr := &storeReader{sha256Reader: *newSHA256Reader(body), check: func(n int64, sha string, head []byte) error {
+		if isManifestPath(destPath) {
+			if err := rn.validateManifest(n, contentLength, head, false); err != nil {
+				return err
+			}
+		}
+		return exp.verify(url, destPath, n, sha, blob)
+	}}
+	err := st.Put(ctx, key, sr, StorageMeta{ContentType: contentTypeFor(destPath), Source: url})
+	if sr.err != nil {
+		return download{}, sr.err
+	}
+	if err != nil {
+		return download{}, &diskError{err}
+	}
+	atomic.AddInt64(&rn.totalBytes, sr.n)
+	return download{URL: url, Size: sr.n, SHA256: sr.sum()}, nil
+}
+
+// sha256Reader 在读取时计算 SHA-256，并记录底层 reader 返回的错误 (io.EOF 除外)
+type sha256Reader struct {
+	r   io.Reader
+	h   hash.Hash
+	err error
+}
+
+func newSHA256Reader(r io.Reader) *sha256Reader {
+	return &sha256Reader{r: r, h: sha256.New()}
+}
+
+func (s *sha256Reader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.h.Write(p[:n])
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+	return n, err
+}
+
+func (s *sha256Reader) sum() string { return hex.EncodeToString(s.h.Sum(nil)) }
+
+// localStorage 是默认的本地文件系统存储，path 为本地路径
+type localStorage struct {
+	rn *run
+}
+
+func (l localStorage) Put(ctx context.Context, p string, r io.Reader, meta StorageMeta) error {
+	if err := l.rn.fsys.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	tmp := tempPath(p)
+	out, err := l.rn.fsys.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = l.rn.renameTemp(tmp, p)
+	}
+	if err != nil {
+		l.rn.fsys.Remove(tmp)
+	}
+	return err
+}
+
+func (l localStorage) Exists(ctx context.Context, p string) (bool, error) {
+	_, err := l.Stat(ctx, p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (l localStorage) Stat(ctx context.Context, p string) (StorageInfo, error) {
+	info, err := l.rn.fsys.Stat(p)
+	if err != nil {
+		return StorageInfo{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return StorageInfo{}, fmt.Errorf("%s 不是普通文件", p)
+	}
+	return StorageInfo{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
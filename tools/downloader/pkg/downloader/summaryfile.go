@@ -0,0 +1,61 @@
+package downloader
+
+import (
+	"encoding/json"
+	"path/filepath"
+)
+
+// summaryApp 是 summary_path 中单个成功 App 的记录
+type summaryApp struct {
+	AppID     string   `json:"app_id"`
+	Lua       string   `json:"lua,omitempty"`       // 保存的 Lua (或 .st) 文件名
+	Manifests []string `json:"manifests,omitempty"` // 本次下载的清单文件名
+	Depots    []string `json:"depots,omitempty"`    // 有解密密钥的 depot
+}
+
+// recordSummary 记录一个拿到文件的 App，运行结束后统一写入 summary_path
+func (rn *run) recordSummary(res AppResult) {
+	if appFailed(res) {
+		return
+	}
+	s := summaryApp{AppID: res.AppID, Depots: sortedKeys(res.Keys)}
+	s.Lua = savedScript(res)
+	for _, f := range res.Files {
+		s.Manifests = append(s.Manifests, f.Name)
+	}
+	rn.summaryMu.Lock()
+	defer rn.summaryMu.Unlock()
+	rn.summaryApps[res.AppID] = s
+}
+
+// writeSummaryFile 按 order 的顺序把成功的 App 写成 JSON，先写临时文件再重命名
+func (rn *run) writeSummaryFile(path string, order []string) (int, error) {
+	rn.summaryMu.Lock()
+	apps := make([]summaryApp, 0, len(rn.summaryApps))
+	for _, id := range order {
+		if s, ok := rn.summaryApps[id]; ok {
+			apps = append(apps, s)
+		}
+	}
+	rn.summaryMu.Unlock()
+
+	data, err := json.MarshalIndent(struct {
+		Apps []summaryApp `json:"apps"`
+	}{apps}, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	if err := rn.fsys.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, &diskError{err}
+	}
+	tmp := tempPath(path)
+	if err := rn.fsys.WriteFile(tmp, data, 0644); err != nil {
+		rn.fsys.Remove(tmp)
+		return 0, &diskError{err}
+	}
+	if err := rn.renameTemp(tmp, path); err != nil {
+		rn.fsys.Remove(tmp)
+		return 0, err
+	}
+	return len(apps), nil
+}
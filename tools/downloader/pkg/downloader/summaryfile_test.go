@@ -0,0 +1,69 @@
+package downloader
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSummaryFile(t *testing.T) {
+	r := newTestRepo(t, map[string]string{
+		"a/b/10/10.lua":         "-- 10",
+		"a/b/10/11_22.manifest": testManifest,
+		"a/b/10/12_33.manifest": testManifest,
+		"a/b/10/key.vdf":        "\"depots\" { \"12\" { \"DecryptionKey\" \"bb\" } \"11\" { \"DecryptionKey\" \"aa\" } }",
+		"a/b/20/20.lua":         "-- 20",
+	})
+	cfg := testConfig(t, map[string][]string{"10": {"11_22", "12_33"}, "20": nil, "30": {"31_1"}})
+	cfg.AppIDs = []string{"20", "10", "30"}
+	cfg.FetchKeys = true
+	cfg.SummaryPath = filepath.Join(t.TempDir(), "reports", "summary.json")
+	res := r.download(t, cfg)
+	if res.Summary.Failed != 1 {
+		t.Fatalf("summary = %+v, want app 30 to fail", res.Summary)
+	}
+
+	data, err := os.ReadFile(cfg.SummaryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Apps []summaryApp `json:"apps"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("summary is not valid JSON: %v\n%s", err, data)
+	}
+
+	// 与内存中的结果逐项对应：按 app_ids 的顺序，只含成功的 App
+	results := map[string]AppResult{}
+	for _, r := range res.Results {
+		results[r.AppID] = r
+	}
+	var want []summaryApp
+	for _, id := range []string{"20", "10"} {
+		r := results[id]
+		s := summaryApp{AppID: id, Lua: savedScript(r)}
+		for _, f := range r.Files {
+			s.Manifests = append(s.Manifests, f.Name)
+		}
+		for depot := range r.Keys {
+			s.Depots = append(s.Depots, depot)
+		}
+		sort.Strings(s.Depots)
+		want = append(want, s)
+	}
+	if !reflect.DeepEqual(got.Apps, want) {
+		t.Errorf("summary apps = %+v, want %+v", got.Apps, want)
+	}
+	if got.Apps[0].Lua != "20.lua" || got.Apps[1].Lua != "10.lua" || len(got.Apps[1].Manifests) != 2 ||
+		!reflect.DeepEqual(got.Apps[1].Depots, []string{"11", "12"}) {
+		t.Errorf("summary apps = %+v", got.Apps)
+	}
+	// 原子写入：目录中只有 summary.json
+	if files := listFiles(t, filepath.Dir(cfg.SummaryPath)); len(files) != 1 {
+		t.Errorf("summary dir = %v", files)
+	}
+}
@@ -0,0 +1,82 @@
+package downloader
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// TOKEN_DEFAULT_COOLDOWN: 限流响应没有给出 X-RateLimit-Reset 时 Token 的冷却时间
+const TOKEN_DEFAULT_COOLDOWN = 60 * time.Second
+
+// pooledToken 是轮换池中的一个 Token，coolUntil 为冷却结束时间 (UnixNano，0 表示可用)
+type pooledToken struct {
+	value     string
+	coolUntil int64
+}
+
+// tokenPool 在多个 Token 间轮换请求以分摊每个账号每小时 5000 次的配额，被限流的 Token 冷却到重置时间
+type tokenPool struct {
+	rn     *run
+	tokens []*pooledToken
+	next   uint64
+}
+
+func (rn *run) newTokenPool(values []string) *tokenPool {
+	p := &tokenPool{rn: rn}
+	for _, v := range values {
+		p.tokens = append(p.tokens, &pooledToken{value: v})
+	}
+	return p
+}
+
+// pick 按轮询顺序返回下一个未冷却的 Token；全部冷却时返回最早恢复的一个
+func (p *tokenPool) pick() string {
+	now := time.Now().UnixNano()
+	n := uint64(len(p.tokens))
+	start := atomic.AddUint64(&p.next, 1) - 1
+	var soonest *pooledToken
+	for i := uint64(0); i < n; i++ {
+		t := p.tokens[(start+i)%n]
+		until := atomic.LoadInt64(&t.coolUntil)
+		if until <= now {
+			return t.value
+		}
+		if soonest == nil || until < atomic.LoadInt64(&soonest.coolUntil) {
+			soonest = t
+		}
+	}
+	return soonest.value
+}
+
+// coolDown 把被限流的 Token 标记为冷却到 reset (X-RateLimit-Reset，Unix 秒)
+func (p *tokenPool) coolDown(value, reset string) {
+	until := time.Now().Add(TOKEN_DEFAULT_COOLDOWN)
+	if sec, err := strconv.ParseInt(reset, 10, 64); err == nil && sec > 0 {
+		until = time.Unix(sec, 0)
+	}
+	for _, t := range p.tokens {
+		if t.value == value {
+			atomic.StoreInt64(&t.coolUntil, until.UnixNano())
+			p.rn.debugf("token ...%s 被限流，冷却到 %s", tokenSuffix(value), until.Format(time.RFC3339))
+			return
+		}
+	}
+}
+
+// authToken 返回本次请求使用的 Token：配置了多个 Token 时从轮换池中选取
+func (rn *run) authToken(token string) string {
+	if rn.tokens == nil || token == "" {
+		return token
+	}
+	return rn.tokens.pick()
+}
+
+// tokenSuffix 返回 Token 末尾几位，日志中不输出完整 Token
+func tokenSuffix(value string) string {
+	if len(value) <= 4 {
+		return strings.Repeat("*", len(value))
+	}
+	return value[len(value)-4:]
+}
@@ -0,0 +1,140 @@
+package downloader
+
+import (
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNormalizeTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		tokens []string
+		want   []string
+		first  string
+	}{
+		{"single token", "ghp_a", nil, []string{"ghp_a"}, "ghp_a"},
+		{"tokens only", "", []string{"ghp_a", "ghp_b"}, []string{"ghp_a", "ghp_b"}, "ghp_a"},
+		{"token first", "ghp_c", []string{"ghp_a", "ghp_b"}, []string{"ghp_c", "ghp_a", "ghp_b"}, "ghp_c"},
+		{"dedup and trim", " ghp_a ", []string{"ghp_a", "", "  ", "ghp_b", "ghp_b"}, []string{"ghp_a", "ghp_b"}, "ghp_a"},
+		{"none", "", nil, nil, ""},
+	}
+	for _, tt := range tests {
+		config := Config{Token: tt.token, Tokens: tt.tokens}
+		normalizeTokens(&config)
+		if !reflect.DeepEqual(config.Tokens, tt.want) || config.Token != tt.first {
+			t.Errorf("%s: Token %q, Tokens %q; want %q, %q", tt.name, config.Token, config.Tokens, tt.first, tt.want)
+		}
+	}
+}
+
+func TestTokenPoolPick(t *testing.T) {
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	past := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	tests := []struct {
+		name  string
+		cool  map[string]string // Token -> X-RateLimit-Reset
+		picks int
+		want  []string
+	}{
+		{"round robin", nil, 6, []string{"a", "b", "c", "a", "b", "c"}},
+		{"skip cooling", map[string]string{"b": future}, 4, []string{"a", "c", "c", "a"}},
+		{"default cooldown", map[string]string{"a": ""}, 3, []string{"b", "b", "c"}},
+		{"reset passed", map[string]string{"a": past}, 3, []string{"a", "b", "c"}},
+		// 全部冷却时使用最早恢复的 Token，而不是停止请求
+		{"all cooling", map[string]string{"a": future, "b": strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10), "c": future}, 2, []string{"b", "b"}},
+	}
+	for _, tt := range tests {
+		p := newRun().newTokenPool([]string{"a", "b", "c"})
+		for tok, reset := range tt.cool {
+			p.coolDown(tok, reset)
+		}
+		var got []string
+		for i := 0; i < tt.picks; i++ {
+			got = append(got, p.pick())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: picks = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTokenRotation(t *testing.T) {
+	files := map[string]string{"a/b/10/10.lua": "-- 10"}
+	var items []string
+	for i := 0; i < 8; i++ {
+		item := "1" + strconv.Itoa(i) + "_2" + strconv.Itoa(i)
+		files["a/b/10/"+item+".manifest"] = testManifest + item
+		items = append(items, item)
+	}
+	tests := []struct {
+		name    string
+		limited string // 被限流的 Token，空表示都可用
+	}{
+		{"spread", ""},
+		{"one rate limited", "ghp_limited"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepo(t, nil)
+			var mu sync.Mutex
+			used := map[string]int{}
+			limitedHits := map[string]int{}
+			for p, body := range files {
+				p, body := p, body
+				r.handle(p, func(w http.ResponseWriter, req *http.Request) {
+					tok := strings.TrimPrefix(req.Header.Get("Authorization"), "token ")
+					if tok == tt.limited {
+						mu.Lock()
+						limitedHits[p]++
+						mu.Unlock()
+						w.Header().Set("X-RateLimit-Remaining", "0")
+						w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+						http.Error(w, "API rate limit exceeded", http.StatusForbidden)
+						return
+					}
+					mu.Lock()
+					used[tok]++
+					mu.Unlock()
+					io.WriteString(w, body)
+				})
+			}
+			cfg := testConfig(t, map[string][]string{"10": items})
+			cfg.Token = "ghp_limited"
+			cfg.Tokens = []string{"ghp_ok"}
+			if tt.limited == "" {
+				cfg.Token = "ghp_x"
+				cfg.Tokens = []string{"ghp_y"}
+			}
+			res := r.download(t, cfg)
+			if res.Summary.Lua != 1 || res.Summary.Manifest != len(items) {
+				t.Fatalf("summary = %+v", res.Summary)
+			}
+			if tt.limited == "" {
+				// 两个 Token 都分到请求
+				if used["ghp_x"] == 0 || used["ghp_y"] == 0 || len(used) != 2 {
+					t.Errorf("requests per token = %v, want both used", used)
+				}
+				return
+			}
+			// 被限流的 Token 冷却后不再使用：并发的首批请求之后，每个文件的重试都改用另一个
+			if used["ghp_ok"] != len(files) || len(used) != 1 {
+				t.Errorf("requests per token = %v, want all %d on ghp_ok", used, len(files))
+			}
+			if len(limitedHits) == 0 {
+				t.Error("rate-limited token was never picked")
+			}
+			for p, n := range limitedHits {
+				if n > 1 {
+					t.Errorf("%s: rate-limited token used %d times, want at most 1", p, n)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package downloader
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HTTP 连接池默认值。默认 Transport 的 MaxIdleConnsPerHost 只有 2，
+// 上百个协程同时请求 raw.githubusercontent.com 时大部分连接用完即关，反复握手。
+const (
+	DEFAULT_MAX_IDLE_CONNS          = DOWNLOAD_CONCURRENCY * 2 // 全部主机合计的空闲连接上限
+	DEFAULT_MAX_IDLE_CONNS_PER_HOST = DOWNLOAD_CONCURRENCY     // 每个主机保留的空闲连接，与 App 并发数一致
+	DEFAULT_MAX_CONNS_PER_HOST      = DOWNLOAD_CONCURRENCY * 2 // 每个主机的连接上限 (含清单二级并行)，超出的请求排队等待
+	DEFAULT_IDLE_CONN_TIMEOUT       = 90                       // 空闲连接保留秒数
+	DEFAULT_DIAL_TIMEOUT            = 15                       // 建立 TCP 连接的超时秒数
+	DEFAULT_TLS_HANDSHAKE_TIMEOUT   = 10                       // TLS 握手的超时秒数
+	DEFAULT_RESPONSE_HEADER_TIMEOUT = 30                       // 发出请求后等待响应头的超时秒数 (不含读取响应体)
+)
+
+// TransportConfig 覆盖连接池参数 (config 中的 "transport"，未设置或 <= 0 的字段使用默认值)
+type TransportConfig struct {
+	MaxIdleConns           int `json:"max_idle_conns"`
+	MaxIdleConnsPerHost    int `json:"max_idle_conns_per_host"`
+	MaxConnsPerHost        int `json:"max_conns_per_host"`
+	IdleConnTimeoutSeconds int `json:"idle_conn_timeout_seconds"`
+	DialTimeoutSeconds     int `json:"dial_timeout_seconds"`
+	TLSHandshakeSeconds    int `json:"tls_handshake_timeout_seconds"`
+	ResponseHeaderSeconds  int `json:"response_header_timeout_seconds"`
+}
+
+// withDefaults 返回填充了默认值的副本
+func (tc TransportConfig) withDefaults() TransportConfig {
+	if tc.MaxIdleConns <= 0 {
+		tc.MaxIdleConns = DEFAULT_MAX_IDLE_CONNS
+	}
+	if tc.MaxIdleConnsPerHost <= 0 {
+		tc.MaxIdleConnsPerHost = DEFAULT_MAX_IDLE_CONNS_PER_HOST
+	}
+	if tc.MaxConnsPerHost <= 0 {
+		tc.MaxConnsPerHost = DEFAULT_MAX_CONNS_PER_HOST
+	}
+	if tc.IdleConnTimeoutSeconds <= 0 {
+		tc.IdleConnTimeoutSeconds = DEFAULT_IDLE_CONN_TIMEOUT
+	}
+	if tc.DialTimeoutSeconds <= 0 {
+		tc.DialTimeoutSeconds = DEFAULT_DIAL_TIMEOUT
+	}
+	if tc.TLSHandshakeSeconds <= 0 {
+		tc.TLSHandshakeSeconds = DEFAULT_TLS_HANDSHAKE_TIMEOUT
+	}
+	if tc.ResponseHeaderSeconds <= 0 {
+		tc.ResponseHeaderSeconds = DEFAULT_RESPONSE_HEADER_TIMEOUT
+	}
+	return tc
+}
+
+// newTransport 按配置构造共享的 http.Transport，proxy 为 nil 时使用环境变量中的代理
+func (rn *run) newTransport(tc TransportConfig, proxy *url.URL) *http.Transport {
+	tc = tc.withDefaults()
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(tc.DialTimeoutSeconds) * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	t := &http.Transport{
+		DialContext:           rn.routedDial(dialer.DialContext),
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          tc.MaxIdleConns,
+		MaxIdleConnsPerHost:   tc.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       tc.MaxConnsPerHost,
+		IdleConnTimeout:       time.Duration(tc.IdleConnTimeoutSeconds) * time.Second,
+		TLSHandshakeTimeout:   time.Duration(tc.TLSHandshakeSeconds) * time.Second,
+		ResponseHeaderTimeout: time.Duration(tc.ResponseHeaderSeconds) * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	applyProxy(t, dialer, proxy)
+	return t
+}
@@ -0,0 +1,268 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTransportFromConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  func(*Config)
+		want TransportConfig
+	}{
+		{"defaults", func(*Config) {}, TransportConfig{
+			MaxIdleConns:           DEFAULT_MAX_IDLE_CONNS,
+			MaxIdleConnsPerHost:    DEFAULT_MAX_IDLE_CONNS_PER_HOST,
+			MaxConnsPerHost:        DEFAULT_MAX_CONNS_PER_HOST,
+			IdleConnTimeoutSeconds: DEFAULT_IDLE_CONN_TIMEOUT,
+			TLSHandshakeSeconds:    DEFAULT_TLS_HANDSHAKE_TIMEOUT,
+			ResponseHeaderSeconds:  DEFAULT_RESPONSE_HEADER_TIMEOUT,
+		}},
+		{"overrides", func(c *Config) {
+			c.Transport = TransportConfig{MaxIdleConns: 7, MaxIdleConnsPerHost: 3, MaxConnsPerHost: 5,
+				IdleConnTimeoutSeconds: 11, TLSHandshakeSeconds: 4, ResponseHeaderSeconds: 6}
+		}, TransportConfig{MaxIdleConns: 7, MaxIdleConnsPerHost: 3, MaxConnsPerHost: 5,
+			IdleConnTimeoutSeconds: 11, TLSHandshakeSeconds: 4, ResponseHeaderSeconds: 6}},
+		// max_conns_per_host 优先于 transport.max_conns_per_host
+		{"max_conns_per_host", func(c *Config) {
+			c.Transport = TransportConfig{MaxConnsPerHost: 5, ResponseHeaderSeconds: 6}
+			c.MaxConnsPerHost = 2
+		}, TransportConfig{
+			MaxIdleConns:           DEFAULT_MAX_IDLE_CONNS,
+			MaxIdleConnsPerHost:    DEFAULT_MAX_IDLE_CONNS_PER_HOST,
+			MaxConnsPerHost:        2,
+			IdleConnTimeoutSeconds: DEFAULT_IDLE_CONN_TIMEOUT,
+			TLSHandshakeSeconds:    DEFAULT_TLS_HANDSHAKE_TIMEOUT,
+			ResponseHeaderSeconds:  6,
+		}},
+		// 负数与 0 一样使用默认值
+		{"negative", func(c *Config) { c.Transport = TransportConfig{MaxIdleConns: -1, MaxConnsPerHost: -1} }, TransportConfig{
+			MaxIdleConns:           DEFAULT_MAX_IDLE_CONNS,
+			MaxIdleConnsPerHost:    DEFAULT_MAX_IDLE_CONNS_PER_HOST,
+			MaxConnsPerHost:        DEFAULT_MAX_CONNS_PER_HOST,
+			IdleConnTimeoutSeconds: DEFAULT_IDLE_CONN_TIMEOUT,
+			TLSHandshakeSeconds:    DEFAULT_TLS_HANDSHAKE_TIMEOUT,
+			ResponseHeaderSeconds:  DEFAULT_RESPONSE_HEADER_TIMEOUT,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, map[string][]string{"10": nil})
+			tt.cfg(&cfg)
+			c := &Client{Output: io.Discard, LogLevel: "error"}
+			rn := c.start()
+			if _, err := c.prepare(rn, &cfg, true); err != nil {
+				t.Fatalf("prepare: %v", err)
+			}
+			tr, ok := rn.httpClient.Transport.(*http.Transport)
+			if !ok {
+				t.Fatalf("transport = %T", rn.httpClient.Transport)
+			}
+			got := TransportConfig{
+				MaxIdleConns:           tr.MaxIdleConns,
+				MaxIdleConnsPerHost:    tr.MaxIdleConnsPerHost,
+				MaxConnsPerHost:        tr.MaxConnsPerHost,
+				IdleConnTimeoutSeconds: int(tr.IdleConnTimeout / time.Second),
+				TLSHandshakeSeconds:    int(tr.TLSHandshakeTimeout / time.Second),
+				ResponseHeaderSeconds:  int(tr.ResponseHeaderTimeout / time.Second),
+			}
+			if got != tt.want {
+				t.Errorf("transport = %+v, want %+v", got, tt.want)
+			}
+			if !tr.ForceAttemptHTTP2 {
+				t.Error("ForceAttemptHTTP2 not set")
+			}
+		})
+	}
+}
+
+// benchFetch 用 client 并发请求 srv 共 n 次，每次读完响应体
+func benchFetch(b *testing.B, client *http.Client, url string, workers, n int) {
+	var wg sync.WaitGroup
+	var next int64
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.AddInt64(&next, 1) <= int64(n) {
+				resp, err := client.Get(url)
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// BenchmarkTransport 对比 http.DefaultTransport (每个主机只保留 2 个空闲连接) 与 newTransport
+// 在 DOWNLOAD_CONCURRENCY 个协程同时请求同一主机时的吞吐与新建连接数
+func BenchmarkTransport(b *testing.B) {
+	body := []byte(testManifest)
+	var opened int64
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(time.Millisecond) // 模拟往返延迟，让请求在连接上交错
+		w.Write(body)
+	}))
+	srv.Config.ConnState = func(_ net.Conn, s http.ConnState) {
+		if s == http.StateNew {
+			atomic.AddInt64(&opened, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	rn := newRun()
+	transports := []struct {
+		name string
+		new  func() http.RoundTripper
+	}{
+		{"default", func() http.RoundTripper { return http.DefaultTransport.(*http.Transport).Clone() }},
+		{"tuned", func() http.RoundTripper { return rn.newTransport(TransportConfig{}, nil) }},
+	}
+	const requests = 2000
+	for _, tr := range transports {
+		b.Run(tr.name, func(b *testing.B) {
+			atomic.StoreInt64(&opened, 0)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				rt := tr.new()
+				benchFetch(b, &http.Client{Transport: rt}, srv.URL, DOWNLOAD_CONCURRENCY, requests)
+				rt.(*http.Transport).CloseIdleConnections()
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(atomic.LoadInt64(&opened))/float64(b.N), "conns/op")
+			b.ReportMetric(float64(requests*b.N)/b.Elapsed().Seconds(), "req/s")
+		})
+	}
+}
+
+// manifestRepo 返回 n 个 App、每个 App 三个清单的仓库文件与 app_data
+func manifestRepo(n int) (map[string]string, map[string][]string) {
+	files := map[string]string{}
+	appData := map[string][]string{}
+	for i := 1; i <= n; i++ {
+		id := strconv.Itoa(i * 10)
+		files["a/b/"+id+"/"+id+".lua"] = "-- " + id
+		for j := 1; j <= 3; j++ {
+			item := fmt.Sprintf("%d_%d", i*10+j, j)
+			files["a/b/"+id+"/"+item+".manifest"] = testManifest + item
+			appData[id] = append(appData[id], item)
+		}
+	}
+	return files, appData
+}
+
+func TestMaxConnsPerHost(t *testing.T) {
+	files, appData := manifestRepo(4)
+	r := newTestRepo(t, files)
+	var inFlight, peak int64
+	for path, body := range files {
+		if !strings.HasSuffix(path, ".manifest") {
+			continue
+		}
+		body := body
+		r.handle(path, func(w http.ResponseWriter, _ *http.Request) {
+			n := atomic.AddInt64(&inFlight, 1)
+			defer atomic.AddInt64(&inFlight, -1)
+			for p := atomic.LoadInt64(&peak); n > p && !atomic.CompareAndSwapInt64(&peak, p, n); p = atomic.LoadInt64(&peak) {
+			}
+			time.Sleep(5 * time.Millisecond) // 不限制时足以让多个请求重叠
+			io.WriteString(w, body)
+		})
+	}
+	cfg := testConfig(t, appData)
+	cfg.MaxConnsPerHost = 1
+	res := r.download(t, cfg)
+	if res.Summary.Manifest != 12 {
+		t.Errorf("summary = %+v, want 12 manifests", res.Summary)
+	}
+	// HTTP/1.1 下每个连接同时只有一个请求
+	if peak != 1 {
+		t.Errorf("peak concurrent manifest requests = %d, want 1", peak)
+	}
+}
+
+// connLine 从 log_file 中读出运行结束时记录的连接计数
+var connLine = regexp.MustCompile(`连接: 新建 (\d+)，复用 (\d+)，TLS 握手 (\d+)`)
+
+func TestConnCountersLogged(t *testing.T) {
+	files, appData := manifestRepo(3)
+	r := newTestRepo(t, files)
+	cfg := testConfig(t, appData)
+	cfg.MaxConnsPerHost = 2
+	cfg.LogFile = filepath.Join(t.TempDir(), "run.log")
+	r.download(t, cfg)
+	data, err := os.ReadFile(cfg.LogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := connLine.FindStringSubmatch(string(data))
+	if m == nil {
+		t.Fatalf("connection counters not logged:\n%s", data)
+	}
+	opened, _ := strconv.Atoi(m[1])
+	reused, _ := strconv.Atoi(m[2])
+	handshakes, _ := strconv.Atoi(m[3])
+	// 明文 HTTP 没有握手；连接数受 max_conns_per_host 限制，其余请求复用已有连接
+	if opened < 1 || reused < 1 || handshakes != 0 {
+		t.Errorf("opened %d, reused %d, handshakes %d", opened, reused, handshakes)
+	}
+}
+
+func TestGzipManifests(t *testing.T) {
+	tests := []struct {
+		name       string
+		steamSafe  bool
+		forceGzip  bool   // 服务器无视 Accept-Encoding 总是压缩
+		wantAccept string // 服务器收到的 Accept-Encoding
+	}{
+		{"transport gzip", false, false, "gzip"},
+		{"steam-safe identity", true, false, "identity"},
+		{"server ignores identity", true, true, "identity"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepo(t, map[string]string{"a/b/10/10.lua": "-- 10"})
+			page := "a/b/10/11_22.manifest"
+			var accept atomic.Value
+			r.handle(page, func(w http.ResponseWriter, req *http.Request) {
+				accept.Store(req.Header.Get("Accept-Encoding"))
+				if tt.forceGzip || strings.Contains(req.Header.Get("Accept-Encoding"), "gzip") {
+					w.Header().Set("Content-Encoding", "gzip")
+					w.Write(gzipBytes(t, []byte(testManifest)))
+					return
+				}
+				io.WriteString(w, testManifest)
+			})
+			cfg := testConfig(t, map[string][]string{"10": {"11_22"}})
+			// 目标为 depotcache 时自动启用 steam-safe，这里换成普通目录
+			cfg.ManifestDir = filepath.Join(filepath.Dir(cfg.ManifestDir), "manifests")
+			cfg.SteamSafeWrites = tt.steamSafe
+			res := r.download(t, cfg)
+			if got, _ := accept.Load().(string); got != tt.wantAccept {
+				t.Errorf("Accept-Encoding = %q, want %q", got, tt.wantAccept)
+			}
+			data, err := os.ReadFile(filepath.Join(cfg.ManifestDir, "11_22.manifest"))
+			if err != nil || string(data) != testManifest || res.Summary.Manifest != 1 {
+				t.Errorf("saved manifest %d bytes (%v), summary %+v; want decoded manifest", len(data), err, res.Summary)
+			}
+		})
+	}
+}
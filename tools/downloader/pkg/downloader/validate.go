@@ -0,0 +1,159 @@
+package downloader
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// SNIFF_BYTES: 用于判断文件内容类型的文件头长度
+const SNIFF_BYTES = 512
+
+// FINGERPRINT_BYTES: 日志中记录的文件头字节数，用于事后排查被代理篡改的文件
+const FINGERPRINT_BYTES = 8
+
+// steamManifestMagics 是 Steam 能识别的清单容器头：
+// 原始 protobuf 清单 (0x71F617D0，小端) 与 CDN 上的 zip 压缩清单
+var steamManifestMagics = [][]byte{
+	{0xD0, 0x17, 0xF6, 0x71},
+	{'P', 'K', 0x03, 0x04},
+}
+
+// textErrorPrefixes 是仓库或代理把错误页面保存成清单时常见的开头 (比较前转为小写)
+var textErrorPrefixes = []string{"<html", "<!doctype", `{"message"`}
+
+// corruptError 表示下载内容不是有效清单 (0 字节、过小、长度不符、错误页面或文本内容)。
+// permanent 表示内容本身无效 (错误页面、未知文件头、文本)，对同一地址重试只会得到相同内容；
+// 长度不符等可能由传输中断造成的损坏仍然重试
+type corruptError struct {
+	reason    string
+	permanent bool
+}
+
+func (e *corruptError) Error() string { return "corrupt: " + e.reason }
+
+// tooLargeError 表示响应体超过 max_file_bytes，下载被中止且不留下文件
+type tooLargeError struct {
+	limit int64
+}
+
+func (e *tooLargeError) Error() string {
+	return fmt.Sprintf("文件超过 max_file_bytes (%d 字节)，已中止下载", e.limit)
+}
+
+// limitBody 按 max_file_bytes 限制响应体：Content-Length 已超出时直接拒绝，
+// 否则读到第 limit+1 个字节时返回 tooLargeError，写了一半的文件由调用方删除
+func (rn *run) limitBody(body io.Reader, contentLength int64) (io.Reader, error) {
+	if rn.maxFileBytes <= 0 {
+		return body, nil
+	}
+	if contentLength > rn.maxFileBytes {
+		return nil, &tooLargeError{rn.maxFileBytes}
+	}
+	return &limitedBody{r: body, left: rn.maxFileBytes, limit: rn.maxFileBytes}, nil
+}
+
+// limitedBody 最多读出 left 个字节，之后还有数据时返回 tooLargeError
+type limitedBody struct {
+	r     io.Reader
+	left  int64
+	limit int64 // max_file_bytes，用于错误信息
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	// 多读一个字节，用于区分恰好达到上限与超出上限
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.left {
+		n = int(l.left)
+		l.left = 0
+		return n, &tooLargeError{l.limit}
+	}
+	l.left -= int64(n)
+	return n, err
+}
+
+// headWriter 记录写入数据的前 SNIFF_BYTES 个字节
+type headWriter struct {
+	head []byte
+}
+
+func (h *headWriter) Write(p []byte) (int, error) {
+	if need := SNIFF_BYTES - len(h.head); need > 0 {
+		if need > len(p) {
+			need = len(p)
+		}
+		h.head = append(h.head, p[:need]...)
+	}
+	return len(p), nil
+}
+
+// fingerprint 返回文件头前 FINGERPRINT_BYTES 个字节的十六进制
+func (h *headWriter) fingerprint() string {
+	if len(h.head) > FINGERPRINT_BYTES {
+		return hex.EncodeToString(h.head[:FINGERPRINT_BYTES])
+	}
+	return hex.EncodeToString(h.head)
+}
+
+// isManifestPath 判断本地路径是否为清单文件
+func isManifestPath(p string) bool {
+	return strings.HasSuffix(strings.ToLower(p), ".manifest")
+}
+
+// validateManifest 校验已写入的清单：大小不低于 min_manifest_size、与 Content-Length 一致、
+// 不是 HTML/API 错误页面，且是二进制内容。strict (steam-safe) 时还要求文件头为已知容器格式。
+func (rn *run) validateManifest(n, contentLength int64, head []byte, strict bool) error {
+	if n == 0 {
+		return &corruptError{reason: "0 字节"}
+	}
+	if n < rn.minManifestSize {
+		return &corruptError{reason: fmt.Sprintf("%d 字节，小于 min_manifest_size %d", n, rn.minManifestSize)}
+	}
+	if contentLength > 0 && n != contentLength {
+		return &corruptError{reason: fmt.Sprintf("长度 %d 与 Content-Length %d 不符", n, contentLength)}
+	}
+	if !rn.contentCheck {
+		return nil
+	}
+	for _, magic := range steamManifestMagics {
+		if bytes.HasPrefix(head, magic) {
+			return nil
+		}
+	}
+	lower := strings.ToLower(string(bytes.TrimLeft(head, " \t\r\n\ufeff")))
+	for _, p := range textErrorPrefixes {
+		if strings.HasPrefix(lower, p) {
+			return &corruptError{reason: "内容是错误页面 (" + p + "...)", permanent: true}
+		}
+	}
+	if strict {
+		return &corruptError{reason: "未知文件头 " + hex.EncodeToString(head[:min(len(head), FINGERPRINT_BYTES)]), permanent: true}
+	}
+	if looksLikeText(head) {
+		return &corruptError{reason: "内容是文本，不是二进制清单", permanent: true}
+	}
+	return nil
+}
+
+// looksLikeText 判断文件头是否为纯文本 (合法 UTF-8 且不含制表/换行以外的控制字符)
+func looksLikeText(head []byte) bool {
+	// 截断处可能切开多字节字符，去掉末尾不完整的部分再判断
+	for i := 0; i < utf8.UTFMax && len(head) > 0 && !utf8.Valid(head); i++ {
+		head = head[:len(head)-1]
+	}
+	if !utf8.Valid(head) {
+		return false
+	}
+	for _, b := range head {
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package downloader
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLimitedBody(t *testing.T) {
+	tests := []struct {
+		size, limit int
+		tooLarge    bool
+	}{
+		{10, 10, false},
+		{9, 10, false},
+		{11, 10, true},
+		{100000, 1000, true},
+	}
+	for _, tt := range tests {
+		rn := newRun()
+		rn.maxFileBytes = int64(tt.limit)
+		body, err := rn.limitBody(strings.NewReader(strings.Repeat("x", tt.size)), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := io.Copy(io.Discard, body)
+		var te *tooLargeError
+		if got := errors.As(err, &te); got != tt.tooLarge {
+			t.Errorf("size %d limit %d: err = %v, want too large %v", tt.size, tt.limit, err, tt.tooLarge)
+		}
+		if n > int64(tt.limit) {
+			t.Errorf("size %d limit %d: read %d bytes past the limit", tt.size, tt.limit, n)
+		}
+	}
+}
+
+func TestMaxFileBytesRejectsOversized(t *testing.T) {
+	oversized := testManifest + strings.Repeat("\x00", 4096)
+	tests := []struct {
+		name    string
+		chunked bool // 不发送 Content-Length，只能在读取时发现超限
+	}{
+		{"content-length", false},
+		{"chunked", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepo(t, map[string]string{
+				"a/b/10/10.lua":         "-- lua",
+				"a/b/20/20.lua":         "-- lua",
+				"a/b/20/12_33.manifest": testManifest,
+			})
+			big := "a/b/10/11_22.manifest"
+			r.handle(big, func(w http.ResponseWriter, _ *http.Request) {
+				if !tt.chunked {
+					io.WriteString(w, oversized)
+					return
+				}
+				for i := 0; i < len(oversized); i += 512 {
+					io.WriteString(w, oversized[i:min(i+512, len(oversized))])
+					w.(http.Flusher).Flush()
+				}
+			})
+			cfg := testConfig(t, map[string][]string{"10": {"11_22"}, "20": {"12_33"}})
+			cfg.MaxFileBytes = int64(len(testManifest) + 100)
+			cfg.MaxRetries = 3
+			res := r.download(t, cfg)
+
+			// 上限以内的清单照常下载
+			if files := listFiles(t, cfg.ManifestDir); len(files) != 1 || files[0] != "12_33.manifest" {
+				t.Errorf("manifest_dir = %v, want only 12_33.manifest (no oversized or temp file)", files)
+			}
+			if n := r.count(big); n != 1 {
+				t.Errorf("oversized file requested %d times, want 1 (not retried)", n)
+			}
+			for _, app := range res.Results {
+				if app.AppID == "10" && (app.ErrorKind != KIND_FILE_TOO_LARGE || len(app.FailedManifests) != 1) {
+					t.Errorf("10: error_kind %q, failed %v; want %s", app.ErrorKind, app.FailedManifests, KIND_FILE_TOO_LARGE)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// FILE_VANISHED_WARN: 临时文件消失达到该次数时提示把目标目录加入杀毒软件排除列表
+const FILE_VANISHED_WARN = 3
+
+// tempSeq 是每次写入尝试的临时文件序号，在整个进程内递增，并发的运行写同一目录时也不会重名
+var tempSeq int64
+
+// vanishedError 表示刚写完的临时文件在重命名前消失，几乎总是杀毒软件把它隔离了。
+// 与磁盘错误不同，该错误会重试，且每次重试使用新的临时文件名。
+type vanishedError struct {
+	path string
+}
+
+func (e *vanishedError) Error() string {
+	return fmt.Sprintf("临时文件 %s 写入后消失 (可能被杀毒软件隔离)", filepath.Base(e.path))
+}
+
+// tempPath 返回 destPath 在本次尝试中使用的临时文件名，每次调用都不同，
+// 上一次尝试被隔离的文件不会与新的尝试冲突
+func tempPath(destPath string) string {
+	return fmt.Sprintf("%s.%d.tmp", destPath, atomic.AddInt64(&tempSeq, 1))
+}
+
+// checkVanished 确认刚写入的文件仍然存在，不存在时记录并返回 vanishedError
+func (rn *run) checkVanished(p string) error {
+	if _, err := rn.fsys.Stat(p); !errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return rn.noteVanished(p)
+}
+
+// noteVanished 累计消失次数，达到 FILE_VANISHED_WARN 时输出一次针对性的警告
+func (rn *run) noteVanished(p string) error {
+	if atomic.AddInt64(&rn.fileVanishedCount, 1) == FILE_VANISHED_WARN {
+		rn.warnf("已有 %d 个临时文件在写入后消失，通常是杀毒软件误报隔离，建议把 %s 加入杀毒软件的排除列表", FILE_VANISHED_WARN, filepath.Dir(p))
+	}
+	return &vanishedError{path: p}
+}
+
+// renameTemp 把临时文件重命名为目标文件，源文件已不存在时返回 vanishedError，其它失败为 diskError
+func (rn *run) renameTemp(tmp, dest string) error {
+	err := rn.renameWithRetry(tmp, dest)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, fs.ErrNotExist):
+		return rn.noteVanished(tmp)
+	}
+	return &diskError{err}
+}
@@ -0,0 +1,328 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// vdfNode 是 Valve KeyValues 文本中的一个键：叶子保存字符串值，对象保存子节点。
+// 偏移量指向原始数据，合并时只改动必要的字节，其余内容原样保留。
+type vdfNode struct {
+	key      string
+	value    string
+	children []*vdfNode
+	isObject bool
+
+	valStart, valEnd int // 叶子：值 token (含引号) 的范围；对象：'{' 与 '}' 的偏移
+}
+
+// child 按键名 (不区分大小写，与 Steam 一致) 查找子节点
+func (n *vdfNode) child(key string) *vdfNode {
+	for _, c := range n.children {
+		if strings.EqualFold(c.key, key) {
+			return c
+		}
+	}
+	return nil
+}
+
+// path 依次查找多级子节点
+func (n *vdfNode) path(keys ...string) *vdfNode {
+	cur := n
+	for _, k := range keys {
+		if cur = cur.child(k); cur == nil {
+			return nil
+		}
+	}
+	return cur
+}
+
+// find 深度优先查找第一个键名为 key 的对象节点
+func (n *vdfNode) find(key string) *vdfNode {
+	for _, c := range n.children {
+		if !c.isObject {
+			continue
+		}
+		if strings.EqualFold(c.key, key) {
+			return c
+		}
+		if f := c.find(key); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// depotsNode 返回保存 DecryptionKey 的 depots 节点：key.vdf 中位于顶层，config.vdf 中位于
+// InstallConfigStore/Software/Valve/Steam 下。只看这两处，其他对象里的同名节点 (如 apps/<id>/depots) 不算
+func depotsNode(root *vdfNode) *vdfNode {
+	if d := root.child("depots"); d != nil && d.isObject {
+		return d
+	}
+	if d := root.path("InstallConfigStore", "Software", "Valve", "Steam", "depots"); d != nil && d.isObject {
+		return d
+	}
+	return nil
+}
+
+// vdfToken 是词法分析得到的 token
+type vdfToken struct {
+	text       string
+	quoted     bool
+	start, end int
+}
+
+type vdfLexer struct {
+	data []byte
+	pos  int
+}
+
+// next 返回下一个 token，数据结束时返回 ok=false
+func (l *vdfLexer) next() (tok vdfToken, ok bool, err error) {
+	if l.pos == 0 && bytes.HasPrefix(l.data, utf8BOM) {
+		l.pos = len(utf8BOM)
+	}
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			l.pos++
+		case c == '/' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '/':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' {
+				l.pos++
+			}
+		case c == '{' || c == '}':
+			l.pos++
+			return vdfToken{text: string(c), start: l.pos - 1, end: l.pos}, true, nil
+		case c == '"':
+			start := l.pos
+			l.pos++
+			var sb strings.Builder
+			for l.pos < len(l.data) && l.data[l.pos] != '"' {
+				if l.data[l.pos] == '\\' && l.pos+1 < len(l.data) {
+					l.pos++
+					switch l.data[l.pos] {
+					case 'n':
+						sb.WriteByte('\n')
+					case 't':
+						sb.WriteByte('\t')
+					default:
+						sb.WriteByte(l.data[l.pos])
+					}
+				} else {
+					sb.WriteByte(l.data[l.pos])
+				}
+				l.pos++
+			}
+			if l.pos >= len(l.data) {
+				return vdfToken{}, false, fmt.Errorf("vdf: 偏移 %d 处的字符串未闭合", start)
+			}
+			l.pos++
+			return vdfToken{text: sb.String(), quoted: true, start: start, end: l.pos}, true, nil
+		default:
+			start := l.pos
+			for l.pos < len(l.data) && !strings.ContainsRune(" \t\r\n{}\"", rune(l.data[l.pos])) {
+				l.pos++
+			}
+			return vdfToken{text: string(l.data[start:l.pos]), start: start, end: l.pos}, true, nil
+		}
+	}
+	return vdfToken{}, false, nil
+}
+
+// parseVDF 解析 KeyValues 文本，返回一个虚拟根节点 (其子节点为顶层键)
+func parseVDF(data []byte) (*vdfNode, error) {
+	l := &vdfLexer{data: data}
+	root := &vdfNode{isObject: true, valStart: -1, valEnd: len(data)}
+	stack := []*vdfNode{root}
+	for {
+		tok, ok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		top := stack[len(stack)-1]
+		if !tok.quoted && tok.text == "}" {
+			if len(stack) == 1 {
+				return nil, fmt.Errorf("vdf: 偏移 %d 处多余的 '}'", tok.start)
+			}
+			top.valEnd = tok.start
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		if !tok.quoted && tok.text == "{" {
+			return nil, fmt.Errorf("vdf: 偏移 %d 处的 '{' 缺少键名", tok.start)
+		}
+		if strings.HasPrefix(tok.text, "[") && !tok.quoted {
+			// 平台条件 ([$WIN32] 等) 不影响结构，忽略
+			continue
+		}
+
+		node := &vdfNode{key: tok.text}
+		val, ok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("vdf: 键 %q 缺少值", tok.text)
+		}
+		if !val.quoted && val.text == "{" {
+			node.isObject, node.valStart = true, val.start
+			top.children = append(top.children, node)
+			stack = append(stack, node)
+			continue
+		}
+		node.value, node.valStart, node.valEnd = val.text, val.start, val.end
+		top.children = append(top.children, node)
+	}
+	if len(stack) != 1 {
+		return nil, fmt.Errorf("vdf: 对象 %q 未闭合", stack[len(stack)-1].key)
+	}
+	return root, nil
+}
+
+// parseDepotKeys 从 key.vdf 中提取 depots -> depotid -> DecryptionKey
+func parseDepotKeys(data []byte) (map[string]string, error) {
+	root, err := parseVDF(data)
+	if err != nil {
+		return nil, err
+	}
+	depots := root.find("depots")
+	if depots == nil {
+		return nil, fmt.Errorf("vdf: 找不到 depots 节点")
+	}
+	keys := make(map[string]string)
+	for _, d := range depots.children {
+		if !d.isObject {
+			continue
+		}
+		if k := d.child("DecryptionKey"); k != nil && !k.isObject && k.value != "" {
+			keys[d.key] = k.value
+		}
+	}
+	return keys, nil
+}
+
+// vdfEdit 是对原始数据的一处替换
+type vdfEdit struct {
+	at, del int
+	text    string
+}
+
+// mergeDepotKeys 把 keys 写入 config.vdf 的 depots 节点并返回新内容：
+// 已有 depot 的 DecryptionKey 就地替换，缺少的 depot 插入到 depots 末尾，其余字节保持不变。
+// 文件中没有 depots 时在 InstallConfigStore/Software/Valve/Steam 下新建。
+func mergeDepotKeys(data []byte, keys map[string]string) ([]byte, int, error) {
+	root, err := parseVDF(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	nl := "\n"
+	if strings.Contains(string(data), "\r\n") {
+		nl = "\r\n"
+	}
+
+	ids := make([]string, 0, len(keys))
+	for id := range keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var edits []vdfEdit
+	changed := 0
+	depots := depotsNode(root)
+	if depots == nil {
+		steam := root.path("InstallConfigStore", "Software", "Valve", "Steam")
+		if steam == nil {
+			return nil, 0, fmt.Errorf("vdf: 找不到 depots 或 InstallConfigStore/Software/Valve/Steam 节点")
+		}
+		var body strings.Builder
+		indent := lineIndent(data, steam.valEnd) + "\t"
+		body.WriteString(indent + `"depots"` + nl + indent + "{" + nl)
+		for _, id := range ids {
+			body.WriteString(depotBlock(id, keys[id], indent+"\t", nl))
+		}
+		body.WriteString(indent + "}" + nl)
+		edits = append(edits, insertBeforeClose(data, steam.valEnd, body.String(), nl))
+		changed = len(ids)
+	} else {
+		indent := lineIndent(data, depots.valEnd) + "\t"
+		var added strings.Builder
+		for _, id := range ids {
+			d := depots.child(id)
+			switch {
+			case d == nil:
+				added.WriteString(depotBlock(id, keys[id], indent, nl))
+				changed++
+			case !d.isObject:
+				continue
+			default:
+				k := d.child("DecryptionKey")
+				if k != nil && !k.isObject {
+					if k.value != keys[id] {
+						edits = append(edits, vdfEdit{at: k.valStart, del: k.valEnd - k.valStart, text: quoteVDF(keys[id])})
+						changed++
+					}
+					continue
+				}
+				line := lineIndent(data, d.valEnd) + "\t" + `"DecryptionKey"` + "\t\t" + quoteVDF(keys[id]) + nl
+				edits = append(edits, insertBeforeClose(data, d.valEnd, line, nl))
+				changed++
+			}
+		}
+		if added.Len() > 0 {
+			edits = append(edits, insertBeforeClose(data, depots.valEnd, added.String(), nl))
+		}
+	}
+
+	// 从后往前应用，前面的偏移不受影响
+	sort.Slice(edits, func(i, j int) bool { return edits[i].at > edits[j].at })
+	out := append([]byte(nil), data...)
+	for _, e := range edits {
+		out = append(out[:e.at], append([]byte(e.text), out[e.at+e.del:]...)...)
+	}
+	return out, changed, nil
+}
+
+// depotBlock 生成一个 depot 条目
+func depotBlock(id, key, indent, nl string) string {
+	return indent + quoteVDF(id) + nl +
+		indent + "{" + nl +
+		indent + "\t" + `"DecryptionKey"` + "\t\t" + quoteVDF(key) + nl +
+		indent + "}" + nl
+}
+
+// insertBeforeClose 在 close 处的 '}' 之前插入若干完整行 (text 以换行结尾)。
+// '}' 独占一行时插在该行行首；否则 (如 "depots" {}) 先换行再插入。
+func insertBeforeClose(data []byte, close int, text, nl string) vdfEdit {
+	lineStart := close
+	for lineStart > 0 && (data[lineStart-1] == ' ' || data[lineStart-1] == '\t') {
+		lineStart--
+	}
+	if lineStart == 0 || data[lineStart-1] == '\n' {
+		return vdfEdit{at: lineStart, text: text}
+	}
+	return vdfEdit{at: close, text: nl + text + lineIndent(data, close)}
+}
+
+// lineIndent 返回 pos 所在行的行首空白
+func lineIndent(data []byte, pos int) string {
+	start := pos
+	for start > 0 && data[start-1] != '\n' {
+		start--
+	}
+	end := start
+	for end < len(data) && (data[end] == ' ' || data[end] == '\t') {
+		end++
+	}
+	return string(data[start:end])
+}
+
+func quoteVDF(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
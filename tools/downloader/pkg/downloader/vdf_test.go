@@ -0,0 +1,169 @@
+package downloader
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// tabs 把测试数据中的四个空格缩进换成 Steam 使用的制表符
+func tabs(s string) string {
+	return strings.ReplaceAll(s, "    ", "\t")
+}
+
+// configVDF 的 apps/10 下有一个同名的 depots 对象，出现在真正的 Steam/depots 之前
+var configVDF = tabs(`"InstallConfigStore"
+{
+    "Software"
+    {
+        "Valve"
+        {
+            "Steam"
+            {
+                // 其他工具写入的注释
+                "apps"
+                {
+                    "10"
+                    {
+                        "depots"
+                        {
+                            "11"        "1"
+                        }
+                    }
+                }
+                "depots"
+                {
+                    "11"
+                    {
+                        "DecryptionKey"        "old"
+                    }
+                    "12"
+                    {
+                        "DecryptionKey"        "same"
+                    }
+                    "13"
+                    {
+                        "Other"        "x"
+                    }
+                }
+                "Accounts"    { "name" "a\"b" }
+            }
+        }
+    }
+}
+`)
+
+func TestMergeDepotKeys(t *testing.T) {
+	newDepot := tabs(`                    "14"
+                    {
+                        "DecryptionKey"        "k14"
+                    }
+`)
+	depotsClose := tabs("                }\n                \"Accounts\"")
+	tests := []struct {
+		name    string
+		in      string
+		keys    map[string]string
+		want    string
+		changed int
+	}{
+		{
+			name:    "unchanged",
+			in:      configVDF,
+			keys:    map[string]string{"12": "same"},
+			want:    configVDF,
+			changed: 0,
+		},
+		{
+			name:    "replace in place",
+			in:      configVDF,
+			keys:    map[string]string{"11": "new", "12": "same"},
+			want:    strings.Replace(configVDF, `"old"`, `"new"`, 1),
+			changed: 1,
+		},
+		{
+			name:    "add key to existing depot",
+			in:      configVDF,
+			keys:    map[string]string{"13": "k13"},
+			want:    strings.Replace(configVDF, tabs(`"Other"        "x"`+"\n"), tabs(`"Other"        "x"`+"\n                        \"DecryptionKey\"\t\t\"k13\"\n"), 1),
+			changed: 1,
+		},
+		{
+			name:    "append depot",
+			in:      configVDF,
+			keys:    map[string]string{"14": "k14"},
+			want:    strings.Replace(configVDF, depotsClose, newDepot+depotsClose, 1),
+			changed: 1,
+		},
+		{
+			name:    "crlf",
+			in:      strings.ReplaceAll(configVDF, "\n", "\r\n"),
+			keys:    map[string]string{"11": "new", "14": "k14"},
+			want:    strings.ReplaceAll(strings.Replace(strings.Replace(configVDF, depotsClose, newDepot+depotsClose, 1), `"old"`, `"new"`, 1), "\n", "\r\n"),
+			changed: 2,
+		},
+		{
+			name:    "quoted value",
+			in:      configVDF,
+			keys:    map[string]string{"11": `a"b\c`},
+			want:    strings.Replace(configVDF, `"old"`, `"a\"b\\c"`, 1),
+			changed: 1,
+		},
+		{
+			name: "create depots under Steam",
+			in:   "\"InstallConfigStore\"\n{\n\t\"Software\"\n\t{\n\t\t\"Valve\"\n\t\t{\n\t\t\t\"Steam\"\n\t\t\t{\n\t\t\t\t\"apps\" { \"10\" { \"depots\" { } } }\n\t\t\t}\n\t\t}\n\t}\n}\n",
+			keys: map[string]string{"11": "k11"},
+			want: "\"InstallConfigStore\"\n{\n\t\"Software\"\n\t{\n\t\t\"Valve\"\n\t\t{\n\t\t\t\"Steam\"\n\t\t\t{\n\t\t\t\t\"apps\" { \"10\" { \"depots\" { } } }\n" +
+				"\t\t\t\t\"depots\"\n\t\t\t\t{\n\t\t\t\t\t\"11\"\n\t\t\t\t\t{\n\t\t\t\t\t\t\"DecryptionKey\"\t\t\"k11\"\n\t\t\t\t\t}\n\t\t\t\t}\n" +
+				"\t\t\t}\n\t\t}\n\t}\n}\n",
+			changed: 1,
+		},
+		{
+			name:    "top-level depots",
+			in:      "\"depots\"\n{\n}\n",
+			keys:    map[string]string{"11": "k11"},
+			want:    "\"depots\"\n{\n\t\"11\"\n\t{\n\t\t\"DecryptionKey\"\t\t\"k11\"\n\t}\n}\n",
+			changed: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, changed, err := mergeDepotKeys([]byte(tt.in), tt.keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("merged =\n%s\nwant\n%s", out, tt.want)
+			}
+			if changed != tt.changed {
+				t.Errorf("changed = %d, want %d", changed, tt.changed)
+			}
+		})
+	}
+}
+
+func TestMergeDepotKeysErrors(t *testing.T) {
+	for _, in := range []string{
+		`"InstallConfigStore" { "Software" { } }`,
+		// 只有嵌套在其他对象里的 depots 时不能写到那里
+		`"InstallConfigStore" { "apps" { "10" { "depots" { } } } }`,
+		`"depots" {`,
+	} {
+		if out, _, err := mergeDepotKeys([]byte(in), map[string]string{"11": "k"}); err == nil {
+			t.Errorf("mergeDepotKeys(%q) = %q, want error", in, out)
+		}
+	}
+}
+
+func TestParseDepotKeys(t *testing.T) {
+	keys, err := parseDepotKeys([]byte("\xef\xbb\xbf\"depots\"\n{\n\t\"11\" { \"DecryptionKey\" \"k11\" }\n\t\"12\" { \"DecryptionKey\" \"\" }\n\t\"13\" \"leaf\"\n}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"11": "k11"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys = %v, want %v", keys, want)
+	}
+	if _, err := parseDepotKeys([]byte(`"apps" { }`)); err == nil {
+		t.Error("missing depots was accepted")
+	}
+}
@@ -0,0 +1,215 @@
+package downloader
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 保存内容 SHA-256 的自定义 WebDAV 属性 (命名空间与名称)
+const (
+	DAV_PROP_NS     = "urn:steamunlocker:downloader"
+	DAV_PROP_SHA256 = "sha256"
+)
+
+// webdavStorage 把对象写到 WebDAV 集合下：先 PUT 到同目录的临时名，成功后 MOVE 到目标
+type webdavStorage struct {
+	rn       *run
+	client   *http.Client
+	base     *url.URL
+	username string
+	password string
+
+	mu   sync.Mutex
+	cols map[string]bool // 已确认存在的集合
+}
+
+func (rn *run) newWebDAVStorage(sc StorageConfig) (*webdavStorage, error) {
+	u, err := url.Parse(strings.TrimRight(sc.URL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("需要 http(s)://host 形式的地址")
+	}
+	return &webdavStorage{rn: rn, client: &http.Client{}, base: u, username: sc.Username, password: sc.Password, cols: make(map[string]bool)}, nil
+}
+
+// objectURL 返回对象路径的完整地址
+func (w *webdavStorage) objectURL(p string) string {
+	u := *w.base
+	u.Path = strings.TrimRight(u.Path, "/") + "/" + strings.TrimLeft(p, "/")
+	u.RawPath = ""
+	return u.String()
+}
+
+// do 发送请求；除 accept 中列出的状态码外都视为失败
+func (w *webdavStorage) do(ctx context.Context, method, p string, header http.Header, body io.Reader, accept ...int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, w.objectURL(p), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if w.username != "" {
+		req.SetBasicAuth(w.username, w.password)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range accept {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+	err = fmt.Errorf("webdav %s %s: Status %d", method, p, resp.StatusCode)
+	if resp.StatusCode == http.StatusNotFound {
+		err = fmt.Errorf("%w: %v", fs.ErrNotExist, err)
+	}
+	return nil, err
+}
+
+// ensureCollections 依次创建 p 的各级父集合 (已存在时服务器返回 405)
+func (w *webdavStorage) ensureCollections(ctx context.Context, p string) error {
+	dir := path.Dir(strings.TrimLeft(p, "/"))
+	if dir == "." || dir == "/" {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	cur := ""
+	for _, part := range strings.Split(dir, "/") {
+		cur = path.Join(cur, part)
+		if w.cols[cur] {
+			continue
+		}
+		resp, err := w.do(ctx, "MKCOL", cur+"/", nil, nil, http.StatusCreated, http.StatusMethodNotAllowed, http.StatusOK)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		w.cols[cur] = true
+	}
+	return nil
+}
+
+// Put 上传到临时名 (读取 r 出错时请求中止，临时名随即删除)，成功后 MOVE 覆盖目标，再记录 SHA-256 属性
+func (w *webdavStorage) Put(ctx context.Context, p string, r io.Reader, meta StorageMeta) error {
+	if err := w.ensureCollections(ctx, p); err != nil {
+		return err
+	}
+	tmp := tempPath(p)
+	hasher := newSHA256Reader(r)
+	h := http.Header{}
+	if meta.ContentType != "" {
+		h.Set("Content-Type", meta.ContentType)
+	}
+	resp, err := w.do(ctx, "PUT", tmp, h, hasher, http.StatusCreated, http.StatusNoContent, http.StatusOK)
+	if err == nil && hasher.err != nil {
+		// 服务器已收到的内容不完整
+		resp.Body.Close()
+		err = hasher.err
+	}
+	if err != nil {
+		w.remove(tmp)
+		if hasher.err != nil {
+			return hasher.err
+		}
+		return err
+	}
+	resp.Body.Close()
+
+	mh := http.Header{"Destination": {w.objectURL(p)}, "Overwrite": {"T"}}
+	resp, err = w.do(ctx, "MOVE", tmp, mh, nil, http.StatusCreated, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		w.remove(tmp)
+		return err
+	}
+	resp.Body.Close()
+
+	body := fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?><d:propertyupdate xmlns:d="DAV:" xmlns:s="%s"><d:set><d:prop><s:%s>%s</s:%s></d:prop></d:set></d:propertyupdate>`,
+		DAV_PROP_NS, DAV_PROP_SHA256, hasher.sum(), DAV_PROP_SHA256)
+	xh := http.Header{"Content-Type": {"application/xml; charset=utf-8"}}
+	if resp, err := w.do(ctx, "PROPPATCH", p, xh, strings.NewReader(body), http.StatusMultiStatus, http.StatusOK); err != nil {
+		w.rn.debugf("webdav %s 记录 sha256 属性失败: %v", p, err)
+	} else {
+		resp.Body.Close()
+	}
+	return nil
+}
+
+// remove 尽力删除临时对象
+func (w *webdavStorage) remove(p string) {
+	ctx, cancel := w.rn.storageContext()
+	defer cancel()
+	if resp, err := w.do(ctx, "DELETE", p, nil, nil, http.StatusNoContent, http.StatusOK, http.StatusNotFound); err == nil {
+		resp.Body.Close()
+	}
+}
+
+func (w *webdavStorage) Exists(ctx context.Context, p string) (bool, error) {
+	_, err := w.Stat(ctx, p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// davMultistatus 是 PROPFIND 的响应
+type davMultistatus struct {
+	Responses []struct {
+		Propstat []struct {
+			Prop struct {
+				Length     string    `xml:"DAV: getcontentlength"`
+				Modified   string    `xml:"DAV: getlastmodified"`
+				Collection *struct{} `xml:"DAV: resourcetype>collection"`
+				SHA256     string    `xml:"urn:steamunlocker:downloader sha256"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+func (w *webdavStorage) Stat(ctx context.Context, p string) (StorageInfo, error) {
+	body := fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?><d:propfind xmlns:d="DAV:" xmlns:s="%s"><d:prop>`+
+		`<d:getcontentlength/><d:getlastmodified/><d:resourcetype/><s:%s/></d:prop></d:propfind>`, DAV_PROP_NS, DAV_PROP_SHA256)
+	h := http.Header{"Depth": {"0"}, "Content-Type": {"application/xml; charset=utf-8"}}
+	resp, err := w.do(ctx, "PROPFIND", p, h, strings.NewReader(body), http.StatusMultiStatus)
+	if err != nil {
+		return StorageInfo{}, err
+	}
+	defer resp.Body.Close()
+	var ms davMultistatus
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&ms); err != nil {
+		return StorageInfo{}, fmt.Errorf("webdav PROPFIND %s: %v", p, err)
+	}
+	var info StorageInfo
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstat {
+			if ps.Prop.Collection != nil {
+				return StorageInfo{}, fmt.Errorf("%s 是集合", p)
+			}
+			if n, err := strconv.ParseInt(ps.Prop.Length, 10, 64); err == nil {
+				info.Size = n
+			}
+			if t, err := http.ParseTime(ps.Prop.Modified); err == nil {
+				info.ModTime = t
+			}
+			if ps.Prop.SHA256 != "" {
+				info.SHA256 = strings.TrimSpace(ps.Prop.SHA256)
+			}
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,782 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 清单条目的处理状态
+const (
+	itemDownloaded = iota
+	itemSkipped
+	itemFailed
+	itemCollided // 与已写入的文件只有大小写不同，未写入
+)
+
+// manifestOutcome 是单个清单条目的处理结果
+type manifestOutcome struct {
+	item   string
+	status int
+	name   string   // 本地文件名 (下载成功或跳过时)
+	dl     download // 下载信息 (下载成功时)
+	err    error    // 最有参考价值的错误 (失败时)
+
+	targetErrs []string // 分发到额外目标目录时的失败记录
+	invalid    []string // 下载后校验失败并已删除的文件
+	collision  string   // itemCollided 时的冲突记录
+	unchanged  bool     // itemSkipped 时由条件请求 (304) 确认未变更
+
+	checksumFailed int // 与 checksums 不符而被拒绝的候选数
+
+	stats   *transferStats // detailed_stats 时该条目的请求计数
+	elapsed time.Duration  // detailed_stats 时该条目的处理耗时
+}
+
+// appTask 是队列中的一个 App，记录入队时间以区分排队等待与实际执行耗时
+type appTask struct {
+	appID    string
+	parent   string // include_dlc 加入的 DLC 所属的 App
+	enqueued time.Time
+}
+
+// processAllApps 处理全部 App；spool 非空时结果直接写入 spool，返回的结果为空。
+// 第二个返回值是处理过的全部 AppID (config.AppIDs 之后是 include_dlc 加入的 DLC)，
+// 第三个是运行期间产生的警告 (例如 leak_check 发现的异常)。
+func (rn *run) processAllApps(ctx context.Context, config Config, spool *resultSpool) ([]AppResult, []string, []string) {
+	var results []AppResult
+	taskChan := make(chan appTask, len(config.AppIDs))
+	downloadResults := make(map[string]*AppResult)
+	var downloadMu sync.Mutex
+	var wg sync.WaitGroup
+
+	// pending 是已入队但尚未处理完的 App 数；include_dlc 会在处理中追加任务，因此归零后才关闭队列
+	var pending sync.WaitGroup
+	order := append([]string(nil), config.AppIDs...)
+	queued := make(map[string]bool, len(order)) // 受 downloadMu 保护
+	for _, id := range order {
+		queued[id] = true
+	}
+	maxDLC := config.MaxDLCPerApp
+	if maxDLC <= 0 {
+		maxDLC = DEFAULT_MAX_DLC_PER_APP
+	}
+
+	baseline := runtime.NumGoroutine()
+	atomic.StoreInt64(&rn.totalTaskCount, int64(len(config.AppIDs)))
+
+	var cache *etagCache
+	if config.VerifyExisting && config.ManifestDir != "" {
+		cache = rn.loadETagCache(config.ManifestDir)
+		defer cache.save()
+	}
+	if config.ConditionalSync && config.LuaDir != "" && !config.ManifestOnly {
+		// lua_dir 与 manifest_dir 相同时共用一个索引文件 (文件名不会冲突)
+		if cache != nil && filepath.Clean(config.LuaDir) == filepath.Clean(config.ManifestDir) {
+			rn.luaETags = cache
+		} else {
+			rn.luaETags = rn.loadETagCache(config.LuaDir)
+			defer rn.luaETags.save()
+		}
+	}
+
+	var bundles *bundler
+	if config.BundleDir != "" {
+		bundles = rn.newBundler(config)
+	}
+
+	for i := 0; i < DOWNLOAD_CONCURRENCY; i++ {
+		wg.Add(1)
+		atomic.AddInt64(&rn.activeAppWorkers, 1)
+		go func() {
+			defer wg.Done()
+			defer atomic.AddInt64(&rn.activeAppWorkers, -1)
+			for task := range taskChan {
+				appID := task.appID
+				started := time.Now()
+				var res *AppResult
+				if ctx.Err() != nil {
+					// 已取消：不再发起请求，只记录该 App 被放弃
+					res = finishApp(ctx, &AppResult{AppID: appID}, nil)
+				} else {
+					appCtx, stats := rn.withStats(ctx)
+					res = rn.processApp(appCtx, config, cache, appID)
+					stats.addTo(res)
+				}
+				res.QueueWaitSeconds = started.Sub(task.enqueued).Seconds()
+				res.ExecutionSeconds = time.Since(started).Seconds()
+				if rn.detailedStats {
+					res.DurationSeconds = res.ExecutionSeconds
+				}
+				res.ParentApp = task.parent
+				if config.IncludeDLC && task.parent == "" && ctx.Err() == nil {
+					// DLC 不再继续展开自己的 DLC；已在队列中的 ID (含 app_ids 中的) 不重复加入
+					dlcs := rn.discoverDLCs(ctx, config, res, maxDLC)
+					downloadMu.Lock()
+					for _, id := range dlcs {
+						if queued[id] {
+							continue
+						}
+						queued[id] = true
+						order = append(order, id)
+						atomic.AddInt64(&rn.totalTaskCount, 1)
+						pending.Add(1)
+						// 队列容量只按 app_ids 分配，在独立协程中发送，避免所有 worker 都阻塞在入队上
+						go func(t appTask) { taskChan <- t }(appTask{appID: id, parent: appID, enqueued: time.Now()})
+					}
+					downloadMu.Unlock()
+				}
+
+				if spool != nil {
+					if err := spool.add(*res); err != nil {
+						rn.errorf("%s 的结果无法写入 low_memory 临时文件，将不会出现在结果中: %v", appID, err)
+					}
+				} else {
+					downloadMu.Lock()
+					downloadResults[appID] = res
+					downloadMu.Unlock()
+				}
+				rn.reportAppDone(res)
+				if config.SummaryPath != "" {
+					rn.recordSummary(*res)
+				}
+				if needProfiles(config) {
+					rn.recordProfile(config, *res)
+				}
+				if config.DedupReport != "" {
+					rn.recordDedupReport(*res, config.ManifestDir)
+				}
+				if rn.resumeState != nil {
+					rn.resumeState.record(*res, ctx.Err() != nil)
+				}
+				if bundles != nil {
+					bundles.add(*res)
+				}
+				pending.Done()
+			}
+		}()
+	}
+
+	pending.Add(len(config.AppIDs))
+	for _, id := range config.AppIDs {
+		taskChan <- appTask{appID: id, enqueued: time.Now()}
+	}
+	pending.Wait()
+	close(taskChan)
+	wg.Wait()
+
+	var warnings []string
+	if bundles != nil {
+		warnings = append(warnings, bundles.wait()...)
+	}
+	if config.LeakCheck {
+		warnings = append(warnings, rn.checkLeaks(baseline)...)
+	}
+
+	for _, id := range order {
+		if r, ok := downloadResults[id]; ok {
+			results = append(results, *r)
+		}
+	}
+	return results, order, warnings
+}
+
+// processApp 下载单个 App 的 Lua 与清单
+func (rn *run) processApp(ctx context.Context, config Config, cache *etagCache, appID string) *AppResult {
+	res := &AppResult{AppID: appID}
+	rn.emitEvent("app_start", map[string]interface{}{"app_id": appID})
+
+	mList := config.AppData[appID]
+	var notes []string
+	var dlcs []string // Lua 中 addappid 引用的其它 ID (DLC/depot)，供 GreenLuma AppList
+
+	// plan 中预先解析好的文件直接下载；只出现在 plan 中的 App 到此为止
+	if len(rn.planByApp[appID]) > 0 {
+		notes = append(notes, rn.runPlan(ctx, config, appID, res)...)
+		if rn.planOnly[appID] {
+			if config.FlattenSymlinks {
+				rn.flattenManifests(config, res)
+			}
+			if config.EstimateInstallSize {
+				rn.estimateInstallSize(config, res)
+			}
+			notes = rn.patchAppLua(config, res, notes)
+			if config.GreenLumaDir != "" && !appFailed(*res) {
+				rn.recordAppList(appID, nil)
+			}
+			return finishApp(ctx, res, notes)
+		}
+	}
+
+	// branch_archive 模式：整个分支一次下载，代替下面的逐文件探测
+	if config.BranchArchive {
+		if err := rn.downloadBranchArchive(ctx, config, appID, mList, res); err != nil && appFailed(*res) {
+			notes = append(notes, "分支包下载失败: "+errorReason(err))
+			res.ErrorKind = classifyError(err)
+		}
+		sort.Slice(res.Files, func(i, j int) bool { return res.Files[i].Name < res.Files[j].Name })
+		sort.Strings(res.FailedManifests)
+		sort.Strings(res.TargetErrors)
+		sort.Strings(res.InvalidFiles)
+		sort.Strings(res.Collisions)
+		if config.PruneOldManifests {
+			res.Pruned = rn.pruneOldManifests(config, mList, res)
+		}
+		if config.ValidateKeys {
+			res.KeyWarnings = rn.validateDepotKeys(config, res)
+		}
+		if config.FlattenSymlinks {
+			rn.flattenManifests(config, res)
+		}
+		if config.EstimateInstallSize {
+			rn.estimateInstallSize(config, res)
+		}
+		notes = rn.patchAppLua(config, res, notes)
+		if config.GreenLumaDir != "" && !appFailed(*res) {
+			rn.recordAppList(appID, nil)
+		}
+		return finishApp(ctx, res, notes)
+	}
+
+	// 1. 下载 Lua
+	var luaFetchErr error
+	if !config.ManifestOnly && config.LuaDir != "" && config.DirectMode && !rn.manifestsOnly[appID] {
+		if d, err := rn.fetchLua(ctx, config, appID); err == nil {
+			recordScript(res, d.template)
+			res.SourceRepo = d.Repo
+			res.LuaBranch, res.LuaTemplate = d.branch, d.template
+			if d.unchanged {
+				res.NotModified++
+			} else {
+				rn.emitEvent("file_done", map[string]interface{}{"app_id": appID, "file": scriptName(appID, d.template), "bytes": d.Size})
+			}
+		} else {
+			luaFetchErr = err
+		}
+	}
+
+	if config.AutoDiscover && res.Lua > 0 {
+		if info, err := rn.parseLuaFile(filepath.Join(config.LuaDir, appID+".lua")); err != nil {
+			notes = append(notes, "lua 解析失败: "+err.Error())
+		} else {
+			mList = mergeManifestItems(mList, info.Manifests)
+			for _, id := range info.AppIDs {
+				if id != appID {
+					dlcs = append(dlcs, id)
+				}
+			}
+			if msg := info.malformedSummary(); msg != "" {
+				notes = append(notes, msg)
+			}
+		}
+	}
+
+	probe := mList // dry_run 中需要逐个探测的条目
+	if config.ResolveDepots && config.ManifestDir != "" {
+		r := rn.resolveDepots(ctx, config, appID, mList)
+		mList = mergeManifestItems(mList, r.items)
+		probe = mList
+		res.ResolvedDepots, res.DepotsWithoutManifest = r.depots, r.missing
+		if rn.dryRun {
+			probe = rn.takeListed(config, appID, mList, r, res)
+		}
+	}
+
+	// 2. 下载清单 (二级并行)
+	if config.ManifestDir != "" && len(probe) > 0 {
+		rn.downloadManifests(ctx, config, cache, appID, probe, res)
+		if config.PruneOldManifests {
+			res.Pruned = rn.pruneOldManifests(config, mList, res)
+		}
+	}
+	if rn.dryRun {
+		res.Missing = append([]string(nil), res.notFound...)
+		sort.Strings(res.Missing)
+	}
+
+	// 3. 下载 key.vdf 中的 depot 密钥 (分支中没有 key.vdf 很常见，不算错误)
+	if wantKeys(config) {
+		if keys, err := rn.fetchDepotKeys(ctx, config, appID, res.SourceRepo); err == nil {
+			res.Keys = keys
+			rn.recordKeys(keys)
+		} else {
+			rn.debugf("%s 未获取到 key.vdf: %v", appID, err)
+		}
+	}
+	if config.ValidateKeys {
+		res.KeyWarnings = rn.validateDepotKeys(config, res)
+	}
+	if config.FlattenSymlinks {
+		rn.flattenManifests(config, res)
+	}
+	if config.EstimateInstallSize {
+		rn.estimateInstallSize(config, res)
+	}
+	notes = rn.patchAppLua(config, res, notes)
+	if config.GreenLumaDir != "" && !appFailed(*res) {
+		rn.recordAppList(appID, dlcs)
+	}
+
+	if appFailed(*res) && luaFetchErr != nil && res.ErrorKind == "" {
+		res.ErrorKind = classifyError(luaFetchErr)
+		notes = append(notes, "lua 下载失败: "+errorReason(luaFetchErr))
+	}
+	return finishApp(ctx, res, notes)
+}
+
+// patchAppLua 在 patch_lua 模式下按本次下载结果改写 appID.lua，失败时追加说明
+func (rn *run) patchAppLua(config Config, res *AppResult, notes []string) []string {
+	if !config.PatchLua || res.Lua == 0 {
+		return notes
+	}
+	changed, err := rn.patchLuaFile(filepath.Join(config.LuaDir, res.AppID+".lua"), downloadedManifests(res.Files), res.Keys)
+	if err != nil {
+		return append(notes, "lua 改写失败: "+err.Error())
+	}
+	res.LuaPatched = changed
+	return notes
+}
+
+// finishApp 补充取消说明并把附加说明合并到 res.Error
+func finishApp(ctx context.Context, res *AppResult, notes []string) *AppResult {
+	if ae := abortCause(ctx); ae != nil {
+		notes = append(notes, "运行已中止 ("+ae.msg+")，结果不完整")
+		if res.ErrorKind == "" || res.ErrorKind == KIND_NETWORK {
+			// 中止后的请求都以 context canceled 失败，归入中止原因
+			res.ErrorKind = ae.kind
+		}
+	} else if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		res.TimedOut = true
+		notes = append(notes, "运行超时，结果不完整")
+	} else if ctx.Err() != nil {
+		notes = append(notes, "运行被取消，结果不完整")
+	}
+	if len(notes) > 0 {
+		if res.Error != "" {
+			notes = append([]string{res.Error}, notes...)
+		}
+		res.Error = strings.Join(notes, "; ")
+	}
+	return res
+}
+
+// errLostRace 是候选竞速中落选请求被取消的原因 (context.Cause)，重试统计将其计为 cancelled 而不是失败
+var errLostRace = errors.New("已有其它候选胜出")
+
+// luaHit 是下载成功的 Lua 及其来源分支与命中的路径模板
+type luaHit struct {
+	download
+	branch    string
+	template  string
+	unchanged bool // conditional_sync 的条件请求返回 304，沿用本地文件
+}
+
+// fetchLua 按仓库优先级下载 Lua，当前仓库全部分支与候选都失败时尝试下一个仓库
+func (rn *run) fetchLua(ctx context.Context, config Config, appID string) (luaHit, error) {
+	if rn.luaETags != nil {
+		if d, ok := rn.revalidateLua(ctx, config, appID); ok {
+			return d, nil
+		}
+	}
+	var lastErr error
+	for _, repo := range config.Repos {
+		d, err := rn.fetchLuaFromRepo(ctx, config, repo, appID)
+		if err == nil {
+			if rn.luaETags != nil {
+				rn.luaETags.setEntry(scriptName(appID, d.template), cacheEntry{URL: d.URL, ETag: d.ETag, Repo: d.Repo, Branch: d.branch, Template: d.template})
+			}
+			return d, nil
+		}
+		if ctx.Err() != nil {
+			return luaHit{}, err
+		}
+		if lastErr == nil || statusCode(err) != 404 {
+			lastErr = err
+		}
+	}
+	return luaHit{}, lastErr
+}
+
+// fetchLuaFromRepo 按与清单相同的分支顺序 (appID、默认分支、main、master 或 branches) 查找 Lua
+func (rn *run) fetchLuaFromRepo(ctx context.Context, config Config, repo, appID string) (luaHit, error) {
+	var lastErr error
+	for _, branch := range rn.manifestBranches(repo, appID) {
+		if len(rn.luaBranchCandidates(appID, branch)) == 0 {
+			continue
+		}
+		d, err := rn.fetchLuaFromBranch(ctx, config, repo, branch, appID)
+		if err == nil {
+			return d, nil
+		}
+		if ctx.Err() != nil {
+			return luaHit{}, err
+		}
+		if lastErr == nil || statusCode(err) != 404 {
+			lastErr = err
+		}
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("%s 没有适用于任何分支的 lua_path_templates", appID)
+	}
+	return luaHit{}, lastErr
+}
+
+// fetchLuaFromBranch 并发请求一个分支中的全部 Lua 候选路径，按模板顺序采用第一个成功的候选：
+// 靠前的候选成功后立即取消其余请求，靠后的候选先返回时要等靠前的全部失败才采用 (depots.lua 可能只是占位文件)。
+// 每个候选先写入各自的临时文件，胜出者再重命名为 appID.lua (.st 模板为 appID.st)，避免并发写同一文件。
+func (rn *run) fetchLuaFromBranch(ctx context.Context, config Config, repo, branch, appID string) (luaHit, error) {
+	type luaAttempt struct {
+		tmp string
+		i   int
+		d   download
+		err error
+	}
+	dest := filepath.Join(config.LuaDir, appID+".lua")
+	stDest := filepath.Join(config.LuaDir, appID+".st")
+	names := rn.luaCandidates(appID)
+	candidates := rn.luaBranchCandidates(appID, branch)
+
+	luaCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	// 每个候选可单独取消：某个候选成功后，排在它之后的候选已不可能胜出，立即中止其传输
+	pos := make(map[int]int, len(candidates))
+	cancels := make([]context.CancelCauseFunc, len(candidates))
+	attempts := make(chan luaAttempt, len(candidates))
+	for k, i := range candidates {
+		pos[i] = k
+		tmp := rn.stagingPath(dest, fmt.Sprintf(".part%d", i))
+		attemptCtx, cancelAttempt := context.WithCancelCause(luaCtx)
+		cancels[k] = cancelAttempt
+		go func(i int, tmp string) {
+			d, err := rn.fetchFile(attemptCtx, repo, branch, names[i], tmp, config.Token, "")
+			attempts <- luaAttempt{tmp: tmp, i: i, d: d, err: err}
+		}(i, tmp)
+	}
+
+	var won bool
+	var winner luaHit
+	chosen := -1 // 已判定胜出的模板下标
+	var lastErr error
+	arrived := make(map[int]luaAttempt, len(candidates))
+	next := 0 // candidates 中下一个待判定的位置
+	for range candidates {
+		a := <-attempts
+		if chosen >= 0 {
+			// 结果已确定，晚到的成功结果直接丢弃
+			if a.err == nil {
+				rn.fsys.Remove(a.tmp)
+			}
+			continue
+		}
+		arrived[a.i] = a
+		if a.err == nil {
+			for _, c := range cancels[pos[a.i]+1:] {
+				c(errLostRace)
+			}
+		}
+		for ; next < len(candidates) && chosen < 0; next++ {
+			c, ok := arrived[candidates[next]]
+			if !ok {
+				break
+			}
+			if c.err != nil {
+				if lastErr == nil || (statusCode(c.err) != 404 && !errors.Is(c.err, context.Canceled)) {
+					lastErr = c.err
+				}
+				continue
+			}
+			chosen = c.i
+			cancel(errLostRace)
+			if rn.dryRun {
+				won, winner = true, luaHit{download: c.d, branch: branch, template: rn.luaTemplates[c.i]}
+				continue
+			}
+			target := dest
+			if isSTTemplate(rn.luaTemplates[c.i]) {
+				target = stDest
+			}
+			if err := rn.commitFile(ctx, c.tmp, target); err != nil {
+				rn.fsys.Remove(c.tmp)
+				lastErr = &diskError{err}
+				continue
+			}
+			won, winner = true, luaHit{download: c.d, branch: branch, template: rn.luaTemplates[c.i]}
+			rn.verbosef("%s Lua 选中 %s (分支 %s，模板 %s)", appID, c.d.URL, branch, rn.luaTemplates[c.i])
+		}
+		if chosen >= 0 {
+			// 已到达但排在胜出者之后的成功结果
+			for _, c := range arrived {
+				if c.err == nil && c.i != chosen {
+					rn.fsys.Remove(c.tmp)
+				}
+			}
+		}
+	}
+	if won {
+		return winner, nil
+	}
+	return luaHit{}, lastErr
+}
+
+// downloadManifests 并发处理一个 App 的全部清单条目并把结果汇总到 res。
+// 每个条目协程只通过 outcomes 通道返回结果，等待与计数释放都由 defer 完成，
+// 因此条目处理中任何提前返回都不会泄漏协程。
+func (rn *run) downloadManifests(ctx context.Context, config Config, cache *etagCache, appID string, items []string, res *AppResult) {
+	outcomes := make(chan manifestOutcome, len(items))
+	var mwg sync.WaitGroup
+	for _, item := range items {
+		mwg.Add(1)
+		atomic.AddInt64(&rn.activeItemWorkers, 1)
+		go func(manifestItem string) {
+			defer mwg.Done()
+			defer atomic.AddInt64(&rn.activeItemWorkers, -1)
+			itemCtx, stats := rn.withStats(ctx)
+			started := time.Now()
+			o := rn.downloadManifestShared(itemCtx, config, cache, appID, manifestItem)
+			// 共享的结果 (dedup) 也使用本条目自己的计数与耗时
+			o.stats, o.elapsed = stats, time.Since(started)
+			outcomes <- o
+		}(item)
+	}
+	mwg.Wait()
+	close(outcomes)
+
+	var failReasons, failKinds, repos []string
+	for o := range outcomes {
+		res.TargetErrors = append(res.TargetErrors, o.targetErrs...)
+		res.InvalidFiles = append(res.InvalidFiles, o.invalid...)
+		res.ChecksumFailed += o.checksumFailed
+		o.stats.addTo(res)
+		switch o.status {
+		case itemDownloaded:
+			res.Manifest++
+			repos = append(repos, o.dl.Repo)
+			f := FileInfo{Name: manifestFileName(config, appID, o.name), Size: o.dl.Size, SHA256: o.dl.SHA256}
+			if o.stats != nil {
+				f.Ms, f.Attempts = o.elapsed.Milliseconds(), int(atomic.LoadInt64(&o.stats.attempts))
+			}
+			res.Files = append(res.Files, f)
+			rn.emitEvent("file_done", map[string]interface{}{"app_id": appID, "file": o.name, "bytes": o.dl.Size})
+		case itemSkipped:
+			res.Skipped++
+			if o.unchanged {
+				res.NotModified++
+			}
+			res.existing = append(res.existing, manifestFileName(config, appID, o.name))
+		case itemCollided:
+			res.Collisions = append(res.Collisions, o.collision)
+		case itemFailed:
+			res.FailedManifests = append(res.FailedManifests, o.item)
+			if o.err != nil {
+				failReasons = append(failReasons, errorReason(o.err))
+				failKinds = append(failKinds, classifyError(o.err))
+				if classifyError(o.err) == KIND_NOT_FOUND {
+					res.notFound = append(res.notFound, o.item)
+				}
+			}
+		}
+	}
+	sort.Slice(res.Files, func(i, j int) bool { return res.Files[i].Name < res.Files[j].Name })
+	sort.Strings(res.FailedManifests)
+	sort.Strings(res.TargetErrors)
+	sort.Strings(res.InvalidFiles)
+	sort.Strings(res.Collisions)
+	if res.SourceRepo == "" && len(repos) > 0 {
+		res.SourceRepo = dominantReason(repos)
+	}
+	if res.Manifest == 0 && res.Skipped == 0 && len(failReasons) > 0 {
+		res.Error = fmt.Sprintf("%d/%d manifests failed: %s", len(failReasons), len(items), dominantReason(failReasons))
+		res.ErrorKind = dominantReason(failKinds)
+	}
+}
+
+// downloadManifestItem 处理单个 "depot_manifest" 条目：先检查本地已有文件，再按分支与候选名探测下载
+func (rn *run) downloadManifestItem(ctx context.Context, config Config, cache *etagCache, appID, item string) manifestOutcome {
+	dir := appManifestDir(config, appID)
+	// 本地文件名不安全 (路径穿越、NTFS 非法字符、设备名) 的候选直接排除，全部不安全时该条目失败
+	var onlineNames []string
+	var nameErr error
+	for _, oname := range rn.manifestCandidates(appID, item) {
+		if _, err := safeJoin(dir, manifestLocalName(oname)); err != nil {
+			nameErr = err
+			continue
+		}
+		onlineNames = append(onlineNames, oname)
+	}
+	if len(onlineNames) == 0 && nameErr != nil {
+		rn.warnf("%s 清单条目 %q 已跳过: %v", appID, item, nameErr)
+		return manifestOutcome{item: item, status: itemFailed, err: nameErr}
+	}
+
+	// 已存在的清单：直接跳过，或在 verify 模式下用 ETag 确认未变更
+	if config.SkipExisting || config.VerifyExisting {
+		for _, oname := range onlineNames {
+			localName := manifestLocalName(oname)
+			destPath := filepath.Join(dir, localName)
+			if !rn.fileIsUsable(destPath) {
+				continue
+			}
+			if prev, ok := rn.claimDestination(dir, localName); !ok {
+				// 不区分大小写时 fileIsUsable 找到的是本次写入的另一个文件
+				return manifestOutcome{item: item, status: itemCollided, collision: rn.collisionNote(appID, localName, prev)}
+			}
+			if !config.VerifyExisting {
+				return manifestOutcome{item: item, status: itemSkipped, name: localName}
+			}
+			entry, ok := cache.get(manifestFileName(config, appID, localName))
+			if !ok {
+				continue
+			}
+			d, err := rn.downloadFileWithRetry(rn.manifestExpected(ctx, oname, localName), entry.URL, destPath, config.Token, entry.ETag)
+			if errors.Is(err, errNotModified) {
+				return manifestOutcome{item: item, status: itemSkipped, name: localName, unchanged: true}
+			}
+			if err == nil {
+				cache.set(manifestFileName(config, appID, localName), entry.URL, d.ETag)
+				return manifestOutcome{item: item, status: itemDownloaded, name: localName, dl: d}
+			}
+		}
+	}
+
+	// 仓库按优先级依次尝试，每个仓库内先遍历分支，再遍历候选文件名
+	var itemErr error
+	var invalid []string
+	var collision string
+	probes, checksumFailed := 0, 0
+	for _, repo := range config.Repos {
+		// missed 是本条目在该仓库中候选全部 404 的分支，在后续分支找到文件时标记为无效
+		var missed []string
+		for _, branch := range rn.manifestBranches(repo, appID) {
+			if rn.branchDead(repo, branch, appID) {
+				rn.debugf("%s 清单 %s 跳过分支 %s/%s (本 App 其它清单已确认不在此分支)", appID, item, repo, branch)
+				continue
+			}
+			only404 := true
+			for _, oname := range onlineNames {
+				localName := manifestLocalName(oname)
+				destPath := filepath.Join(dir, localName)
+
+				prev, ok := rn.claimDestination(dir, localName)
+				if !ok {
+					if collision == "" {
+						collision = rn.collisionNote(appID, localName, prev)
+					}
+					continue
+				}
+				fetchCtx := rn.manifestExpected(ctx, oname, localName)
+				if probes > 0 && rn.probeDelay > 0 {
+					// 间隔在限速等待中一并计算 (waitLimiter)
+					fetchCtx = withProbeDelay(fetchCtx, rn.probeDelay)
+				}
+				probes++
+
+				d, err := rn.fetchFile(fetchCtx, repo, branch, oname, destPath, config.Token, "")
+				if err != nil {
+					rn.releaseDestination(dir, localName)
+				}
+				if err == nil {
+					rn.markBranchesDead(repo, appID, missed)
+					if cache != nil {
+						cache.set(manifestFileName(config, appID, localName), d.URL, d.ETag)
+					}
+					rn.debugf("%s 清单 %s -> %s (%s)", appID, item, localName, repo)
+					rn.verbosef("%s 清单 %s 选中 %s", appID, item, d.URL)
+					return manifestOutcome{item: item, status: itemDownloaded, name: localName, dl: d, invalid: invalid, checksumFailed: checksumFailed}
+				}
+				var de *diskError
+				if ctx.Err() != nil || errors.As(err, &de) {
+					// 磁盘错误与候选名无关，换其它候选也会同样失败
+					return manifestOutcome{item: item, status: itemFailed, err: err, invalid: invalid, checksumFailed: checksumFailed}
+				}
+				var ce *corruptError
+				if errors.As(err, &ce) {
+					rn.warnf("%s 清单 %s 校验失败，已删除: %s", appID, localName, ce.reason)
+					invalid = append(invalid, localName)
+				}
+				var ke *checksumError
+				if errors.As(err, &ke) {
+					checksumFailed++
+				}
+				if statusCode(err) != 404 {
+					only404 = false
+				}
+				if itemErr == nil || statusCode(err) != 404 {
+					// 404 只是候选路径不存在，其它错误更有参考价值，不被后续 404 覆盖
+					itemErr = err
+				}
+			}
+			if only404 {
+				missed = append(missed, branch)
+			}
+		}
+	}
+	if collision != "" && (itemErr == nil || statusCode(itemErr) == 404) {
+		return manifestOutcome{item: item, status: itemCollided, collision: collision, invalid: invalid, checksumFailed: checksumFailed}
+	}
+	rn.verbosef("%s 清单 %s 全部 %d 个候选均失败", appID, item, probes)
+	return manifestOutcome{item: item, status: itemFailed, err: itemErr, invalid: invalid, checksumFailed: checksumFailed}
+}
+
+// manifestExpected 在 checksums 中有该清单 (按仓库内路径或本地文件名) 时让下载校验其 SHA-256
+func (rn *run) manifestExpected(ctx context.Context, oname, localName string) context.Context {
+	if sha := rn.checksumFor(oname, localName); sha != "" {
+		return withExpected(ctx, expected{rn: rn, sha256: sha, source: "checksums"})
+	}
+	return ctx
+}
+
+// reportAppDone 输出单个 App 完成后的进度
+func (rn *run) reportAppDone(res *AppResult) {
+	count := atomic.AddInt64(&rn.downloadedCount, 1)
+	total := atomic.LoadInt64(&rn.totalTaskCount)
+	rn.emitEvent("app_done", map[string]interface{}{
+		"app_id": res.AppID, "lua": res.Lua, "st": res.St, "manifest": res.Manifest,
+		"done": count, "total": total, "bytes": atomic.LoadInt64(&rn.totalBytes),
+	})
+	if rn.structuredOutput {
+		rn.emitEvent("progress", map[string]interface{}{"done": count, "total": total})
+	}
+	if count%100 == 0 || count == total {
+		logMu.Lock()
+		rn.writeRunLog("PROGRESS", fmt.Sprintf("%d/%d", count, total))
+		if !rn.eventsEnabled() && rn.consoleLevel <= levelInfo {
+			fmt.Fprintf(rn.logOut, "[PROGRESS] %d/%d\n", count, total)
+			rn.logOut.Sync()
+		}
+		logMu.Unlock()
+	}
+}
+
+// LEAK_CHECK_GRACE 是 leak_check 等待协程自然退出的最长时间
+const LEAK_CHECK_GRACE = 2 * time.Second
+
+// checkLeaks 在所有工作协程结束后检查是否有协程或计数未释放，返回异常说明
+func (rn *run) checkLeaks(baseline int) []string {
+	var warnings []string
+	if n := atomic.LoadInt64(&rn.activeAppWorkers); n != 0 {
+		warnings = append(warnings, fmt.Sprintf("leak_check: %d 个 App 工作协程未正常退出", n))
+	}
+	if n := atomic.LoadInt64(&rn.activeItemWorkers); n != 0 {
+		warnings = append(warnings, fmt.Sprintf("leak_check: %d 个清单协程未正常退出", n))
+	}
+
+	// 空闲的 keep-alive 连接各自占用读写协程，先关闭再比较
+	rn.httpClient.CloseIdleConnections()
+	deadline := time.Now().Add(LEAK_CHECK_GRACE)
+	current := runtime.NumGoroutine()
+	for current > baseline && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		current = runtime.NumGoroutine()
+	}
+	if current > baseline {
+		warnings = append(warnings, fmt.Sprintf("leak_check: 运行结束后协程数 %d，高于开始时的 %d", current, baseline))
+	}
+	return warnings
+}
@@ -0,0 +1,147 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLuaRaceCancelsLosers(t *testing.T) {
+	// 第一个模板很快成功；排在后面的 config.lua 持续慢速传输，应在胜出后立即被中止
+	r := newTestRepo(t, map[string]string{"a/b/10/10.lua": "-- lua"})
+	started := make(chan struct{})
+	aborted := make(chan time.Duration, 1)
+	r.handle("a/b/10/10.lua", func(w http.ResponseWriter, _ *http.Request) {
+		// 等慢速候选开始传输后再返回，确保竞速真的发生
+		select {
+		case <-started:
+		case <-time.After(2 * time.Second):
+		}
+		io.WriteString(w, "-- lua")
+	})
+	r.handle("a/b/10/config.lua", func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		w.Write([]byte("-- slow\n"))
+		w.(http.Flusher).Flush()
+		close(started)
+		for i := 0; i < 100; i++ {
+			select {
+			case <-req.Context().Done():
+				aborted <- time.Since(start)
+				return
+			case <-time.After(50 * time.Millisecond):
+				w.Write([]byte("-- slow\n"))
+				w.(http.Flusher).Flush()
+			}
+		}
+	})
+
+	start := time.Now()
+	res := r.download(t, testConfig(t, map[string][]string{"10": nil}))
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("run took %v, losing transfer was not cancelled", elapsed)
+	}
+	select {
+	case d := <-aborted:
+		if d > time.Second {
+			t.Errorf("slow candidate aborted after %v, want promptly", d)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("slow candidate was never aborted")
+	}
+	if res.Summary.Lua != 1 || len(res.Results) != 1 || res.Results[0].Error != "" {
+		t.Fatalf("results = %+v, want 10.lua", res.Results)
+	}
+	if res.Stats.Cancelled == 0 || res.Stats.Failures != 0 {
+		t.Errorf("stats = %+v, want cancelled > 0 and no failures", res.Stats)
+	}
+}
+
+func TestLeakCheckEarlyExits(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		setup func(r *testRepo, cfg *Config, cancel context.CancelFunc)
+		check func(res Result) bool // 确认确实走到了该退出路径
+	}{
+		{"all found", map[string]string{"a/b/10/10.lua": "-- 10", "a/b/10/11_22.manifest": testManifest}, nil,
+			func(res Result) bool { return res.Summary.Manifest == 1 }},
+		{"lua missing", map[string]string{"a/b/10/11_22.manifest": testManifest}, nil,
+			func(res Result) bool { return res.Summary.Lua == 0 && res.Summary.Manifest == 1 }},
+		// 全部候选 404：每个条目遍历完分支与候选名后返回
+		{"manifests missing", map[string]string{"a/b/10/10.lua": "-- 10"}, nil,
+			func(res Result) bool { return res.Summary.Manifest == 0 && res.Summary.Failed == 2 }},
+		// 磁盘错误：条目在第一个候选后立即返回
+		{"disk error", map[string]string{"a/b/10/10.lua": "-- 10", "a/b/10/11_22.manifest": testManifest}, func(r *testRepo, cfg *Config, _ context.CancelFunc) {
+			os.WriteFile(cfg.ManifestDir, nil, 0o644)
+		}, func(res Result) bool {
+			for _, r := range res.Results {
+				if r.AppID == "10" {
+					// 11_22 存在但写入失败，不计为 404
+					return len(r.FailedManifests) == 3 && len(r.notFound) == 2
+				}
+			}
+			return false
+		}},
+		// 下载中途取消：进行中的请求被中止，排队的 App 不再发起请求
+		{"cancelled", map[string]string{"a/b/10/10.lua": "-- 10"}, func(r *testRepo, cfg *Config, cancel context.CancelFunc) {
+			r.handle("a/b/10/11_22.manifest", func(w http.ResponseWriter, req *http.Request) {
+				cancel()
+				<-req.Context().Done()
+			})
+		}, func(res Result) bool { return res.Cancelled }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepo(t, tt.files)
+			cfg := testConfig(t, map[string][]string{"10": {"11_22", "12_33", "13_44"}, "20": {"21_1"}, "30": nil})
+			cfg.LeakCheck = true
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.setup != nil {
+				tt.setup(r, &cfg, cancel)
+			}
+			c := r.client()
+			res, err := c.Run(ctx, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(res) {
+				t.Errorf("summary = %+v, results = %+v", res.Summary, res.Results)
+			}
+			for _, w := range res.Warnings {
+				if strings.HasPrefix(w, "leak_check:") {
+					t.Errorf("warning: %s", w)
+				}
+			}
+			if n, m := atomic.LoadInt64(&c.last.activeAppWorkers), atomic.LoadInt64(&c.last.activeItemWorkers); n != 0 || m != 0 {
+				t.Errorf("%d app workers and %d manifest workers still active", n, m)
+			}
+		})
+	}
+}
+
+func TestCheckLeaksReports(t *testing.T) {
+	rn := newRun()
+	baseline := runtime.NumGoroutine()
+	rn.activeAppWorkers, rn.activeItemWorkers = 1, 2
+	stuck := make(chan struct{})
+	defer close(stuck)
+	go func() { <-stuck }()
+
+	warnings := rn.checkLeaks(baseline)
+	if len(warnings) != 3 {
+		t.Fatalf("warnings = %q, want app workers, manifest workers and goroutine count", warnings)
+	}
+	for i, want := range []string{"1 个 App 工作协程", "2 个清单协程", "协程数"} {
+		if !strings.Contains(warnings[i], want) {
+			t.Errorf("warning %d = %q, want %q", i, warnings[i], want)
+		}
+	}
+}